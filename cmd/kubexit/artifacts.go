@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/artifacts"
+)
+
+const (
+	artifactsOnFailure = "failure"
+	artifactsOnAlways  = "always"
+)
+
+// deliveryTarget is a named sink, the name keys its circuit breaker and is reported in delivery statuses
+type deliveryTarget struct {
+	name string
+	sink artifacts.Sink
+}
+
+func artifactSinks(config *config) []deliveryTarget {
+	var targets []deliveryTarget
+	if config.ArtifactsDir != "" {
+		targets = append(targets, deliveryTarget{name: "artifacts dir", sink: artifacts.DirSink{Dir: config.ArtifactsDir}})
+	}
+	if config.ArtifactsWebhook != "" {
+		targets = append(targets, deliveryTarget{name: "artifacts webhook", sink: artifacts.WebhookSink{URL: config.ArtifactsWebhook}})
+	}
+	if config.UploadURL != "" {
+		targets = append(targets, deliveryTarget{name: uploadTarget, sink: uploadSink(config)})
+	}
+	return targets
+}
+
+// collectArtifacts collects configured files of the exited child into a tarball, delivers it to configured sinks
+// and records its locations in the tombstone. Failures are logged only, the death is recorded anyway
+func collectArtifacts(d *deliverer, config *config, logger *logrus.Logger, ts *tombstoneWriter) {
+	ctx, cancel := d.within(config.ArtifactsTimeout)
+	defer cancel()
+
+	temp, err := ioutil.TempFile("", "kubexit-artifacts-*.tar.gz")
+	if err != nil {
+		logger.WithError(errors.WithStack(err)).WithField(errorCodeField, errorCodeArtifactsFailed).Error("failed to collect artifacts")
+		return
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	count, err := artifacts.Collect(temp, config.Artifacts)
+	if err != nil {
+		logger.WithError(err).WithField(errorCodeField, errorCodeArtifactsFailed).Error("failed to collect artifacts")
+		return
+	}
+
+	name := fmt.Sprintf("%s-%s.tar.gz", config.Name, time.Now().UTC().Format("20060102T150405Z"))
+	var locations []string
+	for _, target := range artifactSinks(config) {
+		location, err2 := d.put(ctx, target.name, target.sink, name, temp)
+		if err2 != nil {
+			logger.WithError(err2).WithField(errorCodeField, errorCodeArtifactsFailed).Error("failed to deliver artifacts")
+			continue
+		}
+		locations = append(locations, location)
+	}
+	if len(locations) == 0 {
+		return
+	}
+
+	logger.WithField("artifacts", locations).WithField("files", count).Info("artifacts collected")
+	err = ts.recordArtifacts(locations)
+	if err != nil {
+		logger.WithError(err).WithField(errorCodeField, errorCodeTombstoneWriteFailed).Error()
+	}
+}
@@ -0,0 +1,12 @@
+package main
+
+// commands are kubexit subcommands, selected by the first argument.
+// Anything else is a child command to supervise, so to supervise a program named as a subcommand use its path.
+var commands = map[string]func(args []string) int{
+	"ctl":       runCtl,
+	"graph":     runGraph,
+	"install":   runInstall,
+	"simulate":  runSimulate,
+	"tombstone": runTombstone,
+	"webhook":   runWebhook,
+}
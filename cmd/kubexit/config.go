@@ -1,38 +1,555 @@
 package main
 
 import (
-	"os"
+	"bytes"
+	"io/ioutil"
+	"net"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/drain"
+	"github.com/ispringtech/kubexit/pkg/kubexit"
+	"github.com/ispringtech/kubexit/pkg/readiness"
+	"github.com/ispringtech/kubexit/pkg/retry"
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+	"github.com/ispringtech/kubexit/pkg/timestamp"
+	"github.com/ispringtech/kubexit/pkg/tombstone"
+)
+
+// IP families of listeners and network checks
+const (
+	ipFamilyDual = "dual"
+	ipFamilyIPv4 = "ipv4"
+	ipFamilyIPv6 = "ipv6"
 )
 
-// json tags added to be able to Marshall config to json
+const (
+	graveyardWatchAuto   = "auto"
+	graveyardWatchNotify = "notify"
+	graveyardWatchPoll   = "poll"
+
+	graveyardScopePod       = "pod"
+	graveyardScopeNode      = "node"
+	graveyardScopeNodeAgent = "node-agent"
+)
+
+// json tags added to be able to Marshall config to json.
+// Each tag matches env var KUBEXIT_<TAG>, which is relied on by KUBEXIT_CONFIG_JSON.
 type config struct {
 	Name           string        `json:"name"`
 	Graveyard      string        `json:"graveyard"`
-	BirthDeps      []string      `json:"birth_deps"`
-	DeathDeps      []string      `json:"death_deps"`
-	BirthTimeout   time.Duration `json:"birth_timeout"`
-	GracePeriod    time.Duration `json:"grace_period"`
-	PodName        string        `json:"pod_name"`
-	Namespace      string        `json:"namespace"`
-	VerboseLevel   int           `json:"verbose_level"`
-	InstantLogging bool          `json:"instant_logging"`
+	GraveyardWatch string        `json:"graveyard_watch"`
+	GraveyardScope string        `json:"graveyard_scope"`
+	PollInterval   time.Duration `json:"poll_interval"`
+	// CoalesceWindow merges fsnotify events of a tombstone, so the handler reads it once per write
+	CoalesceWindow time.Duration `json:"coalesce_window"`
+	// GraveyardReadOnly participant only watches the graveyard, never writing to it
+	GraveyardReadOnly bool `json:"graveyard_readonly"`
+	// GraveyardLossPolicy applies when the graveyard is removed mid-run and can't be recreated
+	GraveyardLossPolicy string `json:"graveyard_loss_policy"`
+
+	WatchdogStaleAfter time.Duration `json:"watchdog_stale_after"`
+
+	// PodWatchTimeout renews pod watch requests, zero leaves the client default
+	PodWatchTimeout   time.Duration `json:"pod_watch_timeout"`
+	PodWatchBookmarks bool          `json:"pod_watch_bookmarks"`
+
+	// Manifest is the path of shared coordination manifest of the pod, with config sections of all participants
+	Manifest string `json:"manifest"`
+
+	BirthDeps    []string      `json:"birth_deps"`
+	DeathDeps    []string      `json:"death_deps"`
+	BirthTimeout time.Duration `json:"birth_timeout"`
+	// ZeroExitOnDeathDep makes kubexit exit with zero code when the child exits by the shutdown signal sent on death of a death dep
+	ZeroExitOnDeathDep bool `json:"zero_exit_on_death_dep"`
+
+	BirthCheckInterval time.Duration `json:"birth_check_interval"`
+	// BirthDepsLostPolicy is applied when a birth dep is not ready for BirthDepsLostAfter while the child runs:
+	// log, signal, restart or shutdown. Empty disables checking birth deps after birth
+	BirthDepsLostPolicy string         `json:"birth_deps_lost_policy"`
+	BirthDepsLostAfter  time.Duration  `json:"birth_deps_lost_after"`
+	BirthDepsLostSignal syscall.Signal `json:"birth_deps_lost_signal"`
+	// BirthDepsRecoveredAfter is the time a lost or not ready birth dep must stay ready to recover, so blips don't reset the loss
+	BirthDepsRecoveredAfter time.Duration `json:"birth_deps_recovered_after"`
+	// BirthDepsRecoveredSignal is sent to the child when lost birth dep recovers, e.g. to reconnect. Zero disables
+	BirthDepsRecoveredSignal syscall.Signal `json:"birth_deps_recovered_signal"`
+
+	// DNS* configure name resolution of tcp, http, kubexit and dns birth deps and probes, see readiness.ResolverOptions
+	DNSNameserver string        `json:"dns_nameserver"`
+	DNSTimeout    time.Duration `json:"dns_timeout"`
+	DNSCacheTTL   time.Duration `json:"dns_cache_ttl"`
+	// IPFamily restricts listeners and network checks to ipv4 or ipv6, dual by default
+	IPFamily string `json:"ip_family"`
+
+	WaitPortsFree      []int         `json:"wait_ports_free"`
+	PortReleaseTimeout time.Duration `json:"port_release_timeout"`
+
+	ExclusiveLock        string        `json:"exclusive_lock"`
+	ExclusiveLockDir     string        `json:"exclusive_lock_dir"`
+	ExclusiveLockTimeout time.Duration `json:"exclusive_lock_timeout"`
+
+	GracePeriod time.Duration `json:"grace_period"`
+	// GracePeriodQoS scales default grace period by pod QoS class and container CPU limit
+	GracePeriodQoS bool `json:"grace_period_qos"`
+	// SigtermPolicy is SIGTERM handling by phase: birth, running, draining
+	SigtermPolicy map[string]string `json:"sigterm_policy"`
+	// ShutdownSignal is sent to the child for graceful shutdown and in place of received SIGTERM
+	ShutdownSignal syscall.Signal `json:"shutdown_signal"`
+	// ShutdownEscalation are signals sent to the child still running during graceful shutdown
+	ShutdownEscalation []supervisor.EscalationStep `json:"shutdown_escalation"`
+	// ForwardSignals, if set, are the only signals forwarded to the child besides SIGTERM
+	ForwardSignals []syscall.Signal `json:"forward_signals"`
+	// IgnoreSignals are not forwarded to the child
+	IgnoreSignals []syscall.Signal `json:"ignore_signals"`
+	// ExitCodeMap remaps the exit code of the dead child, recorded in the tombstone and returned by kubexit, e.g. 143 to 0
+	ExitCodeMap map[int]int `json:"exit_code_map"`
+	// RestartExitCode of the child requests its restart in place, without recording death. Zero disables
+	RestartExitCode int `json:"restart_exit_code"`
+	// RestartLimit bounds restarts in place by kubexit subsystems, e.g. watchdog, but not requested with RestartExitCode.
+	// Zero is unlimited
+	RestartLimit int `json:"restart_limit"`
+	// RestartExhausted is the exit policy when RestartLimit or RestartMaxRetries is reached: crash or sleep
+	RestartExhausted      string        `json:"restart_exhausted"`
+	RestartExhaustedSleep time.Duration `json:"restart_exhausted_sleep"`
+	// RestartPolicy restarts the exited child in place: never, on-failure or always.
+	// RestartMaxRetries bounds consecutive restarts by it, zero is unlimited
+	RestartPolicy     string `json:"restart_policy"`
+	RestartMaxRetries int    `json:"restart_max_retries"`
+	// RestartBackoff is the delay of the first restart by RestartPolicy, doubled by consecutive ones up to RestartBackoffMax
+	RestartBackoff    time.Duration `json:"restart_backoff"`
+	RestartBackoffMax time.Duration `json:"restart_backoff_max"`
+	// RestartReady is the readiness check of new child in overlapped restart, birth dep spec except container
+	RestartReady        string        `json:"restart_ready"`
+	RestartReadyTimeout time.Duration `json:"restart_ready_timeout"`
+
+	// RuntimeTuning are runtimes of the child to size by cgroup limits: go, java
+	RuntimeTuning []string `json:"runtime_tuning"`
+
+	// PreStartCmd and PostStopCmd are hook commands run before the child is started and after it exits, split by spaces
+	PreStartCmd     string        `json:"pre_start_cmd"`
+	PreStartTimeout time.Duration `json:"pre_start_timeout"`
+	PostStopCmd     string        `json:"post_stop_cmd"`
+	PostStopTimeout time.Duration `json:"post_stop_timeout"`
+
+	DrainCheck         string        `json:"drain_check"`
+	DrainCheckInterval time.Duration `json:"drain_check_interval"`
+	DrainStallTimeout  time.Duration `json:"drain_stall_timeout"`
+	DrainMaxGrace      time.Duration `json:"drain_max_grace"`
+
+	ControlSocket     string        `json:"control_socket"`
+	GraceExtensionMax time.Duration `json:"grace_extension_max"`
+
+	// ShutdownAck are sources of the child acknowledgement of graceful shutdown: http, file, notify
+	ShutdownAck        []string      `json:"shutdown_ack"`
+	ShutdownAckWindow  time.Duration `json:"shutdown_ack_window"`
+	ShutdownAckRetries int           `json:"shutdown_ack_retries"`
+	ShutdownAckFile    string        `json:"shutdown_ack_file"`
+	// Watchdog is the interval for the child to ping kubexit within, zero disables
+	Watchdog time.Duration `json:"watchdog"`
+	// WatchdogAction is applied to the child missing ping: restart or shutdown
+	WatchdogAction string `json:"watchdog_action"`
+	// NotifySocket is the path of sd_notify socket passed to the child
+	NotifySocket string `json:"notify_socket"`
+
+	// RunAsUser and RunAsGroup are names or ids the child runs as, kubexit ones when empty
+	RunAsUser  string `json:"run_as_user"`
+	RunAsGroup string `json:"run_as_group"`
+	// RunAs are the ids resolved from RunAsUser and RunAsGroup, nil when both are empty
+	RunAs *runAs `json:"-"`
+
+	// LivenessProbe is tcp, http or exec probe of the running child, empty disables
+	LivenessProbe            string        `json:"liveness_probe"`
+	LivenessInitialDelay     time.Duration `json:"liveness_initial_delay"`
+	LivenessInterval         time.Duration `json:"liveness_interval"`
+	LivenessTimeout          time.Duration `json:"liveness_timeout"`
+	LivenessFailureThreshold int           `json:"liveness_failure_threshold"`
+	// LivenessAction is applied to the child failing LivenessFailureThreshold probes in a row: restart or shutdown
+	LivenessAction string `json:"liveness_action"`
+
+	// OutputDir is the directory child stdout and stderr are copied to, in addition to the container ones, empty disables
+	OutputDir string `json:"output_dir"`
+	// OutputMaxSizeMB is the size of output files rotated after it, OutputMaxFiles is the number of rotated files kept
+	OutputMaxSizeMB int  `json:"output_max_size_mb"`
+	OutputMaxFiles  int  `json:"output_max_files"`
+	OutputCompress  bool `json:"output_compress"`
+	// OutputFormat of child output written to the container streams: raw or json
+	OutputFormat string `json:"output_format"`
+
+	PodName        string `json:"pod_name"`
+	Namespace      string `json:"namespace"`
+	VerboseLevel   int    `json:"verbose_level"`
+	InstantLogging bool   `json:"instant_logging"`
+	MetricsAddr    string `json:"metrics_addr"`
+	// MetricsNetwork is the network of MetricsAddr resolved by listenAddress: unix or tcp of IPFamily
+	MetricsNetwork string `json:"-"`
+	// MetricsExpose binds port only MetricsAddr on all interfaces instead of loopback
+	MetricsExpose bool `json:"metrics_expose"`
+	// Verbose overrides VerboseLevel of traces by trace keys, see traceKey
+	Verbose map[string]int `json:"verbose"`
+	// Quiet suppresses kubexit logs but errors, so stderr of the container is the child one
+	Quiet bool `json:"quiet"`
+
+	ChildSampleInterval time.Duration `json:"child_sample_interval"`
+
+	SelfMonitorInterval time.Duration `json:"self_monitor_interval"`
+	SelfMaxGoroutines   int           `json:"self_max_goroutines"`
+	SelfMaxFDs          int           `json:"self_max_fds"`
+	SelfMaxRSSMB        int           `json:"self_max_rss_mb"`
+	DumpOnSIGUSR1       bool          `json:"dump_on_sigusr1"`
+
+	PodAnnotations bool `json:"pod_annotations"`
+	PodCondition   bool `json:"pod_condition"`
+
+	ContainerName   string `json:"container_name"`
+	ContainerStatus bool   `json:"container_status"`
+	Image           string `json:"image"`
+	// CheckContainerNames warns about participant and dep names not matching pod containers
+	CheckContainerNames bool `json:"check_container_names"`
+	// HandlerErrorEvents raises Warning Events of the pod on repeated errors of graveyard and pod watch handlers
+	HandlerErrorEvents bool `json:"handler_error_events"`
+
+	Audit bool `json:"audit"`
+	// TracePersist restores event traces of previous incarnations of the container from the graveyard
+	TracePersist bool `json:"trace_persist"`
+
+	// ClockSkewMax enables clock check against the API server, warning when the skew exceeds it
+	ClockSkewMax time.Duration `json:"clock_skew_max"`
+
+	// TimestampFormat is the layout of timestamps in tombstones, logs, event traces and audit log
+	TimestampFormat string `json:"timestamp_format"`
+	// TimestampUTC writes timestamps in UTC instead of the local time zone
+	TimestampUTC bool `json:"timestamp_utc"`
+
+	TombstoneTimeout       time.Duration `json:"tombstone_timeout"`
+	TombstoneFailurePolicy string        `json:"tombstone_failure_policy"`
+	TombstoneTTL           time.Duration `json:"tombstone_ttl"`
+	TombstoneKeyFile       string        `json:"tombstone_key_file"`
+	TombstoneFallback      string        `json:"tombstone_fallback"`
+	TombstoneStrict        bool          `json:"tombstone_strict"`
+	TombstoneFormat        string        `json:"tombstone_format"`
+	// TombstoneLabels are written to the tombstone as is
+	TombstoneLabels map[string]string `json:"tombstone_labels"`
+	// TombstoneMirrors are graveyards the tombstone is mirrored to, e.g. node-local one
+	TombstoneMirrors []tombstoneMirror `json:"tombstone_mirrors"`
+	// TombstoneKey is read from TombstoneKeyFile, never logged
+	TombstoneKey []byte `json:"-"`
+
+	// FatalTimeout bounds stopping the child and recording death after a terminal error, zero disables
+	FatalTimeout time.Duration `json:"fatal_timeout"`
+
+	// Retry* is the policy of retries shared by tombstone writes, pod watch reconnects, kubernetes clients and webhooks
+	RetryInitial    time.Duration `json:"retry_initial"`
+	RetryMax        time.Duration `json:"retry_max"`
+	RetryMaxElapsed time.Duration `json:"retry_max_elapsed"`
+	RetryJitter     float64       `json:"retry_jitter"`
+
+	TerminationMessagePath string `json:"termination_message_path"`
+
+	HoldOnFailure time.Duration `json:"hold_on_failure"`
+
+	Artifacts        []string      `json:"artifacts"`
+	ArtifactsOn      string        `json:"artifacts_on"`
+	ArtifactsDir     string        `json:"artifacts_dir"`
+	ArtifactsWebhook string        `json:"artifacts_webhook"`
+	ArtifactsTimeout time.Duration `json:"artifacts_timeout"`
+
+	UploadURL      string        `json:"upload_url"`
+	UploadEndpoint string        `json:"upload_endpoint"`
+	UploadRegion   string        `json:"upload_region"`
+	UploadOn       string        `json:"upload_on"`
+	UploadTimeout  time.Duration `json:"upload_timeout"`
+	PodUID         string        `json:"pod_uid"`
+
+	// DeliveryDeadline bounds artifacts deliveries and uploads before the death together, up to GracePeriod
+	DeliveryDeadline time.Duration `json:"delivery_deadline"`
+	// DeliveryBreakerThreshold is the number of failed attempts in a row after which a delivery target is not retried, zero disables
+	DeliveryBreakerThreshold int `json:"delivery_breaker_threshold"`
+
+	// ConfigAnnotations reads config values from pod annotations, see configSource
+	ConfigAnnotations bool `json:"config_annotations"`
+
+	// Command is the child command and args, used when none are passed to kubexit. Set by KUBEXIT_CONFIG_JSON only
+	Command []string `json:"-"`
+}
+
+// tombstoneName is the name of own tombstone in graveyard, prefixed by pod uid in node scoped graveyard
+func (c *config) tombstoneName() string {
+	if c.GraveyardScope == graveyardScopeNode {
+		return c.PodUID + "." + c.Name
+	}
+	return c.Name
+}
+
+// timeFormat is the format of timestamps in tombstones, logs, event traces and audit log
+func (c *config) timeFormat() timestamp.Format {
+	return timestamp.Format{
+		Layout: c.TimestampFormat,
+		UTC:    c.TimestampUTC,
+	}
+}
+
+// retryPolicy returns the retry policy shared by kubexit subsystems
+func (c *config) retryPolicy() retry.Policy {
+	return retry.Policy{
+		Initial:    c.RetryInitial,
+		Max:        c.RetryMax,
+		MaxElapsed: c.RetryMaxElapsed,
+		Jitter:     c.RetryJitter,
+	}
+}
+
+// remapExitCode returns the exit code of the child remapped by ExitCodeMap
+func (c *config) remapExitCode(code int) int {
+	if remapped, ok := c.ExitCodeMap[code]; ok {
+		return remapped
+	}
+	return code
+}
+
+func (c *config) resolverOptions() readiness.ResolverOptions {
+	return readiness.ResolverOptions{
+		Nameserver: c.DNSNameserver,
+		Timeout:    c.DNSTimeout,
+		CacheTTL:   c.DNSCacheTTL,
+		Network:    c.network(),
+	}
+}
+
+// network returns tcp network of IPFamily, for listeners and dials
+func (c *config) network() string {
+	return tcpNetwork(c.IPFamily)
+}
+
+func tcpNetwork(ipFamily string) string {
+	switch ipFamily {
+	case ipFamilyIPv4:
+		return "tcp4"
+	case ipFamilyIPv6:
+		return "tcp6"
+	default:
+		return "tcp"
+	}
+}
+
+// sigtermPolicy returns SIGTERM policy of the phase, the default one of termPhases when unset,
+// so config built without parseConfig, e.g. by tests, keeps the default behavior
+func (c *config) sigtermPolicy(phase string) string {
+	if policy := c.SigtermPolicy[phase]; policy != "" {
+		return policy
+	}
+	return termPhases[phase]
+}
+
+// traceVerboseLevel returns verbose level of the event trace with id
+func (c *config) traceVerboseLevel(id string) int {
+	if level, ok := c.Verbose[traceKey(id)]; ok {
+		return level
+	}
+	return c.VerboseLevel
+}
+
+// traceAliases are short keys of event traces in KUBEXIT_VERBOSE
+var traceAliases = map[string]string{
+	"death-graveyard-watcher":    "death-watcher",
+	"birth-dependencies-watcher": "birth-watcher",
+}
+
+// traceKey returns key of event trace id in KUBEXIT_VERBOSE: id in lower case with dashes, e.g. state-publisher,
+// shortened for watchers, and tombstone for the tombstone trace
+func traceKey(id string) string {
+	key := strings.ReplaceAll(strings.ToLower(id), " ", "-")
+	if alias, ok := traceAliases[key]; ok {
+		return alias
+	}
+	if strings.HasSuffix(key, "-tombstone") {
+		return "tombstone"
+	}
+	return key
+}
+
+// parseVerboseLevels parses key=level pairs of KUBEXIT_VERBOSE
+func parseVerboseLevels(pairs []string) (map[string]int, error) {
+	values, err := parsePairs("KUBEXIT_VERBOSE", pairs)
+	if err != nil || values == nil {
+		return nil, err
+	}
+	levels := make(map[string]int, len(values))
+	for key, value := range values {
+		level, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid KUBEXIT_VERBOSE %s=%s", key, value)
+		}
+		levels[key] = level
+	}
+	return levels, nil
+}
+
+// termPhases are phases of KUBEXIT_SIGTERM_POLICY with default policies, matching kubexit behavior before it
+var termPhases = map[string]string{
+	termPhaseBirth:    supervisor.TermCancel,
+	termPhaseRunning:  supervisor.TermForward,
+	termPhaseDraining: supervisor.TermForward,
+}
+
+const (
+	termPhaseBirth    = "birth"
+	termPhaseRunning  = "running"
+	termPhaseDraining = "draining"
+)
+
+// parseTermPolicy parses phase=policy pairs of KUBEXIT_SIGTERM_POLICY, defaulting unset phases
+func parseTermPolicy(pairs []string) (map[string]string, error) {
+	values, err := parsePairs("KUBEXIT_SIGTERM_POLICY", pairs)
+	if err != nil {
+		return nil, err
+	}
+	policy := make(map[string]string, len(termPhases))
+	for phase, def := range termPhases {
+		policy[phase] = def
+	}
+	for phase, value := range values {
+		if _, ok := termPhases[phase]; !ok {
+			return nil, errors.Errorf("invalid KUBEXIT_SIGTERM_POLICY phase %s, expected one of: %s, %s, %s", phase, termPhaseBirth, termPhaseRunning, termPhaseDraining)
+		}
+		switch value {
+		case supervisor.TermCancel, supervisor.TermForward, supervisor.TermGrace:
+		default:
+			return nil, errors.Errorf("invalid KUBEXIT_SIGTERM_POLICY %s=%s, expected one of: %s, %s, %s", phase, value, supervisor.TermCancel, supervisor.TermForward, supervisor.TermGrace)
+		}
+		policy[phase] = value
+	}
+	return policy, nil
+}
+
+// parseShutdownEscalation parses signal=delay steps of KUBEXIT_SHUTDOWN_ESCALATION, delays counting from the shutdown signal.
+// A signal may be repeated, so the steps are not parsed as pairs
+func parseShutdownEscalation(items []string) ([]supervisor.EscalationStep, error) {
+	var steps []supervisor.EscalationStep
+	for _, item := range items {
+		i := strings.Index(item, "=")
+		if i <= 0 {
+			return nil, errors.Errorf("invalid KUBEXIT_SHUTDOWN_ESCALATION %s, expected signal=delay", item)
+		}
+		sig, err := parseSignal(strings.TrimSpace(item[:i]))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid KUBEXIT_SHUTDOWN_ESCALATION")
+		}
+		after, err := time.ParseDuration(strings.TrimSpace(item[i+1:]))
+		if err != nil || after <= 0 {
+			return nil, errors.Errorf("invalid KUBEXIT_SHUTDOWN_ESCALATION %s, expected positive delay", item)
+		}
+		steps = append(steps, supervisor.EscalationStep{Signal: sig, After: after})
+	}
+	return steps, nil
+}
+
+// parseTombstoneMirrors parses path[=policy] items of KUBEXIT_TOMBSTONE_MIRRORS, failures of mirrors are ignored by default,
+// as the graveyard is the primary location
+func parseTombstoneMirrors(items []string, graveyard string) ([]tombstoneMirror, error) {
+	var mirrors []tombstoneMirror
+	for _, item := range items {
+		mirror := tombstoneMirror{Graveyard: item, FailurePolicy: tombstoneFailurePolicyIgnore}
+		if i := strings.Index(item, "="); i >= 0 {
+			mirror.Graveyard = strings.TrimSpace(item[:i])
+			mirror.FailurePolicy = strings.TrimSpace(item[i+1:])
+		}
+		switch mirror.FailurePolicy {
+		case tombstoneFailurePolicyFail, tombstoneFailurePolicyIgnore:
+		default:
+			return nil, errors.Errorf("invalid KUBEXIT_TOMBSTONE_MIRRORS %s, expected failure policy one of: fail, ignore", item)
+		}
+		if mirror.Graveyard == "" || filepath.Clean(mirror.Graveyard) == filepath.Clean(graveyard) {
+			return nil, errors.Errorf("invalid KUBEXIT_TOMBSTONE_MIRRORS %s, expected location other than graveyard", item)
+		}
+		mirrors = append(mirrors, mirror)
+	}
+	return mirrors, nil
+}
+
+// parsePairs parses key=value pairs of env var, nil if empty
+func parsePairs(name string, pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, errors.Errorf("invalid %s %s, expected key=value", name, pair)
+		}
+		values[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	return values, nil
+}
+
+// parseExitCodeMap parses from=to pairs of exit codes
+func parseExitCodeMap(pairs []string) (map[int]int, error) {
+	values, err := parsePairs("KUBEXIT_EXIT_CODE_MAP", pairs)
+	if err != nil || values == nil {
+		return nil, err
+	}
+	codes := make(map[int]int, len(values))
+	for from, to := range values {
+		fromCode, err2 := strconv.Atoi(from)
+		if err2 != nil || fromCode < 0 || fromCode > 255 {
+			return nil, errors.Errorf("invalid exit code %s in KUBEXIT_EXIT_CODE_MAP, expected 0-255", from)
+		}
+		toCode, err2 := strconv.Atoi(to)
+		if err2 != nil || toCode < 0 || toCode > 255 {
+			return nil, errors.Errorf("invalid exit code %s in KUBEXIT_EXIT_CODE_MAP, expected 0-255", to)
+		}
+		codes[fromCode] = toCode
+	}
+	return codes, nil
 }
 
 func parseConfig() (*config, error) {
-	var err error
+	env, err := newConfigSource()
+	if err != nil {
+		return nil, err
+	}
 
-	name := os.Getenv("KUBEXIT_NAME")
+	name := env.Get("KUBEXIT_NAME")
 	if name == "" {
 		return nil, errors.New("missing env var: KUBEXIT_NAME")
 	}
 
-	graveyard := os.Getenv("KUBEXIT_GRAVEYARD")
+	configAnnotations, err := env.Bool(configAnnotationsEnv, false)
+	if err != nil {
+		return nil, err
+	}
+	if configAnnotations {
+		// pod is located by env vars or the document only
+		annotations, err2 := getPodAnnotations(env.Get("KUBEXIT_NAMESPACE"), env.Get("KUBEXIT_POD_NAME"))
+		if err2 != nil {
+			return nil, errors.Wrap(err2, "failed to read config from pod annotations")
+		}
+		err = env.SetAnnotations(annotations, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// manifest section is the lowest precedence source, so it's set after annotations which may point to the manifest
+	manifestPath := env.Get("KUBEXIT_MANIFEST")
+	var podManifest manifest
+	if manifestPath != "" {
+		podManifest, err = readManifest(manifestPath)
+		if err != nil {
+			return nil, err
+		}
+		err = env.SetManifest(podManifest, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	graveyard := env.Get("KUBEXIT_GRAVEYARD")
 	if graveyard == "" {
 		graveyard = "/graveyard"
 	} else {
@@ -40,74 +557,1027 @@ func parseConfig() (*config, error) {
 		graveyard = filepath.Clean(graveyard)
 	}
 
-	birthDepsStr := os.Getenv("KUBEXIT_BIRTH_DEPS")
-	var birthDeps []string
-	if birthDepsStr != "" {
-		birthDeps = strings.Split(birthDepsStr, ",")
+	graveyardWatch := env.Get("KUBEXIT_GRAVEYARD_WATCH")
+	switch graveyardWatch {
+	case "":
+		graveyardWatch = graveyardWatchAuto
+	case graveyardWatchAuto, graveyardWatchNotify, graveyardWatchPoll:
+	default:
+		return nil, errors.Errorf("invalid graveyard watch mode %s, expected one of: auto, notify, poll", graveyardWatch)
+	}
+
+	podUID := env.Get("KUBEXIT_POD_UID")
+
+	graveyardScope := env.Get("KUBEXIT_GRAVEYARD_SCOPE")
+	switch graveyardScope {
+	case "":
+		graveyardScope = graveyardScopePod
+	case graveyardScopePod, graveyardScopeNodeAgent:
+	case graveyardScopeNode:
+		if podUID == "" {
+			return nil, errors.New("node graveyard scope requires KUBEXIT_POD_UID")
+		}
+	default:
+		return nil, errors.Errorf("invalid graveyard scope %s, expected one of: pod, node, node-agent", graveyardScope)
+	}
+
+	graveyardReadOnly, err := env.Bool("KUBEXIT_GRAVEYARD_READONLY", false)
+	if err != nil {
+		return nil, err
+	}
+
+	graveyardLossPolicy := env.Get("KUBEXIT_GRAVEYARD_LOSS_POLICY")
+	switch graveyardLossPolicy {
+	case "":
+		graveyardLossPolicy = kubexit.GraveyardLossWarn
+	case kubexit.GraveyardLossIgnore, kubexit.GraveyardLossWarn, kubexit.GraveyardLossDeath:
+	default:
+		return nil, errors.Errorf("invalid graveyard loss policy %s, expected one of: ignore, warn, death", graveyardLossPolicy)
+	}
+
+	pollInterval, err := env.Duration("KUBEXIT_POLL_INTERVAL", time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	coalesceWindow, err := env.Duration("KUBEXIT_COALESCE_WINDOW", tombstone.DefaultCoalesceWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	watchdogStaleAfter, err := env.Duration("KUBEXIT_WATCHDOG_STALE_AFTER", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	podWatchTimeout, err := env.Duration("KUBEXIT_POD_WATCH_TIMEOUT", 0)
+	if err != nil {
+		return nil, err
+	}
+	if podWatchTimeout != 0 && podWatchTimeout < time.Second {
+		return nil, errors.Errorf("invalid KUBEXIT_POD_WATCH_TIMEOUT %s, expected at least 1s", podWatchTimeout)
+	}
+
+	podWatchBookmarks, err := env.Bool("KUBEXIT_POD_WATCH_BOOKMARKS", true)
+	if err != nil {
+		return nil, err
+	}
+
+	birthDeps := env.List("KUBEXIT_BIRTH_DEPS")
+	deathDeps := env.List("KUBEXIT_DEATH_DEPS")
+
+	zeroExitOnDeathDep, err := env.Bool("KUBEXIT_ZERO_EXIT_ON_DEATH_DEP", false)
+	if err != nil {
+		return nil, err
+	}
+
+	deps, err := readiness.ParseDeps(birthDeps)
+	if err != nil {
+		return nil, err
+	}
+
+	if podManifest != nil {
+		err = checkDependencyCycles(podManifest, name, birthDeps, deathDeps)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	birthTimeout, err := env.Duration("KUBEXIT_BIRTH_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	birthCheckInterval, err := env.Duration("KUBEXIT_BIRTH_CHECK_INTERVAL", time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	birthDepsLostPolicy := env.Get("KUBEXIT_BIRTH_DEPS_LOST_POLICY")
+	switch birthDepsLostPolicy {
+	case "", depLostLog, depLostSignal, depLostRestart, depLostShutdown:
+	default:
+		return nil, errors.Errorf("invalid KUBEXIT_BIRTH_DEPS_LOST_POLICY %s, expected one of: log, signal, restart, shutdown", birthDepsLostPolicy)
+	}
+
+	birthDepsLostAfter, err := env.Duration("KUBEXIT_BIRTH_DEPS_LOST_AFTER", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	birthDepsLostSignal := syscall.SIGHUP
+	if signalName := env.Get("KUBEXIT_BIRTH_DEPS_LOST_SIGNAL"); signalName != "" {
+		birthDepsLostSignal, err = parseSignal(signalName)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid KUBEXIT_BIRTH_DEPS_LOST_SIGNAL")
+		}
+	}
+
+	birthDepsRecoveredAfter, err := env.Duration("KUBEXIT_BIRTH_DEPS_RECOVERED_AFTER", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var birthDepsRecoveredSignal syscall.Signal
+	if signalName := env.Get("KUBEXIT_BIRTH_DEPS_RECOVERED_SIGNAL"); signalName != "" {
+		birthDepsRecoveredSignal, err = parseSignal(signalName)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid KUBEXIT_BIRTH_DEPS_RECOVERED_SIGNAL")
+		}
+	}
+
+	dnsNameserver := env.Get("KUBEXIT_DNS_NAMESERVER")
+	if dnsNameserver != "" {
+		if _, _, err2 := net.SplitHostPort(dnsNameserver); err2 != nil {
+			// host only, IPv6 literal may be bracketed
+			dnsNameserver = net.JoinHostPort(strings.Trim(dnsNameserver, "[]"), "53")
+		}
+		if _, _, err2 := net.SplitHostPort(dnsNameserver); err2 != nil {
+			return nil, errors.Wrap(err2, "invalid KUBEXIT_DNS_NAMESERVER")
+		}
+	}
+
+	ipFamily := env.Get("KUBEXIT_IP_FAMILY")
+	switch ipFamily {
+	case "":
+		ipFamily = ipFamilyDual
+	case ipFamilyDual, ipFamilyIPv4, ipFamilyIPv6:
+	default:
+		return nil, errors.Errorf("invalid KUBEXIT_IP_FAMILY %s, expected one of: dual, ipv4, ipv6", ipFamily)
+	}
+
+	dnsTimeout, err := env.Duration("KUBEXIT_DNS_TIMEOUT", 0)
+	if err != nil {
+		return nil, err
+	}
+	if dnsTimeout < 0 {
+		return nil, errors.Errorf("invalid KUBEXIT_DNS_TIMEOUT %s, expected non-negative", dnsTimeout)
+	}
+
+	dnsCacheTTL, err := env.Duration("KUBEXIT_DNS_CACHE_TTL", 0)
+	if err != nil {
+		return nil, err
+	}
+	if dnsCacheTTL < 0 {
+		return nil, errors.Errorf("invalid KUBEXIT_DNS_CACHE_TTL %s, expected non-negative", dnsCacheTTL)
+	}
+
+	var waitPortsFree []int
+	for _, item := range env.List("KUBEXIT_WAIT_PORTS_FREE") {
+		port, err2 := strconv.Atoi(item)
+		if err2 != nil || port < 1 || port > 65535 {
+			return nil, errors.Errorf("invalid port %s in KUBEXIT_WAIT_PORTS_FREE", item)
+		}
+		waitPortsFree = append(waitPortsFree, port)
+	}
+
+	portReleaseTimeout, err := env.Duration("KUBEXIT_PORT_RELEASE_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	exclusiveLock := env.Get("KUBEXIT_EXCLUSIVE_LOCK")
+	if strings.ContainsRune(exclusiveLock, '/') {
+		return nil, errors.Errorf("invalid exclusive lock name %s", exclusiveLock)
+	}
+
+	exclusiveLockDir := env.Get("KUBEXIT_EXCLUSIVE_LOCK_DIR")
+	if exclusiveLockDir == "" {
+		exclusiveLockDir = graveyard
+	}
+
+	exclusiveLockTimeout, err := env.Duration("KUBEXIT_EXCLUSIVE_LOCK_TIMEOUT", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	gracePeriod, err := env.Duration("KUBEXIT_GRACE_PERIOD", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	gracePeriodQoS, err := env.Bool("KUBEXIT_GRACE_PERIOD_QOS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	sigtermPolicy, err := parseTermPolicy(env.List("KUBEXIT_SIGTERM_POLICY"))
+	if err != nil {
+		return nil, err
+	}
+
+	shutdownSignal := syscall.SIGTERM
+	if signalName := env.Get("KUBEXIT_SHUTDOWN_SIGNAL"); signalName != "" {
+		shutdownSignal, err = parseSignal(signalName)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid KUBEXIT_SHUTDOWN_SIGNAL")
+		}
+		if shutdownSignal == syscall.SIGKILL {
+			return nil, errors.Errorf("invalid KUBEXIT_SHUTDOWN_SIGNAL %s, SIGKILL is the escalation of graceful shutdown", signalName)
+		}
+	}
+
+	shutdownEscalation, err := parseShutdownEscalation(env.List("KUBEXIT_SHUTDOWN_ESCALATION"))
+	if err != nil {
+		return nil, err
+	}
+
+	forwardSignals, err := parseSignals("KUBEXIT_FORWARD_SIGNALS", env.List("KUBEXIT_FORWARD_SIGNALS"))
+	if err != nil {
+		return nil, err
+	}
+
+	ignoreSignals, err := parseSignals("KUBEXIT_IGNORE_SIGNALS", env.List("KUBEXIT_IGNORE_SIGNALS"))
+	if err != nil {
+		return nil, err
+	}
+	for _, sig := range ignoreSignals {
+		if sig == syscall.SIGTERM {
+			return nil, errors.New("invalid KUBEXIT_IGNORE_SIGNALS, SIGTERM is handled by KUBEXIT_SIGTERM_POLICY")
+		}
+	}
+
+	exitCodeMap, err := parseExitCodeMap(env.List("KUBEXIT_EXIT_CODE_MAP"))
+	if err != nil {
+		return nil, err
+	}
+
+	restartExitCode, err := env.Int("KUBEXIT_RESTART_EXIT_CODE", 0)
+	if err != nil {
+		return nil, err
+	}
+	if restartExitCode < 0 || restartExitCode > 255 {
+		return nil, errors.Errorf("invalid KUBEXIT_RESTART_EXIT_CODE %d, expected 0-255, 0 disables", restartExitCode)
+	}
+
+	restartLimit, err := env.Int("KUBEXIT_RESTART_LIMIT", 0)
+	if err != nil {
+		return nil, err
+	}
+	if restartLimit < 0 {
+		return nil, errors.Errorf("invalid KUBEXIT_RESTART_LIMIT %d, expected non-negative", restartLimit)
+	}
+
+	restartExhausted := env.Get("KUBEXIT_RESTART_EXHAUSTED")
+	switch restartExhausted {
+	case "":
+		restartExhausted = restartExhaustedCrash
+	case restartExhaustedCrash, restartExhaustedSleep:
+	default:
+		return nil, errors.Errorf("invalid KUBEXIT_RESTART_EXHAUSTED %s, expected one of: crash, sleep", restartExhausted)
+	}
+
+	restartExhaustedSleep, err := env.Duration("KUBEXIT_RESTART_EXHAUSTED_SLEEP", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	restartPolicy := env.Get("KUBEXIT_RESTART_POLICY")
+	switch restartPolicy {
+	case "":
+		restartPolicy = supervisor.RestartNever
+	case supervisor.RestartNever, supervisor.RestartOnFailure, supervisor.RestartAlways:
+	default:
+		return nil, errors.Errorf("invalid KUBEXIT_RESTART_POLICY %s, expected one of: never, on-failure, always", restartPolicy)
+	}
+
+	restartMaxRetries, err := env.Int("KUBEXIT_RESTART_MAX_RETRIES", 0)
+	if err != nil {
+		return nil, err
+	}
+	if restartMaxRetries < 0 {
+		return nil, errors.Errorf("invalid KUBEXIT_RESTART_MAX_RETRIES %d, expected non-negative", restartMaxRetries)
+	}
+
+	restartBackoff, err := env.Duration("KUBEXIT_RESTART_BACKOFF", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	restartBackoffMax, err := env.Duration("KUBEXIT_RESTART_BACKOFF_MAX", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if restartBackoff <= 0 || restartBackoffMax < restartBackoff {
+		return nil, errors.Errorf("invalid KUBEXIT_RESTART_BACKOFF %s and KUBEXIT_RESTART_BACKOFF_MAX %s, expected positive backoff not greater than max", restartBackoff, restartBackoffMax)
+	}
+
+	restartReady := env.Get("KUBEXIT_RESTART_READY")
+	if restartReady != "" {
+		dep, err := readiness.ParseDep(restartReady)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid KUBEXIT_RESTART_READY")
+		}
+		if dep.Kind == readiness.KindContainer {
+			return nil, errors.Errorf("invalid KUBEXIT_RESTART_READY %s, container readiness can't be checked", restartReady)
+		}
+	}
+
+	restartReadyTimeout, err := env.Duration("KUBEXIT_RESTART_READY_TIMEOUT", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	runtimeTuning := env.List("KUBEXIT_RUNTIME_TUNING")
+	for _, runtime := range runtimeTuning {
+		if runtime != runtimeTuningGo && runtime != runtimeTuningJava {
+			return nil, errors.Errorf("invalid runtime tuning %s, expected any of: go, java", runtime)
+		}
 	}
 
-	deathDepsStr := os.Getenv("KUBEXIT_DEATH_DEPS")
-	var deathDeps []string
-	if deathDepsStr != "" {
-		deathDeps = strings.Split(deathDepsStr, ",")
+	preStartCmd := strings.TrimSpace(env.Get("KUBEXIT_PRE_START_CMD"))
+	preStartTimeout, err := env.Duration("KUBEXIT_PRE_START_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	postStopCmd := strings.TrimSpace(env.Get("KUBEXIT_POST_STOP_CMD"))
+	postStopTimeout, err := env.Duration("KUBEXIT_POST_STOP_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if preStartTimeout <= 0 || postStopTimeout <= 0 {
+		return nil, errors.Errorf("invalid hook timeouts %s and %s, expected positive", preStartTimeout, postStopTimeout)
 	}
 
-	birthTimeout := 30 * time.Second
-	birthTimeoutStr := os.Getenv("KUBEXIT_BIRTH_TIMEOUT")
-	if birthTimeoutStr != "" {
-		birthTimeout, err = time.ParseDuration(birthTimeoutStr)
+	drainCheck := env.Get("KUBEXIT_DRAIN_CHECK")
+	if drainCheck != "" {
+		_, err = drain.ParseCheck(drainCheck)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to parse birth timeout")
+			return nil, err
+		}
+	}
+
+	drainCheckInterval, err := env.Duration("KUBEXIT_DRAIN_CHECK_INTERVAL", time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	drainStallTimeout, err := env.Duration("KUBEXIT_DRAIN_STALL_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	drainMaxGrace, err := env.Duration("KUBEXIT_DRAIN_MAX_GRACE", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	controlSocket := env.Get("KUBEXIT_CONTROL_SOCKET")
+
+	graceExtensionMax, err := env.Duration("KUBEXIT_GRACE_EXTENSION_MAX", 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	shutdownAck := env.List("KUBEXIT_SHUTDOWN_ACK")
+	shutdownAckPath := env.Get("KUBEXIT_SHUTDOWN_ACK_FILE")
+	for _, source := range shutdownAck {
+		switch source {
+		case shutdownAckHTTP:
+			if controlSocket == "" {
+				return nil, errors.New("KUBEXIT_SHUTDOWN_ACK=http requires KUBEXIT_CONTROL_SOCKET")
+			}
+		case shutdownAckFile:
+			if shutdownAckPath == "" {
+				return nil, errors.New("KUBEXIT_SHUTDOWN_ACK=file requires KUBEXIT_SHUTDOWN_ACK_FILE")
+			}
+		case shutdownAckNotify:
+		default:
+			return nil, errors.Errorf("invalid shutdown ack source %s, expected one of: %s, %s, %s", source, shutdownAckHTTP, shutdownAckFile, shutdownAckNotify)
 		}
 	}
 
-	gracePeriod := 30 * time.Second
-	gracePeriodStr := os.Getenv("KUBEXIT_GRACE_PERIOD")
-	if gracePeriodStr != "" {
-		gracePeriod, err = time.ParseDuration(gracePeriodStr)
+	shutdownAckWindow, err := env.Duration("KUBEXIT_SHUTDOWN_ACK_WINDOW", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	shutdownAckRetries, err := env.Int("KUBEXIT_SHUTDOWN_ACK_RETRIES", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	watchdog, err := env.Duration("KUBEXIT_WATCHDOG", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	watchdogAction := env.Get("KUBEXIT_WATCHDOG_ACTION")
+	switch watchdogAction {
+	case "":
+		watchdogAction = watchdogRestart
+	case watchdogRestart, watchdogShutdown:
+	default:
+		return nil, errors.Errorf("invalid KUBEXIT_WATCHDOG_ACTION %s, expected one of: restart, shutdown", watchdogAction)
+	}
+
+	runAsUser := env.Get("KUBEXIT_RUN_AS_USER")
+	runAsGroup := env.Get("KUBEXIT_RUN_AS_GROUP")
+	runAs, err := parseRunAs(runAsUser, runAsGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	livenessProbe := env.Get("KUBEXIT_LIVENESS_PROBE")
+	if livenessProbe != "" {
+		_, err = parseLivenessProbe(livenessProbe)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to parse grace period")
+			return nil, errors.Wrap(err, "invalid KUBEXIT_LIVENESS_PROBE")
 		}
 	}
 
-	podName := os.Getenv("KUBEXIT_POD_NAME")
-	if podName == "" && len(birthDeps) > 0 {
+	livenessInitialDelay, err := env.Duration("KUBEXIT_LIVENESS_INITIAL_DELAY", 0)
+	if err != nil {
+		return nil, err
+	}
+	if livenessInitialDelay < 0 {
+		return nil, errors.Errorf("invalid KUBEXIT_LIVENESS_INITIAL_DELAY %s, expected non-negative", livenessInitialDelay)
+	}
+
+	livenessInterval, err := env.Duration("KUBEXIT_LIVENESS_INTERVAL", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if livenessInterval <= 0 {
+		return nil, errors.Errorf("invalid KUBEXIT_LIVENESS_INTERVAL %s, expected positive", livenessInterval)
+	}
+
+	livenessTimeout, err := env.Duration("KUBEXIT_LIVENESS_TIMEOUT", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if livenessTimeout <= 0 {
+		return nil, errors.Errorf("invalid KUBEXIT_LIVENESS_TIMEOUT %s, expected positive", livenessTimeout)
+	}
+
+	livenessFailureThreshold, err := env.Int("KUBEXIT_LIVENESS_FAILURE_THRESHOLD", 3)
+	if err != nil {
+		return nil, err
+	}
+	if livenessFailureThreshold < 1 {
+		return nil, errors.Errorf("invalid KUBEXIT_LIVENESS_FAILURE_THRESHOLD %d, expected positive", livenessFailureThreshold)
+	}
+
+	livenessAction := env.Get("KUBEXIT_LIVENESS_ACTION")
+	switch livenessAction {
+	case "":
+		livenessAction = livenessRestart
+	case livenessRestart, livenessShutdown:
+	default:
+		return nil, errors.Errorf("invalid KUBEXIT_LIVENESS_ACTION %s, expected one of: restart, shutdown", livenessAction)
+	}
+
+	outputDir := env.Get("KUBEXIT_OUTPUT_DIR")
+
+	outputMaxSizeMB, err := env.Int("KUBEXIT_OUTPUT_MAX_SIZE_MB", 10)
+	if err != nil {
+		return nil, err
+	}
+	if outputMaxSizeMB < 0 {
+		return nil, errors.Errorf("invalid KUBEXIT_OUTPUT_MAX_SIZE_MB %d, expected non-negative", outputMaxSizeMB)
+	}
+
+	outputMaxFiles, err := env.Int("KUBEXIT_OUTPUT_MAX_FILES", 3)
+	if err != nil {
+		return nil, err
+	}
+	if outputMaxFiles < 0 {
+		return nil, errors.Errorf("invalid KUBEXIT_OUTPUT_MAX_FILES %d, expected non-negative", outputMaxFiles)
+	}
+
+	outputCompress, err := env.Bool("KUBEXIT_OUTPUT_COMPRESS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	outputFormat := env.Get("KUBEXIT_OUTPUT_FORMAT")
+	switch outputFormat {
+	case "":
+		outputFormat = outputFormatRaw
+	case outputFormatRaw, outputFormatJSON:
+	default:
+		return nil, errors.Errorf("invalid KUBEXIT_OUTPUT_FORMAT %s, expected one of: raw, json", outputFormat)
+	}
+
+	notifySocket := env.Get("KUBEXIT_NOTIFY_SOCKET")
+	if notifySocket == "" {
+		notifySocket = "/tmp/kubexit-notify.sock"
+	}
+
+	podAnnotations, err := env.Bool("KUBEXIT_POD_ANNOTATIONS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	podCondition, err := env.Bool("KUBEXIT_POD_CONDITION", false)
+	if err != nil {
+		return nil, err
+	}
+
+	containerStatus, err := env.Bool("KUBEXIT_CONTAINER_STATUS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	containerName := env.Get("KUBEXIT_CONTAINER_NAME")
+	if containerName == "" {
+		containerName = name
+	}
+
+	clockSkewMax, err := env.Duration("KUBEXIT_CLOCK_SKEW_MAX", 0)
+	if err != nil {
+		return nil, err
+	}
+	if clockSkewMax > 0 && !kubernetesSupport {
+		return nil, errors.New("KUBEXIT_CLOCK_SKEW_MAX requires kubexit built with kubernetes support")
+	}
+
+	// enabled with container birth deps by default, as the pod is accessed anyway
+	checkContainerNames, err := env.Bool("KUBEXIT_CHECK_CONTAINER_NAMES", len(readiness.Containers(deps)) > 0 && kubernetesSupport)
+	if err != nil {
+		return nil, err
+	}
+
+	handlerErrorEvents, err := env.Bool("KUBEXIT_HANDLER_ERROR_EVENTS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	// pod name and namespace are required by features that access the pod via kubernetes api
+	podRequired := len(readiness.Containers(deps)) > 0 || podAnnotations || podCondition || containerStatus || checkContainerNames || gracePeriodQoS || handlerErrorEvents
+	if podRequired && !kubernetesSupport {
+		return nil, errors.New("container birth deps, pod annotations, pod condition, container status, container names check, grace period qos and handler error events require kubexit built with kubernetes support")
+	}
+
+	podName := env.Get("KUBEXIT_POD_NAME")
+	if podName == "" && podRequired {
 		return nil, errors.New("missing env var: KUBEXIT_POD_NAME")
 	}
 
-	namespace := os.Getenv("KUBEXIT_NAMESPACE")
-	if namespace == "" && len(birthDeps) > 0 {
+	namespace := env.Get("KUBEXIT_NAMESPACE")
+	if namespace == "" && podRequired {
 		return nil, errors.New("missing env var: KUBEXIT_NAMESPACE")
 	}
 
-	verboseLevel := 0
-	verboseLevelStr := os.Getenv("KUBEXIT_VERBOSE_LEVEL")
-	if verboseLevelStr != "" {
-		verboseLevel, err = strconv.Atoi(verboseLevelStr)
+	// explicitly set grace period is kept as is
+	if _, ok := env.Lookup("KUBEXIT_GRACE_PERIOD"); gracePeriodQoS && !ok {
+		gracePeriod, err = resourceAwareGracePeriod(namespace, podName, containerName, gracePeriod)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to parse verbose level %s", verboseLevelStr)
+			return nil, errors.Wrap(err, "failed to scale grace period")
+		}
+	}
+
+	audit, err := env.Bool("KUBEXIT_AUDIT", false)
+	if err != nil {
+		return nil, err
+	}
+
+	tracePersist, err := env.Bool("KUBEXIT_TRACE_PERSIST", false)
+	if err != nil {
+		return nil, err
+	}
+
+	if graveyardReadOnly {
+		if audit {
+			return nil, errors.New("KUBEXIT_AUDIT requires writable graveyard, unset KUBEXIT_GRAVEYARD_READONLY")
 		}
+		if tracePersist {
+			return nil, errors.New("KUBEXIT_TRACE_PERSIST requires writable graveyard, unset KUBEXIT_GRAVEYARD_READONLY")
+		}
+		if exclusiveLock != "" && exclusiveLockDir == graveyard {
+			return nil, errors.New("KUBEXIT_EXCLUSIVE_LOCK requires writable graveyard, set KUBEXIT_EXCLUSIVE_LOCK_DIR")
+		}
+	}
+
+	verboseLevel, err := env.Int("KUBEXIT_VERBOSE_LEVEL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	verbose, err := parseVerboseLevels(env.List("KUBEXIT_VERBOSE"))
+	if err != nil {
+		return nil, err
+	}
+
+	quiet, err := env.Bool("KUBEXIT_QUIET", false)
+	if err != nil {
+		return nil, err
+	}
+
+	instantLogging, err := env.Bool("KUBEXIT_INSTANT_LOGGING", false)
+	if err != nil {
+		return nil, err
+	}
+
+	timeLayout := env.Get("KUBEXIT_TIMESTAMP_FORMAT")
+	switch timeLayout {
+	case "":
+		timeLayout = timestamp.LayoutRFC3339
+	case timestamp.LayoutRFC3339, timestamp.LayoutEpochMillis:
+	default:
+		return nil, errors.Errorf("invalid timestamp format %s, expected %s or %s", timeLayout, timestamp.LayoutRFC3339, timestamp.LayoutEpochMillis)
+	}
+
+	timeUTC, err := env.Bool("KUBEXIT_TIMESTAMP_UTC", false)
+	if err != nil {
+		return nil, err
 	}
 
-	instantLogging := false
-	instantLoggingStr := os.Getenv("KUBEXIT_INSTANT_LOGGING")
-	if instantLoggingStr != "" {
-		instantLogging, err = strconv.ParseBool(instantLoggingStr)
+	metricsExpose, err := env.Bool("KUBEXIT_METRICS_EXPOSE", false)
+	if err != nil {
+		return nil, err
+	}
+
+	metricsAddr := env.Get("KUBEXIT_METRICS_ADDR")
+	var metricsNetwork string
+	if metricsAddr != "" {
+		metricsNetwork, metricsAddr, err = listenAddress("KUBEXIT_METRICS_ADDR", metricsAddr, metricsExpose, ipFamily)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	childSampleInterval, err := env.Duration("KUBEXIT_CHILD_SAMPLE_INTERVAL", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	selfMonitorInterval, err := env.Duration("KUBEXIT_SELF_MONITOR_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	selfMaxGoroutines, err := env.Int("KUBEXIT_SELF_MAX_GOROUTINES", 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	selfMaxFDs, err := env.Int("KUBEXIT_SELF_MAX_FDS", 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	selfMaxRSSMB, err := env.Int("KUBEXIT_SELF_MAX_RSS_MB", 128)
+	if err != nil {
+		return nil, err
+	}
+
+	dumpOnSIGUSR1, err := env.Bool("KUBEXIT_DUMP_ON_SIGUSR1", false)
+	if err != nil {
+		return nil, err
+	}
+
+	tombstoneTimeout, err := env.Duration("KUBEXIT_TOMBSTONE_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	fatalTimeout, err := env.Duration("KUBEXIT_FATAL_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if fatalTimeout < 0 {
+		return nil, errors.Errorf("invalid KUBEXIT_FATAL_TIMEOUT %s, expected non-negative", fatalTimeout)
+	}
+
+	retryInitial, err := env.Duration("KUBEXIT_RETRY_INITIAL", retry.DefaultPolicy.Initial)
+	if err != nil {
+		return nil, err
+	}
+	retryMax, err := env.Duration("KUBEXIT_RETRY_MAX", retry.DefaultPolicy.Max)
+	if err != nil {
+		return nil, err
+	}
+	retryMaxElapsed, err := env.Duration("KUBEXIT_RETRY_MAX_ELAPSED", retry.DefaultPolicy.MaxElapsed)
+	if err != nil {
+		return nil, err
+	}
+	retryJitter, err := env.Float("KUBEXIT_RETRY_JITTER", retry.DefaultPolicy.Jitter)
+	if err != nil {
+		return nil, err
+	}
+	retryPolicy := retry.Policy{Initial: retryInitial, Max: retryMax, MaxElapsed: retryMaxElapsed, Jitter: retryJitter}
+	err = retryPolicy.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	tombstoneTTL, err := env.Duration("KUBEXIT_TOMBSTONE_TTL", 0)
+	if err != nil {
+		return nil, err
+	}
+	if tombstoneTTL != 0 && tombstoneTTL < time.Second {
+		return nil, errors.Errorf("invalid tombstone ttl %s, expected at least 1s", tombstoneTTL)
+	}
+
+	tombstoneKeyFile := env.Get("KUBEXIT_TOMBSTONE_KEY_FILE")
+	var tombstoneKey []byte
+	if tombstoneKeyFile != "" {
+		tombstoneKey, err = ioutil.ReadFile(tombstoneKeyFile)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to parse env instant logging %s", verboseLevelStr)
+			return nil, errors.Wrap(err, "failed to read tombstone key")
+		}
+		tombstoneKey = bytes.TrimSpace(tombstoneKey)
+		if len(tombstoneKey) == 0 {
+			return nil, errors.Errorf("empty tombstone key in %s", tombstoneKeyFile)
+		}
+	}
+
+	tombstoneFailurePolicy := env.Get("KUBEXIT_TOMBSTONE_FAILURE_POLICY")
+	switch tombstoneFailurePolicy {
+	case "":
+		tombstoneFailurePolicy = tombstoneFailurePolicyFail
+	case tombstoneFailurePolicyFail, tombstoneFailurePolicyIgnore:
+	default:
+		return nil, errors.Errorf("invalid tombstone failure policy %s, expected one of: fail, ignore", tombstoneFailurePolicy)
+	}
+
+	tombstoneStrict, err := env.Bool("KUBEXIT_TOMBSTONE_STRICT", false)
+	if err != nil {
+		return nil, err
+	}
+
+	tombstoneFormat := env.Get("KUBEXIT_TOMBSTONE_FORMAT")
+	switch tombstoneFormat {
+	case "":
+		tombstoneFormat = tombstone.FormatYAML
+	case tombstone.FormatYAML, tombstone.FormatJSON:
+	default:
+		return nil, errors.Errorf("invalid tombstone format %s, expected one of: yaml, json", tombstoneFormat)
+	}
+
+	tombstoneLabels, err := parsePairs("KUBEXIT_TOMBSTONE_LABELS", env.List("KUBEXIT_TOMBSTONE_LABELS"))
+	if err != nil {
+		return nil, err
+	}
+
+	tombstoneMirrors, err := parseTombstoneMirrors(env.List("KUBEXIT_TOMBSTONE_MIRRORS"), graveyard)
+	if err != nil {
+		return nil, err
+	}
+
+	tombstoneFallback := env.Get("KUBEXIT_TOMBSTONE_FALLBACK")
+	switch {
+	case tombstoneFallback == tombstoneFallbackAnnotations:
+		if !podAnnotations {
+			return nil, errors.New("KUBEXIT_TOMBSTONE_FALLBACK=annotations requires KUBEXIT_POD_ANNOTATIONS")
 		}
+	case tombstoneFallback != "" && filepath.Clean(tombstoneFallback) == filepath.Clean(graveyard):
+		return nil, errors.Errorf("invalid tombstone fallback %s, expected location other than graveyard", tombstoneFallback)
+	}
+
+	// empty value explicitly disables termination message
+	terminationMessagePath, ok := env.Lookup("KUBEXIT_TERMINATION_MESSAGE_PATH")
+	if !ok {
+		terminationMessagePath = "/dev/termination-log"
+	}
+
+	holdOnFailure, err := env.Duration("KUBEXIT_HOLD_ON_FAILURE", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts := env.List("KUBEXIT_ARTIFACTS")
+	artifactsDir := env.Get("KUBEXIT_ARTIFACTS_DIR")
+	artifactsWebhook := env.Get("KUBEXIT_ARTIFACTS_WEBHOOK")
+	uploadURL := env.Get("KUBEXIT_UPLOAD_URL")
+	if len(artifacts) > 0 && artifactsDir == "" && artifactsWebhook == "" && uploadURL == "" {
+		return nil, errors.New("KUBEXIT_ARTIFACTS require KUBEXIT_ARTIFACTS_DIR, KUBEXIT_ARTIFACTS_WEBHOOK or KUBEXIT_UPLOAD_URL")
+	}
+
+	artifactsOn := env.Get("KUBEXIT_ARTIFACTS_ON")
+	switch artifactsOn {
+	case "":
+		artifactsOn = artifactsOnFailure
+	case artifactsOnFailure, artifactsOnAlways:
+	default:
+		return nil, errors.Errorf("invalid artifacts collection condition %s, expected one of: failure, always", artifactsOn)
+	}
+
+	artifactsTimeout, err := env.Duration("KUBEXIT_ARTIFACTS_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadEndpoint := env.Get("KUBEXIT_UPLOAD_ENDPOINT")
+	uploadRegion := env.Get("KUBEXIT_UPLOAD_REGION")
+	if uploadURL != "" {
+		uploadEndpoint, uploadRegion, err = uploadDefaults(uploadURL, uploadEndpoint, uploadRegion)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	uploadOn := env.Get("KUBEXIT_UPLOAD_ON")
+	switch uploadOn {
+	case "":
+		uploadOn = artifactsOnAlways
+	case artifactsOnFailure, artifactsOnAlways:
+	default:
+		return nil, errors.Errorf("invalid upload condition %s, expected one of: failure, always", uploadOn)
+	}
+
+	uploadTimeout, err := env.Duration("KUBEXIT_UPLOAD_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveryDeadline, err := env.Duration("KUBEXIT_DELIVERY_DEADLINE", gracePeriod)
+	if err != nil {
+		return nil, err
+	}
+	if deliveryDeadline < 0 || deliveryDeadline > gracePeriod {
+		return nil, errors.Errorf("invalid KUBEXIT_DELIVERY_DEADLINE %s, expected non-negative up to grace period %s", deliveryDeadline, gracePeriod)
+	}
+
+	deliveryBreakerThreshold, err := env.Int("KUBEXIT_DELIVERY_BREAKER_THRESHOLD", 3)
+	if err != nil {
+		return nil, err
+	}
+	if deliveryBreakerThreshold < 0 {
+		return nil, errors.Errorf("invalid KUBEXIT_DELIVERY_BREAKER_THRESHOLD %d, expected non-negative", deliveryBreakerThreshold)
 	}
 
 	return &config{
 		Name:           name,
 		Graveyard:      graveyard,
-		BirthDeps:      birthDeps,
-		DeathDeps:      deathDeps,
-		BirthTimeout:   birthTimeout,
+		GraveyardWatch: graveyardWatch,
+		GraveyardScope: graveyardScope,
+		PollInterval:   pollInterval,
+		CoalesceWindow: coalesceWindow,
+
+		GraveyardReadOnly:   graveyardReadOnly,
+		GraveyardLossPolicy: graveyardLossPolicy,
+
+		WatchdogStaleAfter: watchdogStaleAfter,
+
+		PodWatchTimeout:   podWatchTimeout,
+		PodWatchBookmarks: podWatchBookmarks,
+
+		Manifest: manifestPath,
+
+		BirthDeps:    birthDeps,
+		DeathDeps:    deathDeps,
+		BirthTimeout: birthTimeout,
+
+		ZeroExitOnDeathDep: zeroExitOnDeathDep,
+
+		BirthCheckInterval:  birthCheckInterval,
+		BirthDepsLostPolicy: birthDepsLostPolicy,
+		BirthDepsLostAfter:  birthDepsLostAfter,
+		BirthDepsLostSignal: birthDepsLostSignal,
+
+		BirthDepsRecoveredAfter:  birthDepsRecoveredAfter,
+		BirthDepsRecoveredSignal: birthDepsRecoveredSignal,
+
+		DNSNameserver: dnsNameserver,
+		DNSTimeout:    dnsTimeout,
+		DNSCacheTTL:   dnsCacheTTL,
+		IPFamily:      ipFamily,
+
+		WaitPortsFree:      waitPortsFree,
+		PortReleaseTimeout: portReleaseTimeout,
+
+		ExclusiveLock:        exclusiveLock,
+		ExclusiveLockDir:     exclusiveLockDir,
+		ExclusiveLockTimeout: exclusiveLockTimeout,
+
 		GracePeriod:    gracePeriod,
+		GracePeriodQoS: gracePeriodQoS,
+		SigtermPolicy:  sigtermPolicy,
+		ShutdownSignal: shutdownSignal,
+
+		ShutdownEscalation: shutdownEscalation,
+		ForwardSignals:     forwardSignals,
+		IgnoreSignals:      ignoreSignals,
+
+		ExitCodeMap:           exitCodeMap,
+		RestartExitCode:       restartExitCode,
+		RestartLimit:          restartLimit,
+		RestartExhausted:      restartExhausted,
+		RestartExhaustedSleep: restartExhaustedSleep,
+		RestartPolicy:         restartPolicy,
+		RestartMaxRetries:     restartMaxRetries,
+		RestartBackoff:        restartBackoff,
+		RestartBackoffMax:     restartBackoffMax,
+		RestartReady:          restartReady,
+		RestartReadyTimeout:   restartReadyTimeout,
+
+		RuntimeTuning: runtimeTuning,
+
+		PreStartCmd:     preStartCmd,
+		PreStartTimeout: preStartTimeout,
+		PostStopCmd:     postStopCmd,
+		PostStopTimeout: postStopTimeout,
+
+		DrainCheck:         drainCheck,
+		DrainCheckInterval: drainCheckInterval,
+		DrainStallTimeout:  drainStallTimeout,
+		DrainMaxGrace:      drainMaxGrace,
+
+		ControlSocket:     controlSocket,
+		GraceExtensionMax: graceExtensionMax,
+
+		ShutdownAck:        shutdownAck,
+		ShutdownAckWindow:  shutdownAckWindow,
+		ShutdownAckRetries: shutdownAckRetries,
+		ShutdownAckFile:    shutdownAckPath,
+		Watchdog:           watchdog,
+		WatchdogAction:     watchdogAction,
+		NotifySocket:       notifySocket,
+
+		RunAsUser:  runAsUser,
+		RunAsGroup: runAsGroup,
+		RunAs:      runAs,
+
+		LivenessProbe:            livenessProbe,
+		LivenessInitialDelay:     livenessInitialDelay,
+		LivenessInterval:         livenessInterval,
+		LivenessTimeout:          livenessTimeout,
+		LivenessFailureThreshold: livenessFailureThreshold,
+		LivenessAction:           livenessAction,
+
+		OutputDir:       outputDir,
+		OutputMaxSizeMB: outputMaxSizeMB,
+		OutputMaxFiles:  outputMaxFiles,
+		OutputCompress:  outputCompress,
+		OutputFormat:    outputFormat,
+
 		PodName:        podName,
 		Namespace:      namespace,
 		VerboseLevel:   verboseLevel,
+		Verbose:        verbose,
+		Quiet:          quiet,
 		InstantLogging: instantLogging,
+		MetricsAddr:    metricsAddr,
+		MetricsNetwork: metricsNetwork,
+		MetricsExpose:  metricsExpose,
+
+		ChildSampleInterval: childSampleInterval,
+
+		SelfMonitorInterval: selfMonitorInterval,
+		SelfMaxGoroutines:   selfMaxGoroutines,
+		SelfMaxFDs:          selfMaxFDs,
+		SelfMaxRSSMB:        selfMaxRSSMB,
+		DumpOnSIGUSR1:       dumpOnSIGUSR1,
+
+		PodAnnotations: podAnnotations,
+		PodCondition:   podCondition,
+
+		ContainerName:   containerName,
+		ContainerStatus: containerStatus,
+		Image:           env.Get("KUBEXIT_IMAGE"),
+
+		CheckContainerNames: checkContainerNames,
+		HandlerErrorEvents:  handlerErrorEvents,
+
+		Audit:        audit,
+		TracePersist: tracePersist,
+
+		ClockSkewMax: clockSkewMax,
+
+		TimestampFormat: timeLayout,
+		TimestampUTC:    timeUTC,
+
+		TombstoneTimeout:       tombstoneTimeout,
+		TombstoneFailurePolicy: tombstoneFailurePolicy,
+		TombstoneTTL:           tombstoneTTL,
+		TombstoneKeyFile:       tombstoneKeyFile,
+		TombstoneFallback:      tombstoneFallback,
+		TombstoneStrict:        tombstoneStrict,
+		TombstoneFormat:        tombstoneFormat,
+		TombstoneLabels:        tombstoneLabels,
+		TombstoneMirrors:       tombstoneMirrors,
+		TombstoneKey:           tombstoneKey,
+
+		FatalTimeout: fatalTimeout,
+
+		RetryInitial:    retryInitial,
+		RetryMax:        retryMax,
+		RetryMaxElapsed: retryMaxElapsed,
+		RetryJitter:     retryJitter,
+
+		TerminationMessagePath: terminationMessagePath,
+
+		HoldOnFailure: holdOnFailure,
+
+		Artifacts:        artifacts,
+		ArtifactsOn:      artifactsOn,
+		ArtifactsDir:     artifactsDir,
+		ArtifactsWebhook: artifactsWebhook,
+		ArtifactsTimeout: artifactsTimeout,
+
+		UploadURL:      uploadURL,
+		UploadEndpoint: uploadEndpoint,
+		UploadRegion:   uploadRegion,
+		UploadOn:       uploadOn,
+		UploadTimeout:  uploadTimeout,
+		PodUID:         podUID,
+
+		DeliveryDeadline:         deliveryDeadline,
+		DeliveryBreakerThreshold: deliveryBreakerThreshold,
+
+		ConfigAnnotations: configAnnotations,
+
+		Command: env.Command(),
 	}, nil
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestParseExitCodeMap(t *testing.T) {
+	tests := []struct {
+		pairs    []string
+		expected map[int]int
+		invalid  bool
+	}{
+		{pairs: nil, expected: nil},
+		{pairs: []string{"143=0"}, expected: map[int]int{143: 0}},
+		{pairs: []string{"143=0", " 2 = 1 "}, expected: map[int]int{143: 0, 2: 1}},
+		{pairs: []string{"0=255"}, expected: map[int]int{0: 255}},
+		{pairs: []string{"143"}, invalid: true},
+		{pairs: []string{"=0"}, invalid: true},
+		{pairs: []string{"x=0"}, invalid: true},
+		{pairs: []string{"143=x"}, invalid: true},
+		{pairs: []string{"-1=0"}, invalid: true},
+		{pairs: []string{"256=0"}, invalid: true},
+		{pairs: []string{"143=256"}, invalid: true},
+	}
+	for _, test := range tests {
+		codes, err := parseExitCodeMap(test.pairs)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("expected %q to be invalid, got %v", test.pairs, codes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to parse %q: %v", test.pairs, err)
+			continue
+		}
+		if !reflect.DeepEqual(codes, test.expected) {
+			t.Errorf("expected %q parsed to %v, got %v", test.pairs, test.expected, codes)
+		}
+	}
+}
+
+func TestRemapExitCode(t *testing.T) {
+	codes, err := parseExitCodeMap([]string{"143=0", "2=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &config{ExitCodeMap: codes}
+
+	tests := []struct {
+		code     int
+		expected int
+	}{
+		// the child terminated by SIGTERM, whether it handles it or not
+		{code: 143, expected: 0},
+		{code: 2, expected: 1},
+		{code: 0, expected: 0},
+		{code: 1, expected: 1},
+		{code: 137, expected: 137},
+	}
+	for _, test := range tests {
+		if remapped := config.remapExitCode(test.code); remapped != test.expected {
+			t.Errorf("expected %d remapped to %d, got %d", test.code, test.expected, remapped)
+		}
+	}
+}
+
+// TestConfigEnvTags checks each env var read by parseConfig matches json tag of config,
+// which KUBEXIT_CONFIG_JSON, pod annotations and manifest rely on
+func TestConfigEnvTags(t *testing.T) {
+	source, err := ioutil.ReadFile("config.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fieldTypes := configFieldTypes()
+	tags := make(map[string]bool, len(fieldTypes))
+	for key := range fieldTypes {
+		tags[configEnvName(key)] = true
+	}
+
+	reads := regexp.MustCompile(`env\.[A-Za-z]+\("(KUBEXIT_[A-Z0-9_]+)"`).FindAllSubmatch(source, -1)
+	if len(reads) == 0 {
+		t.Fatal("expected env vars read by parseConfig")
+	}
+	for _, read := range reads {
+		name := string(read[1])
+		if !tags[name] {
+			t.Errorf("expected json tag of config matching %s", name)
+		}
+	}
+}
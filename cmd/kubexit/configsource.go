@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	configJSONEnv = "KUBEXIT_CONFIG_JSON"
+	// commandKey is a document only key with the child command and args, used when none are passed as kubexit args
+	commandKey = "command"
+)
+
+const (
+	configAnnotationsEnv = "KUBEXIT_CONFIG_ANNOTATIONS"
+	// configAnnotationPrefix is the prefix of pod annotations with config values
+	configAnnotationPrefix = "kubexit.dev/"
+)
+
+// configSource looks up config values in the environment first, then in pod annotations, if enabled,
+// then in the KUBEXIT_CONFIG_JSON document, and then in the participant section of KUBEXIT_MANIFEST.
+// Document keys are config json tags, each one matching env var KUBEXIT_<TAG>.
+type configSource struct {
+	annotations map[string]string
+	document    map[string]string
+	// manifest is the participant section of the pod manifest
+	manifest map[string]string
+	command  []string
+	// expanded are rendered values with templates, see expandTemplates
+	expanded map[string]string
+}
+
+func newConfigSource() (*configSource, error) {
+	source := &configSource{document: map[string]string{}}
+
+	raw := os.Getenv(configJSONEnv)
+	if raw == "" {
+		return source, source.expandTemplates()
+	}
+
+	var command struct {
+		Command []string `json:"command"`
+	}
+	err := json.Unmarshal([]byte(raw), &command)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", configJSONEnv)
+	}
+	source.command = command.Command
+
+	document, err := flattenConfigDocument([]byte(raw))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", configJSONEnv)
+	}
+	source.document = document
+	return source, source.expandTemplates()
+}
+
+// Command returns the child command from the config document
+func (s *configSource) Command() []string {
+	return s.command
+}
+
+func (s *configSource) Lookup(key string) (string, bool) {
+	if value, ok := s.expanded[key]; ok {
+		return value, true
+	}
+	return s.lookupRaw(key)
+}
+
+// lookupRaw is Lookup of values without rendering templates
+func (s *configSource) lookupRaw(key string) (string, bool) {
+	if value, ok := os.LookupEnv(key); ok {
+		return value, true
+	}
+	if value, ok := s.annotations[key]; ok {
+		return value, true
+	}
+	if value, ok := s.document[key]; ok {
+		return value, true
+	}
+	value, ok := s.manifest[key]
+	return value, ok
+}
+
+// SetAnnotations adds pod annotations as a source of config values of the participant with the name.
+// Annotation keys are config json tags with dashes, e.g. kubexit.dev/birth-deps for KUBEXIT_BIRTH_DEPS,
+// participant specific kubexit.dev/birth-deps-<name> takes precedence over pod wide one. Other annotations are ignored
+func (s *configSource) SetAnnotations(annotations map[string]string, name string) error {
+	s.annotations = map[string]string{}
+	for key := range configFieldTypes() {
+		annotation := configAnnotationPrefix + strings.ReplaceAll(key, "_", "-")
+		if value, ok := annotations[annotation+"-"+name]; ok {
+			s.annotations[configEnvName(key)] = value
+		} else if value, ok := annotations[annotation]; ok {
+			s.annotations[configEnvName(key)] = value
+		}
+	}
+	return s.expandTemplates()
+}
+
+// SetManifest adds the section of participant with the name in the pod manifest as a source of config values.
+// The manifest may have no section of the participant
+func (s *configSource) SetManifest(m manifest, name string) error {
+	s.manifest = map[string]string{}
+	section, ok := m[name]
+	if !ok {
+		return nil
+	}
+
+	values, err := flattenConfigDocument(section)
+	if err != nil {
+		return errors.Wrapf(err, "invalid manifest section %s", name)
+	}
+	s.manifest = values
+	return s.expandTemplates()
+}
+
+func (s *configSource) Get(key string) string {
+	value, _ := s.Lookup(key)
+	return value
+}
+
+// List returns comma separated value as a list, nil if empty
+func (s *configSource) List(key string) []string {
+	value := s.Get(key)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// Duration parses value with time.ParseDuration, returns def if empty
+func (s *configSource) Duration(key string, def time.Duration) (time.Duration, error) {
+	value := s.Get(key)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", key)
+	}
+	return d, nil
+}
+
+// Bool parses value with strconv.ParseBool, returns def if empty
+func (s *configSource) Bool(key string, def bool) (bool, error) {
+	value := s.Get(key)
+	if value == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to parse %s", key)
+	}
+	return b, nil
+}
+
+// Float parses value with strconv.ParseFloat, returns def if empty
+func (s *configSource) Float(key string, def float64) (float64, error) {
+	value := s.Get(key)
+	if value == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", key)
+	}
+	return f, nil
+}
+
+// Int parses value with strconv.Atoi, returns def if empty
+func (s *configSource) Int(key string, def int) (int, error) {
+	value := s.Get(key)
+	if value == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse %s", key)
+	}
+	return i, nil
+}
+
+// flattenConfigDocument converts json config document to env var values:
+// lists are joined by comma, objects are rendered as comma separated key=value pairs,
+// numeric durations are treated as nanoseconds, the way config is logged.
+func flattenConfigDocument(raw []byte) (map[string]string, error) {
+	fieldTypes := configFieldTypes()
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var document map[string]interface{}
+	err := decoder.Decode(&document)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	values := make(map[string]string, len(document))
+	for key, value := range document {
+		if key == commandKey {
+			continue
+		}
+		fieldType, ok := fieldTypes[key]
+		if !ok {
+			return nil, errors.Errorf("unknown config key %s", key)
+		}
+		if value == nil {
+			continue
+		}
+
+		str, err := flattenConfigValue(value, fieldType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid config key %s", key)
+		}
+		values[configEnvName(key)] = str
+	}
+	return values, nil
+}
+
+func flattenConfigValue(value interface{}, fieldType reflect.Type) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return fmt.Sprint(v), nil
+	case json.Number:
+		if fieldType == reflect.TypeOf(time.Duration(0)) {
+			nanoseconds, err := v.Int64()
+			if err != nil {
+				return "", errors.WithStack(err)
+			}
+			return time.Duration(nanoseconds).String(), nil
+		}
+		return v.String(), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			str, err := flattenConfigValue(item, nil)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, str)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(v))
+		for key, item := range v {
+			str, err := flattenConfigValue(item, nil)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, fmt.Sprintf("%s=%s", key, str))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	default:
+		return "", errors.Errorf("unsupported value %v", value)
+	}
+}
+
+// configFieldTypes maps config json tags to field types
+func configFieldTypes() map[string]reflect.Type {
+	configType := reflect.TypeOf(config{})
+	fieldTypes := make(map[string]reflect.Type, configType.NumField())
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		fieldTypes[tag] = field.Type
+	}
+	return fieldTypes
+}
+
+func configEnvName(key string) string {
+	return "KUBEXIT_" + strings.ToUpper(key)
+}
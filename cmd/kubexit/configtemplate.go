@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// templateData is available to templates in config values, e.g. KUBEXIT_GRAVEYARD=/graveyard/{{.PodUID}}.
+// Fields are taken from env vars usually set with the downward API, which can't be templates themselves
+type templateData struct {
+	Name          string
+	ContainerName string
+	PodName       string
+	PodUID        string
+	Namespace     string
+}
+
+func (s *configSource) templateData() (*templateData, error) {
+	get := func(key string) (string, error) {
+		value, _ := s.lookupRaw(key)
+		if isTemplate(value) {
+			return "", errors.Errorf("%s can't be a template, as it's used by templates", key)
+		}
+		return value, nil
+	}
+
+	var data templateData
+	var err error
+	for key, field := range map[string]*string{
+		"KUBEXIT_CONTAINER_NAME": &data.ContainerName,
+		"KUBEXIT_POD_NAME":       &data.PodName,
+		"KUBEXIT_POD_UID":        &data.PodUID,
+		"KUBEXIT_NAMESPACE":      &data.Namespace,
+	} {
+		*field, err = get(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// name may be a template of container name, e.g. {{.ContainerName}}
+	name, _ := s.lookupRaw("KUBEXIT_NAME")
+	if !isTemplate(name) {
+		data.Name = name
+	}
+	if data.ContainerName == "" {
+		data.ContainerName = data.Name
+	}
+	return &data, nil
+}
+
+// expandTemplates renders all config values with templates, so Lookup returns rendered ones
+func (s *configSource) expandTemplates() error {
+	s.expanded = map[string]string{}
+
+	data, err := s.templateData()
+	if err != nil {
+		return err
+	}
+
+	for key := range configFieldTypes() {
+		envName := configEnvName(key)
+		value, _ := s.lookupRaw(envName)
+		if !isTemplate(value) {
+			continue
+		}
+		tmpl, err := template.New(envName).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse %s template", envName)
+		}
+		var rendered bytes.Buffer
+		err = tmpl.Execute(&rendered, data)
+		if err != nil {
+			return errors.Wrapf(err, "failed to render %s template", envName)
+		}
+		s.expanded[envName] = rendered.String()
+	}
+	return nil
+}
+
+func isTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/readiness"
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+)
+
+// control serves kubexit control api on unix socket, for operators via kubectl exec and for the child itself
+type control struct {
+	ctx       context.Context
+	child     *supervisor.Supervisor
+	publisher statePublisher
+	status    *childStatus
+	ts        *tombstoneWriter
+	// watchdog is pinged by the child via control api, nil if disabled
+	watchdog *childWatchdog
+
+	graceExtensionMax time.Duration
+
+	// restartReady checks new child of overlapped restart, nil if restart is not configured
+	restartReady        readiness.Probe
+	restartReadyTimeout time.Duration
+	restartInterval     time.Duration
+	gracePeriod         time.Duration
+
+	m             sync.Mutex
+	graceExtended time.Duration
+
+	// stopping is closed by stopActing, acting counts requests acting on the child in flight
+	actingM  sync.Mutex
+	stopping chan struct{}
+	acting   sync.WaitGroup
+}
+
+func newControl(ctx context.Context, config *config, child *supervisor.Supervisor, publisher statePublisher, status *childStatus, ts *tombstoneWriter, watchdog *childWatchdog) (*control, error) {
+	c := &control{
+		ctx:                 ctx,
+		child:               child,
+		publisher:           publisher,
+		status:              status,
+		ts:                  ts,
+		watchdog:            watchdog,
+		graceExtensionMax:   config.GraceExtensionMax,
+		restartReadyTimeout: config.RestartReadyTimeout,
+		restartInterval:     config.BirthCheckInterval,
+		gracePeriod:         config.GracePeriod,
+		stopping:            make(chan struct{}),
+	}
+	if config.RestartReady != "" {
+		dep, err := readiness.ParseDep(config.RestartReady)
+		if err != nil {
+			return nil, err
+		}
+		c.restartReady, err = readiness.NewProbe(dep, readiness.Graveyard{Path: config.Graveyard, PodUID: config.PodUID, Key: config.TombstoneKey})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *control) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/grace/extend", c.extendGrace)
+	mux.HandleFunc("/pause", c.actOnChild(c.pause))
+	mux.HandleFunc("/resume", c.actOnChild(c.resume))
+	mux.HandleFunc("/shutdown/ack", c.ackShutdown)
+	mux.HandleFunc("/restart", c.actOnChild(c.restart))
+	mux.HandleFunc("/restarts", c.restarts)
+	mux.HandleFunc("/watchdog", c.pingWatchdog)
+	return mux
+}
+
+// actOnChild wraps handler of requests acting on the child: restart, pause and resume, rejected after stopActing.
+// Requests taking part in the shutdown, e.g. shutdown ack and grace extension, are served until the child exits
+func (c *control) actOnChild(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.actingM.Lock()
+		select {
+		case <-c.stopping:
+			c.actingM.Unlock()
+			http.Error(w, "child is shutting down", http.StatusServiceUnavailable)
+			return
+		default:
+		}
+		c.acting.Add(1)
+		c.actingM.Unlock()
+		defer c.acting.Done()
+
+		handler(w, r)
+	}
+}
+
+// stopActing rejects requests acting on the child, cancels overlapped restart in flight and waits for requests in flight,
+// so they don't act on the child being shut down. It is the shutdown hook of the child
+func (c *control) stopActing() {
+	c.actingM.Lock()
+	select {
+	case <-c.stopping:
+	default:
+		close(c.stopping)
+	}
+	c.actingM.Unlock()
+
+	c.acting.Wait()
+}
+
+// restart replaces the child with a new one, stopping the old child after the new one is ready,
+// e.g. to apply changed config without downtime for children with SO_REUSEPORT or socket activation
+func (c *control) restart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c.restartReady == nil {
+		http.Error(w, "restart requires KUBEXIT_RESTART_READY", http.StatusConflict)
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), c.restartReadyTimeout)
+	defer cancel()
+	// shutdown of the child cancels the restart, so the new child doesn't outlive it
+	crash.Go(c.ctx, "overlapped restart cancel", func() {
+		select {
+		case <-c.stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	})
+
+	event.ContextEventTrace(c.ctx).AddEvent("Overlapped restart requested")
+	err := c.child.RestartOverlapped(ctx, c.restartReady, c.restartInterval, c.gracePeriod)
+	if err != nil {
+		event.ContextEventTrace(c.ctx).AddEvent(fmt.Sprintf("Overlapped restart failed: %v", err))
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	c.watchdog.arm()
+	err = recordRestart(c.status, c.ts, restartReasonOverlapped, nil)
+	if err != nil {
+		// the child is restarted anyway
+		event.ContextEventTrace(c.ctx).AddEvent(fmt.Sprintf("Failed to record restart: %v", err))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(restartState{PID: c.child.Pid(), Restarts: c.child.Restarts()})
+}
+
+// restarts responds with restart history of the child
+func (c *control) restarts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(c.status.restarts())
+}
+
+type restartState struct {
+	PID      int `json:"pid"`
+	Restarts int `json:"restarts"`
+}
+
+// ackShutdown acknowledges graceful shutdown by the child, see KUBEXIT_SHUTDOWN_ACK
+func (c *control) ackShutdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c.child.AckShutdown()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type watchdogState struct {
+	Remaining string `json:"remaining"`
+}
+
+// pingWatchdog restarts watchdog interval on POST, see KUBEXIT_WATCHDOG, and responds with time left to the next ping
+func (c *control) pingWatchdog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c.watchdog == nil {
+		http.Error(w, "watchdog requires KUBEXIT_WATCHDOG", http.StatusConflict)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		c.watchdog.ping()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(watchdogState{Remaining: c.watchdog.remaining().String()})
+}
+
+type graceExtension struct {
+	Granted string `json:"granted"`
+	Total   string `json:"total"`
+}
+
+// extendGrace extends grace period of shutdown in progress by duration form value,
+// bounded by total extension limit
+func (c *control) extendGrace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	duration, err := time.ParseDuration(r.FormValue("duration"))
+	if err != nil || duration <= 0 {
+		http.Error(w, fmt.Sprintf("invalid duration %q", r.FormValue("duration")), http.StatusBadRequest)
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	granted := duration
+	if left := c.graceExtensionMax - c.graceExtended; granted > left {
+		granted = left
+	}
+	if granted <= 0 {
+		http.Error(w, fmt.Sprintf("grace extension limit %s reached", c.graceExtensionMax), http.StatusConflict)
+		return
+	}
+
+	err = c.child.ExtendShutdown(granted)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	c.graceExtended += granted
+
+	event.ContextEventTrace(c.ctx).AddEvent(fmt.Sprintf("Grace period extension of %s requested, %s granted, %s total", duration, granted, c.graceExtended))
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(graceExtension{
+		Granted: granted.String(),
+		Total:   c.graceExtended.String(),
+	})
+}
+
+type pauseState struct {
+	Paused bool `json:"paused"`
+}
+
+// pause stops the child with SIGSTOP, e.g. for debugging or coordinated quiesce during backups
+func (c *control) pause(w http.ResponseWriter, r *http.Request) {
+	c.setPaused(w, r, true)
+}
+
+func (c *control) resume(w http.ResponseWriter, r *http.Request) {
+	c.setPaused(w, r, false)
+}
+
+func (c *control) setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	state := childStateRunning
+	change := c.child.Resume
+	if paused {
+		state = childStatePaused
+		change = c.child.Pause
+	}
+
+	err := change()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	event.ContextEventTrace(c.ctx).AddEvent(fmt.Sprintf("Child %s on request", state))
+
+	c.publisher.Publish(state, nil)
+	err = c.ts.recordPause(paused)
+	if err != nil {
+		// the child state is changed anyway
+		event.ContextEventTrace(c.ctx).AddEvent(fmt.Sprintf("Failed to record pause: %v", err))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(pauseState{Paused: paused})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+)
+
+// crashExitCode is returned when kubexit itself panics, EX_SOFTWARE of sysexits
+const crashExitCode = 70
+
+// crashHandler is crash.Handler of kubexit goroutines. A panic leaves kubexit in unknown state,
+// so the child is stopped, the crash is recorded in the tombstone for dependents, and kubexit exits with crashExitCode
+type crashHandler struct {
+	logger                 *logrus.Logger
+	timeout                time.Duration
+	terminationMessagePath string
+
+	m     sync.Mutex
+	child *supervisor.Supervisor
+	ts    *tombstoneWriter
+
+	once sync.Once
+}
+
+func newCrashHandler(config *config, logger *logrus.Logger) *crashHandler {
+	return &crashHandler{
+		logger:                 logger,
+		timeout:                config.TombstoneTimeout,
+		terminationMessagePath: config.TerminationMessagePath,
+	}
+}
+
+// attach sets the child and the tombstone, once created
+func (h *crashHandler) attach(child *supervisor.Supervisor, ts *tombstoneWriter) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.child = child
+	h.ts = ts
+}
+
+func (h *crashHandler) handle(name string, value interface{}, stack []byte) {
+	// panics of other goroutines while crashing are left to the first one
+	h.once.Do(func() {
+		panicked := fmt.Sprintf("%s: %v", name, value)
+		h.logger.
+			WithField(errorCodeField, errorCodeSupervisorCrashed).
+			WithField("goroutine", name).
+			WithField("stack", string(stack)).
+			Errorf("kubexit crashed: %v", value)
+
+		h.m.Lock()
+		child, ts := h.child, h.ts
+		h.m.Unlock()
+
+		// locks may be left held by the panicking goroutine, so cleanup is bounded
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			h.cleanup(child, ts, panicked)
+		}()
+		select {
+		case <-done:
+		case <-time.After(h.timeout):
+			h.logger.WithField(errorCodeField, errorCodeSupervisorCrashed).Error("crash cleanup is not completed in time")
+		}
+
+		err := writeTerminationMessage(h.terminationMessagePath, crashExitCode, fmt.Sprintf("kubexit crashed: %s", panicked))
+		if err != nil {
+			h.logger.WithError(err).Error()
+		}
+	})
+	os.Exit(crashExitCode)
+}
+
+func (h *crashHandler) cleanup(child *supervisor.Supervisor, ts *tombstoneWriter, panicked string) {
+	if child != nil {
+		err := child.ShutdownNow()
+		if err != nil {
+			h.logger.WithError(err).Error("failed to stop the child after crash")
+		}
+	}
+	if ts == nil {
+		return
+	}
+
+	// tombstoneWriter lock may be held by the panicking goroutine, the tombstone is written directly
+	ctx, cancel := context.WithTimeout(ts.ctx, h.timeout)
+	defer cancel()
+	err := ts.RecordCrash(ctx, crashExitCode, panicked)
+	if err != nil {
+		h.logger.WithError(err).WithField(errorCodeField, errorCodeTombstoneWriteFailed).Error("failed to record crash")
+	}
+	err = ts.mirror()
+	if err != nil {
+		h.logger.WithError(err).WithField(errorCodeField, errorCodeTombstoneWriteFailed).Error("failed to mirror crash")
+	}
+}
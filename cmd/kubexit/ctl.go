@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const ctlUsage = `usage: kubexit ctl [--socket path] command
+commands:
+  pause                   stop the child with SIGSTOP
+  resume                  continue the paused child
+  restart                 replace the child with a new one, stopping the old child when the new one is ready
+  restarts                show restart history of the child
+  watchdog                ping the watchdog of the child
+  extend-grace duration   extend grace period of the shutdown in progress`
+
+// runCtl sends a command to control api of kubexit running in the same container
+func runCtl(args []string) int {
+	flags := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	socket := flags.String("socket", os.Getenv("KUBEXIT_CONTROL_SOCKET"), "control socket path, KUBEXIT_CONTROL_SOCKET by default")
+	timeout := flags.Duration("timeout", 10*time.Second, "request timeout")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, ctlUsage)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if *socket == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var path string
+	method := http.MethodPost
+	form := url.Values{}
+	switch command := flags.Arg(0); command {
+	case "pause", "resume", "restart", "watchdog":
+		path = "/" + command
+	case "restarts":
+		path = "/" + command
+		method = http.MethodGet
+	case "extend-grace":
+		if flags.NArg() < 2 {
+			flags.Usage()
+			return 2
+		}
+		path = "/grace/extend"
+		form.Set("duration", flags.Arg(1))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", command)
+		flags.Usage()
+		return 2
+	}
+
+	body, err := callControl(*socket, method, path, form, *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Print(body)
+	return 0
+}
+
+func callControl(socket, method, path string, form url.Values, timeout time.Duration) (string, error) {
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	// host is ignored by the unix socket dialer
+	var response *http.Response
+	var err error
+	if method == http.MethodGet {
+		response, err = client.Get("http://kubexit" + path + "?" + form.Encode())
+	} else {
+		response, err = client.PostForm("http://kubexit"+path, form)
+	}
+	if err != nil {
+		return "", errors.WithStack(fmt.Errorf("failed to call control socket %s: %v", socket, err))
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", errors.WithStack(fmt.Errorf("failed to read control response: %v", err))
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", errors.Errorf("control request failed: %s: %s", response.Status, body)
+	}
+	return string(body), nil
+}
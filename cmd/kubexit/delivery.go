@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/artifacts"
+	"github.com/ispringtech/kubexit/pkg/retry"
+)
+
+// Final statuses of deliveries after the child death
+const (
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+	// deliveryBreakerOpen is a delivery skipped, its target failed in a row before
+	deliveryBreakerOpen = "breaker_open"
+	// deliveryDeadlineExceeded is a delivery stopped by KUBEXIT_DELIVERY_DEADLINE or its own timeout
+	deliveryDeadlineExceeded = "deadline_exceeded"
+)
+
+// deliveryStatus is the final status of a delivered object, reported in the exit report
+type deliveryStatus struct {
+	Target   string `json:"target"`
+	Object   string `json:"object"`
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	Location string `json:"location,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// deliverer delivers artifacts and exit report after the child death, retrying each object with the retry policy of ctx
+// within the single deadline, so flaky collectors can't delay the container termination past the grace period.
+// Each target has a circuit breaker, so a dead one is not retried for each object
+type deliverer struct {
+	ctx       context.Context
+	logger    *logrus.Logger
+	threshold int
+
+	m        sync.Mutex
+	breakers map[string]*retry.Breaker
+	statuses []deliveryStatus
+}
+
+// newDeliverer starts the deadline of all deliveries, stop releases it
+func newDeliverer(ctx context.Context, config *config, logger *logrus.Logger) (*deliverer, context.CancelFunc) {
+	ctx, stop := context.WithTimeout(ctx, config.DeliveryDeadline)
+	return &deliverer{
+		ctx:       ctx,
+		logger:    logger,
+		threshold: config.DeliveryBreakerThreshold,
+		breakers:  map[string]*retry.Breaker{},
+	}, stop
+}
+
+// within returns the context of deliveries limited by timeout besides the deadline of all deliveries
+func (d *deliverer) within(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(d.ctx, timeout)
+}
+
+// put delivers the object to the target sink, rewinding the body for each attempt. ctx must be returned by within
+func (d *deliverer) put(ctx context.Context, target string, sink artifacts.Sink, object string, body io.ReadSeeker) (string, error) {
+	status := deliveryStatus{Target: target, Object: object}
+	err := d.breaker(target).Do(ctx, target, func(ctx context.Context) error {
+		status.Attempts++
+		_, err := body.Seek(0, io.SeekStart)
+		if err != nil {
+			return retry.Permanent(errors.WithStack(err))
+		}
+		status.Location, err = sink.Put(ctx, object, body)
+		return err
+	})
+	switch {
+	case err == nil:
+		status.Status = deliveryDelivered
+	case errors.Cause(err) == retry.ErrBreakerOpen:
+		status.Status = deliveryBreakerOpen
+	case ctx.Err() != nil:
+		status.Status = deliveryDeadlineExceeded
+	default:
+		status.Status = deliveryFailed
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	d.logger.
+		WithField("target", target).
+		WithField("object", object).
+		WithField("status", status.Status).
+		WithField("attempts", status.Attempts).
+		Debug("delivery finished")
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	d.statuses = append(d.statuses, status)
+	return status.Location, err
+}
+
+func (d *deliverer) breaker(target string) *retry.Breaker {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	breaker, ok := d.breakers[target]
+	if !ok {
+		breaker = &retry.Breaker{Threshold: d.threshold}
+		d.breakers[target] = breaker
+	}
+	return breaker
+}
+
+// finished returns statuses of deliveries made so far
+func (d *deliverer) finished() []deliveryStatus {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	return append([]deliveryStatus(nil), d.statuses...)
+}
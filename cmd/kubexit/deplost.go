@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/audit"
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+)
+
+// Policies applied when a birth dep is not ready for longer than KUBEXIT_BIRTH_DEPS_LOST_AFTER while the child runs
+const (
+	depLostLog      = "log"
+	depLostSignal   = "signal"
+	depLostRestart  = "restart"
+	depLostShutdown = "shutdown"
+)
+
+// depLossMonitor applies the policy to the child, when its birth dep stays not ready for longer than downFor.
+// Hysteresis makes readiness blips during dep rollouts harmless: the dep must stay ready for upFor to recover,
+// shorter readiness doesn't stop counting down, and policy is applied once per loss until the dep recovers
+type depLossMonitor struct {
+	ctx         context.Context
+	child       *supervisor.Supervisor
+	restarter   *inPlaceRestarter
+	publisher   statePublisher
+	logger      *logrus.Logger
+	policy      string
+	downFor     time.Duration
+	upFor       time.Duration
+	signal      syscall.Signal
+	gracePeriod time.Duration
+	// recoveredSignal is sent to the child when lost dep recovers, zero disables
+	recoveredSignal syscall.Signal
+
+	m sync.Mutex
+	// deps are states of deps not ready or not yet recovered
+	deps map[string]*depLoss
+}
+
+// depLoss is a dep seen not ready, until it's ready for upFor
+type depLoss struct {
+	// down counts downFor to the loss, nil once the dep is lost
+	down *time.Timer
+	// up counts upFor to the recovery, nil while the dep is not ready
+	up   *time.Timer
+	lost bool
+}
+
+func newDepLossMonitor(ctx context.Context, config *config, child *supervisor.Supervisor, restarter *inPlaceRestarter, publisher statePublisher, logger *logrus.Logger) *depLossMonitor {
+	return &depLossMonitor{
+		ctx:             ctx,
+		child:           child,
+		restarter:       restarter,
+		publisher:       publisher,
+		logger:          logger,
+		policy:          config.BirthDepsLostPolicy,
+		downFor:         config.BirthDepsLostAfter,
+		upFor:           config.BirthDepsRecoveredAfter,
+		signal:          config.BirthDepsLostSignal,
+		gracePeriod:     config.GracePeriod,
+		recoveredSignal: config.BirthDepsRecoveredSignal,
+		deps:            map[string]*depLoss{},
+	}
+}
+
+// setState is called on each readiness report of the dep
+func (m *depLossMonitor) setState(name string, ready bool) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	loss := m.deps[name]
+	switch {
+	case ready && loss != nil && loss.up == nil:
+		event.ContextEventTrace(m.ctx).AddEvent(fmt.Sprintf("Birth dep %s is ready, recovered after %s", name, m.upFor))
+		loss.up = time.AfterFunc(m.upFor, crash.Func(m.ctx, "birth dep recovery timer", func() {
+			m.recover(name, loss)
+		}))
+	case !ready && loss == nil:
+		event.ContextEventTrace(m.ctx).AddEvent(fmt.Sprintf("Birth dep %s is not ready, applying %s policy after %s", name, m.policy, m.downFor))
+		loss = &depLoss{}
+		loss.down = time.AfterFunc(m.downFor, crash.Func(m.ctx, "birth dep loss timer", func() {
+			m.lose(name, loss)
+		}))
+		m.deps[name] = loss
+	case !ready && loss.up != nil:
+		// readiness blip, counting down goes on
+		event.ContextEventTrace(m.ctx).AddEvent(fmt.Sprintf("Birth dep %s is not ready again before recovery", name))
+		loss.up.Stop()
+		loss.up = nil
+	}
+}
+
+// recover forgets the dep ready for upFor, so it may be lost again
+func (m *depLossMonitor) recover(name string, loss *depLoss) {
+	m.m.Lock()
+	if m.deps[name] != loss || loss.up == nil {
+		// stale timer
+		m.m.Unlock()
+		return
+	}
+	if loss.down != nil {
+		loss.down.Stop()
+	}
+	delete(m.deps, name)
+	lost := loss.lost
+	m.m.Unlock()
+
+	event.ContextEventTrace(m.ctx).AddEvent(fmt.Sprintf("Birth dep %s recovered", name))
+	if !lost || m.recoveredSignal == 0 || m.ctx.Err() != nil {
+		return
+	}
+
+	m.logger.WithField("dep", name).WithField("signal", m.recoveredSignal.String()).Info("birth dep recovered, signaling child")
+	err := m.child.Signal(m.recoveredSignal)
+	if err != nil {
+		m.logger.WithError(err).WithField("dep", name).Error("failed to signal child on birth dep recovery")
+	}
+}
+
+// lose applies the policy to the dep not ready for downFor, even if ready shortly within
+func (m *depLossMonitor) lose(name string, loss *depLoss) {
+	m.m.Lock()
+	if m.deps[name] != loss || loss.down == nil {
+		// recovered meanwhile
+		m.m.Unlock()
+		return
+	}
+	loss.down = nil
+	loss.lost = true
+	m.m.Unlock()
+
+	m.apply(name)
+}
+
+func (m *depLossMonitor) apply(name string) {
+	if m.ctx.Err() != nil || m.child.Pid() == 0 || m.child.ShutdownRequested() {
+		return
+	}
+
+	m.logger.WithField("dep", name).WithField("after", m.downFor.String()).WithField("policy", m.policy).Warn("birth dep lost")
+	event.ContextEventTrace(m.ctx).AddEvent(fmt.Sprintf("Birth dep %s lost, policy: %s", name, m.policy))
+
+	var err error
+	switch m.policy {
+	case depLostSignal:
+		err = m.child.Signal(m.signal)
+	case depLostRestart:
+		err = m.restarter.request(restartReasonBirthDepLost)
+	case depLostShutdown:
+		audit.ContextLog(m.ctx).Append(audit.Record{Event: audit.EventShutdown, Trigger: "birth_dependency_lost"})
+		m.publisher.Publish(childStateDraining, nil)
+		err = m.child.ShutdownWithTimeout(m.gracePeriod)
+	}
+	if err != nil {
+		m.logger.WithError(err).WithField("dep", name).Error("failed to apply birth dep loss policy")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/kubexit"
+)
+
+// errorCodeField is the log field with stable error code, so alerting rules can distinguish failure classes
+const errorCodeField = "error_code"
+
+const (
+	errorCodeInternal             = "INTERNAL"
+	errorCodeNoCommand            = "NO_COMMAND"
+	errorCodeConfigInvalid        = "CONFIG_INVALID"
+	errorCodeMetricsServerFailed  = "METRICS_SERVER_FAILED"
+	errorCodeControlSocketFailed  = "CONTROL_SOCKET_FAILED"
+	errorCodeGraveyardWatchFailed = "GRAVEYARD_WATCH_FAILED"
+	errorCodeDuplicateName        = "DUPLICATE_NAME"
+	errorCodeBirthTimeout         = "BIRTH_TIMEOUT"
+	errorCodeBirthInterrupted     = "BIRTH_INTERRUPTED"
+	errorCodeBirthWatchFailed     = "BIRTH_WATCH_FAILED"
+	errorCodePortsBusy            = "PORTS_BUSY"
+	errorCodeLockTimeout          = "LOCK_TIMEOUT"
+	errorCodeLockFailed           = "LOCK_FAILED"
+	errorCodeChildStartFailed     = "CHILD_START_FAILED"
+	errorCodeTombstoneWriteFailed = "TOMBSTONE_WRITE_FAILED"
+	errorCodeEventTraceFailed     = "EVENT_TRACE_FAILED"
+	errorCodeArtifactsFailed      = "ARTIFACTS_FAILED"
+	errorCodeUploadFailed         = "UPLOAD_FAILED"
+	errorCodeSupervisorCrashed    = "SUPERVISOR_CRASHED"
+	errorCodeFatalTimeout         = "FATAL_TIMEOUT"
+	errorCodeHookFailed           = "HOOK_FAILED"
+	errorCodeOutputFailed         = "OUTPUT_FAILED"
+)
+
+// codedError attaches error code to the error, keeping its cause for stack trace
+type codedError struct {
+	code string
+	err  error
+}
+
+func withErrorCode(code string, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Cause() error {
+	return e.err
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// errorCode returns the outermost code attached to err, errorCodeInternal if none
+func errorCode(err error) string {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return errorCodeInternal
+}
+
+// birthErrorCode classifies WaitForBirthDeps error
+func birthErrorCode(err error) string {
+	switch errors.Cause(err) {
+	case kubexit.ErrBirthTimeout:
+		return errorCodeBirthTimeout
+	case kubexit.ErrBirthInterrupted:
+		return errorCodeBirthInterrupted
+	default:
+		return errorCodeBirthWatchFailed
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/lock"
+)
+
+// acquireExclusiveLock queues for the configured exclusive lock, so only one participant sharing the lock directory runs its child at a time.
+// Waits until ExclusiveLockTimeout elapses, if set, or ctx is done
+func acquireExclusiveLock(ctx context.Context, config *config) (*lock.Lock, error) {
+	if config.ExclusiveLockTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.ExclusiveLockTimeout)
+		defer cancel()
+	}
+
+	path := filepath.Join(config.ExclusiveLockDir, config.ExclusiveLock+".lock")
+	exclusive, err := lock.Acquire(ctx, path, config.Name, config.BirthCheckInterval)
+	switch errors.Cause(err) {
+	case nil:
+		return exclusive, nil
+	case context.DeadlineExceeded:
+		return nil, withErrorCode(errorCodeLockTimeout, errors.Wrapf(err, "exclusive lock timeout %s elapsed", config.ExclusiveLockTimeout))
+	case context.Canceled:
+		return nil, withErrorCode(errorCodeBirthInterrupted, err)
+	default:
+		return nil, withErrorCode(errorCodeLockFailed, err)
+	}
+}
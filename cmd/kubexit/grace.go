@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	qosGuaranteed = "Guaranteed"
+	qosBurstable  = "Burstable"
+	qosBestEffort = "BestEffort"
+
+	// maxGraceScale caps scaling of the default grace period
+	maxGraceScale = 4
+)
+
+// scaleGracePeriod scales the default grace period for containers, which are likely throttled and need longer to flush and exit:
+// 1.5x for Burstable and 2x for BestEffort pods, and inversely to CPU limit below one core.
+// The result is capped by maxGraceScale and by the pod termination grace period, if known, after which kubelet kills the container anyway
+func scaleGracePeriod(gracePeriod time.Duration, qosClass string, cpuLimitMillis int64, podGracePeriod time.Duration) time.Duration {
+	scale := 1.0
+	switch qosClass {
+	case qosBurstable:
+		scale = 1.5
+	case qosBestEffort:
+		scale = 2
+	}
+	if cpuLimitMillis > 0 && cpuLimitMillis < 1000 {
+		scale *= 1000 / float64(cpuLimitMillis)
+	}
+	if scale > maxGraceScale {
+		scale = maxGraceScale
+	}
+
+	scaled := time.Duration(float64(gracePeriod) * scale)
+	if podGracePeriod > 0 && scaled > podGracePeriod {
+		scaled = podGracePeriod
+	}
+	return scaled
+}
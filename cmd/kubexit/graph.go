@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ispringtech/kubexit/pkg/depgraph"
+	"github.com/ispringtech/kubexit/pkg/tombstone"
+)
+
+const (
+	graphFormatDOT  = "dot"
+	graphFormatJSON = "json"
+)
+
+// runGraph prints dependency graph of this container, configured by env as for supervising,
+// together with the other participants of the pod listed in the manifest and recorded in the graveyard, if it is readable
+func runGraph(args []string) int {
+	flags := flag.NewFlagSet("graph", flag.ContinueOnError)
+	format := flags.String("format", graphFormatDOT, "output format: dot or json")
+	podWide := flags.Bool("pod", true, "include participants recorded in the graveyard")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if *format != graphFormatDOT && *format != graphFormatJSON {
+		fmt.Fprintf(os.Stderr, "invalid format %s, expected one of: dot, json\n", *format)
+		return 2
+	}
+
+	config, err := parseConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	graph := depgraph.New()
+	if config.Manifest != "" {
+		m, err := readManifest(config.Manifest)
+		if err == nil {
+			err = m.addTo(graph)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+	if *podWide {
+		tombstones, err := tombstone.ReadAll(config.Graveyard, config.TombstoneKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "graveyard is not readable, showing this container only: %v\n", err)
+		}
+		for _, ts := range tombstones {
+			graph.Add(depgraph.Node{Name: ts.Name, BirthDeps: ts.BirthDeps, DeathDeps: ts.DeathDeps})
+		}
+	}
+	// own config is newer than the own tombstone
+	graph.Add(depgraph.Node{Name: config.Name, BirthDeps: config.BirthDeps, DeathDeps: config.DeathDeps})
+
+	if *format == graphFormatJSON {
+		err = graph.WriteJSON(os.Stdout)
+	} else {
+		err = graph.WriteDOT(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// holdOnFailure keeps the container alive after abnormal child exit, so engineers can inspect it with kubectl exec
+// before kubelet restarts it. Death is recorded already. Returns when hold elapses or kubexit is terminated
+func holdOnFailure(ctx context.Context, logger *logrus.Logger, hold time.Duration, exitCode int) {
+	ctx, stopSignals := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stopSignals()
+
+	ctx, cancel := context.WithTimeout(ctx, hold)
+	defer cancel()
+
+	logger.WithField("exit_code", exitCode).WithField("hold", hold.String()).
+		Warn("child failed, holding container for inspection")
+	<-ctx.Done()
+	logger.Info("hold on failure finished")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/event"
+)
+
+// Hooks run around the child, named as in the event trace
+const (
+	hookPreStart = "pre-start"
+	hookPostStop = "post-stop"
+)
+
+// maxHookOutput bounds hook output recorded in the event trace
+const maxHookOutput = 4096
+
+// runHook runs the hook command within timeout and records its output in the event trace of ctx.
+// Returns error when the hook fails or times out
+func runHook(ctx context.Context, name string, argv []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	trace := event.ContextEventTrace(ctx)
+	trace.AddEvent(fmt.Sprintf("Hook %s: running %s", name, strings.Join(argv, " ")))
+
+	begin := time.Now()
+	// #nosec G204 hook command is configured by kubexit user
+	output, err := exec.CommandContext(ctx, argv[0], argv[1:]...).CombinedOutput()
+	elapsed := time.Since(begin).Round(time.Millisecond)
+
+	text := strings.TrimSpace(string(output))
+	if len(text) > maxHookOutput {
+		text = text[:maxHookOutput] + "..."
+	}
+	if text != "" {
+		trace.AddEvent(fmt.Sprintf("Hook %s output: %s", name, text))
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.Errorf("hook %s timed out after %s", name, timeout)
+	}
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("hook %s failed after %s: %v", name, elapsed, err))
+	}
+	trace.AddEvent(fmt.Sprintf("Hook %s: completed in %s", name, elapsed))
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// runInstall copies the running kubexit binary (and optionally a config file) into a shared volume,
+// e.g. from an init container injecting kubexit into other containers
+func runInstall(args []string) int {
+	flags := flag.NewFlagSet("install", flag.ContinueOnError)
+	dest := flags.String("dest", "/kubexit", "directory to install kubexit into")
+	name := flags.String("name", "kubexit", "file name of the installed binary")
+	configPath := flags.String("config", "", "optional config file to copy next to the binary")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	source, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to find kubexit executable: %v\n", err)
+		return 1
+	}
+
+	target := filepath.Join(*dest, *name)
+	checksum, err := installFile(source, target, 0o755)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	// checksum file allows to verify binary later, e.g. `sha256sum -c kubexit.sha256`
+	err = ioutil.WriteFile(target+".sha256", []byte(fmt.Sprintf("%s  %s\n", checksum, *name)), 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write checksum: %v\n", err)
+		return 1
+	}
+	fmt.Printf("installed %s to %s (sha256 %s)\n", source, target, checksum)
+
+	if *configPath != "" {
+		configTarget := filepath.Join(*dest, filepath.Base(*configPath))
+		_, err = installFile(*configPath, configTarget, 0o644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Printf("installed %s to %s\n", *configPath, configTarget)
+	}
+
+	return 0
+}
+
+// installFile copies source to target atomically and verifies the copy has the same sha256 checksum.
+// Returns hex encoded checksum
+func installFile(source, target string, perm os.FileMode) (string, error) {
+	err := os.MkdirAll(filepath.Dir(target), os.ModePerm)
+	if err != nil {
+		return "", errors.WithStack(fmt.Errorf("failed to create install directory: %v", err))
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return "", errors.WithStack(fmt.Errorf("failed to open %s: %v", source, err))
+	}
+	defer in.Close()
+
+	// write to temp file in the same directory, so rename is atomic and a partially copied binary is never executed
+	tmp, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".tmp")
+	if err != nil {
+		return "", errors.WithStack(fmt.Errorf("failed to create temp file: %v", err))
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), in)
+	if err != nil {
+		tmp.Close()
+		return "", errors.WithStack(fmt.Errorf("failed to copy %s: %v", source, err))
+	}
+	err = tmp.Close()
+	if err != nil {
+		return "", errors.WithStack(fmt.Errorf("failed to write %s: %v", tmp.Name(), err))
+	}
+
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return "", errors.WithStack(fmt.Errorf("failed to chmod %s: %v", tmp.Name(), err))
+	}
+
+	err = os.Rename(tmp.Name(), target)
+	if err != nil {
+		return "", errors.WithStack(fmt.Errorf("failed to move %s to %s: %v", tmp.Name(), target, err))
+	}
+
+	expected := hash.Sum(nil)
+	actual, err := fileChecksum(target)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(expected, actual) {
+		return "", errors.Errorf("integrity check failed for %s: expected sha256 %x, got %x", target, expected, actual)
+	}
+
+	return hex.EncodeToString(actual), nil
+}
+
+func fileChecksum(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to open %s: %v", path, err))
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to read %s: %v", path, err))
+	}
+	return hash.Sum(nil), nil
+}
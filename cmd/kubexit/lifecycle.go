@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+)
+
+// Stages of kubexit exit, subsystems are stopped stage by stage in the order
+const (
+	// stageControl closes the control socket. Requests acting on the child are stopped before its shutdown already,
+	// see control.stopActing
+	stageControl = iota
+	// stageSubsystems stops watchers, monitors and samplers of the exited child, and tombstone renewal,
+	// so nothing writes the tombstone after the death is recorded
+	stageSubsystems
+	// stageFlush finishes deliveries, flushes event traces and child output, before the death is recorded
+	stageFlush
+	// stageListeners closes listeners last, so status and metrics are served until the exit
+	stageListeners
+
+	stageCount
+)
+
+// lifecycleStopTimeout bounds each stop, so a stuck subsystem doesn't hold the container termination
+const lifecycleStopTimeout = 5 * time.Second
+
+// lifecycle stops kubexit subsystems on exit in the deterministic order of stages, waiting for their goroutines,
+// so they don't race the process exit and lose events. Stops of a stage are called in reverse order of registration
+type lifecycle struct {
+	ctx    context.Context
+	logger *logrus.Logger
+
+	m       sync.Mutex
+	stops   [stageCount][]namedStop
+	stopped int
+}
+
+type namedStop struct {
+	name string
+	stop func()
+}
+
+// newLifecycle returns lifecycle calling stops with the crash handler of ctx
+func newLifecycle(ctx context.Context, logger *logrus.Logger) *lifecycle {
+	return &lifecycle{ctx: ctx, logger: logger}
+}
+
+// onStop registers stop of subsystem name called at the stage. Stop registered after the stage is called at once
+func (l *lifecycle) onStop(stage int, name string, stop func()) {
+	l.m.Lock()
+	if stage >= l.stopped {
+		l.stops[stage] = append(l.stops[stage], namedStop{name: name, stop: stop})
+		l.m.Unlock()
+		return
+	}
+	l.m.Unlock()
+
+	l.call(namedStop{name: name, stop: stop})
+}
+
+// goUntil runs f in background until the stage, which cancels ctx and waits for f to return
+func (l *lifecycle) goUntil(ctx context.Context, stage int, name string, f func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	crash.Go(ctx, name, func() {
+		defer close(done)
+		f(ctx)
+	})
+	l.onStop(stage, name, func() {
+		cancel()
+		<-done
+	})
+}
+
+// stopUntil stops stages up to and including stage, skipping ones stopped before
+func (l *lifecycle) stopUntil(stage int) {
+	for {
+		l.m.Lock()
+		if l.stopped > stage {
+			l.m.Unlock()
+			return
+		}
+		stops := l.stops[l.stopped]
+		l.stops[l.stopped] = nil
+		l.stopped++
+		l.m.Unlock()
+
+		for i := len(stops) - 1; i >= 0; i-- {
+			l.call(stops[i])
+		}
+	}
+}
+
+// stop stops all stages, it is deferred by runApp, so fatal exits are ordered too
+func (l *lifecycle) stop() {
+	l.stopUntil(stageCount - 1)
+}
+
+// call calls stop within lifecycleStopTimeout, the stop left running is abandoned to the process exit
+func (l *lifecycle) call(s namedStop) {
+	done := make(chan struct{})
+	crash.Go(l.ctx, s.name+" stop", func() {
+		defer close(done)
+		s.stop()
+	})
+
+	timer := time.NewTimer(lifecycleStopTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		l.logger.WithField("subsystem", s.name).WithField("timeout", lifecycleStopTimeout).Warn("subsystem did not stop in time")
+	}
+}
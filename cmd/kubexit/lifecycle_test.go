@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testLifecycle() *lifecycle {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	return newLifecycle(context.Background(), logger)
+}
+
+// stopRecorder records names of called stops in order
+type stopRecorder struct {
+	m       sync.Mutex
+	stopped []string
+}
+
+func (r *stopRecorder) stop(name string) func() {
+	return func() {
+		r.m.Lock()
+		defer r.m.Unlock()
+		r.stopped = append(r.stopped, name)
+	}
+}
+
+func (r *stopRecorder) assert(t *testing.T, expected ...string) {
+	t.Helper()
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	if !reflect.DeepEqual(r.stopped, expected) {
+		t.Fatalf("expected stops %v, got %v", expected, r.stopped)
+	}
+}
+
+func TestLifecycleStopOrder(t *testing.T) {
+	lc := testLifecycle()
+	var r stopRecorder
+
+	// registered out of stage order
+	lc.onStop(stageListeners, "metrics server", r.stop("metrics server"))
+	lc.onStop(stageFlush, "event traces", r.stop("event traces"))
+	lc.onStop(stageSubsystems, "pod watcher", r.stop("pod watcher"))
+	lc.onStop(stageControl, "control socket", r.stop("control socket"))
+	lc.onStop(stageSubsystems, "tombstone renewal", r.stop("tombstone renewal"))
+	lc.onStop(stageFlush, "deliveries", r.stop("deliveries"))
+
+	lc.stopUntil(stageSubsystems)
+	// stops of a stage are called in reverse order of registration
+	r.assert(t, "control socket", "tombstone renewal", "pod watcher")
+
+	lc.stop()
+	r.assert(t, "control socket", "tombstone renewal", "pod watcher", "deliveries", "event traces", "metrics server")
+
+	// stopped stages are not stopped again
+	lc.stopUntil(stageFlush)
+	lc.stop()
+	r.assert(t, "control socket", "tombstone renewal", "pod watcher", "deliveries", "event traces", "metrics server")
+}
+
+func TestLifecycleLateOnStop(t *testing.T) {
+	lc := testLifecycle()
+	var r stopRecorder
+
+	lc.onStop(stageFlush, "event traces", r.stop("event traces"))
+	lc.stopUntil(stageSubsystems)
+
+	// registered after its stage is stopped, e.g. by subsystem started late, it's called at once
+	lc.onStop(stageControl, "control socket", r.stop("control socket"))
+	r.assert(t, "control socket")
+
+	// registered before its stage is stopped, it waits for the stage
+	lc.onStop(stageFlush, "deliveries", r.stop("deliveries"))
+	r.assert(t, "control socket")
+
+	lc.stop()
+	r.assert(t, "control socket", "deliveries", "event traces")
+
+	lc.onStop(stageListeners, "metrics server", r.stop("metrics server"))
+	r.assert(t, "control socket", "deliveries", "event traces", "metrics server")
+}
+
+func TestLifecycleGoUntil(t *testing.T) {
+	lc := testLifecycle()
+	var r stopRecorder
+
+	started := make(chan struct{})
+	lc.goUntil(context.Background(), stageSubsystems, "sampler", func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		r.stop("sampler")()
+	})
+	lc.onStop(stageFlush, "event traces", r.stop("event traces"))
+	<-started
+
+	// the goroutine returns before the next stage is stopped
+	lc.stop()
+	r.assert(t, "sampler", "event traces")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/audit"
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/readiness"
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+)
+
+// Actions on the child failing liveness probe
+const (
+	// livenessRestart kills the child and restarts it in place
+	livenessRestart = "restart"
+	// livenessShutdown stops the child gracefully, recording its death
+	livenessShutdown = "shutdown"
+)
+
+// parseLivenessProbe parses liveness probe spec, one of tcp, http or exec birth dep specs
+func parseLivenessProbe(spec string) (readiness.Probe, error) {
+	dep, err := readiness.ParseDep(spec)
+	if err != nil {
+		return nil, err
+	}
+	switch dep.Kind {
+	case readiness.KindTCP, readiness.KindHTTP, readiness.KindExec:
+		return readiness.NewProbe(dep, readiness.Graveyard{})
+	default:
+		return nil, errors.Errorf("unsupported liveness probe %s, expected one of: tcp://host:port, http://host:port/path, exec:command", spec)
+	}
+}
+
+// childLiveness probes the running child every interval and applies the action to the child failing
+// threshold probes in a row, e.g. wedged but not exited, which would keep the pod alive forever
+type childLiveness struct {
+	ctx          context.Context
+	child        *supervisor.Supervisor
+	restarter    *inPlaceRestarter
+	publisher    statePublisher
+	logger       *logrus.Logger
+	probe        readiness.Probe
+	initialDelay time.Duration
+	interval     time.Duration
+	timeout      time.Duration
+	threshold    int
+	action       string
+	gracePeriod  time.Duration
+
+	m    sync.Mutex
+	stop context.CancelFunc
+}
+
+func newChildLiveness(ctx context.Context, config *config, child *supervisor.Supervisor, restarter *inPlaceRestarter, publisher statePublisher, logger *logrus.Logger) (*childLiveness, error) {
+	probe, err := parseLivenessProbe(config.LivenessProbe)
+	if err != nil {
+		return nil, err
+	}
+	return &childLiveness{
+		ctx:          ctx,
+		child:        child,
+		restarter:    restarter,
+		publisher:    publisher,
+		logger:       logger,
+		probe:        probe,
+		initialDelay: config.LivenessInitialDelay,
+		interval:     config.LivenessInterval,
+		timeout:      config.LivenessTimeout,
+		threshold:    config.LivenessFailureThreshold,
+		action:       config.LivenessAction,
+		gracePeriod:  config.GracePeriod,
+	}, nil
+}
+
+// arm starts probing the started child after the initial delay. No-op for nil liveness, i.e. disabled
+func (l *childLiveness) arm() {
+	if l == nil {
+		return
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.stop != nil {
+		l.stop()
+	}
+	ctx, stop := context.WithCancel(l.ctx)
+	l.stop = stop
+	crash.Go(ctx, "liveness probe", func() {
+		l.run(ctx)
+	})
+}
+
+// disarm stops probing, e.g. when the child exited
+func (l *childLiveness) disarm() {
+	if l == nil {
+		return
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.stop != nil {
+		l.stop()
+		l.stop = nil
+	}
+}
+
+// run probes the child until ctx is done or the action is applied once, the next run starts with the restarted child
+func (l *childLiveness) run(ctx context.Context) {
+	if l.initialDelay > 0 {
+		timer := time.NewTimer(l.initialDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, l.timeout)
+		err := l.probe(probeCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case err == nil && failures > 0:
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Liveness probe succeeded after %d failures", failures))
+			failures = 0
+		case err != nil:
+			failures++
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Liveness probe failed %d/%d: %v", failures, l.threshold, err))
+			if failures >= l.threshold {
+				l.fail(err)
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *childLiveness) fail(probeErr error) {
+	if l.child.Pid() == 0 || l.child.ShutdownRequested() {
+		return
+	}
+
+	l.logger.
+		WithError(probeErr).
+		WithField("failures", l.threshold).
+		WithField("action", l.action).
+		Warn("child failed liveness probe")
+	event.ContextEventTrace(l.ctx).AddEvent(fmt.Sprintf("Liveness probe failed %d times, action: %s", l.threshold, l.action))
+
+	var err error
+	switch l.action {
+	case livenessRestart:
+		err = l.restarter.request(restartReasonLiveness)
+	case livenessShutdown:
+		audit.ContextLog(l.ctx).Append(audit.Record{Event: audit.EventShutdown, Trigger: "liveness_probe"})
+		l.publisher.Publish(childStateDraining, nil)
+		err = l.child.ShutdownWithTimeout(l.gracePeriod)
+	}
+	if err != nil {
+		l.logger.WithError(err).Error("failed to stop child on liveness probe failure")
+	}
+}
@@ -5,79 +5,337 @@ import (
 	"encoding/json"
 	"fmt"
 	stdlog "log"
+	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
 
-	"github.com/fsnotify/fsnotify"
-
+	"github.com/ispringtech/kubexit/pkg/audit"
+	"github.com/ispringtech/kubexit/pkg/chaos"
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/drain"
 	"github.com/ispringtech/kubexit/pkg/event"
-	"github.com/ispringtech/kubexit/pkg/kubernetes"
+	"github.com/ispringtech/kubexit/pkg/health"
+	"github.com/ispringtech/kubexit/pkg/kubexit"
+	"github.com/ispringtech/kubexit/pkg/lock"
 	"github.com/ispringtech/kubexit/pkg/loggerhook"
+	"github.com/ispringtech/kubexit/pkg/metrics"
+	"github.com/ispringtech/kubexit/pkg/readiness"
+	"github.com/ispringtech/kubexit/pkg/retry"
+	"github.com/ispringtech/kubexit/pkg/sdnotify"
 	"github.com/ispringtech/kubexit/pkg/supervisor"
+	"github.com/ispringtech/kubexit/pkg/timestamp"
 	"github.com/ispringtech/kubexit/pkg/tombstone"
 
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/watch"
-
 	"github.com/sirupsen/logrus"
 )
 
+const graveyardProbeTimeout = time.Second
+
+// fatalTimeoutExitCode is returned when stopping after a terminal error exceeds FatalTimeout, as of timeout(1)
+const fatalTimeoutExitCode = 124
+
 func main() {
+	begin := time.Now()
+
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
 	config, err := parseConfig()
 	if err != nil {
 		stdlog.Fatalf("failed to parse conf: %s", err)
 	}
 
+	startup := newStartupTimer(begin)
+	startup.observe(phaseConfigParse, begin)
+
+	timestamp.SetDefault(config.timeFormat())
+	tombstone.SetStrict(config.TombstoneStrict)
+	setPodWatchOptions(config)
+	readiness.SetResolver(config.resolverOptions())
 	logger := initLogger(config)
 
 	logger.WithField("config", *config).Info("kubexit initialized")
 
-	os.Exit(runApp(config, logger))
+	os.Exit(runApp(config, logger, startup, os.Args[1:]))
 }
 
-// runApp should return exit code
-func runApp(config *config, logger *logrus.Logger) int {
+// runApp should return exit code.
+// options are applied over coordinator config, e.g. to replace watchers
+func runApp(config *config, logger *logrus.Logger, startup *startupTimer, args []string, options ...kubexit.Option) int {
 	var eventTraces []event.Trace
 	eventTraceFactory := eventTraceFactoryMethod(config, logger)
 
 	var err error
 
-	args := os.Args[1:]
 	if len(args) == 0 {
-		logger.Errorf("no arguments found")
+		args = config.Command
+	}
+	if len(args) == 0 {
+		logger.WithField(errorCodeField, errorCodeNoCommand).Errorf("no arguments found")
 		return 2
 	}
 
+	registry := metrics.NewRegistry()
+	baseCtx := metrics.WithRecorder(context.Background(), registry)
+	healthRegistry := health.NewRegistry(registry)
+	baseCtx = health.WithRegistry(baseCtx, healthRegistry)
+	baseCtx = retry.WithPolicy(baseCtx, config.retryPolicy())
+
+	crashes := newCrashHandler(config, logger)
+	baseCtx = crash.WithHandler(baseCtx, crashes.handle)
+	defer crash.Recover(baseCtx, "main")
+
+	// subsystems are stopped at return in the order of lifecycle stages, after the child exited
+	lc := newLifecycle(baseCtx, logger)
+	defer lc.stop()
+
+	if config.TracePersist {
+		var persistTraces func([]event.Trace)
+		eventTraceFactory, persistTraces = restoreEventTraces(config, logger, eventTraceFactory)
+		// traces are read at the stop, when all of them are created
+		lc.onStop(stageFlush, "event traces", func() {
+			persistTraces(eventTraces)
+		})
+	}
+
+	if config.Audit {
+		auditTrace := eventTraceFactory("audit")
+		eventTraces = append(eventTraces, auditTrace)
+
+		baseCtx = audit.WithLog(baseCtx, audit.NewFileLog(event.WithEventTrace(baseCtx, auditTrace), config.Graveyard, config.tombstoneName()))
+	}
+
+	if config.HandlerErrorEvents {
+		baseCtx = event.WithWarnings(baseCtx, newPodEventWarnings(baseCtx, config))
+	}
+
+	// chaos mode is deliberately kept out of config, it is not meant for production
+	if spec := os.Getenv("KUBEXIT_CHAOS"); spec != "" {
+		injector, err2 := chaos.Parse(spec)
+		if err2 != nil {
+			logger.WithField(errorCodeField, errorCodeConfigInvalid).Errorf("failed to parse KUBEXIT_CHAOS: %v", err2)
+			return 2
+		}
+		logger.WithField("chaos", spec).Warn("chaos mode enabled, failures will be injected")
+		baseCtx = chaos.WithInjector(baseCtx, injector)
+	}
+
+	status := newChildStatus(config.Name)
+
+	if config.MetricsAddr != "" {
+		ctx, stopServer := context.WithCancel(baseCtx)
+
+		serverTrace := eventTraceFactory("metrics server")
+		eventTraces = append(eventTraces, serverTrace)
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry)
+		mux.Handle("/status", status)
+		mux.Handle("/healthz", healthRegistry)
+
+		served, err2 := serveHTTP(event.WithEventTrace(ctx, serverTrace), "metrics server", config.MetricsNetwork, config.MetricsAddr, mux)
+		if err2 != nil {
+			stopServer()
+			logger.WithError(err2).WithField(errorCodeField, errorCodeMetricsServerFailed).Error()
+			return 2
+		}
+		lc.onStop(stageListeners, "metrics server", func() {
+			stopServer()
+			<-served
+		})
+	}
+
+	if config.SelfMonitorInterval > 0 {
+		lc.goUntil(baseCtx, stageSubsystems, "self monitor", func(ctx context.Context) {
+			monitorSelf(ctx, config, logger)
+		})
+	}
+
 	tbEventTrace := eventTraceFactory(fmt.Sprintf("%s tombstone", config.Name))
 	eventTraces = append(eventTraces, tbEventTrace)
 
-	tombstoneCtx := event.WithEventTrace(
-		context.Background(),
-		tbEventTrace,
-	)
-	ts := &tombstone.Tombstone{
-		Context:   tombstoneCtx,
-		Graveyard: config.Graveyard,
-		Name:      config.Name,
-	}
+	ts := newTombstoneWriter(event.WithEventTrace(baseCtx, tbEventTrace), config, logger)
 
 	supervisorTrace := eventTraceFactory("supervisor")
 	eventTraces = append(eventTraces, supervisorTrace)
 
-	child := supervisor.New(event.WithEventTrace(context.Background(), supervisorTrace), args[0], args[1:]...)
+	child := supervisor.New(event.WithEventTrace(baseCtx, supervisorTrace), args[0], args[1:]...)
+	crashes.attach(child, ts)
+
+	if config.DumpOnSIGUSR1 {
+		// SIGUSR1 is handled by dumpOnSignal
+		child.SkipSignals(syscall.SIGUSR1)
+	}
+	if len(config.ForwardSignals) > 0 {
+		child.ForwardSignals(osSignals(config.ForwardSignals)...)
+	}
+	child.SkipSignals(osSignals(config.IgnoreSignals)...)
+	child.SetTermPolicy(config.sigtermPolicy(termPhaseRunning), config.sigtermPolicy(termPhaseDraining), config.GracePeriod)
+	child.SetShutdownSignal(config.ShutdownSignal)
+	if config.RunAs != nil {
+		child.SetCredential(config.RunAs.UID, config.RunAs.GID)
+	}
+	if config.OutputDir != "" || config.OutputFormat == outputFormatJSON {
+		output, err2 := openChildOutput(config)
+		if err2 != nil {
+			logger.WithError(err2).WithField(errorCodeField, errorCodeOutputFailed).Error("failed to open child output")
+			return 2
+		}
+		lc.onStop(stageFlush, "child output", output.close)
+
+		child.SetOutput(output.writers())
+	}
+	child.SetShutdownEscalation(config.ShutdownEscalation)
+	child.SetRestartPolicy(supervisor.RestartPolicy{
+		Mode:       config.RestartPolicy,
+		MaxRetries: config.RestartMaxRetries,
+		Backoff: retry.Policy{
+			Initial: config.RestartBackoff,
+			Max:     config.RestartBackoffMax,
+			Jitter:  config.RetryJitter,
+		},
+	})
+
+	publisherTrace := eventTraceFactory("state publisher")
+	eventTraces = append(eventTraces, publisherTrace)
+
+	publisher := multiStatePublisher{status, newStatePublisher(event.WithEventTrace(baseCtx, publisherTrace), config)}
+
+	if config.GraveyardScope != graveyardScopePod {
+		err = prepareNodeGraveyard(event.WithEventTrace(baseCtx, tbEventTrace), config, logger, ts)
+		if err != nil {
+			return fatalf(logger, eventTraces, child, ts, publisher, config, err)
+		}
+	}
+
+	if !config.GraveyardReadOnly {
+		// Cancel context on SIGTERM to trigger graceful exit
+		ctx, stopSignals := withCancelOnSignal(event.WithEventTrace(baseCtx, tbEventTrace), syscall.SIGTERM)
+		defer stopSignals()
+
+		err = tombstone.CheckDuplicate(ctx, config.Graveyard, config.tombstoneName(), config.PodUID, config.TombstoneKey, config.PollInterval)
+		if err != nil && !errors.Is(err, tombstone.ErrDuplicateName) {
+			return fatalf(logger, eventTraces, child, ts, publisher, config, withErrorCode(errorCodeBirthInterrupted, err))
+		}
+		if err != nil {
+			// not fatalf, recording death would overwrite the tombstone of another live kubexit
+			logger.WithError(err).WithField(errorCodeField, errorCodeDuplicateName).
+				Error("KUBEXIT_NAME must be unique among participants sharing the graveyard")
+			err2 := writeTerminationMessage(config.TerminationMessagePath, 1, fmt.Sprintf("kubexit failed: %v", err))
+			if err2 != nil {
+				logger.WithError(err2).Error()
+			}
+			return 1
+		}
+	}
+
+	if config.DrainCheck != "" {
+		drainTrace := eventTraceFactory("drain")
+		eventTraces = append(eventTraces, drainTrace)
+
+		check, err2 := drain.ParseCheck(config.DrainCheck)
+		if err2 != nil {
+			return fatalf(logger, eventTraces, child, ts, publisher, config, withErrorCode(errorCodeConfigInvalid, err2))
+		}
+		child.SetGraceExtender(drain.NewExtender(event.WithEventTrace(baseCtx, drainTrace), check, drain.Options{
+			Interval:     config.DrainCheckInterval,
+			StallTimeout: config.DrainStallTimeout,
+			MaxGrace:     config.DrainMaxGrace,
+		}))
+	}
+
+	restarter := &inPlaceRestarter{child: child}
+
+	var watchdog *childWatchdog
+	if config.Watchdog > 0 {
+		watchdog = newChildWatchdog(event.WithEventTrace(baseCtx, supervisorTrace), config, child, restarter, logger)
+	}
+
+	var liveness *childLiveness
+	if config.LivenessProbe != "" {
+		livenessTrace := eventTraceFactory("liveness probe")
+		eventTraces = append(eventTraces, livenessTrace)
+
+		liveness, err = newChildLiveness(event.WithEventTrace(baseCtx, livenessTrace), config, child, restarter, publisher, logger)
+		if err != nil {
+			return fatalf(logger, eventTraces, child, ts, publisher, config, withErrorCode(errorCodeConfigInvalid, err))
+		}
+		lc.onStop(stageSubsystems, "liveness probe", liveness.disarm)
+	}
+
+	if config.ControlSocket != "" {
+		ctx, stopControl := context.WithCancel(baseCtx)
+
+		controlTrace := eventTraceFactory("control")
+		eventTraces = append(eventTraces, controlTrace)
+
+		ctx = event.WithEventTrace(ctx, controlTrace)
+		control, err2 := newControl(ctx, config, child, publisher, status, ts, watchdog)
+		if err2 != nil {
+			stopControl()
+			return fatalf(logger, eventTraces, child, ts, publisher, config, withErrorCode(errorCodeConfigInvalid, err2))
+		}
+		served, err2 := serveHTTP(ctx, "control socket", "unix", config.ControlSocket, control.handler())
+		if err2 != nil {
+			stopControl()
+			return fatalf(logger, eventTraces, child, ts, publisher, config, withErrorCode(errorCodeControlSocketFailed, err2))
+		}
+		// requests acting on the child are stopped before its shutdown, the socket is closed after its exit
+		child.SetShutdownHook(control.stopActing)
+		lc.onStop(stageControl, "control socket", func() {
+			stopControl()
+			<-served
+		})
+	}
+
+	var notifyHandlers []sdnotify.Handler
+	if watchdog != nil {
+		notifyHandlers = append(notifyHandlers, watchdog.handleNotify)
+	}
+
+	if len(config.ShutdownAck) > 0 {
+		ctx, stopAck := context.WithCancel(event.WithEventTrace(baseCtx, supervisorTrace))
+		lc.onStop(stageSubsystems, "shutdown ack", stopAck)
+
+		if handler := setupShutdownAck(ctx, config, child); handler != nil {
+			notifyHandlers = append(notifyHandlers, handler)
+		}
+	}
+
+	if len(notifyHandlers) > 0 {
+		ctx, stopNotify := context.WithCancel(event.WithEventTrace(baseCtx, supervisorTrace))
+		lc.onStop(stageSubsystems, "notify socket", stopNotify)
+
+		err = listenNotify(ctx, config, child, notifyHandlers)
+		if err != nil {
+			return fatalf(logger, eventTraces, child, ts, publisher, config, withErrorCode(errorCodeConfigInvalid, err))
+		}
+	}
+
+	coordinator, err := newCoordinator(config, logger, options...)
+	if err != nil {
+		return fatalf(logger, eventTraces, child, ts, publisher, config, withErrorCode(errorCodeConfigInvalid, err))
+	}
+
+	// shutDownByDeathDep is set when death of a death dep triggers graceful shutdown of the child
+	var shutDownByDeathDep int32
 
 	// watch for death deps early, so they can interrupt waiting for birth deps
 	if len(config.DeathDeps) > 0 {
-		ctx, stopGraveyardWatcher := context.WithCancel(context.Background())
+		watchStart := time.Now()
+		ctx, stopGraveyardWatcher := context.WithCancel(baseCtx)
 		// stop graveyard watchers on exit, if not sooner
-		defer stopGraveyardWatcher()
+		lc.onStop(stageSubsystems, "death graveyard watcher", stopGraveyardWatcher)
 
 		graveyardWatcherTrace := eventTraceFactory("death graveyard watcher")
 
@@ -85,8 +343,10 @@ func runApp(config *config, logger *logrus.Logger) int {
 
 		ctx = event.WithEventTrace(ctx, graveyardWatcherTrace)
 
-		err = tombstone.Watch(ctx, config.Graveyard, onDeathOfAny(config.DeathDeps, func() error {
-			stopGraveyardWatcher()
+		err = coordinator.WatchDeathDeps(ctx, func() error {
+			audit.ContextLog(ctx).Append(audit.Record{Event: audit.EventShutdown, Trigger: "death_dependency"})
+			atomic.StoreInt32(&shutDownByDeathDep, 1)
+			publisher.Publish(childStateDraining, nil)
 			// trigger graceful shutdown
 			// Skipped if not started.
 			err2 := child.ShutdownWithTimeout(config.GracePeriod)
@@ -95,14 +355,44 @@ func runApp(config *config, logger *logrus.Logger) int {
 				return errors.Wrapf(err2, "failed to shutdown")
 			}
 			return nil
-		}))
+		})
+		if err != nil {
+			return fatalf(logger, eventTraces, child, ts, publisher, config, withErrorCode(errorCodeGraveyardWatchFailed, err))
+		}
+		startup.observe(phaseGraveyardWatch, watchStart)
+	}
+
+	if config.ContainerStatus {
+		recordContainerStatus(baseCtx, config, logger, ts)
+	}
+
+	if config.ClockSkewMax > 0 {
+		checkClockSkew(baseCtx, config, logger, ts)
+	}
+
+	if config.CheckContainerNames {
+		checkContainerNames(baseCtx, config, logger)
+	}
+
+	if config.BirthDepsLostPolicy != "" && len(config.BirthDeps) > 0 {
+		// container birth deps are watched by a single pod watch from the birth until the exit
+		ctx, stopPodWatch := context.WithCancel(baseCtx)
+		lc.onStop(stageSubsystems, "pod watcher", stopPodWatch)
+
+		podWatchTrace := eventTraceFactory("pod watcher")
+		eventTraces = append(eventTraces, podWatchTrace)
+
+		err = coordinator.WatchContainers(event.WithEventTrace(ctx, podWatchTrace))
 		if err != nil {
-			return fatalf(logger, eventTraces, child, ts, errors.Wrap(err, "failed to watch graveyard"))
+			return fatalf(logger, eventTraces, child, ts, publisher, config, withErrorCode(errorCodeBirthWatchFailed, err))
 		}
 	}
 
+	// termReceived reports SIGTERM received while waiting for birth deps, to be forwarded to the child
+	termReceived := func() bool { return false }
 	if len(config.BirthDeps) > 0 {
-		ctx := context.Background()
+		birthStart := time.Now()
+		ctx := baseCtx
 
 		graveyardWatcherTrace := eventTraceFactory("birth dependencies watcher")
 
@@ -110,85 +400,409 @@ func runApp(config *config, logger *logrus.Logger) int {
 
 		ctx = event.WithEventTrace(ctx, graveyardWatcherTrace)
 
-		err = waitForBirthDeps(ctx, config.BirthDeps, config.Namespace, config.PodName, config.BirthTimeout)
+		// SIGTERM cancels waiting, or is passed to the child when it starts, by the policy
+		var stopSignals context.CancelFunc
+		ctx, termReceived, stopSignals = withBirthTermPolicy(ctx, config.sigtermPolicy(termPhaseBirth), config.GracePeriod)
+		defer stopSignals()
+
+		err = coordinator.WaitForBirthDeps(ctx, func(name string) {
+			startup.observeBirthDep(name, birthStart)
+		})
+		if err != nil {
+			return fatalf(logger, eventTraces, child, ts, publisher, config, withErrorCode(birthErrorCode(err), err))
+		}
+		startup.observe(phaseBirthDeps, birthStart)
+	}
+
+	if len(config.WaitPortsFree) > 0 {
+		portsTrace := eventTraceFactory("port release")
+		eventTraces = append(eventTraces, portsTrace)
+
+		// Cancel context on SIGTERM to trigger graceful exit
+		ctx, stopSignals := withCancelOnSignal(event.WithEventTrace(baseCtx, portsTrace), syscall.SIGTERM)
+		defer stopSignals()
+
+		err = waitPortsFree(ctx, config)
+		if err != nil {
+			return fatalf(logger, eventTraces, child, ts, publisher, config, err)
+		}
+	}
+
+	var exclusive *lock.Lock
+	if config.ExclusiveLock != "" {
+		lockTrace := eventTraceFactory("exclusive lock")
+		eventTraces = append(eventTraces, lockTrace)
+
+		// Cancel context on SIGTERM to trigger graceful exit
+		ctx, stopSignals := withCancelOnSignal(event.WithEventTrace(baseCtx, lockTrace), syscall.SIGTERM)
+		defer stopSignals()
+
+		exclusive, err = acquireExclusiveLock(ctx, config)
+		if err != nil {
+			return fatalf(logger, eventTraces, child, ts, publisher, config, err)
+		}
+	}
+
+	if len(config.RuntimeTuning) > 0 {
+		tuneChildRuntime(config, logger, child)
+	}
+
+	hookTrace := eventTraceFactory("hooks")
+	eventTraces = append(eventTraces, hookTrace)
+	hookCtx := event.WithEventTrace(baseCtx, hookTrace)
+
+	if config.PreStartCmd != "" {
+		err = runHook(hookCtx, hookPreStart, strings.Fields(config.PreStartCmd), config.PreStartTimeout)
 		if err != nil {
-			return fatalf(logger, eventTraces, child, ts, err)
+			return fatalf(logger, eventTraces, child, ts, publisher, config, withErrorCode(errorCodeHookFailed, err))
 		}
 	}
 
+	childStart := time.Now()
 	err = child.Start()
 	if err != nil {
-		return fatalf(logger, eventTraces, child, ts, err)
+		return fatalf(logger, eventTraces, child, ts, publisher, config, withErrorCode(errorCodeChildStartFailed, err))
+	}
+	startup.observe(phaseChildStart, childStart)
+	watchdog.arm()
+	liveness.arm()
+
+	if termReceived() {
+		audit.ContextLog(baseCtx).Append(audit.Record{Event: audit.EventShutdown, Trigger: "sigterm"})
+		err = child.Signal(config.ShutdownSignal)
+		if err != nil {
+			logger.WithError(err).Errorf("failed to forward SIGTERM received while waiting for birth deps as %s", config.ShutdownSignal)
+		}
+	}
+
+	if config.MetricsAddr != "" && config.ChildSampleInterval > 0 {
+		samplerTrace := eventTraceFactory("child sampler")
+		eventTraces = append(eventTraces, samplerTrace)
+
+		lc.goUntil(event.WithEventTrace(baseCtx, samplerTrace), stageSubsystems, "child sampler", func(ctx context.Context) {
+			sampleChild(ctx, child, status, config.ChildSampleInterval)
+		})
 	}
 
-	err = ts.RecordBirth()
+	startupLatency := startup.ready(registry)
+	logger.WithField("startup", startupLatency).Info("child started")
+
+	err = ts.recordBirth(startupLatency)
 	if err != nil {
-		return fatalf(logger, eventTraces, child, ts, err)
+		return fatalf(logger, eventTraces, child, ts, publisher, config, withErrorCode(errorCodeTombstoneWriteFailed, err))
+	}
+
+	if config.TombstoneTTL > 0 {
+		// renewal is stopped before the death is recorded. Renewal abandoned by the stop timeout can't overwrite the death either,
+		// as Renew skips the dead tombstone under the writer lock
+		lc.goUntil(baseCtx, stageSubsystems, "tombstone renewal", func(ctx context.Context) {
+			ts.renewEvery(ctx, config.TombstoneTTL/3)
+		})
+	}
+
+	publisher.Publish(childStateRunning, nil)
+
+	if config.BirthDepsLostPolicy != "" && len(config.BirthDeps) > 0 {
+		ctx, stopMonitor := context.WithCancel(baseCtx)
+		lc.onStop(stageSubsystems, "birth deps monitor", stopMonitor)
+
+		monitorTrace := eventTraceFactory("birth deps monitor")
+		eventTraces = append(eventTraces, monitorTrace)
+
+		ctx = event.WithEventTrace(ctx, monitorTrace)
+		monitor := newDepLossMonitor(ctx, config, child, restarter, publisher, logger)
+		err = coordinator.WatchBirthDeps(ctx, monitor.setState)
+		if err != nil {
+			// the child is running already, it keeps running unmonitored
+			logger.WithError(err).WithField(errorCodeField, errorCodeBirthWatchFailed).Error("failed to watch birth deps")
+		}
+	}
+
+	if config.DumpOnSIGUSR1 {
+		traces := eventTraces
+		lc.goUntil(baseCtx, stageSubsystems, "dump on signal", func(ctx context.Context) {
+			dumpOnSignal(ctx, logger, traces)
+		})
+	}
+
+	code := kubexit.WaitForExit(child)
+	watchdog.disarm()
+	liveness.disarm()
+	inPlaceRestarts := 0
+	restartsExhausted := false
+	// exhaustedLimit is the limit of restarts exhausted, RestartLimit or RestartMaxRetries
+	exhaustedLimit := 0
+	for !child.ShutdownRequested() {
+		// restart requested by kubexit subsystem takes precedence, as it killed the child
+		reason := restarter.take()
+		if reason == "" && config.RestartExitCode != 0 && code == config.RestartExitCode {
+			reason = restartReasonExitCode
+		}
+		if reason == "" {
+			// the child exited by itself, restarted by restart policy after backoff,
+			// so death is recorded after the last attempt only
+			restarted, err2 := child.RestartByPolicy(code)
+			if errors.Cause(err2) == supervisor.ErrRetriesExhausted {
+				logger.WithField("exitCode", code).WithField("retries", config.RestartMaxRetries).Warn("child exhausted restarts by policy")
+				restartsExhausted = true
+				exhaustedLimit = config.RestartMaxRetries
+				break
+			}
+			if err2 != nil {
+				logger.WithError(err2).WithField(errorCodeField, errorCodeChildStartFailed).Error("failed to restart child")
+				break
+			}
+			if !restarted {
+				break
+			}
+			logger.WithField("exitCode", code).WithField("reason", restartReasonPolicy).Info("restarted child")
+			reason = restartReasonPolicy
+		} else {
+			// restarts requested by the child itself are not limited, e.g. of self-upgrading apps
+			if reason != restartReasonExitCode {
+				if config.RestartLimit > 0 && inPlaceRestarts >= config.RestartLimit {
+					logger.WithField("exitCode", code).WithField("restarts", inPlaceRestarts).Warn("child exhausted restarts")
+					restartsExhausted = true
+					exhaustedLimit = config.RestartLimit
+					break
+				}
+				inPlaceRestarts++
+			}
+
+			// restart in place is not a death, the tombstone stays alive
+			logger.WithField("exitCode", code).WithField("reason", reason).Info("restarting child")
+			err = child.Restart()
+			if err != nil {
+				logger.WithError(err).WithField(errorCodeField, errorCodeChildStartFailed).Error("failed to restart child")
+				break
+			}
+		}
+		watchdog.arm()
+		liveness.arm()
+
+		restartedCode := code
+		err = recordRestart(status, ts, reason, &restartedCode)
+		if err != nil {
+			logger.WithError(err).WithField(errorCodeField, errorCodeTombstoneWriteFailed).Error()
+		}
+		code = kubexit.WaitForExit(child)
+		watchdog.disarm()
+		liveness.disarm()
+	}
+
+	// the child exited for good: control requests and subsystems acting on it are stopped,
+	// deliveries, event traces and child output are flushed before the death is recorded, listeners are closed at return
+	lc.stopUntil(stageSubsystems)
+
+	summary := child.Summary()
+	summary.RestartCount = ts.RestartCount + child.Restarts()
+	supervisorTrace.SetSummary(summary)
+
+	// the child gracefully stopped by death dep is killed by the shutdown signal, when it doesn't handle it,
+	// or exits with 128+signo, when it handles it the way shells do
+	terminatedByDeathDep := atomic.LoadInt32(&shutDownByDeathDep) == 1 &&
+		(summary.Signal == config.ShutdownSignal.String() || code == 128+int(config.ShutdownSignal))
+
+	// the summary keeps the code the child exited with
+	if remapped := config.remapExitCode(code); remapped != code {
+		logger.WithField("exitCode", code).WithField("remappedExitCode", remapped).Info("child exit code remapped")
+		code = remapped
+	}
+
+	if exclusive != nil {
+		// let the next queued participant run
+		err = exclusive.Release()
+		if err != nil {
+			logger.WithError(err).WithField(errorCodeField, errorCodeLockFailed).Error()
+		}
+	}
+
+	if config.PostStopCmd != "" {
+		// e.g. flushing buffers, before dependents react to the death
+		err = runHook(hookCtx, hookPostStop, strings.Fields(config.PostStopCmd), config.PostStopTimeout)
+		if err != nil {
+			logger.WithError(err).WithField(errorCodeField, errorCodeHookFailed).Error()
+		}
+	}
+
+	deliveries, stopDeliveries := newDeliverer(baseCtx, config, logger)
+	lc.onStop(stageFlush, "deliveries", stopDeliveries)
+	if len(config.Artifacts) > 0 && (code != 0 || config.ArtifactsOn == artifactsOnAlways) {
+		collectArtifacts(deliveries, config, logger, ts)
+	}
+
+	if config.UploadURL != "" && (code != 0 || config.UploadOn == artifactsOnAlways) {
+		uploadReport(deliveries, config, logger, ts, code, eventTraces)
 	}
+	// flushed before the death, as dependents may tear down the pod as soon as they see it
+	lc.stopUntil(stageFlush)
 
-	code := waitForChildExit(child)
+	publisher.Publish(childStateExited, &code)
 
-	err = ts.RecordDeath(code)
+	err = ts.recordDeath(code)
 	if err != nil {
-		logger.WithError(err).Error()
+		logger.WithError(err).WithField(errorCodeField, errorCodeTombstoneWriteFailed).Error()
 		return 2
 	}
 
-	if config.VerboseLevel > 0 {
-		messages, err2 := serializeEventTraces(eventTraces)
+	exitCode := code
+	message := fmt.Sprintf("child %s exited", config.Name)
+	if restartsExhausted {
+		message = fmt.Sprintf("child %s exhausted %d restarts, last restarts: %s", config.Name, exhaustedLimit, formatRestarts(status.restarts()))
+		if config.RestartExhausted == restartExhaustedSleep {
+			exitCode = 0
+		}
+	}
+	if terminatedByDeathDep && config.ZeroExitOnDeathDep {
+		// the tombstone keeps the real code
+		logger.WithField("exitCode", code).Info("child terminated by death dep, exiting with zero code")
+		message = fmt.Sprintf("child %s terminated by death dep", config.Name)
+		exitCode = 0
+	}
+	err = writeTerminationMessage(config.TerminationMessagePath, exitCode, message)
+	if err != nil {
+		logger.WithError(err).Error()
+	}
+
+	var verboseTraces []event.Trace
+	for _, trace := range eventTraces {
+		if config.traceVerboseLevel(trace.ID()) > 0 {
+			verboseTraces = append(verboseTraces, trace)
+		}
+	}
+	if len(verboseTraces) > 0 {
+		messages, err2 := serializeEventTraces(verboseTraces)
 		if err2 != nil {
-			logger.WithError(err).Error()
+			logger.WithError(err2).WithField(errorCodeField, errorCodeEventTraceFailed).Error()
 			return 2
 		}
 
-		logger.WithField("event-traces", messages).Info("supervising proceed successfully")
+		logger.WithFields(summary.Fields()).WithField("event-traces", messages).Info("supervising proceed successfully")
 	}
 
-	return code
+	if restartsExhausted && config.RestartExhausted == restartExhaustedSleep {
+		sleepOnExhaustedRestarts(baseCtx, logger, config.RestartExhaustedSleep)
+	}
+
+	if exitCode != 0 && config.HoldOnFailure > 0 {
+		holdOnFailure(baseCtx, logger, config.HoldOnFailure, exitCode)
+	}
+
+	return exitCode
+}
+
+// newCoordinator returns coordinator of birth and death deps configured by config
+func newCoordinator(config *config, logger *logrus.Logger, options ...kubexit.Option) (*kubexit.Coordinator, error) {
+	return kubexit.New(config.Name, append([]kubexit.Option{
+		kubexit.WithGraveyard(config.Graveyard),
+		kubexit.WithBirthDeps(config.BirthDeps...),
+		kubexit.WithDeathDeps(config.DeathDeps...),
+		kubexit.WithBirthTimeout(config.BirthTimeout),
+		kubexit.WithBirthCheckInterval(config.BirthCheckInterval),
+		kubexit.WithGracePeriod(config.GracePeriod),
+		kubexit.WithPod(config.Namespace, config.PodName),
+		kubexit.WithPodUID(config.PodUID),
+		kubexit.WithTombstoneKey(config.TombstoneKey),
+		kubexit.WithTombstoneTimeout(config.TombstoneTimeout),
+		kubexit.WithWatchdog(config.WatchdogStaleAfter),
+		kubexit.WithGraveyardLossPolicy(config.GraveyardLossPolicy),
+		kubexit.WithGraveyardWatcher(func(ctx context.Context, graveyard string, names []string, handler tombstone.EventHandler) error {
+			watch, err := graveyardWatchFunc(ctx, config, logger)
+			if err != nil {
+				return errors.Wrap(err, "failed to probe graveyard")
+			}
+			return watch(ctx, graveyard, names, handler)
+		}),
+		kubexit.WithLogger(logger),
+	}, options...)...)
 }
 
-func waitForBirthDeps(ctx context.Context, birthDeps []string, namespace, podName string, timeout time.Duration) error {
-	// Cancel context on SIGTERM to trigger graceful exit
-	ctx = withCancelOnSignal(ctx, syscall.SIGTERM)
-
-	ctx, stopPodWatcher := context.WithTimeout(ctx, timeout)
-	// Stop pod watcher on exit, if not sooner
-	defer stopPodWatcher()
-
-	event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Watching pod %s updates", podName))
-	err := kubernetes.WatchPod(
-		ctx,
-		namespace,
-		podName,
-		onReadyOfAll(birthDeps, stopPodWatcher),
-	)
+// graveyardWatchFunc returns a function watching graveyard according to configured mode.
+// In auto mode graveyard is probed first, and polling is used when fsnotify doesn't deliver events.
+func graveyardWatchFunc(ctx context.Context, config *config, logger *logrus.Logger) (kubexit.GraveyardWatcher, error) {
+	poll := func(ctx context.Context, graveyard string, names []string, eventHandler tombstone.EventHandler) error {
+		return tombstone.Poll(ctx, graveyard, config.PollInterval, names, eventHandler)
+	}
+	notify := func(ctx context.Context, graveyard string, names []string, eventHandler tombstone.EventHandler) error {
+		return tombstone.WatchCoalesced(ctx, graveyard, config.CoalesceWindow, names, eventHandler)
+	}
+
+	switch config.GraveyardWatch {
+	case graveyardWatchNotify:
+		return notify, nil
+	case graveyardWatchPoll:
+		return poll, nil
+	}
+
+	if config.GraveyardReadOnly {
+		// probe can't write to read-only graveyard, inotify works on read-only mounts anyway
+		logger.WithField("graveyard", config.Graveyard).Info("graveyard is read-only, skip probe and watch with fsnotify")
+		return notify, nil
+	}
+
+	probe, err := tombstone.Probe(ctx, config.Graveyard, config.tombstoneName(), graveyardProbeTimeout)
 	if err != nil {
-		return errors.Wrap(err, "failed to watch pod")
+		return nil, err
 	}
 
-	// Block until all birth deps are ready
-	<-ctx.Done()
-	err = ctx.Err()
-	if err == context.DeadlineExceeded {
-		return errors.WithStack(fmt.Errorf("timed out waiting for birth deps to be ready: %s", timeout))
-	} else if err != nil && err != context.Canceled {
-		// ignore canceled. shouldn't be other errors, but just in case...
-		return errors.WithStack(fmt.Errorf("waiting for birth deps to be ready: %v", err))
+	entry := logger.WithField("graveyard", config.Graveyard).WithField("fs_type", probe.FSType)
+	if !probe.MemoryBacked {
+		entry.Warn("graveyard is not on a memory-backed volume, use emptyDir with medium: Memory")
+	}
+	if !probe.Notify {
+		entry.WithField("poll_interval", config.PollInterval.String()).
+			Warn("fsnotify doesn't deliver events in graveyard, falling back to polling")
+		return poll, nil
 	}
 
-	event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("All birth deps ready: %v\n", strings.Join(birthDeps, ", ")))
-	return nil
+	return notify, nil
+}
+
+// withBirthTermPolicy applies SIGTERM policy of waiting for birth deps: cancel, also the empty policy, cancels ctx on SIGTERM,
+// grace cancels it after grace period, forward keeps waiting. Returned function reports whether SIGTERM is received,
+// returned cancel func stops watching SIGTERM
+func withBirthTermPolicy(ctx context.Context, policy string, gracePeriod time.Duration) (context.Context, func() bool, context.CancelFunc) {
+	if policy == "" || policy == supervisor.TermCancel {
+		ctx, cancel := withCancelOnSignal(ctx, syscall.SIGTERM)
+		// the child is not started after cancellation
+		return ctx, func() bool { return false }, cancel
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var received int32
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM)
+
+	crash.Go(ctx, "birth sigterm policy", func() {
+		defer signal.Stop(sigCh)
+		select {
+		case <-sigCh:
+			atomic.StoreInt32(&received, 1)
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("SIGTERM received while waiting for birth deps, policy %s", policy))
+			if policy == supervisor.TermGrace {
+				time.AfterFunc(gracePeriod, crash.Func(ctx, "birth sigterm grace timer", cancel))
+			}
+		case <-ctx.Done():
+		}
+	})
+
+	return ctx, func() bool {
+		return atomic.LoadInt32(&received) == 1
+	}, cancel
 }
 
 // withCancelOnSignal calls cancel when one of the specified signals is received.
-func withCancelOnSignal(ctx context.Context, signals ...os.Signal) context.Context {
+// Returned cancel func stops watching the signals, callers defer it till return,
+// so SIGTERM received between startup phases is still caught rather than killing kubexit
+func withCancelOnSignal(ctx context.Context, signals ...os.Signal) (context.Context, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(ctx)
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, signals...)
 
 	// Trigger context cancel on SIGTERM
-	go func() {
+	crash.Go(ctx, "signal cancel", func() {
 		for {
 			select {
 			case _, ok := <-sigCh:
@@ -197,51 +811,61 @@ func withCancelOnSignal(ctx context.Context, signals ...os.Signal) context.Conte
 				}
 				cancel()
 			case <-ctx.Done():
-				signal.Reset()
+				signal.Stop(sigCh)
 				close(sigCh)
+				return
 			}
 		}
-	}()
-
-	return ctx
-}
+	})
 
-// wait for the child to exit and return the exit code
-func waitForChildExit(child *supervisor.Supervisor) int {
-	var code int
-	err := child.Wait()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			code = exitErr.ProcessState.ExitCode()
-		} else {
-			code = -1
-		}
-	} else {
-		code = 0
-	}
-	return code
+	return ctx, cancel
 }
 
 // fatalf is for terminal errors.
 // Returns exit code
 // The child process may or may not be running.
+// The whole sequence is bounded by FatalTimeout, after which kubexit exits with fatalTimeoutExitCode,
+// so the container terminates even when the child can't be reaped or the graveyard hangs
 func fatalf(
 	logger *logrus.Logger,
 	eventTraces []event.Trace,
 	child *supervisor.Supervisor,
-	ts *tombstone.Tombstone,
+	ts *tombstoneWriter,
+	publisher statePublisher,
+	config *config,
 	err error,
 ) int {
 	const exitCode = 1
 
+	// code is taken before err is wrapped with shutdown errors
+	errCode := errorCode(err)
+
+	if config.FatalTimeout > 0 {
+		message := fmt.Sprintf("kubexit failed: %v", err)
+		deadline := time.AfterFunc(config.FatalTimeout, func() {
+			logger.WithField(errorCodeField, errorCodeFatalTimeout).WithField("cause_error_code", errCode).
+				Errorf("failed to stop the child and record death within %s, exiting: %s", config.FatalTimeout, message)
+			_ = writeTerminationMessage(config.TerminationMessagePath, fatalTimeoutExitCode, message)
+			os.Exit(fatalTimeoutExitCode)
+		})
+		defer deadline.Stop()
+	}
+
+	defer func() {
+		err2 := writeTerminationMessage(config.TerminationMessagePath, exitCode, fmt.Sprintf("kubexit failed: %v", err))
+		if err2 != nil {
+			logger.WithError(err2).Error()
+		}
+	}()
+
 	defer func() {
 		messages, err2 := serializeEventTraces(eventTraces)
 		if err2 != nil {
-			logger.WithError(errors.Wrap(err, err2.Error())).Error()
+			logger.WithError(errors.Wrap(err, err2.Error())).WithField(errorCodeField, errCode).Error()
 			return
 		}
 
-		logger.WithField("event-traces", messages).WithError(err).Error()
+		logger.WithField("event-traces", messages).WithField(errorCodeField, errCode).WithError(err).Error()
 	}()
 
 	// Skipped if not started.
@@ -251,13 +875,14 @@ func fatalf(
 		return exitCode
 	}
 
-	// Wait for shutdown...
-	//TODO: timout in case the process is zombie?
-	code := waitForChildExit(child)
+	// Wait for shutdown, a zombie is left to FatalTimeout
+	code := config.remapExitCode(kubexit.WaitForExit(child))
+
+	publisher.Publish(childStateExited, &code)
 
 	// Attempt to record death, if possible.
 	// Another process may be waiting for it.
-	recordDeathErr := ts.RecordDeath(code)
+	recordDeathErr := ts.recordDeath(code)
 	if recordDeathErr != nil {
 		err = errors.Wrap(err, recordDeathErr.Error())
 		return exitCode
@@ -266,101 +891,37 @@ func fatalf(
 	return exitCode
 }
 
-// onReadyOfAll returns an EventHandler that executes the callback when all of
-// the birthDeps containers are ready.
-func onReadyOfAll(birthDeps []string, callback func()) kubernetes.EventHandler {
-	birthDepSet := map[string]struct{}{}
-	for _, depName := range birthDeps {
-		birthDepSet[depName] = struct{}{}
-	}
-
-	return func(ctx context.Context, e watch.Event) {
-		// ignore Deleted (Watch will auto-stop on delete)
-		if e.Type == watch.Deleted {
-			return
-		}
-
-		pod, ok := e.Object.(*corev1.Pod)
-		if !ok {
-			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Error: unexpected non-pod object type: %+v\n", e.Object))
-			return
-		}
-
-		// Convert ContainerStatuses list to map of ready container names
-		readyContainers := map[string]struct{}{}
-		for _, status := range pod.Status.ContainerStatuses {
-			if status.Ready {
-				readyContainers[status.Name] = struct{}{}
-			}
-		}
-
-		// Check if all birth deps are ready
-		for _, name := range birthDeps {
-			if _, ok := readyContainers[name]; !ok {
-				// at least one birth dep is not ready
-				return
-			}
-		}
-
-		callback()
-	}
-}
-
-// onDeathOfAny returns an EventHandler that executes the callback when any of
-// the deathDeps processes have died.
-func onDeathOfAny(deathDeps []string, callback func() error) tombstone.EventHandler {
-	deathDepSet := map[string]struct{}{}
-	for _, depName := range deathDeps {
-		deathDepSet[depName] = struct{}{}
-	}
-
-	return func(ctx context.Context, e fsnotify.Event) error {
-		if e.Op&fsnotify.Create != fsnotify.Create && e.Op&fsnotify.Write != fsnotify.Write {
-			// ignore other events
-			return nil
-		}
-		graveyard := filepath.Dir(e.Name)
-		name := filepath.Base(e.Name)
-
-		if _, ok := deathDepSet[name]; !ok {
-			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Ignore tombstone %s", name))
-			// ignore other tombstones
-			return nil
-		}
-
-		event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Reading tombstone: %s", name))
-		ts, err := tombstone.Read(graveyard, name)
-		if err != nil {
-			return errors.Wrapf(err, "failed to read tombstone %s", name)
-		}
-
-		if ts.Died == nil {
-			// still alive
-			return nil
-		}
-		event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("New death: %s", name))
-
-		return callback()
-	}
-}
-
 func initLogger(config *config) *logrus.Logger {
 	impl := logrus.New()
-	impl.SetFormatter(&logrus.JSONFormatter{
-		TimestampFormat: time.RFC3339Nano,
-		FieldMap: logrus.FieldMap{
-			logrus.FieldKeyTime: "@timestamp",
-			logrus.FieldKeyMsg:  "message",
-		},
-	})
+	if config.TimestampFormat == timestamp.LayoutEpochMillis {
+		// logrus formats time as string only, so epoch timestamp is set by TimestampHook
+		impl.SetFormatter(&logrus.JSONFormatter{
+			DisableTimestamp: true,
+			FieldMap: logrus.FieldMap{
+				logrus.FieldKeyMsg: "message",
+			},
+		})
+	} else {
+		impl.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339Nano,
+			FieldMap: logrus.FieldMap{
+				logrus.FieldKeyTime: "@timestamp",
+				logrus.FieldKeyMsg:  "message",
+			},
+		})
+	}
 
 	level := logrus.InfoLevel
 	if config.InstantLogging {
 		level = logrus.TraceLevel
 	}
+	if config.Quiet {
+		level = logrus.ErrorLevel
+	}
 
 	impl.SetLevel(level)
 	impl.AddHook(new(loggerhook.StackTraceHook))
+	impl.AddHook(&loggerhook.TimestampHook{Format: config.timeFormat(), Key: "@timestamp"})
 
 	return impl
 }
@@ -378,6 +939,31 @@ func serializeEventTraces(traces []event.Trace) ([]json.RawMessage, error) {
 	return messages, nil
 }
 
+// restoreEventTraces returns factory of traces continuing traces of previous kubexit incarnations of the container,
+// and a function persisting traces for the next incarnation
+func restoreEventTraces(config *config, logger *logrus.Logger, factory func(id string) event.Trace) (func(id string) event.Trace, func([]event.Trace)) {
+	path := filepath.Join(config.Graveyard, config.tombstoneName()+".traces.json")
+
+	previous, err := event.LoadPersisted(path)
+	if err != nil {
+		// history is lost, but the new incarnation is recorded anyway
+		logger.WithError(err).Warn("failed to restore event traces")
+	}
+
+	restoring := func(id string) event.Trace {
+		tr := factory(id)
+		previous.Restore(tr)
+		return tr
+	}
+	persist := func(traces []event.Trace) {
+		err := previous.Persist(path, traces)
+		if err != nil {
+			logger.WithError(err).Error("failed to persist event traces")
+		}
+	}
+	return restoring, persist
+}
+
 // When InstantLogging environment variable is set eventTraceFactoryMethod returns event.Trace which logs event instantly when received it
 // otherwise returns default event.Trace
 func eventTraceFactoryMethod(config *config, logger *logrus.Logger) func(id string) event.Trace {
@@ -387,5 +973,11 @@ func eventTraceFactoryMethod(config *config, logger *logrus.Logger) func(id stri
 		}
 	}
 
-	return event.NewTrace
+	return func(id string) event.Trace {
+		// level 2 of the trace logs its events instantly, without flooding the log with other traces
+		if config.traceVerboseLevel(id) >= 2 {
+			return event.NewInstantTraceAt(id, logger.WithField("app", "kubexit"), logrus.InfoLevel)
+		}
+		return event.NewTrace(id)
+	}
 }
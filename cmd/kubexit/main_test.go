@@ -0,0 +1,300 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ispringtech/kubexit/pkg/kubexit"
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+	"github.com/ispringtech/kubexit/pkg/testkit"
+)
+
+func testConfig(graveyard *testkit.Graveyard) *config {
+	return &config{
+		Name:                   "client",
+		Graveyard:              graveyard.Path,
+		BirthTimeout:           30 * time.Second,
+		BirthCheckInterval:     time.Second,
+		GracePeriod:            30 * time.Second,
+		ShutdownSignal:         syscall.SIGTERM,
+		TombstoneTimeout:       10 * time.Second,
+		TombstoneFailurePolicy: tombstoneFailurePolicyFail,
+		PodName:                "pod",
+		Namespace:              "default",
+	}
+}
+
+func testRunApp(t *testing.T, config *config, args []string, options ...kubexit.Option) <-chan int {
+	t.Helper()
+
+	logger := initLogger(config)
+	logger.SetOutput(ioutil.Discard)
+
+	codeCh := make(chan int, 1)
+	go func() {
+		codeCh <- runApp(config, logger, newStartupTimer(time.Now()), args, options...)
+	}()
+	return codeCh
+}
+
+func awaitCode(t *testing.T, codeCh <-chan int, timeout time.Duration) int {
+	t.Helper()
+
+	select {
+	case code := <-codeCh:
+		return code
+	case <-time.After(timeout):
+		t.Fatalf("runApp did not return in %s", timeout)
+		return 0
+	}
+}
+
+func TestBirthDepsReady(t *testing.T) {
+	graveyard := testkit.NewGraveyard(t)
+	pod := testkit.NewPod()
+	clock := testkit.NewClock(time.Now())
+	pod.Script(clock, testkit.PodStep{After: time.Minute, Ready: []string{"server"}})
+
+	config := testConfig(graveyard)
+	config.BirthDeps = []string{"server"}
+
+	codeCh := testRunApp(t, config, []string{"true"}, kubexit.WithContainerWatcher(pod.Watch))
+
+	<-pod.Watched()
+	_, err := graveyard.Read("client")
+	if err == nil {
+		t.Fatal("child is born before birth deps are ready")
+	}
+
+	clock.Advance(time.Minute)
+
+	code := awaitCode(t, codeCh, 5*time.Second)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	ts, err := graveyard.Read("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Born == nil || ts.Died == nil || ts.ExitCode == nil || *ts.ExitCode != 0 {
+		t.Fatalf("unexpected tombstone: %s", ts)
+	}
+}
+
+func TestBirthTimeout(t *testing.T) {
+	graveyard := testkit.NewGraveyard(t)
+	pod := testkit.NewPod()
+
+	config := testConfig(graveyard)
+	config.BirthDeps = []string{"server"}
+	config.BirthTimeout = 100 * time.Millisecond
+
+	codeCh := testRunApp(t, config, []string{"true"}, kubexit.WithContainerWatcher(pod.Watch))
+
+	code := awaitCode(t, codeCh, 5*time.Second)
+	if code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	// death is recorded, so others may react on it
+	_, err := graveyard.Await("client", testkit.Died, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSIGTERMDuringBirthWait(t *testing.T) {
+	graveyard := testkit.NewGraveyard(t)
+	pod := testkit.NewPod()
+
+	config := testConfig(graveyard)
+	config.BirthDeps = []string{"server"}
+
+	codeCh := testRunApp(t, config, []string{"true"}, kubexit.WithContainerWatcher(pod.Watch))
+
+	// SIGTERM handler is installed before the pod is watched
+	<-pod.Watched()
+	err := syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := awaitCode(t, codeCh, 5*time.Second)
+	if code != 1 {
+		t.Fatalf("expected exit code 1, got %d", code)
+	}
+	_, err = graveyard.Await("client", testkit.Died, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeathDepKillDuringGrace(t *testing.T) {
+	graveyard := testkit.NewGraveyard(t)
+	trapped := filepath.Join(t.TempDir(), "trapped")
+
+	config := testConfig(graveyard)
+	config.DeathDeps = []string{"server"}
+	config.GracePeriod = 200 * time.Millisecond
+
+	// child ignores SIGTERM, so it is killed when grace period elapses
+	codeCh := testRunApp(t, config, []string{"sh", "-c", `trap "" TERM; touch "$0"; exec sleep 30`, trapped},
+		kubexit.WithGraveyardWatcher(graveyard.Watch))
+
+	_, err := graveyard.Await("client", testkit.Born, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err = os.Stat(trapped); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("child did not trap SIGTERM")
+		}
+	}
+
+	buried := time.Now()
+	err = graveyard.Bury("server", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := awaitCode(t, codeCh, 5*time.Second)
+	if code != 128+int(syscall.SIGKILL) {
+		t.Fatalf("expected child to be killed with exit code %d, got %d", 128+int(syscall.SIGKILL), code)
+	}
+	if elapsed := time.Since(buried); elapsed < config.GracePeriod {
+		t.Fatalf("child killed in %s, before grace period %s", elapsed, config.GracePeriod)
+	}
+	ts, err := graveyard.Read("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Died == nil {
+		t.Fatalf("death is not recorded: %s", ts)
+	}
+}
+
+func TestExitCodeMapOfSignaledChild(t *testing.T) {
+	graveyard := testkit.NewGraveyard(t)
+
+	config := testConfig(graveyard)
+	config.ExitCodeMap = map[int]int{128 + int(syscall.SIGTERM): 0}
+
+	// child killed by SIGTERM it doesn't handle
+	codeCh := testRunApp(t, config, []string{"sh", "-c", `kill -TERM $$`})
+
+	code := awaitCode(t, codeCh, 5*time.Second)
+	if code != 0 {
+		t.Fatalf("expected exit code remapped to 0, got %d", code)
+	}
+	ts, err := graveyard.Read("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.ExitCode == nil || *ts.ExitCode != 0 {
+		t.Fatalf("unexpected tombstone: %s", ts)
+	}
+}
+
+func TestZeroExitOnDeathDep(t *testing.T) {
+	graveyard := testkit.NewGraveyard(t)
+
+	config := testConfig(graveyard)
+	config.DeathDeps = []string{"server"}
+	config.ZeroExitOnDeathDep = true
+
+	// child doesn't handle SIGTERM, so it is killed by it
+	codeCh := testRunApp(t, config, []string{"sleep", "30"}, kubexit.WithGraveyardWatcher(graveyard.Watch))
+
+	_, err := graveyard.Await("client", testkit.Born, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = graveyard.Bury("server", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := awaitCode(t, codeCh, 5*time.Second)
+	if code != 0 {
+		t.Fatalf("expected zero exit code, got %d", code)
+	}
+	// the tombstone keeps the real code
+	ts, err := graveyard.Read("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.ExitCode == nil || *ts.ExitCode != 128+int(syscall.SIGTERM) {
+		t.Fatalf("unexpected tombstone: %s", ts)
+	}
+}
+
+func TestRestartExitCodeNotLimited(t *testing.T) {
+	graveyard := testkit.NewGraveyard(t)
+	runs := filepath.Join(t.TempDir(), "runs")
+
+	config := testConfig(graveyard)
+	config.RestartExitCode = 64
+	config.RestartLimit = 1
+
+	// child requests restart on the first 3 runs
+	codeCh := testRunApp(t, config, []string{"sh", "-c", `echo >> "$0"; [ $(wc -l < "$0") -gt 3 ] || exit 64`, runs})
+
+	code := awaitCode(t, codeCh, 5*time.Second)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	content, err := ioutil.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(content), "\n"); n != 4 {
+		t.Fatalf("expected child to run 4 times, got %d", n)
+	}
+}
+
+func TestRestartPolicyExhausted(t *testing.T) {
+	graveyard := testkit.NewGraveyard(t)
+	message := filepath.Join(t.TempDir(), "termination-log")
+	err := ioutil.WriteFile(message, nil, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := testConfig(graveyard)
+	config.RestartPolicy = supervisor.RestartOnFailure
+	config.RestartMaxRetries = 2
+	config.RestartBackoff = time.Millisecond
+	config.RestartBackoffMax = time.Minute
+	config.RestartExhausted = restartExhaustedSleep
+	config.RestartExhaustedSleep = time.Millisecond
+	config.TerminationMessagePath = message
+
+	codeCh := testRunApp(t, config, []string{"sh", "-c", "exit 3"})
+
+	// exhausted restarts sleep and exit with zero code
+	code := awaitCode(t, codeCh, 5*time.Second)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	ts, err := graveyard.Read("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.ExitCode == nil || *ts.ExitCode != 3 {
+		t.Fatalf("unexpected tombstone: %s", ts)
+	}
+	content, err := ioutil.ReadFile(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "exhausted 2 restarts") {
+		t.Fatalf("unexpected termination message: %s", content)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ispringtech/kubexit/pkg/depgraph"
+)
+
+// manifest is the shared coordination manifest of the pod: config documents of all participants by name,
+// in KUBEXIT_CONFIG_JSON format, as yaml or json
+type manifest map[string]json.RawMessage
+
+func readManifest(path string) (manifest, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to read manifest: %v", err))
+	}
+	raw, err = yaml.YAMLToJSON(raw)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to parse manifest %s: %v", path, err))
+	}
+
+	var m manifest
+	err = json.Unmarshal(raw, &m)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to parse manifest %s: %v", path, err))
+	}
+	return m, nil
+}
+
+// addTo adds dependencies of all participants to the graph
+func (m manifest) addTo(graph *depgraph.Graph) error {
+	for name, document := range m {
+		values, err := flattenConfigDocument(document)
+		if err != nil {
+			return errors.Wrapf(err, "invalid manifest section %s", name)
+		}
+		graph.Add(depgraph.Node{
+			Name:      name,
+			BirthDeps: splitList(values["KUBEXIT_BIRTH_DEPS"]),
+			DeathDeps: splitList(values["KUBEXIT_DEATH_DEPS"]),
+		})
+	}
+	return nil
+}
+
+// checkDependencyCycles fails on birth dependency cycles of the participants in the manifest and the own config,
+// as they wait for each other until birth timeout. Death dependency cycles are fine, participants just stop together
+func checkDependencyCycles(m manifest, name string, birthDeps, deathDeps []string) error {
+	graph := depgraph.New()
+	err := m.addTo(graph)
+	if err != nil {
+		return err
+	}
+	graph.Add(depgraph.Node{Name: name, BirthDeps: birthDeps, DeathDeps: deathDeps})
+
+	// absence deps are not checked, as a participant without tombstone is absent, so they don't wait for each other
+	if cycle := graph.Cycle(depgraph.KindBirth); cycle != nil {
+		return errors.Errorf("birth dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
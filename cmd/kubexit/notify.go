@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ispringtech/kubexit/pkg/sdnotify"
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+)
+
+// listenNotify serves sd_notify socket to the child, passing each message to all handlers,
+// as shutdown acknowledgement and watchdog share the socket
+func listenNotify(ctx context.Context, config *config, child *supervisor.Supervisor, handlers []sdnotify.Handler) error {
+	err := sdnotify.Listen(ctx, config.NotifySocket, func(state map[string]string) {
+		for _, handler := range handlers {
+			handler(state)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	child.SetEnv(fmt.Sprintf("%s=%s", sdnotify.EnvName, config.NotifySocket))
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/rotate"
+)
+
+// Formats of child output written to the container streams
+const (
+	// outputFormatRaw passes child output as is
+	outputFormatRaw = "raw"
+	// outputFormatJSON wraps each line of child output into kubexit JSON log entry
+	outputFormatJSON = "json"
+)
+
+// maxOutputLine is the length of child output lines after which they are split into several entries
+const maxOutputLine = 64 * 1024
+
+// childOutput is where child stdout and stderr are written instead of the container streams as is:
+// the streams wrapped into JSON log entries, and/or files the output is copied to, so it survives the container, e.g. killed by OOM
+type childOutput struct {
+	stdout io.Writer
+	stderr io.Writer
+	// closers flush and close the writers, in order
+	closers []func()
+}
+
+// openChildOutput wraps the container streams per config.OutputFormat,
+// and opens <name>.stdout.log and <name>.stderr.log in config.OutputDir when set
+func openChildOutput(config *config) (*childOutput, error) {
+	o := &childOutput{stdout: os.Stdout, stderr: os.Stderr}
+	if config.OutputFormat == outputFormatJSON {
+		stdout := newLineLogger(newOutputLogger(config, os.Stdout), config.Name, "stdout")
+		stderr := newLineLogger(newOutputLogger(config, os.Stderr), config.Name, "stderr")
+		o.stdout, o.stderr = stdout, stderr
+		o.closers = append(o.closers, stdout.flush, stderr.flush)
+	}
+	if config.OutputDir == "" {
+		return o, nil
+	}
+
+	options := rotate.Options{
+		MaxSize:    int64(config.OutputMaxSizeMB) << 20,
+		MaxBackups: config.OutputMaxFiles,
+		Compress:   config.OutputCompress,
+	}
+	stdout, err := rotate.Open(filepath.Join(config.OutputDir, config.Name+".stdout.log"), options)
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := rotate.Open(filepath.Join(config.OutputDir, config.Name+".stderr.log"), options)
+	if err != nil {
+		_ = stdout.Close()
+		return nil, err
+	}
+	// failed file writes, e.g. of the full volume, are dropped, so they don't break the child output to the container
+	o.stdout = io.MultiWriter(o.stdout, bestEffortWriter{stdout})
+	o.stderr = io.MultiWriter(o.stderr, bestEffortWriter{stderr})
+	o.closers = append(o.closers, func() {
+		_ = stdout.Close()
+		_ = stderr.Close()
+	})
+	return o, nil
+}
+
+// writers return child stdout and stderr
+func (o *childOutput) writers() (io.Writer, io.Writer) {
+	return o.stdout, o.stderr
+}
+
+// close flushes output not ended with newline and closes files. Must be called after the child exits
+func (o *childOutput) close() {
+	for _, closer := range o.closers {
+		closer()
+	}
+}
+
+// bestEffortWriter reports writes to w succeeded regardless of errors
+type bestEffortWriter struct {
+	w io.Writer
+}
+
+func (b bestEffortWriter) Write(p []byte) (int, error) {
+	_, _ = b.w.Write(p)
+	return len(p), nil
+}
+
+// newOutputLogger returns logger of child output in the format of kubexit logs. Child output is never suppressed by KUBEXIT_QUIET
+func newOutputLogger(config *config, out io.Writer) *logrus.Logger {
+	impl := initLogger(config)
+	impl.SetOutput(out)
+	impl.SetLevel(logrus.InfoLevel)
+	return impl
+}
+
+// lineLogger logs each line written to it as an entry with the container and stream fields
+type lineLogger struct {
+	logger    *logrus.Entry
+	m         sync.Mutex
+	unwritten []byte
+}
+
+func newLineLogger(logger *logrus.Logger, container, stream string) *lineLogger {
+	return &lineLogger{logger: logger.WithField("container", container).WithField("stream", stream)}
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.unwritten = append(l.unwritten, p...)
+	for {
+		i := bytes.IndexByte(l.unwritten, '\n')
+		if i >= 0 && i <= maxOutputLine {
+			l.log(l.unwritten[:i])
+			l.unwritten = l.unwritten[i+1:]
+			continue
+		}
+		if len(l.unwritten) < maxOutputLine {
+			break
+		}
+		l.log(l.unwritten[:maxOutputLine])
+		l.unwritten = l.unwritten[maxOutputLine:]
+	}
+	// keep the buffer from growing by the consumed prefix
+	l.unwritten = append([]byte(nil), l.unwritten...)
+	return len(p), nil
+}
+
+// flush logs the last line not ended with newline
+func (l *lineLogger) flush() {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if len(l.unwritten) > 0 {
+		l.log(l.unwritten)
+		l.unwritten = nil
+	}
+}
+
+func (l *lineLogger) log(line []byte) {
+	l.logger.Info(string(bytes.TrimSuffix(line, []byte("\r"))))
+}
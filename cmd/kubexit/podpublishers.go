@@ -0,0 +1,273 @@
+//go:build !nokubernetes
+// +build !nokubernetes
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/kubernetes"
+	"github.com/ispringtech/kubexit/pkg/readiness"
+)
+
+// kubernetesSupport is false in slim build made with nokubernetes tag
+const kubernetesSupport = true
+
+const podPatchTimeout = 5 * time.Second
+
+// setPodWatchOptions applies pod watch config to pod watches of birth deps
+func setPodWatchOptions(config *config) {
+	kubernetes.SetWatchOptions(kubernetes.WatchOptions{
+		Timeout:   config.PodWatchTimeout,
+		Bookmarks: config.PodWatchBookmarks,
+	})
+}
+
+// recordContainerStatus stamps tombstone with restart count and image of the container from pod status.
+// Failures are logged only, the tombstone falls back to locally counted restarts
+func recordContainerStatus(ctx context.Context, config *config, logger *logrus.Logger, ts *tombstoneWriter) {
+	ctx, cancel := context.WithTimeout(ctx, podPatchTimeout)
+	defer cancel()
+
+	status, err := kubernetes.GetContainerStatus(ctx, config.Namespace, config.PodName, config.ContainerName)
+	if err != nil {
+		logger.WithError(err).Warn("failed to get container status")
+		return
+	}
+
+	ts.m.Lock()
+	defer ts.m.Unlock()
+	ts.RestartCount = status.RestartCount
+	ts.Image = status.Image
+	ts.ImageID = status.ImageID
+}
+
+// getPodAnnotations returns annotations of the pod, which config values are read from
+func getPodAnnotations(namespace, podName string) (map[string]string, error) {
+	if namespace == "" || podName == "" {
+		return nil, errors.New("missing env var: KUBEXIT_POD_NAME or KUBEXIT_NAMESPACE")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), podPatchTimeout)
+	defer cancel()
+	return kubernetes.GetPodAnnotations(ctx, namespace, podName)
+}
+
+// resourceAwareGracePeriod scales the default grace period by the pod QoS class and the container CPU limit
+func resourceAwareGracePeriod(namespace, podName, container string, gracePeriod time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), podPatchTimeout)
+	defer cancel()
+
+	resources, err := kubernetes.GetPodResources(ctx, namespace, podName, container)
+	if err != nil {
+		return 0, err
+	}
+	return scaleGracePeriod(gracePeriod, resources.QOSClass, resources.CPULimitMillis, resources.TerminationGracePeriod), nil
+}
+
+// checkClockSkew records offset of the local clock from the API server one in the tombstone,
+// and warns when it exceeds config.ClockSkewMax, as Born and Died of different containers are compared
+func checkClockSkew(ctx context.Context, config *config, logger *logrus.Logger, ts *tombstoneWriter) {
+	ctx, cancel := context.WithTimeout(ctx, podPatchTimeout)
+	defer cancel()
+
+	offset, err := kubernetes.ClockOffset(ctx)
+	if err != nil {
+		logger.WithError(err).Warn("failed to check clock skew")
+		return
+	}
+
+	ts.m.Lock()
+	ts.ClockOffset = offset.String()
+	ts.m.Unlock()
+
+	// Date header resolution is one second, so offset is rounded to it
+	skew := offset.Round(time.Second)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > config.ClockSkewMax {
+		logger.WithField("clock_offset", offset.String()).WithField("clock_skew_max", config.ClockSkewMax.String()).
+			Warn("local clock is skewed from the api server clock, timestamps of tombstones are not comparable")
+	}
+}
+
+// checkContainerNames warns about KUBEXIT_NAME, container birth deps and death deps not matching pod container names,
+// as typos there make kubexit wait for birth deps or watch death deps forever. Failures are logged only
+func checkContainerNames(ctx context.Context, config *config, logger *logrus.Logger) {
+	ctx, cancel := context.WithTimeout(ctx, podPatchTimeout)
+	defer cancel()
+
+	containers, err := kubernetes.GetContainerNames(ctx, config.Namespace, config.PodName)
+	if err != nil {
+		logger.WithError(err).Warn("failed to get pod container names")
+		return
+	}
+
+	known := make(map[string]struct{}, len(containers))
+	for _, container := range containers {
+		known[container] = struct{}{}
+	}
+	warnUnknown := func(field, name, message string) {
+		if _, ok := known[name]; ok {
+			return
+		}
+		logger.WithField(field, name).WithField("containers", containers).Warn(message)
+	}
+
+	warnUnknown("container_name", config.ContainerName, "KUBEXIT_NAME doesn't match any pod container name, set KUBEXIT_CONTAINER_NAME if it differs intentionally")
+
+	deps, err := readiness.ParseDeps(config.BirthDeps)
+	if err != nil {
+		return
+	}
+	for _, name := range readiness.Containers(deps) {
+		warnUnknown("birth_dep", name, "birth dependency doesn't match any pod container name, it will never be ready")
+	}
+
+	// death deps in node graveyards are participants of other pods
+	if config.GraveyardScope == graveyardScopePod {
+		for _, name := range config.DeathDeps {
+			warnUnknown("death_dep", name, "death dependency doesn't match any pod container name")
+		}
+	}
+}
+
+func newPodAnnotationsPublisher(ctx context.Context, config *config) statePublisher {
+	return &podAnnotationsPublisher{
+		ctx:       ctx,
+		name:      config.Name,
+		namespace: config.Namespace,
+		podName:   config.PodName,
+	}
+}
+
+// podAnnotationsPublisher writes state to `kubexit.dev/state-<name>` and `kubexit.dev/exit-code-<name>` pod annotations
+type podAnnotationsPublisher struct {
+	ctx       context.Context
+	name      string
+	namespace string
+	podName   string
+}
+
+func (p *podAnnotationsPublisher) Publish(state string, exitCode *int) {
+	annotations := map[string]string{
+		fmt.Sprintf("kubexit.dev/state-%s", p.name):     state,
+		fmt.Sprintf("kubexit.dev/exit-code-%s", p.name): "",
+	}
+	if exitCode != nil {
+		annotations[fmt.Sprintf("kubexit.dev/exit-code-%s", p.name)] = strconv.Itoa(*exitCode)
+	}
+
+	ctx, cancel := context.WithTimeout(p.ctx, podPatchTimeout)
+	defer cancel()
+
+	event.ContextEventTrace(p.ctx).AddEvent(fmt.Sprintf("Publishing state to pod annotations: %s", state))
+	err := kubernetes.PatchPodAnnotations(ctx, p.namespace, p.podName, annotations)
+	if err != nil {
+		event.ContextEventTrace(p.ctx).AddEvent(fmt.Sprintf("Failed to publish state: %v", err))
+	}
+}
+
+func newPodConditionPublisher(ctx context.Context, config *config) statePublisher {
+	return &podConditionPublisher{
+		ctx:       ctx,
+		name:      config.Name,
+		namespace: config.Namespace,
+		podName:   config.PodName,
+	}
+}
+
+// podConditionPublisher sets `kubexit.dev/ready-<name>` pod condition, which may be used in pod readinessGates.
+// Condition is true while the child is running.
+type podConditionPublisher struct {
+	ctx       context.Context
+	name      string
+	namespace string
+	podName   string
+}
+
+func (p *podConditionPublisher) Publish(state string, _ *int) {
+	conditionType := fmt.Sprintf("kubexit.dev/ready-%s", p.name)
+	ready := state == childStateRunning
+
+	ctx, cancel := context.WithTimeout(p.ctx, podPatchTimeout)
+	defer cancel()
+
+	event.ContextEventTrace(p.ctx).AddEvent(fmt.Sprintf("Setting pod condition %s: %t", conditionType, ready))
+	err := kubernetes.SetPodCondition(ctx, p.namespace, p.podName, conditionType, ready, strings.ToUpper(state[:1])+state[1:])
+	if err != nil {
+		event.ContextEventTrace(p.ctx).AddEvent(fmt.Sprintf("Failed to set pod condition: %v", err))
+	}
+}
+
+// podEventWarningsThreshold is the number of handler errors with the same reason raising the first pod event.
+// Following events are raised each time the number doubles, so persistent errors don't flood the namespace
+const podEventWarningsThreshold = 3
+
+func newPodEventWarnings(ctx context.Context, config *config) event.Warnings {
+	return &podEventWarnings{
+		ctx:       ctx,
+		namespace: config.Namespace,
+		podName:   config.PodName,
+		podUID:    config.PodUID,
+		name:      config.Name,
+		next:      map[string]int{},
+		counts:    map[string]int{},
+	}
+}
+
+// podEventWarnings raises Warning Events of the pod on repeated handler errors
+type podEventWarnings struct {
+	ctx       context.Context
+	namespace string
+	podName   string
+	podUID    string
+	name      string
+
+	m      sync.Mutex
+	counts map[string]int
+	// next is the count of errors raising the next event by reason
+	next map[string]int
+}
+
+func (w *podEventWarnings) Warn(reason, message string) {
+	w.m.Lock()
+	w.counts[reason]++
+	count := w.counts[reason]
+	next, ok := w.next[reason]
+	if !ok {
+		next = podEventWarningsThreshold
+	}
+	raise := count >= next
+	if raise {
+		w.next[reason] = next * 2
+	}
+	w.m.Unlock()
+
+	if !raise {
+		return
+	}
+
+	// raised asynchronously, handlers are not blocked by the API server
+	crash.Go(w.ctx, "pod warning event", func() {
+		ctx, cancel := context.WithTimeout(w.ctx, podPatchTimeout)
+		defer cancel()
+
+		message := fmt.Sprintf("kubexit %s: %s (%d times)", w.name, message, count)
+		err := kubernetes.CreatePodWarningEvent(ctx, w.namespace, w.podName, w.podUID, reason, message)
+		if err != nil {
+			event.ContextEventTrace(w.ctx).AddEvent(fmt.Sprintf("Failed to raise pod event: %v", err))
+		}
+	})
+}
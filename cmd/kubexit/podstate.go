@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+
+	"github.com/ispringtech/kubexit/pkg/audit"
+)
+
+const (
+	childStateRunning  = "running"
+	childStatePaused   = "paused"
+	childStateDraining = "draining"
+	childStateExited   = "exited"
+)
+
+// statePublisher reflects supervised child state transitions outside of the container
+type statePublisher interface {
+	Publish(state string, exitCode *int)
+}
+
+func newStatePublisher(ctx context.Context, config *config) statePublisher {
+	var publishers multiStatePublisher
+	if config.PodAnnotations {
+		publishers = append(publishers, newPodAnnotationsPublisher(ctx, config))
+	}
+	if config.PodCondition {
+		publishers = append(publishers, newPodConditionPublisher(ctx, config))
+	}
+	if config.Audit {
+		publishers = append(publishers, auditPublisher{log: audit.ContextLog(ctx)})
+	}
+	return publishers
+}
+
+// auditPublisher appends state transitions to audit log
+type auditPublisher struct {
+	log audit.Log
+}
+
+func (p auditPublisher) Publish(state string, exitCode *int) {
+	p.log.Append(audit.Record{Event: audit.EventState, State: state, ExitCode: exitCode})
+}
+
+type multiStatePublisher []statePublisher
+
+func (m multiStatePublisher) Publish(state string, exitCode *int) {
+	for _, publisher := range m {
+		publisher.Publish(state, exitCode)
+	}
+}
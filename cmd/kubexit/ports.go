@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/readiness"
+)
+
+// waitPortsFree blocks until nobody listens on configured ports, so the child doesn't crash loop
+// on the port still held by its just killed predecessor. Fails when PortReleaseTimeout elapses or ctx is done
+func waitPortsFree(ctx context.Context, config *config) error {
+	ctx, stopProbes := context.WithTimeout(ctx, config.PortReleaseTimeout)
+	defer stopProbes()
+
+	names := make([]string, 0, len(config.WaitPortsFree))
+	for _, port := range config.WaitPortsFree {
+		names = append(names, fmt.Sprintf("port %d", port))
+	}
+
+	matrix := readiness.NewMatrix(names, nil)
+	for i, port := range config.WaitPortsFree {
+		name, probe := names[i], readiness.PortFreeProbe(port)
+		crash.Go(ctx, name+" probe", func() {
+			readiness.Poll(ctx, name, config.BirthCheckInterval, probe, matrix)
+		})
+	}
+
+	err := matrix.Wait(ctx)
+	if err == context.DeadlineExceeded {
+		return withErrorCode(errorCodePortsBusy, errors.Errorf("port release timeout %s elapsed, busy: %s",
+			config.PortReleaseTimeout, strings.Join(matrix.Pending(), ", ")))
+	} else if err != nil {
+		return withErrorCode(errorCodeBirthInterrupted, errors.Errorf("%v, busy: %s", err, strings.Join(matrix.Pending(), ", ")))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+	"github.com/ispringtech/kubexit/pkg/tombstone"
+)
+
+const (
+	// restartReasonExitCode is a restart requested by the child with KUBEXIT_RESTART_EXIT_CODE
+	restartReasonExitCode = "exit_code"
+	// restartReasonOverlapped is an overlapped restart requested via control api
+	restartReasonOverlapped = "overlapped"
+	// restartReasonWatchdog is a restart of the child missing watchdog ping, see KUBEXIT_WATCHDOG
+	restartReasonWatchdog = "watchdog"
+	// restartReasonLiveness is a restart of the child failing liveness probe, see KUBEXIT_LIVENESS_PROBE
+	restartReasonLiveness = "liveness"
+	// restartReasonBirthDepLost is a restart of the child after its birth dep is lost, see KUBEXIT_BIRTH_DEPS_LOST_POLICY
+	restartReasonBirthDepLost = "birth_dep_lost"
+	// restartReasonPolicy is a restart of the exited child by KUBEXIT_RESTART_POLICY
+	restartReasonPolicy = "restart_policy"
+)
+
+const (
+	// restartExhaustedCrash exits with the child exit code, so kubelet restarts the container with CrashLoopBackOff
+	restartExhaustedCrash = "crash"
+	// restartExhaustedSleep sleeps and exits with zero, so kubelet restarts the container after the sleep
+	restartExhaustedSleep = "sleep"
+)
+
+// inPlaceRestarter kills the child on request of kubexit subsystems, so it's restarted in place with the reason
+type inPlaceRestarter struct {
+	child *supervisor.Supervisor
+
+	m      sync.Mutex
+	reason string
+}
+
+// request kills the running child to restart it, the first reason wins until taken
+func (r *inPlaceRestarter) request(reason string) error {
+	if r.child.Pid() == 0 {
+		return nil
+	}
+
+	r.m.Lock()
+	if r.reason == "" {
+		r.reason = reason
+	}
+	r.m.Unlock()
+
+	return r.child.ShutdownNow()
+}
+
+// take returns and clears the reason of requested restart, empty if none
+func (r *inPlaceRestarter) take() string {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	reason := r.reason
+	r.reason = ""
+	return reason
+}
+
+// recordRestart adds the restart to the history in status and tombstone,
+// exitCode is nil when the child was replaced while running
+func recordRestart(status *childStatus, ts *tombstoneWriter, reason string, exitCode *int) error {
+	now := time.Now()
+	status.addRestart(restartRecord{Time: now, ExitCode: exitCode, Reason: reason})
+	return ts.recordRestart(tombstone.Restart{Time: now, ExitCode: exitCode, Reason: reason})
+}
+
+// formatRestarts formats restart history for the single line termination message
+func formatRestarts(restarts []restartRecord) string {
+	parts := make([]string, 0, len(restarts))
+	for _, r := range restarts {
+		part := fmt.Sprintf("%s %s", r.Time.UTC().Format(time.RFC3339), r.Reason)
+		if r.ExitCode != nil {
+			part += fmt.Sprintf(" exit code %d", *r.ExitCode)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// sleepOnExhaustedRestarts delays exit after the child exhausted restarts, so kubelet restarts the container
+// not sooner than after sleep. Returns when sleep elapses or kubexit is terminated
+func sleepOnExhaustedRestarts(ctx context.Context, logger *logrus.Logger, sleep time.Duration) {
+	ctx, stopSignals := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	defer stopSignals()
+
+	ctx, cancel := context.WithTimeout(ctx, sleep)
+	defer cancel()
+
+	logger.WithField("sleep", sleep.String()).Info("child exhausted restarts, sleeping before exit")
+	<-ctx.Done()
+}
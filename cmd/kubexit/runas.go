@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// runAs are the ids the child runs as, see KUBEXIT_RUN_AS_USER and KUBEXIT_RUN_AS_GROUP
+type runAs struct {
+	UID uint32
+	GID uint32
+}
+
+// parseRunAs resolves user and group names or ids the child runs as, nil when both are empty.
+// The group defaults to the primary group of the user, when the user is known to the image, or kubexit group otherwise.
+// The user defaults to kubexit user
+func parseRunAs(userSpec, groupSpec string) (*runAs, error) {
+	if userSpec == "" && groupSpec == "" {
+		return nil, nil
+	}
+
+	ids := &runAs{UID: uint32(os.Getuid()), GID: uint32(os.Getgid())}
+	if userSpec != "" {
+		u, err := lookupUser(userSpec)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid KUBEXIT_RUN_AS_USER")
+		}
+		uid, err := parseID(u.Uid)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid KUBEXIT_RUN_AS_USER")
+		}
+		ids.UID = uid
+		if u.Gid != "" {
+			ids.GID, err = parseID(u.Gid)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid KUBEXIT_RUN_AS_USER")
+			}
+		}
+	}
+	if groupSpec != "" {
+		gid, err := parseID(groupSpec)
+		if err != nil {
+			g, err2 := user.LookupGroup(groupSpec)
+			if err2 != nil {
+				return nil, errors.Wrap(err2, "invalid KUBEXIT_RUN_AS_GROUP")
+			}
+			gid, err = parseID(g.Gid)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid KUBEXIT_RUN_AS_GROUP")
+			}
+		}
+		ids.GID = gid
+	}
+	return ids, nil
+}
+
+// lookupUser looks up user by name or id. Numeric ids unknown to the image are valid, as with securityContext.runAsUser
+func lookupUser(spec string) (*user.User, error) {
+	if _, err := parseID(spec); err == nil {
+		u, err := user.LookupId(spec)
+		if err != nil {
+			return &user.User{Uid: spec}, nil
+		}
+		return u, nil
+	}
+	return user.Lookup(spec)
+}
+
+func parseID(id string) (uint32, error) {
+	value, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, errors.Errorf("invalid id %s", id)
+	}
+	return uint32(value), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/cgroup"
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+)
+
+const (
+	runtimeTuningGo   = "go"
+	runtimeTuningJava = "java"
+
+	// goMemoryLimitRatio leaves headroom for memory not managed by Go runtime
+	goMemoryLimitRatio = 0.9
+	// javaHeapRatio leaves headroom for metaspace, thread stacks and direct buffers
+	javaHeapRatio = 0.75
+)
+
+// tuneChildRuntime sets runtime env vars of the child sized by the container cgroup limits,
+// unless they are set already. Failures are logged only, the child runs with runtime defaults
+func tuneChildRuntime(config *config, logger *logrus.Logger, child *supervisor.Supervisor) {
+	limits, err := cgroup.Read()
+	if err != nil {
+		logger.WithError(err).Warn("failed to read cgroup limits, child runtime is not tuned")
+		return
+	}
+
+	env := runtimeTuningEnv(config.RuntimeTuning, limits, os.LookupEnv)
+	if len(env) == 0 {
+		return
+	}
+	logger.WithField("env", env).WithField("cpu_limit", limits.CPU).WithField("memory_limit", limits.Memory).
+		Info("child runtime tuned by cgroup limits")
+	child.SetEnv(env...)
+}
+
+// runtimeTuningEnv returns KEY=value env vars of the runtimes sized by limits, skipping ones set in env already
+func runtimeTuningEnv(runtimes []string, limits *cgroup.Limits, lookupEnv func(string) (string, bool)) []string {
+	var env []string
+	for _, runtime := range runtimes {
+		switch runtime {
+		case runtimeTuningGo:
+			if _, ok := lookupEnv("GOMAXPROCS"); !ok && limits.CPU > 0 {
+				env = append(env, fmt.Sprintf("GOMAXPROCS=%d", int(math.Max(1, math.Ceil(limits.CPU)))))
+			}
+			if _, ok := lookupEnv("GOMEMLIMIT"); !ok && limits.Memory > 0 {
+				env = append(env, fmt.Sprintf("GOMEMLIMIT=%d", uint64(float64(limits.Memory)*goMemoryLimitRatio)))
+			}
+		case runtimeTuningJava:
+			options, _ := lookupEnv("JAVA_TOOL_OPTIONS")
+			if limits.Memory == 0 || strings.Contains(options, "-Xmx") || strings.Contains(options, "MaxRAM") {
+				continue
+			}
+			heapMiB := uint64(float64(limits.Memory)*javaHeapRatio) / (1 << 20)
+			env = append(env, "JAVA_TOOL_OPTIONS="+strings.TrimSpace(fmt.Sprintf("%s -Xmx%dm", options, heapMiB)))
+		}
+	}
+	return env
+}
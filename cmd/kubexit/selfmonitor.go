@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/metrics"
+	"github.com/ispringtech/kubexit/pkg/procstat"
+)
+
+// selfStats is kubexit own resource usage
+type selfStats struct {
+	Goroutines int    `json:"goroutines"`
+	FDs        int    `json:"fds"`
+	RSS        uint64 `json:"rss_bytes"`
+	HeapAlloc  uint64 `json:"heap_alloc_bytes"`
+}
+
+func sampleSelf() selfStats {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	stats := selfStats{
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  memStats.HeapAlloc,
+	}
+	// fds and rss are available on linux only
+	if stat, err := procstat.Sample(os.Getpid()); err == nil {
+		stats.FDs = stat.FDs
+		stats.RSS = stat.RSS
+	}
+	return stats
+}
+
+// monitorSelf periodically samples kubexit own resource usage into metrics,
+// and logs a warning when a threshold is exceeded, once per exceeding, until ctx is done
+func monitorSelf(ctx context.Context, config *config, logger *logrus.Logger) {
+	recorder := metrics.ContextRecorder(ctx)
+
+	ticker := time.NewTicker(config.SelfMonitorInterval)
+	defer ticker.Stop()
+
+	exceeded := map[string]bool{}
+	check := func(name string, value, threshold uint64) {
+		over := threshold > 0 && value > threshold
+		if over && !exceeded[name] {
+			logger.WithField(name, value).WithField("threshold", threshold).
+				Warn(fmt.Sprintf("kubexit %s exceeded threshold, possible leak", name))
+		}
+		exceeded[name] = over
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stats := sampleSelf()
+		recorder.SetGauge("kubexit_goroutines", "Number of kubexit goroutines", float64(stats.Goroutines))
+		recorder.SetGauge("kubexit_open_fds", "Number of kubexit open file descriptors", float64(stats.FDs))
+		recorder.SetGauge("kubexit_rss_bytes", "Resident set size of kubexit", float64(stats.RSS))
+		recorder.SetGauge("kubexit_heap_alloc_bytes", "Bytes of allocated kubexit heap objects", float64(stats.HeapAlloc))
+
+		check("goroutines", uint64(stats.Goroutines), uint64(config.SelfMaxGoroutines))
+		check("fds", uint64(stats.FDs), uint64(config.SelfMaxFDs))
+		check("rss_bytes", stats.RSS, uint64(config.SelfMaxRSSMB)<<20)
+	}
+}
+
+// dumpOnSignal logs kubexit own resource usage and all event traces collected so far, each time SIGUSR1 is received
+func dumpOnSignal(ctx context.Context, logger *logrus.Logger, eventTraces []event.Trace) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigCh:
+			messages, err := serializeEventTraces(eventTraces)
+			if err != nil {
+				logger.WithError(err).Error()
+				continue
+			}
+			logger.WithField("self", sampleSelf()).WithField("event-traces", messages).Info("kubexit dump")
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/health"
+)
+
+// listenAddress returns network and address of the server listening on addr of the config var name: unix:<path> for unix socket,
+// or host:port of tcp network of IP family. Port only address binds loopback, unless expose binds all interfaces,
+// so servers are kept off the pod network unless explicitly exposed
+func listenAddress(name, addr string, expose bool, ipFamily string) (string, string, error) {
+	if strings.HasPrefix(addr, "unix:") {
+		path := strings.TrimPrefix(addr, "unix:")
+		if path == "" {
+			return "", "", errors.Errorf("invalid %s %s, expected unix:<path>", name, addr)
+		}
+		return "unix", path, nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", errors.Errorf("invalid %s %s, expected unix:<path>, host:port or :port with IPv6 literals in brackets, e.g. [::1]:9090", name, addr)
+	}
+	network := tcpNetwork(ipFamily)
+	if host != "" || expose {
+		return network, addr, nil
+	}
+	if ipFamily == ipFamilyIPv6 {
+		return network, net.JoinHostPort("::1", port), nil
+	}
+	return network, net.JoinHostPort("127.0.0.1", port), nil
+}
+
+// serveHTTP starts serving handler on addr of network ("tcp", "tcp4", "tcp6" or "unix") in background. Server is closed when ctx is done,
+// the returned channel is closed when it stops serving. Server health is reported as subsystem with the name
+func serveHTTP(ctx context.Context, name, network, addr string, handler http.Handler) (<-chan struct{}, error) {
+	if network == "unix" {
+		// socket left by previous run of the container
+		_ = os.Remove(addr)
+	}
+
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to listen %s: %v", addr, err))
+	}
+
+	server := &http.Server{Handler: handler}
+	registry := health.ContextRegistry(ctx)
+	registry.Up(name)
+
+	crash.Go(ctx, name+" close", func() {
+		<-ctx.Done()
+		registry.Remove(name)
+		_ = server.Close()
+	})
+
+	served := make(chan struct{})
+	crash.Go(ctx, name, func() {
+		defer close(served)
+		event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Serving http on %s", listener.Addr()))
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Http server(%s): terminal error: %v", addr, err))
+			registry.Down(name, err.Error())
+		}
+	})
+
+	return served, nil
+}
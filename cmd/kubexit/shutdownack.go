@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/sdnotify"
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+)
+
+// Sources of the child acknowledgement of graceful shutdown
+const (
+	shutdownAckHTTP   = "http"
+	shutdownAckFile   = "file"
+	shutdownAckNotify = "notify"
+)
+
+// ackFilePollInterval is the interval of checking removal of the shutdown ack file
+const ackFilePollInterval = 100 * time.Millisecond
+
+// setupShutdownAck makes graceful shutdown of the child wait for acknowledgement from configured sources.
+// http source is served by control socket, notify source returns the handler of notify socket messages, nil otherwise
+func setupShutdownAck(ctx context.Context, config *config, child *supervisor.Supervisor) sdnotify.Handler {
+	child.SetShutdownAck(config.ShutdownAckWindow, config.ShutdownAckRetries)
+
+	var notify sdnotify.Handler
+	for _, source := range config.ShutdownAck {
+		switch source {
+		case shutdownAckNotify:
+			notify = func(state map[string]string) {
+				if state["STOPPING"] == "1" {
+					child.AckShutdown()
+				}
+			}
+		case shutdownAckFile:
+			crash.Go(ctx, "shutdown ack file", func() {
+				ackOnFileRemoval(ctx, config.ShutdownAckFile, child)
+			})
+		}
+	}
+	return notify
+}
+
+// ackOnFileRemoval acknowledges shutdown when the ready file of the child is removed
+func ackOnFileRemoval(ctx context.Context, path string, child *supervisor.Supervisor) {
+	ticker := time.NewTicker(ackFilePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := os.Stat(path)
+			if os.IsNotExist(err) {
+				// ignored by child unless shutdown is in progress
+				child.AckShutdown()
+			} else if err != nil {
+				event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Failed to check shutdown ack file: %v", err))
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// signalsByName are signals configurable to send or forward to the child
+var signalsByName = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"ABRT":  syscall.SIGABRT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"TERM":  syscall.SIGTERM,
+	"ALRM":  syscall.SIGALRM,
+	"PIPE":  syscall.SIGPIPE,
+	"CONT":  syscall.SIGCONT,
+	"TSTP":  syscall.SIGTSTP,
+	"TTIN":  syscall.SIGTTIN,
+	"TTOU":  syscall.SIGTTOU,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// parseSignal parses signal name with or without SIG prefix, e.g. SIGHUP or HUP
+func parseSignal(name string) (syscall.Signal, error) {
+	sig, ok := signalsByName[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, errors.Errorf("unsupported signal %s", name)
+	}
+	return sig, nil
+}
+
+// parseSignals parses signal names of env var list
+func parseSignals(name string, names []string) ([]syscall.Signal, error) {
+	var signals []syscall.Signal
+	for _, signalName := range names {
+		sig, err := parseSignal(signalName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", name)
+		}
+		signals = append(signals, sig)
+	}
+	return signals, nil
+}
+
+// osSignals converts signals for os/signal based APIs
+func osSignals(signals []syscall.Signal) []os.Signal {
+	converted := make([]os.Signal, len(signals))
+	for i, sig := range signals {
+		converted[i] = sig
+	}
+	return converted
+}
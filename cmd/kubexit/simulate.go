@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ispringtech/kubexit/pkg/simulate"
+)
+
+// runSimulate replays the scenario against coordination decisions of this container, configured by env as for supervising
+// and overridden by the scenario config, and prints the decisions with their timings. No child is started
+func runSimulate(args []string) int {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	path := flags.String("scenario", "", "scenario yaml or json file")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if *path == "" {
+		fmt.Fprintln(os.Stderr, "missing --scenario")
+		return 2
+	}
+
+	scenario, err := readScenario(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	// scenario config is what is reviewed, so it takes precedence over env
+	if len(scenario.Config) > 0 {
+		values, err2 := flattenConfigDocument(scenario.Config)
+		if err2 != nil {
+			fmt.Fprintf(os.Stderr, "invalid scenario config: %v\n", err2)
+			return 1
+		}
+		for key, value := range values {
+			os.Setenv(key, value)
+		}
+	}
+
+	config, err := parseConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	result, err := simulate.Run(simulate.Config{
+		BirthDeps:    config.BirthDeps,
+		DeathDeps:    config.DeathDeps,
+		BirthTimeout: config.BirthTimeout,
+		GracePeriod:  config.GracePeriod,
+
+		BirthTerm:    config.sigtermPolicy(termPhaseBirth),
+		RunningTerm:  config.sigtermPolicy(termPhaseRunning),
+		DrainingTerm: config.sigtermPolicy(termPhaseDraining),
+
+		RestartExitCode: config.RestartExitCode,
+		RestartLimit:    config.RestartLimit,
+
+		BirthDepsLostPolicy:      config.BirthDepsLostPolicy,
+		BirthDepsLostAfter:       config.BirthDepsLostAfter,
+		BirthDepsLostSignal:      config.BirthDepsLostSignal,
+		BirthDepsRecoveredAfter:  config.BirthDepsRecoveredAfter,
+		BirthDepsRecoveredSignal: config.BirthDepsRecoveredSignal,
+	}, *scenario)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	for _, decision := range result.Decisions {
+		fmt.Printf("%10s  %s\n", decision.At, decision.What)
+	}
+	if result.Exited {
+		fmt.Printf("exit code %d after %s, %d restarts\n", result.ExitCode, result.Duration, result.Restarts)
+	} else {
+		fmt.Printf("child still running after %s, %d restarts\n", result.Duration, result.Restarts)
+	}
+	return 0
+}
+
+func readScenario(path string) (*simulate.Scenario, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to read scenario: %v", err))
+	}
+	raw, err = yaml.YAMLToJSON(raw)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to parse scenario %s: %v", path, err))
+	}
+
+	var scenario simulate.Scenario
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	// typos in steps would silently change the scenario
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&scenario)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to parse scenario %s: %v", path, err))
+	}
+
+	for i, step := range scenario.Steps {
+		if step.Signal == "" {
+			continue
+		}
+		_, err = parseSignal(step.Signal)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid step %d", i+1)
+		}
+	}
+	return &scenario, nil
+}
@@ -0,0 +1,54 @@
+//go:build nokubernetes
+// +build nokubernetes
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/event"
+)
+
+// kubernetesSupport is false in slim build made with nokubernetes tag
+const kubernetesSupport = false
+
+var errNoKubernetesSupport = errors.New("kubexit is built without kubernetes support")
+
+func setPodWatchOptions(*config) {}
+
+func getPodAnnotations(string, string) (map[string]string, error) {
+	return nil, errNoKubernetesSupport
+}
+
+func resourceAwareGracePeriod(string, string, string, time.Duration) (time.Duration, error) {
+	return 0, errNoKubernetesSupport
+}
+
+func checkClockSkew(context.Context, *config, *logrus.Logger, *tombstoneWriter) {}
+
+func recordContainerStatus(context.Context, *config, *logrus.Logger, *tombstoneWriter) {}
+
+func checkContainerNames(context.Context, *config, *logrus.Logger) {}
+
+func newPodAnnotationsPublisher(context.Context, *config) statePublisher {
+	return multiStatePublisher{}
+}
+
+func newPodConditionPublisher(context.Context, *config) statePublisher {
+	return multiStatePublisher{}
+}
+
+func newPodEventWarnings(context.Context, *config) event.Warnings {
+	return nil
+}
+
+func runWebhook([]string) int {
+	fmt.Fprintf(os.Stderr, "%v\n", errNoKubernetesSupport)
+	return 1
+}
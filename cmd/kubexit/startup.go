@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ispringtech/kubexit/pkg/metrics"
+	"github.com/ispringtech/kubexit/pkg/tombstone"
+)
+
+const (
+	phaseConfigParse    = "config_parse"
+	phaseGraveyardWatch = "graveyard_watch"
+	phaseBirthDeps      = "birth_deps"
+	phaseChildStart     = "child_start"
+)
+
+// startupTimer measures how long kubexit spends in each phase between process start and child readiness
+type startupTimer struct {
+	begin     time.Time
+	m         sync.Mutex
+	phases    map[string]time.Duration
+	birthDeps map[string]time.Duration
+}
+
+func newStartupTimer(begin time.Time) *startupTimer {
+	return &startupTimer{
+		begin:     begin,
+		phases:    map[string]time.Duration{},
+		birthDeps: map[string]time.Duration{},
+	}
+}
+
+// observe records the duration of a phase started at since
+func (t *startupTimer) observe(phase string, since time.Time) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.phases[phase] = time.Since(since)
+}
+
+// observeBirthDep records the time a birth dependency took to become ready, counted from since.
+// Only the first readiness is taken into account
+func (t *startupTimer) observeBirthDep(name string, since time.Time) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if _, ok := t.birthDeps[name]; ok {
+		return
+	}
+	t.birthDeps[name] = time.Since(since)
+}
+
+// ready completes the measurement, publishes it to recorder and returns the summary for tombstone and logs
+func (t *startupTimer) ready(recorder metrics.Recorder) *tombstone.StartupLatency {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	timeToReady := time.Since(t.begin)
+
+	for phase, d := range t.phases {
+		recorder.SetGauge(
+			"kubexit_startup_phase_duration_seconds",
+			"Duration of kubexit startup phases",
+			d.Seconds(),
+			metrics.L("phase", phase),
+		)
+	}
+	for name, d := range t.birthDeps {
+		recorder.SetGauge(
+			"kubexit_birth_dep_wait_duration_seconds",
+			"Time spent waiting for each birth dependency to become ready",
+			d.Seconds(),
+			metrics.L("dep", name),
+		)
+	}
+	recorder.SetGauge(
+		"kubexit_time_to_ready_seconds",
+		"Time from kubexit start to the supervised child start",
+		timeToReady.Seconds(),
+	)
+
+	latency := &tombstone.StartupLatency{
+		ConfigParse:    formatPhase(t.phases, phaseConfigParse),
+		GraveyardWatch: formatPhase(t.phases, phaseGraveyardWatch),
+		BirthDepsWait:  formatPhase(t.phases, phaseBirthDeps),
+		ChildStart:     formatPhase(t.phases, phaseChildStart),
+		TimeToReady:    timeToReady.String(),
+	}
+	if len(t.birthDeps) > 0 {
+		latency.BirthDeps = make(map[string]string, len(t.birthDeps))
+		for name, d := range t.birthDeps {
+			latency.BirthDeps[name] = d.String()
+		}
+	}
+
+	return latency
+}
+
+func formatPhase(phases map[string]time.Duration, phase string) string {
+	d, ok := phases[phase]
+	if !ok {
+		return ""
+	}
+	return d.String()
+}
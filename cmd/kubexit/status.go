@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/metrics"
+	"github.com/ispringtech/kubexit/pkg/procstat"
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+	"github.com/ispringtech/kubexit/pkg/tombstone"
+)
+
+// childStateWaiting is the state before the child is started
+const childStateWaiting = "waiting"
+
+// childStatus is the supervised child state reported by /status endpoint
+type childStatus struct {
+	m sync.Mutex
+
+	Name      string          `json:"name"`
+	State     string          `json:"state"`
+	Pid       int             `json:"pid,omitempty"`
+	ExitCode  *int            `json:"exit_code,omitempty"`
+	Resources *procstat.Stat  `json:"resources,omitempty"`
+	Restarts  []restartRecord `json:"restarts,omitempty"`
+}
+
+// restartRecord is a restart of the child by kubexit, the last tombstone.MaxRestarts are kept
+type restartRecord struct {
+	Time     time.Time `json:"time"`
+	ExitCode *int      `json:"exit_code,omitempty"`
+	Reason   string    `json:"reason"`
+}
+
+func newChildStatus(name string) *childStatus {
+	return &childStatus{
+		Name:  name,
+		State: childStateWaiting,
+	}
+}
+
+// Publish implements statePublisher, so status follows child state transitions
+func (s *childStatus) Publish(state string, exitCode *int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.State = state
+	s.ExitCode = exitCode
+}
+
+func (s *childStatus) setResources(pid int, resources *procstat.Stat) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.Pid = pid
+	s.Resources = resources
+}
+
+func (s *childStatus) addRestart(restart restartRecord) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.Restarts = append(s.Restarts, restart)
+	if len(s.Restarts) > tombstone.MaxRestarts {
+		s.Restarts = s.Restarts[len(s.Restarts)-tombstone.MaxRestarts:]
+	}
+}
+
+func (s *childStatus) restarts() []restartRecord {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return append([]restartRecord(nil), s.Restarts...)
+}
+
+func (s *childStatus) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s)
+}
+
+// sampleChild periodically samples child resource usage into status and metrics until ctx is done
+func sampleChild(ctx context.Context, child *supervisor.Supervisor, status *childStatus, interval time.Duration) {
+	recorder := metrics.ContextRecorder(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pid := child.Pid()
+		if pid == 0 {
+			continue
+		}
+
+		stat, err := procstat.Sample(pid)
+		if err != nil {
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Failed to sample child: %v", err))
+			continue
+		}
+
+		status.setResources(pid, stat)
+		recorder.SetGauge("kubexit_child_rss_bytes", "Resident set size of the child process", float64(stat.RSS))
+		recorder.SetGauge("kubexit_child_cpu_seconds", "Total user and system CPU time of the child process", stat.CPUSeconds)
+		recorder.SetGauge("kubexit_child_open_fds", "Number of open file descriptors of the child process", float64(stat.FDs))
+		recorder.SetGauge("kubexit_child_threads", "Number of threads of the child process", float64(stat.Threads))
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// kubelet reads at most 4096 bytes of termination message
+const maxTerminationMessageLength = 4096
+
+// writeTerminationMessage writes a single line message to the container termination message path,
+// so it is shown in pod status under lastState.terminated.message.
+// Nothing is written if path is empty or the file doesn't exist, i.e. not running in kubernetes.
+func writeTerminationMessage(path string, exitCode int, reason string) error {
+	if path == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	message := fmt.Sprintf("exit code %d: %s", exitCode, strings.Join(strings.Fields(reason), " "))
+	if len(message) > maxTerminationMessageLength {
+		message = message[:maxTerminationMessageLength]
+	}
+
+	err := os.WriteFile(path, []byte(message), 0o644)
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to write termination message: %v", err))
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/tombstone"
+)
+
+const (
+	tombstoneFailurePolicyFail   = "fail"
+	tombstoneFailurePolicyIgnore = "ignore"
+
+	// tombstoneFallbackAnnotations stops writing tombstone, leaving the state to pod annotations
+	tombstoneFallbackAnnotations = "annotations"
+)
+
+// tombstoneMirror is a graveyard the tombstone is mirrored to, with its own failure policy
+type tombstoneMirror struct {
+	Graveyard     string `json:"graveyard"`
+	FailurePolicy string `json:"failure_policy"`
+}
+
+// tombstoneWriter records the tombstone within configured timeout, so a hung graveyard can't block kubexit forever,
+// falls back to secondary location when the graveyard is read-only or full,
+// mirrors it to other graveyards, and applies configured failure policies to write errors
+type tombstoneWriter struct {
+	*tombstone.Tombstone
+
+	// ctx carries event trace
+	ctx           context.Context
+	timeout       time.Duration
+	failurePolicy string
+	// fallback is a directory or tombstoneFallbackAnnotations, empty when disabled or used already
+	fallback string
+	mirrors  []tombstoneMirror
+	logger   *logrus.Logger
+
+	// m serializes records, as the child may be paused via control api concurrently
+	m sync.Mutex
+}
+
+func newTombstoneWriter(ctx context.Context, config *config, logger *logrus.Logger) *tombstoneWriter {
+	return &tombstoneWriter{
+		Tombstone: &tombstone.Tombstone{
+			Graveyard:  config.Graveyard,
+			Name:       config.tombstoneName(),
+			TTL:        config.TombstoneTTL,
+			PodUID:     config.PodUID,
+			Image:      config.Image,
+			BirthDeps:  config.BirthDeps,
+			DeathDeps:  config.DeathDeps,
+			Key:        config.TombstoneKey,
+			ReadOnly:   config.GraveyardReadOnly,
+			TimeFormat: config.timeFormat(),
+			Format:     config.TombstoneFormat,
+			Labels:     config.TombstoneLabels,
+		},
+		ctx:           ctx,
+		timeout:       config.TombstoneTimeout,
+		failurePolicy: config.TombstoneFailurePolicy,
+		fallback:      config.TombstoneFallback,
+		mirrors:       config.TombstoneMirrors,
+		logger:        logger,
+	}
+}
+
+// record applies the tombstone update within timeout, falling back to secondary location once,
+// when the graveyard is read-only or full
+func (w *tombstoneWriter) record(update func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(w.ctx, w.timeout)
+	defer cancel()
+
+	err := update(ctx)
+	if err == nil || w.fallback == "" || !tombstone.Unwritable(err) {
+		return err
+	}
+
+	entry := w.logger.WithError(err).WithField(errorCodeField, errorCodeTombstoneWriteFailed).WithField("fallback", w.fallback)
+	if w.fallback == tombstoneFallbackAnnotations {
+		entry.Warn("graveyard is not writable, tombstone state is left to pod annotations")
+		w.ReadOnly = true
+	} else {
+		entry.Warn("graveyard is not writable, falling back to secondary tombstone location")
+		w.Graveyard = w.fallback
+	}
+	w.fallback = ""
+
+	// the update is applied in memory already, only the write is repeated
+	ctx, cancel = context.WithTimeout(w.ctx, w.timeout)
+	defer cancel()
+	return w.Write(ctx)
+}
+
+func (w *tombstoneWriter) recordBirth(startup *tombstone.StartupLatency) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.Startup = startup
+	return w.commit(w.RecordBirth)
+}
+
+func (w *tombstoneWriter) recordDeath(exitCode int) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.commit(func(ctx context.Context) error {
+		return w.RecordDeath(ctx, exitCode)
+	})
+}
+
+// renewEvery renews tombstone expiry every interval until ctx is done. Failures are logged only,
+// the tombstone expires only if renewals keep failing
+func (w *tombstoneWriter) renewEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := w.renew()
+		if err != nil {
+			w.logger.WithError(err).WithField(errorCodeField, errorCodeTombstoneWriteFailed).Warn("failed to renew tombstone")
+		}
+	}
+}
+
+func (w *tombstoneWriter) renew() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	err := w.record(w.Renew)
+	if err != nil {
+		return err
+	}
+	return w.mirror()
+}
+
+func (w *tombstoneWriter) recordPause(paused bool) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.commit(func(ctx context.Context) error {
+		return w.RecordPause(ctx, paused)
+	})
+}
+
+func (w *tombstoneWriter) recordRestart(restart tombstone.Restart) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.commit(func(ctx context.Context) error {
+		return w.RecordRestart(ctx, restart)
+	})
+}
+
+func (w *tombstoneWriter) recordArtifacts(locations []string) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.commit(func(ctx context.Context) error {
+		return w.RecordArtifacts(ctx, locations)
+	})
+}
+
+// commit records the update in the graveyard and mirrors it, applying failure policies.
+// Mirrors are written regardless of the graveyard failure, as they are watched independently
+func (w *tombstoneWriter) commit(update func(ctx context.Context) error) error {
+	err := w.applyFailurePolicy(w.record(update))
+	mirrorErr := w.mirror()
+	if err != nil {
+		return err
+	}
+	return mirrorErr
+}
+
+// mirror writes the recorded tombstone to each mirror within timeout, returns the first error not ignored by mirror failure policy
+func (w *tombstoneWriter) mirror() error {
+	var firstErr error
+	for _, mirror := range w.mirrors {
+		ctx, cancel := context.WithTimeout(w.ctx, w.timeout)
+		err := w.Mirror(ctx, mirror.Graveyard)
+		cancel()
+		if err == nil {
+			continue
+		}
+
+		entry := w.logger.WithError(err).WithField(errorCodeField, errorCodeTombstoneWriteFailed).WithField("graveyard", mirror.Graveyard)
+		if mirror.FailurePolicy == tombstoneFailurePolicyIgnore {
+			entry.Warn("tombstone mirror write failure ignored by failure policy")
+			continue
+		}
+		if firstErr == nil {
+			firstErr = errors.Wrapf(err, "failed to mirror tombstone to %s", mirror.Graveyard)
+		}
+	}
+	return firstErr
+}
+
+// applyFailurePolicy returns nil for ignored errors, logging them as warnings
+func (w *tombstoneWriter) applyFailurePolicy(err error) error {
+	if err == nil || w.failurePolicy != tombstoneFailurePolicyIgnore {
+		return err
+	}
+	w.logger.WithError(err).WithField(errorCodeField, errorCodeTombstoneWriteFailed).
+		Warn("tombstone write failure ignored by failure policy")
+	return nil
+}
+
+// prepareNodeGraveyard stamps tombstone with its owner and collects stale tombstones of owners gone without recording death,
+// so participants on the node are not blocked forever by crashed ones
+func prepareNodeGraveyard(ctx context.Context, config *config, logger *logrus.Logger, ts *tombstoneWriter) error {
+	owner, err := tombstone.CurrentOwner()
+	if err != nil {
+		return err
+	}
+	ts.Owner = owner
+
+	if config.GraveyardReadOnly {
+		return nil
+	}
+
+	collected, err := tombstone.CollectStale(ctx, config.Graveyard, owner, config.TombstoneKey)
+	if len(collected) > 0 {
+		logger.WithField("tombstones", collected).Info("stale tombstones collected")
+	}
+	if err != nil {
+		logger.WithError(err).WithField(errorCodeField, errorCodeTombstoneWriteFailed).Warn("failed to collect stale tombstones")
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ispringtech/kubexit/pkg/tombstone"
+)
+
+const tombstoneUsage = `usage: kubexit tombstone [--graveyard path] [--key-file path] [--dry-run] command
+commands:
+  list      list tombstones in the graveyard with their state, exit code and labels
+  migrate   upgrade tombstones of older schema versions in the graveyard to the current one`
+
+// runTombstone runs tombstone maintenance commands against the graveyard
+func runTombstone(args []string) int {
+	flags := flag.NewFlagSet("tombstone", flag.ContinueOnError)
+	graveyard := flags.String("graveyard", os.Getenv("KUBEXIT_GRAVEYARD"), "graveyard path, KUBEXIT_GRAVEYARD or /graveyard by default")
+	keyFile := flags.String("key-file", os.Getenv("KUBEXIT_TOMBSTONE_KEY_FILE"), "key to verify and re-sign tombstones, KUBEXIT_TOMBSTONE_KEY_FILE by default")
+	dryRun := flags.Bool("dry-run", false, "migrate: report tombstones to upgrade without writing them")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, tombstoneUsage)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if *graveyard == "" {
+		*graveyard = "/graveyard"
+	}
+
+	var key []byte
+	if *keyFile != "" {
+		key, err = ioutil.ReadFile(*keyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read tombstone key: %v\n", err)
+			return 1
+		}
+		key = bytes.TrimSpace(key)
+	}
+
+	switch command := flags.Arg(0); command {
+	case "list":
+		return listTombstones(*graveyard, key)
+	case "migrate":
+		return migrateTombstones(*graveyard, key, *dryRun)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", command)
+		flags.Usage()
+		return 2
+	}
+}
+
+// listTombstones prints a table of tombstones in the graveyard. Forged tombstones are skipped, when key is set
+func listTombstones(graveyard string, key []byte) int {
+	tombstones, err := tombstone.ReadAll(graveyard, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATE\tEXIT CODE\tLABELS")
+	now := time.Now()
+	for _, ts := range tombstones {
+		exitCode := ""
+		if ts.ExitCode != nil {
+			exitCode = fmt.Sprint(*ts.ExitCode)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", ts.Name, ts.State(now), exitCode, formatLabels(ts.Labels))
+	}
+	err = w.Flush()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// formatLabels formats labels as sorted key=value pairs, as they are configured
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func migrateTombstones(graveyard string, key []byte, dryRun bool) int {
+	results, err := tombstone.MigrateGraveyard(context.Background(), graveyard, key, dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	code := 0
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			code = 1
+			fmt.Printf("%s: failed: %v\n", result.Name, result.Err)
+		case result.Migrated && dryRun:
+			fmt.Printf("%s: would migrate from version %d to %d\n", result.Name, result.FromVersion, tombstone.CurrentSchemaVersion)
+		case result.Migrated:
+			fmt.Printf("%s: migrated from version %d to %d\n", result.Name, result.FromVersion, tombstone.CurrentSchemaVersion)
+		default:
+			fmt.Printf("%s: version %d, up to date\n", result.Name, result.FromVersion)
+		}
+	}
+	return code
+}
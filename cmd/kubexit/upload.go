@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/artifacts"
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/tombstone"
+)
+
+// uploadDefaults validates upload url (s3://bucket/prefix or gs://bucket/prefix)
+// and returns storage endpoint and region, defaulted by the url scheme unless set explicitly
+func uploadDefaults(uploadURL, endpoint, region string) (string, string, error) {
+	u, err := url.Parse(uploadURL)
+	if err != nil {
+		return "", "", errors.Wrap(err, "invalid KUBEXIT_UPLOAD_URL")
+	}
+	if u.Host == "" {
+		return "", "", errors.Errorf("invalid KUBEXIT_UPLOAD_URL %s: missing bucket", uploadURL)
+	}
+
+	switch u.Scheme {
+	case "s3":
+		if region == "" {
+			region = os.Getenv("AWS_REGION")
+		}
+		if region == "" {
+			region = "us-east-1"
+		}
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+		}
+	case "gs":
+		// GCS XML API is S3 compatible with HMAC keys
+		if region == "" {
+			region = "auto"
+		}
+		if endpoint == "" {
+			endpoint = "https://storage.googleapis.com"
+		}
+	default:
+		return "", "", errors.Errorf("invalid KUBEXIT_UPLOAD_URL scheme %s, expected one of: s3, gs", u.Scheme)
+	}
+	return endpoint, region, nil
+}
+
+// uploadTarget is the delivery target of uploads to object storage
+const uploadTarget = "upload"
+
+// uploadSink returns sink of objects of this container, keyed by <prefix>/<pod uid>/<name>.
+// Pod name is used when pod uid is unknown, hostname when both are unknown
+func uploadSink(config *config) artifacts.Sink {
+	u, _ := url.Parse(config.UploadURL) // validated by parseConfig
+
+	pod := config.PodUID
+	if pod == "" {
+		pod = config.PodName
+	}
+	if pod == "" {
+		pod, _ = os.Hostname()
+	}
+
+	return artifacts.S3Sink{
+		Endpoint:    config.UploadEndpoint,
+		Region:      config.UploadRegion,
+		Bucket:      u.Host,
+		Prefix:      path.Join(strings.TrimPrefix(u.Path, "/"), pod, config.Name),
+		Credentials: artifacts.CredentialsFromEnv,
+	}
+}
+
+// exitReport describes the death of the child for post-mortem analysis, when the pod is gone already
+type exitReport struct {
+	Name      string               `json:"name"`
+	PodName   string               `json:"podName,omitempty"`
+	PodUID    string               `json:"podUID,omitempty"`
+	Namespace string               `json:"namespace,omitempty"`
+	ExitCode  int                  `json:"exitCode"`
+	Tombstone *tombstone.Tombstone `json:"tombstone"`
+	// Deliveries are final statuses of artifacts deliveries, made before the report
+	Deliveries []deliveryStatus `json:"deliveries,omitempty"`
+}
+
+// uploadReport uploads exit report and event traces to object storage.
+// Failures are logged only, the death is recorded anyway
+func uploadReport(d *deliverer, config *config, logger *logrus.Logger, ts *tombstoneWriter, exitCode int, eventTraces []event.Trace) {
+	ctx, cancel := d.within(config.UploadTimeout)
+	defer cancel()
+
+	ts.m.Lock()
+	report, err := json.Marshal(exitReport{
+		Name:       config.Name,
+		PodName:    config.PodName,
+		PodUID:     config.PodUID,
+		Namespace:  config.Namespace,
+		ExitCode:   exitCode,
+		Tombstone:  ts.Tombstone,
+		Deliveries: d.finished(),
+	})
+	ts.m.Unlock()
+	if err != nil {
+		logger.WithError(errors.WithStack(err)).WithField(errorCodeField, errorCodeUploadFailed).Error("failed to upload exit report")
+		return
+	}
+
+	// traces are streamed through a temporary file, as they may be large after long uptime
+	traces, err := ioutil.TempFile("", "kubexit-event-traces")
+	if err != nil {
+		logger.WithError(errors.WithStack(err)).WithField(errorCodeField, errorCodeUploadFailed).Error("failed to upload event traces")
+		return
+	}
+	defer os.Remove(traces.Name())
+	defer traces.Close()
+
+	err = event.FireAll(traces, eventTraces)
+	if err == nil {
+		_, err = traces.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		logger.WithError(errors.WithStack(err)).WithField(errorCodeField, errorCodeUploadFailed).Error("failed to upload event traces")
+		return
+	}
+
+	sink := uploadSink(config)
+	var locations []string
+	for _, object := range []struct {
+		name string
+		body io.ReadSeeker
+	}{
+		{name: "exit-report.json", body: bytes.NewReader(report)},
+		{name: "event-traces.json", body: traces},
+	} {
+		location, err2 := d.put(ctx, uploadTarget, sink, object.name, object.body)
+		if err2 != nil {
+			logger.WithError(err2).WithField(errorCodeField, errorCodeUploadFailed).Errorf("failed to upload %s", object.name)
+			continue
+		}
+		locations = append(locations, location)
+	}
+	if len(locations) > 0 {
+		logger.WithField("uploads", locations).Info("exit report uploaded")
+	}
+}
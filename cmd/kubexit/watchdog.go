@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/audit"
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+)
+
+// Actions on the child missing watchdog ping
+const (
+	// watchdogRestart kills the child and restarts it in place
+	watchdogRestart = "restart"
+	// watchdogShutdown stops the child gracefully, recording its death
+	watchdogShutdown = "shutdown"
+)
+
+// watchdogEnvName passes watchdog interval in microseconds to the child, as systemd does
+const watchdogEnvName = "WATCHDOG_USEC"
+
+// childWatchdog applies watchdog action to the child not pinging it within interval,
+// with sd_notify WATCHDOG=1 or via control socket
+type childWatchdog struct {
+	ctx         context.Context
+	child       *supervisor.Supervisor
+	restarter   *inPlaceRestarter
+	logger      *logrus.Logger
+	interval    time.Duration
+	action      string
+	gracePeriod time.Duration
+
+	m        sync.Mutex
+	timer    *time.Timer
+	deadline time.Time
+}
+
+func newChildWatchdog(ctx context.Context, config *config, child *supervisor.Supervisor, restarter *inPlaceRestarter, logger *logrus.Logger) *childWatchdog {
+	child.SetEnv(fmt.Sprintf("%s=%d", watchdogEnvName, config.Watchdog.Microseconds()))
+	return &childWatchdog{
+		ctx:         ctx,
+		child:       child,
+		restarter:   restarter,
+		logger:      logger,
+		interval:    config.Watchdog,
+		action:      config.WatchdogAction,
+		gracePeriod: config.GracePeriod,
+	}
+}
+
+// arm starts the watchdog timer for the started child. No-op for nil watchdog, i.e. disabled
+func (w *childWatchdog) arm() {
+	if w == nil {
+		return
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.reset()
+}
+
+// disarm stops the watchdog timer, e.g. when the child exited
+func (w *childWatchdog) disarm() {
+	if w == nil {
+		return
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.deadline = time.Time{}
+}
+
+// ping restarts the interval of armed watchdog
+func (w *childWatchdog) ping() {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.timer == nil {
+		return
+	}
+	w.reset()
+}
+
+// remaining returns time left to ping the watchdog, zero when not armed
+func (w *childWatchdog) remaining() time.Duration {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.deadline.IsZero() {
+		return 0
+	}
+	return time.Until(w.deadline)
+}
+
+// handleNotify is sdnotify.Handler of watchdog pings, WATCHDOG=trigger expires the watchdog right away
+func (w *childWatchdog) handleNotify(state map[string]string) {
+	switch state["WATCHDOG"] {
+	case "1":
+		w.ping()
+	case "trigger":
+		w.expire()
+	}
+}
+
+func (w *childWatchdog) reset() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.deadline = time.Now().Add(w.interval)
+	w.timer = time.AfterFunc(w.interval, crash.Func(w.ctx, "watchdog timer", w.expire))
+}
+
+func (w *childWatchdog) expire() {
+	w.m.Lock()
+	if w.timer == nil || w.child.Pid() == 0 || w.child.ShutdownRequested() {
+		w.m.Unlock()
+		return
+	}
+	w.timer = nil
+	w.deadline = time.Time{}
+	w.m.Unlock()
+
+	w.logger.WithField("interval", w.interval.String()).WithField("action", w.action).Warn("child missed watchdog ping")
+	event.ContextEventTrace(w.ctx).AddEvent(fmt.Sprintf("Watchdog expired, action: %s", w.action))
+
+	var err error
+	switch w.action {
+	case watchdogRestart:
+		err = w.restarter.request(restartReasonWatchdog)
+	case watchdogShutdown:
+		audit.ContextLog(w.ctx).Append(audit.Record{Event: audit.EventShutdown, Trigger: "watchdog"})
+		err = w.child.ShutdownWithTimeout(w.gracePeriod)
+	}
+	if err != nil {
+		w.logger.WithError(err).Error("failed to stop child on watchdog expiry")
+	}
+}
@@ -0,0 +1,257 @@
+//go:build !nokubernetes
+// +build !nokubernetes
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+)
+
+const (
+	// injectAnnotation enables injection into the pod
+	injectAnnotation = "kubexit.dev/inject"
+	// containersAnnotation lists containers to wrap, comma separated. All containers are wrapped by default
+	containersAnnotation = "kubexit.dev/containers"
+	// containerConfigAnnotationPrefix followed by container name holds KUBEXIT_CONFIG_JSON document for the container
+	containerConfigAnnotationPrefix = "kubexit.dev/config."
+)
+
+type webhookOptions struct {
+	image         string
+	installPath   string
+	graveyardPath string
+}
+
+// runWebhook serves mutating admission webhook injecting kubexit into annotated pods
+func runWebhook(args []string) int {
+	flags := flag.NewFlagSet("webhook", flag.ContinueOnError)
+	addr := flags.String("addr", ":8443", "address to serve https on")
+	certFile := flags.String("tls-cert", "/etc/kubexit-webhook/tls.crt", "tls certificate file")
+	keyFile := flags.String("tls-key", "/etc/kubexit-webhook/tls.key", "tls private key file")
+	options := webhookOptions{}
+	flags.StringVar(&options.image, "image", "kubexit:latest", "kubexit image for the injected init container")
+	flags.StringVar(&options.installPath, "install-path", "/kubexit", "path to install kubexit to in wrapped containers")
+	flags.StringVar(&options.graveyardPath, "graveyard", "/graveyard", "graveyard path in wrapped containers")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	logger := initLogger(&config{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mutate", func(w http.ResponseWriter, r *http.Request) {
+		review, err2 := handleAdmissionReview(r, options)
+		if err2 != nil {
+			logger.WithError(err2).Error("failed to handle admission review")
+			http.Error(w, err2.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(review)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := &http.Server{Addr: *addr, Handler: mux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	crash.Go(ctx, "webhook shutdown", func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	})
+
+	logger.WithField("addr", *addr).Info("kubexit webhook started")
+	err = server.ListenAndServeTLS(*certFile, *keyFile)
+	if err != nil && err != http.ErrServerClosed {
+		logger.WithError(errors.WithStack(err)).Error("kubexit webhook failed")
+		return 1
+	}
+	return 0
+}
+
+func handleAdmissionReview(r *http.Request, options webhookOptions) (*admissionv1.AdmissionReview, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to read request: %v", err))
+	}
+
+	review := &admissionv1.AdmissionReview{}
+	err = json.Unmarshal(body, review)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to unmarshal admission review: %v", err))
+	}
+	if review.Request == nil {
+		return nil, errors.New("admission review without request")
+	}
+
+	response := &admissionv1.AdmissionResponse{
+		UID:     review.Request.UID,
+		Allowed: true,
+	}
+	review.Response = response
+
+	pod := &corev1.Pod{}
+	err = json.Unmarshal(review.Request.Object.Raw, pod)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to unmarshal pod: %v", err))
+	}
+
+	patch, err := injectionPatch(pod, options)
+	if err != nil {
+		// do not block pod creation, but report the reason
+		response.Result = &metav1.Status{Message: fmt.Sprintf("kubexit is not injected: %v", err)}
+		return review, nil
+	}
+	if len(patch) == 0 {
+		return review, nil
+	}
+
+	response.Patch, err = json.Marshal(patch)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to marshal patch: %v", err))
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	response.PatchType = &patchType
+	return review, nil
+}
+
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// injectionPatch returns json patch adding kubexit volumes and init container to the pod,
+// and wrapping selected containers with kubexit configured by KUBEXIT_CONFIG_JSON
+func injectionPatch(pod *corev1.Pod, options webhookOptions) ([]jsonPatchOperation, error) {
+	if pod.Annotations[injectAnnotation] != "true" {
+		return nil, nil
+	}
+
+	wrapped := map[string]struct{}{}
+	if names := pod.Annotations[containersAnnotation]; names != "" {
+		for _, name := range strings.Split(names, ",") {
+			wrapped[strings.TrimSpace(name)] = struct{}{}
+		}
+	}
+
+	var patch []jsonPatchOperation
+
+	for i, container := range pod.Spec.Containers {
+		if _, ok := wrapped[container.Name]; len(wrapped) > 0 && !ok {
+			continue
+		}
+		if len(container.Command) == 0 {
+			return nil, errors.Errorf("container %s has no command, image entrypoint can't be wrapped", container.Name)
+		}
+
+		document, err := renderContainerConfig(pod, container, options)
+		if err != nil {
+			return nil, err
+		}
+
+		containerPath := fmt.Sprintf("/spec/containers/%d", i)
+		patch = append(patch,
+			jsonPatchOperation{Op: "replace", Path: containerPath + "/command", Value: []string{options.installPath + "/kubexit"}},
+			jsonPatchOperation{Op: "remove", Path: containerPath + "/args"},
+		)
+		if len(container.Args) == 0 {
+			// remove fails on a missing path
+			patch = patch[:len(patch)-1]
+		}
+
+		patch = append(patch, appendPatch(containerPath+"/env", len(container.Env) == 0,
+			corev1.EnvVar{Name: configJSONEnv, Value: document},
+			corev1.EnvVar{Name: "KUBEXIT_IMAGE", Value: container.Image},
+			corev1.EnvVar{Name: "KUBEXIT_POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+			corev1.EnvVar{Name: "KUBEXIT_NAMESPACE", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}}},
+		)...)
+
+		patch = append(patch, appendPatch(containerPath+"/volumeMounts", len(container.VolumeMounts) == 0,
+			corev1.VolumeMount{Name: "kubexit", MountPath: options.installPath},
+			corev1.VolumeMount{Name: "graveyard", MountPath: options.graveyardPath},
+		)...)
+	}
+
+	if len(patch) == 0 {
+		return nil, nil
+	}
+
+	patch = append(patch, appendPatch("/spec/volumes", len(pod.Spec.Volumes) == 0,
+		corev1.Volume{Name: "kubexit", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		corev1.Volume{Name: "graveyard", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory}}},
+	)...)
+
+	patch = append(patch, appendPatch("/spec/initContainers", len(pod.Spec.InitContainers) == 0,
+		corev1.Container{
+			Name:         "kubexit-install",
+			Image:        options.image,
+			Command:      []string{"/app/bin/kubexit", "install", "--dest", options.installPath},
+			VolumeMounts: []corev1.VolumeMount{{Name: "kubexit", MountPath: options.installPath}},
+		},
+	)...)
+
+	return patch, nil
+}
+
+// renderContainerConfig merges container config annotation with values known from the pod spec
+func renderContainerConfig(pod *corev1.Pod, container corev1.Container, options webhookOptions) (string, error) {
+	document := map[string]interface{}{}
+	if raw, ok := pod.Annotations[containerConfigAnnotationPrefix+container.Name]; ok {
+		err := json.Unmarshal([]byte(raw), &document)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to parse config annotation of container %s", container.Name)
+		}
+	}
+
+	document["name"] = container.Name
+	if _, ok := document["graveyard"]; !ok {
+		document["graveyard"] = options.graveyardPath
+	}
+	document[commandKey] = append(append([]string{}, container.Command...), container.Args...)
+
+	rendered, err := json.Marshal(document)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	// validate the same way kubexit parses it
+	_, err = flattenConfigDocument(rendered)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid config of container %s", container.Name)
+	}
+
+	return string(rendered), nil
+}
+
+// appendPatch appends items to array at path, creating it when missing
+func appendPatch(path string, missing bool, items ...interface{}) []jsonPatchOperation {
+	if missing {
+		return []jsonPatchOperation{{Op: "add", Path: path, Value: items}}
+	}
+
+	patch := make([]jsonPatchOperation, 0, len(items))
+	for _, item := range items {
+		patch = append(patch, jsonPatchOperation{Op: "add", Path: path + "/-", Value: item})
+	}
+	return patch
+}
@@ -0,0 +1,85 @@
+// Package artifacts collects post-mortem files of the child, e.g. logs, heap dumps and core files, and delivers them to sinks.
+package artifacts
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Collect writes gzipped tar of files matching globs to w. Matched directories are added recursively.
+// Returns number of files added, files which disappeared meanwhile are skipped
+func Collect(w io.Writer, globs []string) (int, error) {
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	added := map[string]struct{}{}
+	for _, glob := range globs {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return 0, errors.Wrapf(err, "invalid artifacts glob %s", glob)
+		}
+		for _, match := range matches {
+			err = filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					if os.IsNotExist(err) {
+						return nil
+					}
+					return err
+				}
+				if !info.Mode().IsRegular() {
+					return nil
+				}
+				if _, ok := added[path]; ok {
+					return nil
+				}
+				added[path] = struct{}{}
+				return addFile(archive, path, info)
+			})
+			if err != nil {
+				return 0, errors.Wrapf(err, "failed to collect %s", match)
+			}
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	err = gz.Close()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return len(added), nil
+}
+
+func addFile(archive *tar.Writer, path string, info os.FileInfo) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	// keep the directory structure, archive paths are relative
+	header.Name = strings.TrimPrefix(filepath.ToSlash(path), "/")
+
+	err = archive.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+	// file may grow meanwhile, copy the size written to the header only
+	_, err = io.CopyN(archive, file, header.Size)
+	return err
+}
@@ -0,0 +1,99 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Credentials are S3 compatible storage access keys
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// CredentialsFromEnv returns credentials from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY env vars,
+// or assumes AWS_ROLE_ARN with AWS_WEB_IDENTITY_TOKEN_FILE token, as configured by EKS IAM roles for service accounts (IRSA)
+func CredentialsFromEnv(ctx context.Context) (Credentials, error) {
+	if accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID"); accessKeyID != "" {
+		return Credentials{
+			AccessKeyID:     accessKeyID,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	roleARN := os.Getenv("AWS_ROLE_ARN")
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if roleARN == "" || tokenFile == "" {
+		return Credentials{}, errors.New("no credentials: neither AWS_ACCESS_KEY_ID nor AWS_ROLE_ARN with AWS_WEB_IDENTITY_TOKEN_FILE are set")
+	}
+	return assumeRoleWithWebIdentity(ctx, roleARN, tokenFile)
+}
+
+type assumeRoleWithWebIdentityResponse struct {
+	Credentials struct {
+		AccessKeyID     string `xml:"AccessKeyId"`
+		SecretAccessKey string `xml:"SecretAccessKey"`
+		SessionToken    string `xml:"SessionToken"`
+	} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+}
+
+func assumeRoleWithWebIdentity(ctx context.Context, roleARN, tokenFile string) (Credentials, error) {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return Credentials{}, errors.WithStack(fmt.Errorf("failed to read web identity token: %v", err))
+	}
+
+	endpoint := "https://sts.amazonaws.com"
+	if region := os.Getenv("AWS_REGION"); region != "" && os.Getenv("AWS_STS_REGIONAL_ENDPOINTS") == "regional" {
+		endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+	}
+
+	query := url.Values{}
+	query.Set("Action", "AssumeRoleWithWebIdentity")
+	query.Set("Version", "2011-06-15")
+	query.Set("RoleArn", roleARN)
+	query.Set("RoleSessionName", "kubexit")
+	query.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+
+	// the request is authenticated by the token itself, no signing needed
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(query.Encode()))
+	if err != nil {
+		return Credentials{}, errors.WithStack(err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return Credentials{}, errors.WithStack(fmt.Errorf("failed to assume role %s: %v", roleARN, err))
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return Credentials{}, errors.WithStack(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return Credentials{}, errors.Errorf("failed to assume role %s: %s: %s", roleARN, response.Status, body)
+	}
+
+	var result assumeRoleWithWebIdentityResponse
+	err = xml.Unmarshal(body, &result)
+	if err != nil {
+		return Credentials{}, errors.Wrap(err, "failed to parse assume role response")
+	}
+	return Credentials{
+		AccessKeyID:     result.Credentials.AccessKeyID,
+		SecretAccessKey: result.Credentials.SecretAccessKey,
+		SessionToken:    result.Credentials.SessionToken,
+	}, nil
+}
@@ -0,0 +1,173 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// S3Sink uploads artifacts to S3 compatible object storage, e.g. AWS S3, GCS with HMAC keys or MinIO,
+// using path style urls: <Endpoint>/<Bucket>/<Prefix>/<name>
+type S3Sink struct {
+	Endpoint string
+	Region   string
+	Bucket   string
+	Prefix   string
+	// Credentials are resolved on each upload, so short-lived ones are not cached
+	Credentials func(ctx context.Context) (Credentials, error)
+}
+
+// Put makes a single request, failures not worth retrying are retry.Permanent
+func (s S3Sink) Put(ctx context.Context, name string, body io.Reader) (string, error) {
+	credentials, err := s.Credentials(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	key := path.Join(s.Prefix, name)
+	objectURL := strings.TrimRight(s.Endpoint, "/") + "/" + s.Bucket + "/" + key
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL, body)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	// object storage requires content length, file bodies are not measured by http package
+	request.ContentLength, err = contentLength(body)
+	if err != nil {
+		return "", err
+	}
+	if request.ContentLength > 0 && request.GetBody == nil {
+		request.Body = ioutil.NopCloser(body)
+	}
+
+	signV4(request, credentials, s.Region, time.Now())
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", errors.WithStack(fmt.Errorf("failed to upload %s: %v", key, err))
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+		return "", retryableStatus(errors.Errorf("failed to upload %s: unexpected status %s: %s", key, response.Status, message), response.StatusCode)
+	}
+	return fmt.Sprintf("s3://%s/%s", s.Bucket, key), nil
+}
+
+func contentLength(body io.Reader) (int64, error) {
+	switch b := body.(type) {
+	case *bytes.Reader:
+		return int64(b.Len()), nil
+	case *bytes.Buffer:
+		return int64(b.Len()), nil
+	case *strings.Reader:
+		return int64(b.Len()), nil
+	case *os.File:
+		info, err := b.Stat()
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		offset, err := b.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		return info.Size() - offset, nil
+	default:
+		return 0, errors.Errorf("unsupported body type %T, size is unknown", body)
+	}
+}
+
+// signV4 signs request with AWS Signature Version 4 for s3 service. Payload is not signed, it is protected by tls
+func signV4(request *http.Request, credentials Credentials, region string, now time.Time) {
+	const payloadHash = "UNSIGNED-PAYLOAD"
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if credentials.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if credentials.SessionToken != "" {
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+	}
+	var canonicalHeaders strings.Builder
+	for _, header := range signedHeaders {
+		value := request.Header.Get(header)
+		if header == "host" {
+			value = request.URL.Host
+		}
+		canonicalHeaders.WriteString(header + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		canonicalURI(request.URL),
+		request.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.AccessKeyID, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+// canonicalURI encodes each path segment per RFC 3986, as S3 expects
+func canonicalURI(u *url.URL) string {
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			unescaped = segment
+		}
+		segments[i] = strings.ReplaceAll(url.QueryEscape(unescaped), "+", "%20")
+	}
+	uri := strings.Join(segments, "/")
+	if uri == "" {
+		return "/"
+	}
+	return uri
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
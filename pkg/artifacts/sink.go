@@ -0,0 +1,92 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/retry"
+)
+
+// Sink delivers an artifact, returns its location
+type Sink interface {
+	Put(ctx context.Context, name string, body io.Reader) (string, error)
+}
+
+// DirSink writes artifacts to a directory, e.g. on a durable volume
+type DirSink struct {
+	Dir string
+}
+
+func (s DirSink) Put(_ context.Context, name string, body io.Reader) (string, error) {
+	err := os.MkdirAll(s.Dir, 0o755)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	target := filepath.Join(s.Dir, name)
+	// write to temp file first, so a partial artifact is never seen under the name
+	temp, err := ioutil.TempFile(s.Dir, "."+name+".*")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = io.Copy(temp, body)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", errors.WithStack(fmt.Errorf("failed to write artifact %s: %v", target, err))
+	}
+
+	err = os.Rename(temp.Name(), target)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return target, nil
+}
+
+// WebhookSink uploads artifacts with POST request, artifact name is passed in X-Kubexit-Artifact header
+type WebhookSink struct {
+	URL string
+}
+
+// Put makes a single request, failures not worth retrying are retry.Permanent
+func (s WebhookSink) Put(ctx context.Context, name string, body io.Reader) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, body)
+	if err != nil {
+		return "", retry.Permanent(errors.WithStack(err))
+	}
+	request.Header.Set("Content-Type", "application/gzip")
+	request.Header.Set("X-Kubexit-Artifact", name)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", errors.WithStack(fmt.Errorf("failed to upload artifact %s: %v", name, err))
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return "", retryableStatus(errors.Errorf("failed to upload artifact %s: unexpected status %s", name, response.Status), response.StatusCode)
+	}
+	// uploaded artifact may be found by its name in the webhook storage
+	if location := response.Header.Get("Location"); location != "" {
+		return location, nil
+	}
+	return s.URL + "#" + name, nil
+}
+
+// retryableStatus marks err of the response status as retry.Permanent, unless it's a server error or throttling
+func retryableStatus(err error, status int) error {
+	if status < 500 && status != http.StatusTooManyRequests && status != http.StatusRequestTimeout {
+		return retry.Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/timestamp"
+)
+
+const (
+	EventSignalReceived = "signal_received"
+	EventSignalSent     = "signal_sent"
+	EventShutdown       = "shutdown"
+	EventState          = "state"
+)
+
+// Record is a single line of audit log
+type Record struct {
+	Time      time.Time `json:"time"`
+	Container string    `json:"container"`
+	Event     string    `json:"event"`
+	Signal    string    `json:"signal,omitempty"`
+	// Forwarded is set for received signals, false when the signal is not propagated to the child
+	Forwarded *bool  `json:"forwarded,omitempty"`
+	Trigger   string `json:"trigger,omitempty"`
+	State     string `json:"state,omitempty"`
+	ExitCode  *int   `json:"exitCode,omitempty"`
+}
+
+// MarshalJSON writes Time in timestamp.Default format
+func (r Record) MarshalJSON() ([]byte, error) {
+	type plainRecord Record
+	return json.Marshal(struct {
+		plainRecord
+		Time interface{} `json:"time"`
+	}{
+		plainRecord: plainRecord(r),
+		Time:        timestamp.Default().Value(r.Time),
+	})
+}
+
+// Log appends records to audit log. Time and Container are filled by Log
+type Log interface {
+	Append(record Record)
+}
+
+type auditLogKey struct{}
+
+func WithLog(ctx context.Context, log Log) context.Context {
+	return context.WithValue(ctx, auditLogKey{}, log)
+}
+
+func ContextLog(ctx context.Context) Log {
+	log, ok := ctx.Value(auditLogKey{}).(Log)
+	if !ok {
+		return noopLog{}
+	}
+	return log
+}
+
+type noopLog struct{}
+
+func (noopLog) Append(Record) {}
+
+// FileLog appends records as json lines to <graveyard>/<name>.audit.jsonl next to the tombstone,
+// so timelines of all containers can be reconstructed from the shared volume
+type FileLog struct {
+	ctx       context.Context
+	path      string
+	container string
+	m         sync.Mutex
+}
+
+// NewFileLog returns audit log of container name in graveyard. Write failures are added to ctx event trace
+func NewFileLog(ctx context.Context, graveyard, name string) *FileLog {
+	return &FileLog{
+		ctx:       ctx,
+		path:      filepath.Join(graveyard, name+".audit.jsonl"),
+		container: name,
+	}
+}
+
+func (l *FileLog) Path() string {
+	return l.path
+}
+
+func (l *FileLog) Append(record Record) {
+	record.Time = time.Now()
+	record.Container = l.container
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		event.ContextEventTrace(l.ctx).AddEvent(fmt.Sprintf("Audit log: failed to marshal record: %v", err))
+		return
+	}
+
+	err = l.write(append(line, '\n'))
+	if err != nil {
+		event.ContextEventTrace(l.ctx).AddEvent(fmt.Sprintf("Audit log: %v", err))
+	}
+}
+
+func (l *FileLog) write(line []byte) error {
+	// one write at a time, so lines are not interleaved
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	err := os.MkdirAll(filepath.Dir(l.path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	// reopened on each write, so the log survives graveyard cleanup
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(line)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	return nil
+}
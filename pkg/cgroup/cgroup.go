@@ -0,0 +1,10 @@
+// Package cgroup reads resource limits of the container from its cgroup.
+package cgroup
+
+// Limits of the container cgroup
+type Limits struct {
+	// CPU is the CPU quota in cores, zero if unlimited
+	CPU float64
+	// Memory is the memory limit in bytes, zero if unlimited
+	Memory uint64
+}
@@ -0,0 +1,112 @@
+//go:build linux
+// +build linux
+
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// Read returns limits of the cgroup of current process, from cgroup v2 unified hierarchy or cgroup v1 controllers
+func Read() (*Limits, error) {
+	if _, err := os.Stat(cgroupRoot + "/cgroup.controllers"); err == nil {
+		return readV2()
+	}
+	return readV1()
+}
+
+func readV2() (*Limits, error) {
+	limits := &Limits{}
+
+	// cpu.max is "<quota> <period>" or "max <period>"
+	cpuMax, err := readFields(cgroupRoot + "/cpu.max")
+	if err != nil {
+		return nil, err
+	}
+	if len(cpuMax) == 2 && cpuMax[0] != "max" {
+		limits.CPU, err = quota(cpuMax[0], cpuMax[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	memoryMax, err := readFields(cgroupRoot + "/memory.max")
+	if err != nil {
+		return nil, err
+	}
+	if len(memoryMax) == 1 && memoryMax[0] != "max" {
+		limits.Memory, err = strconv.ParseUint(memoryMax[0], 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse memory.max")
+		}
+	}
+	return limits, nil
+}
+
+func readV1() (*Limits, error) {
+	limits := &Limits{}
+
+	quotaFields, err := readFields(cgroupRoot + "/cpu/cpu.cfs_quota_us")
+	if err != nil {
+		return nil, err
+	}
+	periodFields, err := readFields(cgroupRoot + "/cpu/cpu.cfs_period_us")
+	if err != nil {
+		return nil, err
+	}
+	// quota is -1 when unlimited
+	if len(quotaFields) == 1 && len(periodFields) == 1 && quotaFields[0] != "-1" {
+		limits.CPU, err = quota(quotaFields[0], periodFields[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	memoryFields, err := readFields(cgroupRoot + "/memory/memory.limit_in_bytes")
+	if err != nil {
+		return nil, err
+	}
+	if len(memoryFields) == 1 {
+		memory, err := strconv.ParseUint(memoryFields[0], 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse memory.limit_in_bytes")
+		}
+		// unlimited is reported as a huge page aligned number close to max int64
+		if memory < math.MaxInt64/2 {
+			limits.Memory = memory
+		}
+	}
+	return limits, nil
+}
+
+func quota(quotaValue, periodValue string) (float64, error) {
+	quota, err := strconv.ParseFloat(quotaValue, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse cpu quota")
+	}
+	period, err := strconv.ParseFloat(periodValue, 64)
+	if err != nil || period <= 0 {
+		return 0, errors.Errorf("invalid cpu period %s", periodValue)
+	}
+	return quota / period, nil
+}
+
+// readFields returns whitespace separated fields of the file, nil if it doesn't exist, e.g. the controller is not mounted
+func readFields(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to read cgroup limit: %v", err))
+	}
+	return strings.Fields(string(content)), nil
+}
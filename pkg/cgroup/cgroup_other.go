@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package cgroup
+
+import "github.com/pkg/errors"
+
+// Read returns limits of the cgroup of current process
+func Read() (*Limits, error) {
+	return nil, errors.New("cgroup limits are supported on linux only")
+}
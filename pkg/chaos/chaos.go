@@ -0,0 +1,110 @@
+// Package chaos injects artificial failures into coordination, so platform teams can rehearse failure scenarios in staging.
+// Nothing is injected unless an Injector is set in the context
+package chaos
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Injector of failures, zero value injects nothing
+type Injector struct {
+	// TombstoneWriteDelay delays each tombstone write
+	TombstoneWriteDelay time.Duration
+	// DropEvents is the probability of dropping each fsnotify event of the graveyard
+	DropEvents float64
+	// WatchDisconnect stops graveyard and pod watchers after the duration, as if they were disconnected
+	WatchDisconnect time.Duration
+	// ShutdownDelay delays SIGTERM of graceful shutdown to the child, as if it were slow to stop
+	ShutdownDelay time.Duration
+
+	m    sync.Mutex
+	rand *rand.Rand
+}
+
+// Parse parses comma separated key=value spec, e.g. tombstone_write_delay=2s,drop_events=0.5,watch_disconnect=1m,shutdown_delay=10s
+func Parse(spec string) (*Injector, error) {
+	injector := &Injector{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for _, pair := range strings.Split(spec, ",") {
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, errors.Errorf("invalid chaos %s, expected key=value", pair)
+		}
+		key, value := strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+
+		var err error
+		switch key {
+		case "tombstone_write_delay":
+			injector.TombstoneWriteDelay, err = time.ParseDuration(value)
+		case "drop_events":
+			injector.DropEvents, err = strconv.ParseFloat(value, 64)
+			if err == nil && (injector.DropEvents < 0 || injector.DropEvents > 1) {
+				err = errors.New("expected probability from 0 to 1")
+			}
+		case "watch_disconnect":
+			injector.WatchDisconnect, err = time.ParseDuration(value)
+		case "shutdown_delay":
+			injector.ShutdownDelay, err = time.ParseDuration(value)
+		default:
+			return nil, errors.Errorf("unknown chaos %s, expected one of: tombstone_write_delay, drop_events, watch_disconnect, shutdown_delay", key)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid chaos %s", key)
+		}
+	}
+	return injector, nil
+}
+
+// DelayWrite blocks for TombstoneWriteDelay or until ctx is done
+func (i *Injector) DelayWrite(ctx context.Context) {
+	if i.TombstoneWriteDelay <= 0 {
+		return
+	}
+	timer := time.NewTimer(i.TombstoneWriteDelay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// DropEvent reports whether to drop the next event
+func (i *Injector) DropEvent() bool {
+	if i.DropEvents <= 0 {
+		return false
+	}
+
+	i.m.Lock()
+	defer i.m.Unlock()
+	return i.rand.Float64() < i.DropEvents
+}
+
+// Disconnect returns a channel receiving when the watcher started now is to be disconnected, nil channel blocking forever when disabled
+func (i *Injector) Disconnect() <-chan time.Time {
+	if i.WatchDisconnect <= 0 {
+		return nil
+	}
+	return time.After(i.WatchDisconnect)
+}
+
+type injectorKey struct{}
+
+func WithInjector(ctx context.Context, i *Injector) context.Context {
+	return context.WithValue(ctx, injectorKey{}, i)
+}
+
+// ContextInjector returns injector of ctx, or the one injecting nothing
+func ContextInjector(ctx context.Context) *Injector {
+	i, ok := ctx.Value(injectorKey{}).(*Injector)
+	if !ok {
+		return &Injector{}
+	}
+	return i
+}
@@ -0,0 +1,48 @@
+// Package crash recovers panics of kubexit goroutines and passes them to a handler set in the context,
+// so a bug in a watcher or timer crashes kubexit loudly rather than leaving the child silently unsupervised
+package crash
+
+import (
+	"context"
+	"runtime/debug"
+)
+
+// Handler handles panic value recovered in the goroutine with the name, with the goroutine stack.
+// The handler is expected to exit the process, the panicking goroutine is stopped anyway
+type Handler func(name string, value interface{}, stack []byte)
+
+type handlerKey struct{}
+
+func WithHandler(ctx context.Context, h Handler) context.Context {
+	return context.WithValue(ctx, handlerKey{}, h)
+}
+
+// Recover must be deferred directly by the goroutine. It passes the panic to the handler set in ctx,
+// and re-panics without a handler, keeping the default crash
+func Recover(ctx context.Context, name string) {
+	value := recover()
+	if value == nil {
+		return
+	}
+	h, ok := ctx.Value(handlerKey{}).(Handler)
+	if !ok {
+		panic(value)
+	}
+	h(name, value, debug.Stack())
+}
+
+// Go runs f in a new goroutine recovering its panic
+func Go(ctx context.Context, name string, f func()) {
+	go func() {
+		defer Recover(ctx, name)
+		f()
+	}()
+}
+
+// Func wraps f recovering its panic, e.g. for time.AfterFunc
+func Func(ctx context.Context, name string, f func()) func() {
+	return func() {
+		defer Recover(ctx, name)
+		f()
+	}
+}
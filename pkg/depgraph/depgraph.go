@@ -0,0 +1,197 @@
+// Package depgraph models birth and death dependencies of kubexit participants of a pod,
+// rendering them as Graphviz dot or json for review of startup and shutdown ordering
+package depgraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/readiness"
+)
+
+// Kinds of edges
+const (
+	// KindBirth is a birth dependency, the participant starts after it is ready
+	KindBirth = "birth"
+	// KindAbsent is an absence birth dependency, the participant starts after it is dead or absent
+	KindAbsent = "absent"
+	// KindDeath is a death dependency, the participant stops when it dies
+	KindDeath = "death"
+)
+
+// Node is a participant with its deps as configured, birth deps in spec format
+type Node struct {
+	Name      string   `json:"name"`
+	BirthDeps []string `json:"birth_deps,omitempty"`
+	DeathDeps []string `json:"death_deps,omitempty"`
+}
+
+// Edge points from the participant to its dependency. To is a participant name,
+// or birth dep spec for deps other than containers, e.g. tcp://localhost:5432
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// Graph of participants
+type Graph struct {
+	nodes map[string]Node
+}
+
+func New() *Graph {
+	return &Graph{nodes: map[string]Node{}}
+}
+
+// Add adds the participant, replacing the one added with the same name
+func (g *Graph) Add(node Node) {
+	g.nodes[node.Name] = node
+}
+
+// Nodes returns participants sorted by name
+func (g *Graph) Nodes() []Node {
+	nodes := make([]Node, 0, len(g.nodes))
+	for _, node := range g.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes
+}
+
+// Edges returns dependencies of all participants, sorted. Invalid birth dep specs are edges to the spec itself
+func (g *Graph) Edges() []Edge {
+	var edges []Edge
+	for _, node := range g.Nodes() {
+		for _, spec := range node.BirthDeps {
+			edge := Edge{From: node.Name, To: spec, Kind: KindBirth}
+			dep, err := readiness.ParseDep(spec)
+			if err == nil {
+				switch dep.Kind {
+				case readiness.KindContainer:
+					edge.To = dep.Target
+				case readiness.KindAbsent:
+					edge.To = dep.Target
+					edge.Kind = KindAbsent
+				}
+			}
+			edges = append(edges, edge)
+		}
+		for _, name := range node.DeathDeps {
+			edges = append(edges, Edge{From: node.Name, To: name, Kind: KindDeath})
+		}
+	}
+	sort.SliceStable(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+	return edges
+}
+
+// WriteJSON writes nodes and edges as json
+func (g *Graph) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(struct {
+		Nodes []Node `json:"nodes"`
+		Edges []Edge `json:"edges"`
+	}{
+		Nodes: g.Nodes(),
+		Edges: g.Edges(),
+	})
+	return errors.WithStack(err)
+}
+
+// edgeStyles are dot attributes of edge kinds
+var edgeStyles = map[string]string{
+	KindBirth:  `label="birth"`,
+	KindAbsent: `label="absent", style=dashed`,
+	KindDeath:  `label="death", color=red`,
+}
+
+// WriteDOT writes the graph in Graphviz dot format. Dependencies other than participants are drawn as ellipses
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph kubexit {\n")
+	b.WriteString("  node [shape=box];\n")
+	for _, node := range g.Nodes() {
+		fmt.Fprintf(&b, "  %q;\n", node.Name)
+	}
+	external := map[string]struct{}{}
+	for _, edge := range g.Edges() {
+		_, participant := g.nodes[edge.To]
+		_, declared := external[edge.To]
+		if !participant && !declared {
+			external[edge.To] = struct{}{}
+			fmt.Fprintf(&b, "  %q [shape=ellipse];\n", edge.To)
+		}
+		fmt.Fprintf(&b, "  %q -> %q [%s];\n", edge.From, edge.To, edgeStyles[edge.Kind])
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return errors.WithStack(err)
+}
+
+// Cycle returns the first cycle of edges of the kinds as the path of participants, starting and ending with the same one,
+// e.g. [app proxy app]. Nil if there is no cycle
+func (g *Graph) Cycle(kinds ...string) []string {
+	include := map[string]bool{}
+	for _, kind := range kinds {
+		include[kind] = true
+	}
+	next := map[string][]string{}
+	for _, edge := range g.Edges() {
+		if include[edge.Kind] {
+			next[edge.From] = append(next[edge.From], edge.To)
+		}
+	}
+
+	const (
+		unvisited = iota
+		inPath
+		done
+	)
+	state := map[string]int{}
+	var path []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = inPath
+		path = append(path, name)
+		for _, to := range next[name] {
+			switch state[to] {
+			case inPath:
+				for i, n := range path {
+					if n == to {
+						return append(append([]string{}, path[i:]...), to)
+					}
+				}
+			case unvisited:
+				if cycle := visit(to); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		return nil
+	}
+
+	for _, node := range g.Nodes() {
+		if state[node.Name] == unvisited {
+			if cycle := visit(node.Name); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package drain
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Check returns count of work remaining in the child
+type Check func(ctx context.Context) (int, error)
+
+// ParseCheck parses check spec:
+//   - `http://host:port/path` or `https://...` - GET responds with 2xx status and the count in body
+//   - `exec:command arg...` - command exits with zero code and prints the count
+func ParseCheck(spec string) (Check, error) {
+	switch {
+	case strings.HasPrefix(spec, "exec:"):
+		argv := strings.Fields(strings.TrimPrefix(spec, "exec:"))
+		if len(argv) == 0 {
+			return nil, errors.Errorf("empty command in drain check %s", spec)
+		}
+		return ExecCheck(argv), nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return HTTPCheck(spec), nil
+	default:
+		return nil, errors.Errorf("unsupported drain check %s, expected exec: or http(s):// check", spec)
+	}
+}
+
+func HTTPCheck(url string) Check {
+	return func(ctx context.Context) (int, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode < 200 || response.StatusCode >= 300 {
+			return 0, errors.Errorf("unexpected status %s", response.Status)
+		}
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		return parseCount(body)
+	}
+}
+
+func ExecCheck(argv []string) Check {
+	return func(ctx context.Context) (int, error) {
+		// #nosec G204 command is configured by kubexit user
+		cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+		output, err := cmd.Output()
+		if err != nil {
+			return 0, errors.WithStack(fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output))))
+		}
+		return parseCount(output)
+	}
+}
+
+func parseCount(output []byte) (int, error) {
+	count, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse remaining work count")
+	}
+	return count, nil
+}
@@ -0,0 +1,75 @@
+// Package drain extends child shutdown grace period while the child makes progress draining its work.
+package drain
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/metrics"
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+)
+
+type Options struct {
+	// Interval of checks after grace period elapsed, also limits each check duration
+	Interval time.Duration
+	// StallTimeout is how long the remaining work count may not decrease before the child is killed
+	StallTimeout time.Duration
+	// MaxGrace is the hard cap of shutdown duration
+	MaxGrace time.Duration
+}
+
+// NewExtender returns supervisor.GraceExtender, which extends grace period by Interval while the remaining work count decreases.
+// The child is killed, when work is drained, the count stalls for StallTimeout or MaxGrace is reached.
+// Check errors count as no progress.
+func NewExtender(ctx context.Context, check Check, options Options) supervisor.GraceExtender {
+	recorder := metrics.ContextRecorder(ctx)
+
+	lastCount := -1
+	var lastProgress time.Time
+
+	return func(elapsed time.Duration) time.Duration {
+		remaining := options.MaxGrace - elapsed
+		if remaining <= 0 {
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Drain max grace %s reached", options.MaxGrace))
+			return 0
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, options.Interval)
+		count, err := check(checkCtx)
+		cancel()
+
+		now := time.Now()
+		if lastProgress.IsZero() {
+			// grace period is the progress made before the first check
+			lastProgress = now
+		}
+
+		switch {
+		case err != nil:
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Drain check failed: %v", err))
+		case count <= 0:
+			recorder.SetGauge("kubexit_drain_remaining", "Work remaining in the child during shutdown, by drain check", 0)
+			event.ContextEventTrace(ctx).AddEvent("Drained")
+			return 0
+		default:
+			recorder.SetGauge("kubexit_drain_remaining", "Work remaining in the child during shutdown, by drain check", float64(count))
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Drain remaining: %d", count))
+			if lastCount < 0 || count < lastCount {
+				lastProgress = now
+			}
+			lastCount = count
+		}
+
+		if now.Sub(lastProgress) >= options.StallTimeout {
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Drain stalled for %s", options.StallTimeout))
+			return 0
+		}
+
+		if options.Interval < remaining {
+			return options.Interval
+		}
+		return remaining
+	}
+}
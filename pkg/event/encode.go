@@ -0,0 +1,122 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// encoderFlushSize is the buffered size flushed to the writer while encoding
+const encoderFlushSize = 32 << 10
+
+var encoderBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, encoderFlushSize*2)
+		return &buf
+	},
+}
+
+// encoder appends JSON to a pooled buffer, flushing it to the writer when it's full
+type encoder struct {
+	w   io.Writer
+	buf []byte
+	err error
+	// pooled holds buf between uses
+	pooled *[]byte
+}
+
+func newEncoder(w io.Writer) *encoder {
+	pooled := encoderBuffers.Get().(*[]byte)
+	return &encoder{
+		w:      w,
+		buf:    (*pooled)[:0],
+		pooled: pooled,
+	}
+}
+
+// release returns the buffer to the pool
+func (enc *encoder) release() {
+	*enc.pooled = enc.buf[:0]
+	encoderBuffers.Put(enc.pooled)
+}
+
+// flushFull flushes the buffer, if it's full
+func (enc *encoder) flushFull() {
+	if len(enc.buf) >= encoderFlushSize {
+		_ = enc.flush()
+	}
+}
+
+func (enc *encoder) flush() error {
+	if enc.err == nil && len(enc.buf) > 0 {
+		_, enc.err = enc.w.Write(enc.buf)
+	}
+	enc.buf = enc.buf[:0]
+	return errors.WithStack(enc.err)
+}
+
+// appendJSONFields appends fields of v marshaled to JSON object, without braces
+func (enc *encoder) appendJSONFields(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	data = bytes.TrimSpace(data)
+	enc.buf = append(enc.buf, data[1:len(data)-1]...)
+	return nil
+}
+
+const hex = "0123456789abcdef"
+
+// appendJSONString appends s as JSON string, escaped the same way as by encoding/json
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 break JavaScript parsers
+		if c == '\u2028' || c == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hex[c&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
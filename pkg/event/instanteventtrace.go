@@ -5,9 +5,15 @@ import (
 )
 
 func NewInstantTrace(id string, logger *logrus.Entry) Trace {
+	return NewInstantTraceAt(id, logger, logrus.TraceLevel)
+}
+
+// NewInstantTraceAt returns trace logging events instantly with the level
+func NewInstantTraceAt(id string, logger *logrus.Entry, level logrus.Level) Trace {
 	return &instantEventTrace{
 		trace:  &trace{id: id},
 		logger: logger,
+		level:  level,
 	}
 }
 
@@ -15,11 +21,12 @@ type instantEventTrace struct {
 	*trace
 
 	logger *logrus.Entry
+	level  logrus.Level
 }
 
 func (trace *instantEventTrace) AddEvent(message string) {
 	trace.m.Lock()
 	defer trace.m.Unlock()
 	trace.events = append(trace.events, newEvent(message))
-	trace.logger.WithField("event-trace-id", trace.id).WithField("event", message).Trace()
+	trace.logger.WithField("event-trace-id", trace.id).WithField("event", message).Log(trace.level)
 }
@@ -1,6 +1,9 @@
 package event
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"io"
+)
 
 type noopTrace struct{}
 
@@ -13,7 +16,16 @@ func (n noopTrace) AddEvent(string) {
 	//	Do nothing
 }
 
+func (n noopTrace) SetSummary(Summary) {
+	//	Do nothing
+}
+
 func (n noopTrace) Fire() (json.RawMessage, error) {
 	//	Do nothing
 	return nil, nil
 }
+
+func (n noopTrace) FireTo(w io.Writer) error {
+	_, err := io.WriteString(w, "null")
+	return err
+}
@@ -0,0 +1,135 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/timestamp"
+)
+
+// Persisted holds event traces of previous kubexit incarnations of the container, loaded by LoadPersisted
+type Persisted struct {
+	// Incarnation is the number of previous incarnations
+	Incarnation int
+	traces      map[string][]event
+	// restored are ids of traces passed to Restore
+	restored map[string]bool
+}
+
+type persistedFile struct {
+	Incarnation int `json:"incarnation"`
+	Traces      []struct {
+		ID     string `json:"id"`
+		Events []struct {
+			Timestamp json.RawMessage `json:"timestamp"`
+			Message   string          `json:"message"`
+		} `json:"events"`
+	} `json:"traces"`
+}
+
+// LoadPersisted reads event traces written by Persist. Missing file means the first incarnation
+func LoadPersisted(path string) (*Persisted, error) {
+	p := &Persisted{
+		traces:   map[string][]event{},
+		restored: map[string]bool{},
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return p, errors.WithStack(err)
+	}
+
+	var file persistedFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return p, errors.Wrapf(err, "invalid event traces file %s", path)
+	}
+
+	p.Incarnation = file.Incarnation
+	for _, tr := range file.Traces {
+		events := make([]event, 0, len(tr.Events))
+		for _, e := range tr.Events {
+			t, _, err := timestamp.Parse(e.Timestamp)
+			if err != nil {
+				return p, errors.Wrapf(err, "invalid event traces file %s", path)
+			}
+			events = append(events, event{time: t, message: e.Message})
+		}
+		p.traces[tr.ID] = append(p.traces[tr.ID], events...)
+	}
+	return p, nil
+}
+
+// Restore prepends previous events of tr to it, followed by the incarnation marker
+func (p *Persisted) Restore(tr Trace) {
+	if p.Incarnation == 0 {
+		return
+	}
+	r, ok := tr.(interface{ restore(events []event) })
+	if !ok {
+		return
+	}
+	r.restore(p.traces[tr.ID()])
+	p.restored[tr.ID()] = true
+	tr.AddEvent(fmt.Sprintf("Incarnation %d", p.Incarnation+1))
+}
+
+// Persist writes traces to path atomically as the next incarnation, to be restored by the one after it.
+// Previous traces not restored by this incarnation are kept too
+func (p *Persisted) Persist(path string, traces []Trace) error {
+	ids := make([]string, 0, len(p.traces))
+	for id := range p.traces {
+		if !p.restored[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	all := append(make([]Trace, 0, len(traces)+len(ids)), traces...)
+	for _, id := range ids {
+		tr := &trace{id: id}
+		tr.restore(p.traces[id])
+		all = append(all, tr)
+	}
+
+	return persist(path, p.Incarnation+1, all)
+}
+
+func persist(path string, incarnation int, traces []Trace) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	ew := &errWriter{w: file}
+	ew.writeString(fmt.Sprintf(`{"incarnation":%d,"traces":`, incarnation))
+	if ew.err == nil {
+		ew.err = FireAll(file, traces)
+	}
+	ew.writeString("}")
+	if ew.err != nil {
+		return errors.Wrapf(ew.err, "failed to write event traces %s", path)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(file.Name(), path))
+}
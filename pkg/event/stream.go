@@ -0,0 +1,41 @@
+package event
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// FireAll writes traces to w as JSON array, one trace at a time, so long and verbose traces
+// are not marshaled to a single blob in memory
+func FireAll(w io.Writer, traces []Trace) error {
+	ew := &errWriter{w: w}
+	ew.writeString("[")
+	for i, tr := range traces {
+		if i > 0 {
+			ew.writeString(",")
+		}
+		if ew.err != nil {
+			break
+		}
+		err := tr.FireTo(w)
+		if err != nil {
+			return errors.Wrapf(err, "failed to marshal event trace: %s", tr.ID())
+		}
+	}
+	ew.writeString("]")
+	return ew.err
+}
+
+// errWriter keeps the first write error, so encoding code checks it once at the end
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) writeString(s string) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = io.WriteString(ew.w, s)
+}
@@ -0,0 +1,69 @@
+package event
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/timestamp"
+)
+
+// Summary describes the whole child run, so a single fired trace or log line is enough to tell how it ended
+type Summary struct {
+	PID      int
+	ExitCode *int
+	// Signal terminated the child, if any
+	Signal       string
+	StartedAt    time.Time
+	StoppedAt    time.Time
+	RestartCount int
+}
+
+type summaryRecord struct {
+	PID          int         `json:"pid,omitempty"`
+	ExitCode     *int        `json:"exitCode,omitempty"`
+	Signal       string      `json:"signal,omitempty"`
+	StartedAt    interface{} `json:"startedAt,omitempty"`
+	StoppedAt    interface{} `json:"stoppedAt,omitempty"`
+	RestartCount int         `json:"restartCount"`
+}
+
+func (s Summary) record() *summaryRecord {
+	r := &summaryRecord{
+		PID:          s.PID,
+		ExitCode:     s.ExitCode,
+		Signal:       s.Signal,
+		RestartCount: s.RestartCount,
+	}
+	if !s.StartedAt.IsZero() {
+		r.StartedAt = timestamp.Default().Value(s.StartedAt)
+	}
+	if !s.StoppedAt.IsZero() {
+		r.StoppedAt = timestamp.Default().Value(s.StoppedAt)
+	}
+	return r
+}
+
+// Fields returns the summary as top-level log fields
+func (s Summary) Fields() logrus.Fields {
+	r := s.record()
+	fields := logrus.Fields{
+		"restartCount": r.RestartCount,
+	}
+	if r.PID != 0 {
+		fields["pid"] = r.PID
+	}
+	if r.ExitCode != nil {
+		fields["exitCode"] = *r.ExitCode
+	}
+	if r.Signal != "" {
+		fields["signal"] = r.Signal
+	}
+	if r.StartedAt != nil {
+		fields["startedAt"] = r.StartedAt
+	}
+	if r.StoppedAt != nil {
+		fields["stoppedAt"] = r.StoppedAt
+	}
+	return fields
+}
@@ -1,10 +1,14 @@
 package event
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"sync"
 	"time"
+
+	"github.com/ispringtech/kubexit/pkg/timestamp"
 )
 
 type Event interface {
@@ -12,8 +16,8 @@ type Event interface {
 	Message() string
 }
 
-func newEvent(message string) Event {
-	return &event{
+func newEvent(message string) event {
+	return event{
 		time:    time.Now(),
 		message: message,
 	}
@@ -53,13 +57,19 @@ func NewTrace(id string) Trace {
 type Trace interface {
 	ID() string
 	AddEvent(message string)
+	// SetSummary adds summary of the child run to top-level fields of fired trace
+	SetSummary(summary Summary)
 	Fire() (json.RawMessage, error)
+	// FireTo writes the same JSON as Fire to w
+	FireTo(w io.Writer) error
 }
 
 type trace struct {
-	id     string
-	events []Event
-	m      sync.Mutex
+	id string
+	// events are stored by value, so chatty traces don't allocate per event
+	events  []event
+	summary *Summary
+	m       sync.Mutex
 }
 
 func (t *trace) ID() string {
@@ -72,23 +82,62 @@ func (t *trace) AddEvent(message string) {
 	t.events = append(t.events, newEvent(message))
 }
 
+// restore prepends events of previous incarnations
+func (t *trace) restore(events []event) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.events = append(append(make([]event, 0, len(events)+len(t.events)), events...), t.events...)
+}
+
+func (t *trace) SetSummary(summary Summary) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.summary = &summary
+}
+
 func (t *trace) Fire() (json.RawMessage, error) {
-	records := make([]interface{}, 0, len(t.events))
-	for _, e := range t.events {
-		records = append(records, struct {
-			Timestamp time.Time `json:"timestamp"`
-			Message   string    `json:"message,omitempty"`
-		}{
-			Timestamp: e.Time(),
-			Message:   e.Message(),
-		})
+	var buffer bytes.Buffer
+	err := t.FireTo(&buffer)
+	if err != nil {
+		return nil, err
 	}
+	return buffer.Bytes(), nil
+}
 
-	return json.Marshal(struct {
-		ID     string        `json:"id"`
-		Events []interface{} `json:"events"`
-	}{
-		ID:     t.id,
-		Events: records,
-	})
+// FireTo encodes the trace to w event by event, without building the whole trace in memory
+func (t *trace) FireTo(w io.Writer) error {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	enc := newEncoder(w)
+	defer enc.release()
+
+	format := timestamp.Default()
+	enc.buf = append(enc.buf, `{"id":`...)
+	enc.buf = appendJSONString(enc.buf, t.id)
+	enc.buf = append(enc.buf, `,"events":[`...)
+	for i, e := range t.events {
+		if i > 0 {
+			enc.buf = append(enc.buf, ',')
+		}
+		enc.buf = append(enc.buf, `{"timestamp":`...)
+		enc.buf = format.AppendJSON(enc.buf, e.time)
+		if e.message != "" {
+			enc.buf = append(enc.buf, `,"message":`...)
+			enc.buf = appendJSONString(enc.buf, e.message)
+		}
+		enc.buf = append(enc.buf, '}')
+		enc.flushFull()
+	}
+	enc.buf = append(enc.buf, ']')
+	if t.summary != nil {
+		// summary fields are inlined into the trace object
+		enc.buf = append(enc.buf, ',')
+		err := enc.appendJSONFields(t.summary.record())
+		if err != nil {
+			return err
+		}
+	}
+	enc.buf = append(enc.buf, '}')
+	return enc.flush()
 }
@@ -0,0 +1,27 @@
+package event
+
+import "context"
+
+// Warnings receive handler errors, which cluster operators should notice without reading container logs
+type Warnings interface {
+	// Warn reports an error of a handler, reason is CamelCase cause of the error
+	Warn(reason, message string)
+}
+
+type warningsKey struct{}
+
+func WithWarnings(ctx context.Context, w Warnings) context.Context {
+	return context.WithValue(ctx, warningsKey{}, w)
+}
+
+func ContextWarnings(ctx context.Context) Warnings {
+	w, ok := ctx.Value(warningsKey{}).(Warnings)
+	if !ok {
+		return noopWarnings{}
+	}
+	return w
+}
+
+type noopWarnings struct{}
+
+func (noopWarnings) Warn(string, string) {}
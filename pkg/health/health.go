@@ -0,0 +1,126 @@
+// Package health tracks status of kubexit internal subsystems, e.g. watchers and servers,
+// so a kubexit running but internally degraded can be caught by probes and alerting
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/ispringtech/kubexit/pkg/metrics"
+)
+
+// Registry keeps the latest status of each subsystem and mirrors it to kubexit_subsystem_up gauge
+type Registry struct {
+	m          sync.Mutex
+	recorder   metrics.Recorder
+	subsystems map[string]status
+}
+
+type status struct {
+	up     bool
+	reason string
+}
+
+func NewRegistry(recorder metrics.Recorder) *Registry {
+	return &Registry{recorder: recorder, subsystems: map[string]status{}}
+}
+
+// Up reports the subsystem works
+func (r *Registry) Up(name string) {
+	r.set(name, status{up: true})
+}
+
+// Down reports the subsystem is degraded for the reason
+func (r *Registry) Down(name, reason string) {
+	r.set(name, status{reason: reason})
+}
+
+// Remove forgets the subsystem stopped on purpose, its gauge keeps the last value
+func (r *Registry) Remove(name string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	delete(r.subsystems, name)
+}
+
+func (r *Registry) set(name string, s status) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.subsystems[name] = s
+
+	value := 0.0
+	if s.up {
+		value = 1
+	}
+	if r.recorder != nil {
+		r.recorder.SetGauge("kubexit_subsystem_up", "Whether kubexit internal subsystem works", value, metrics.L("subsystem", name))
+	}
+}
+
+// Healthy reports whether all subsystems are up
+func (r *Registry) Healthy() bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+	for _, s := range r.subsystems {
+		if !s.up {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP responds with 200 when all subsystems are up, 503 otherwise.
+// With verbose=1 query each subsystem is listed, in the format of kubernetes /healthz
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.m.Lock()
+	names := make([]string, 0, len(r.subsystems))
+	for name := range r.subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	healthy := true
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		s := r.subsystems[name]
+		if s.up {
+			lines = append(lines, fmt.Sprintf("[+]%s ok", name))
+			continue
+		}
+		healthy = false
+		lines = append(lines, fmt.Sprintf("[-]%s failed: %s", name, s.reason))
+	}
+	r.m.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if v := req.URL.Query().Get("verbose"); v != "" && v != "0" && v != "false" {
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+	}
+	if healthy {
+		fmt.Fprintln(w, "ok")
+	} else {
+		fmt.Fprintln(w, "healthz check failed")
+	}
+}
+
+type registryKey struct{}
+
+func WithRegistry(ctx context.Context, r *Registry) context.Context {
+	return context.WithValue(ctx, registryKey{}, r)
+}
+
+// ContextRegistry returns the registry set in ctx, or a detached one, so reporting is safe without it
+func ContextRegistry(ctx context.Context) *Registry {
+	r, ok := ctx.Value(registryKey{}).(*Registry)
+	if !ok {
+		return NewRegistry(nil)
+	}
+	return r
+}
@@ -0,0 +1,41 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/ispringtech/kubexit/pkg/retry"
+)
+
+func newClientset(ctx context.Context) (*kubernetes.Clientset, error) {
+	config, err := inClusterConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to create kubernetes client: %v", err))
+	}
+	return clientset, nil
+}
+
+// inClusterConfig retries reading in-cluster config, as the service account token may be mounted after the container starts
+func inClusterConfig(ctx context.Context) (*rest.Config, error) {
+	var config *rest.Config
+	err := retry.Do(ctx, "kubernetes client", func(context.Context) error {
+		var err error
+		config, err = rest.InClusterConfig()
+		if err == rest.ErrNotInCluster {
+			return retry.Permanent(err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to configure kubernetes client: %v", err))
+	}
+	return config, nil
+}
@@ -0,0 +1,46 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/rest"
+)
+
+// ClockOffset returns the offset of the API server clock from the local one, by the Date header of a version request.
+// Positive offset means the local clock is behind. Date header has one second resolution
+func ClockOffset(ctx context.Context) (time.Duration, error) {
+	config, err := inClusterConfig(ctx)
+	if err != nil {
+		return 0, err
+	}
+	transport, err := rest.TransportFor(config)
+	if err != nil {
+		return 0, errors.WithStack(fmt.Errorf("failed to create kubernetes transport: %v", err))
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, config.Host+"/version", nil)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	sent := time.Now()
+	response, err := (&http.Client{Transport: transport}).Do(request)
+	if err != nil {
+		return 0, errors.WithStack(fmt.Errorf("failed to request api server version: %v", err))
+	}
+	received := time.Now()
+	_ = response.Body.Close()
+
+	date, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		return 0, errors.WithStack(fmt.Errorf("api server response has no valid Date header: %v", err))
+	}
+
+	// the server time is taken halfway through the round trip
+	local := sent.Add(received.Sub(sent) / 2)
+	return date.Sub(local), nil
+}
@@ -0,0 +1,124 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+)
+
+// ContainerStateChange is a container state reported in pod status, different from the one reported before
+type ContainerStateChange struct {
+	Name string
+	// Init is set for init containers, including native sidecars, which readiness is reported the same way as of regular containers
+	Init    bool
+	Ready   bool
+	Started bool
+	// Terminated is set for the container exited with ExitCode, which is zero otherwise
+	Terminated   bool
+	ExitCode     int
+	RestartCount int
+}
+
+// ContainerStates returns states of the pod init containers and containers, in this order
+func ContainerStates(pod *corev1.Pod) []ContainerStateChange {
+	states := make([]ContainerStateChange, 0, len(pod.Status.InitContainerStatuses)+len(pod.Status.ContainerStatuses))
+	for _, status := range pod.Status.InitContainerStatuses {
+		states = append(states, containerState(status, true))
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		states = append(states, containerState(status, false))
+	}
+	return states
+}
+
+func containerState(status corev1.ContainerStatus, init bool) ContainerStateChange {
+	state := ContainerStateChange{
+		Name:         status.Name,
+		Init:         init,
+		Ready:        status.Ready,
+		Started:      status.Started != nil && *status.Started,
+		RestartCount: int(status.RestartCount),
+	}
+	if terminated := status.State.Terminated; terminated != nil {
+		state.Terminated = true
+		state.ExitCode = int(terminated.ExitCode)
+	}
+	return state
+}
+
+// WatchContainers watches the pod as WatchPod does and sends changes of its container states, the first pod update reports all containers.
+// The channel is closed when ctx is done or the pod is deleted
+func WatchContainers(ctx context.Context, namespace, podName string) (<-chan ContainerStateChange, error) {
+	changes := make(chan ContainerStateChange)
+	w := &containerWatcher{changes: changes, states: map[string]ContainerStateChange{}}
+
+	err := WatchPod(ctx, namespace, podName, w.handle)
+	if err != nil {
+		return nil, err
+	}
+
+	crash.Go(ctx, "container states", func() {
+		<-ctx.Done()
+		w.close()
+	})
+	return changes, nil
+}
+
+// containerWatcher is the EventHandler of WatchContainers, sending changed container states
+type containerWatcher struct {
+	changes chan ContainerStateChange
+
+	m      sync.Mutex
+	closed bool
+	// states are the last sent states of containers
+	states map[string]ContainerStateChange
+}
+
+func (w *containerWatcher) handle(ctx context.Context, e watch.Event) {
+	if e.Type == watch.Deleted {
+		w.close()
+		return
+	}
+
+	pod, ok := e.Object.(*corev1.Pod)
+	if !ok {
+		event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Error: unexpected non-pod object type: %+v\n", e.Object))
+		return
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	for _, state := range ContainerStates(pod) {
+		if w.closed {
+			return
+		}
+		if last, ok := w.states[state.Name]; ok && last == state {
+			continue
+		}
+		w.states[state.Name] = state
+
+		select {
+		case w.changes <- state:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *containerWatcher) close() {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.closed {
+		return
+	}
+	w.closed = true
+	close(w.changes)
+}
@@ -0,0 +1,46 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// CreatePodWarningEvent creates a Warning Event of the pod, shown by kubectl describe pod.
+// Requires create verb on events in the pod namespace
+func CreatePodWarningEvent(ctx context.Context, namespace, podName, podUID, reason, message string) error {
+	clientset, err := newClientset(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := metav1.Now()
+	_, err = clientset.CoreV1().Events(namespace).Create(ctx, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: podName + ".",
+			Namespace:    namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Namespace:  namespace,
+			Name:       podName,
+			UID:        types.UID(podUID),
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: "kubexit"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to create pod %s event: %v", podName, err))
+	}
+	return nil
+}
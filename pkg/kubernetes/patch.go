@@ -0,0 +1,84 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// PatchPodAnnotations merges annotations into the pod metadata.
+// Annotations with empty value are removed.
+func PatchPodAnnotations(ctx context.Context, namespace, podName string, annotations map[string]string) error {
+	clientset, err := newClientset(ctx)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{}, len(annotations))
+	for key, value := range annotations {
+		if value == "" {
+			// null removes the key in merge patch
+			values[key] = nil
+			continue
+		}
+		values[key] = value
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": values,
+		},
+	})
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to marshal annotations patch: %v", err))
+	}
+
+	_, err = clientset.CoreV1().Pods(namespace).Patch(ctx, podName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to patch pod %s annotations: %v", podName, err))
+	}
+	return nil
+}
+
+// SetPodCondition sets the status of a custom pod condition, e.g. one listed in pod readinessGates.
+func SetPodCondition(ctx context.Context, namespace, podName, conditionType string, ready bool, reason string) error {
+	clientset, err := newClientset(ctx)
+	if err != nil {
+		return err
+	}
+
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+
+	now := metav1.Now()
+	// conditions are merged by type with strategic merge patch
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []corev1.PodCondition{
+				{
+					Type:               corev1.PodConditionType(conditionType),
+					Status:             status,
+					Reason:             reason,
+					LastProbeTime:      now,
+					LastTransitionTime: now,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to marshal condition patch: %v", err))
+	}
+
+	_, err = clientset.CoreV1().Pods(namespace).Patch(ctx, podName, types.StrategicMergePatchType, patch, metav1.PatchOptions{}, "status")
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to patch pod %s condition %s: %v", podName, conditionType, err))
+	}
+	return nil
+}
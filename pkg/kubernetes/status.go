@@ -0,0 +1,110 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ContainerStatus is the container incarnation and version reported in pod status
+type ContainerStatus struct {
+	RestartCount int
+	Image        string
+	ImageID      string
+}
+
+// GetContainerStatus returns status of the pod container or init container
+func GetContainerStatus(ctx context.Context, namespace, podName, container string) (*ContainerStatus, error) {
+	clientset, err := newClientset(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to get pod %s: %v", podName, err))
+	}
+
+	statuses := append(pod.Status.ContainerStatuses, pod.Status.InitContainerStatuses...)
+	for _, status := range statuses {
+		if status.Name == container {
+			return &ContainerStatus{
+				RestartCount: int(status.RestartCount),
+				Image:        status.Image,
+				ImageID:      status.ImageID,
+			}, nil
+		}
+	}
+	return nil, errors.Errorf("container %s is not found in pod %s status", container, podName)
+}
+
+// GetContainerNames returns names of the pod containers and init containers from the pod spec
+func GetContainerNames(ctx context.Context, namespace, podName string) ([]string, error) {
+	clientset, err := newClientset(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to get pod %s: %v", podName, err))
+	}
+
+	names := make([]string, 0, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+	for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		names = append(names, container.Name)
+	}
+	return names, nil
+}
+
+// GetPodAnnotations returns annotations of the pod
+func GetPodAnnotations(ctx context.Context, namespace, podName string) (map[string]string, error) {
+	clientset, err := newClientset(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to get pod %s: %v", podName, err))
+	}
+	return pod.Annotations, nil
+}
+
+// PodResources are the pod QoS class and the container CPU limit, affecting how fast the container can exit
+type PodResources struct {
+	// QOSClass is Guaranteed, Burstable or BestEffort
+	QOSClass string
+	// CPULimitMillis is zero when the container has no CPU limit
+	CPULimitMillis int64
+	// TerminationGracePeriod is the time kubelet waits for the pod to exit before killing it, zero if unknown
+	TerminationGracePeriod time.Duration
+}
+
+// GetPodResources returns QoS class of the pod and CPU limit of its container or init container
+func GetPodResources(ctx context.Context, namespace, podName, container string) (*PodResources, error) {
+	clientset, err := newClientset(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to get pod %s: %v", podName, err))
+	}
+
+	resources := &PodResources{QOSClass: string(pod.Status.QOSClass)}
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		resources.TerminationGracePeriod = time.Duration(*pod.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+	for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		if c.Name == container {
+			resources.CPULimitMillis = c.Resources.Limits.Cpu().MilliValue()
+			return resources, nil
+		}
+	}
+	return nil, errors.Errorf("container %s is not found in pod %s spec", container, podName)
+}
@@ -3,84 +3,148 @@ package kubernetes
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
-	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	watchtools "k8s.io/client-go/tools/watch"
 
+	"github.com/ispringtech/kubexit/pkg/chaos"
+	"github.com/ispringtech/kubexit/pkg/crash"
 	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/retry"
+	"github.com/ispringtech/kubexit/pkg/watchdog"
 )
 
 type EventHandler func(context.Context, watch.Event)
 
+// WatchOptions of pod watch requests, see SetWatchOptions
+type WatchOptions struct {
+	// Timeout ends each watch request after it, so the watch is renewed, e.g. before idle streams are silently dropped by L4 load balancers.
+	// Zero leaves the client default, random from 5 to 10 minutes
+	Timeout time.Duration
+	// Bookmarks lets the API server send bookmark events, so idle streams carry traffic and resume from a recent resource version
+	Bookmarks bool
+}
+
+var (
+	watchOptionsLock sync.RWMutex
+	watchOptions     = WatchOptions{Bookmarks: true}
+)
+
+// SetWatchOptions sets options of pod watches started afterwards. Bookmarks are enabled by default
+func SetWatchOptions(options WatchOptions) {
+	watchOptionsLock.Lock()
+	defer watchOptionsLock.Unlock()
+	watchOptions = options
+}
+
+func currentWatchOptions() WatchOptions {
+	watchOptionsLock.RLock()
+	defer watchOptionsLock.RUnlock()
+	return watchOptions
+}
+
 // Watch a pod and call the eventHandler (asyncronously) when an
 // event happens. When the supplied context is canceled, watching will stop.
 func WatchPod(ctx context.Context, namespace, podName string, eventHandler EventHandler) error {
-	config, err := rest.InClusterConfig()
+	clientset, err := newClientset(ctx)
 	if err != nil {
-		return errors.WithStack(fmt.Errorf("failed to configure kubernetes client: %v", err))
-	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return errors.WithStack(fmt.Errorf("failed to create kubernetes client: %v", err))
+		return err
 	}
 
 	// Watch doesn't take name matches, only selectors. So select on name.
 	fieldSelector := fields.OneTermEqualSelector("metadata.name", podName).String()
+	watchOptions := currentWatchOptions()
+	heartbeat := watchdog.ContextHeartbeat(ctx)
 
 	// UntilWithSync takes this crazy compound input to List and then Watch.
 	// These functions add our FieldSelector to the requests.
 	// UntilWithSync uses the List to get the current resource version, because
 	// Watch requires an initial resource version to start at, and the resource
 	// version needs to still be in the etcd event history cache.
+	// Heartbeat beats only on progress of the watch: successful lists, events and bookmarks,
+	// so watchdog notices when watch is wedged.
 	lw := &cache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (object runtime.Object, e error) {
 			options.FieldSelector = fieldSelector
-			return clientset.CoreV1().Pods(namespace).List(ctx, options)
+			list, err := clientset.CoreV1().Pods(namespace).List(ctx, options)
+			if err == nil {
+				heartbeat.Beat()
+			}
+			return list, err
 		},
 		WatchFunc: func(options metav1.ListOptions) (i watch.Interface, e error) {
 			options.FieldSelector = fieldSelector
-			return clientset.CoreV1().Pods(namespace).Watch(ctx, options)
+			if watchOptions.Timeout > 0 {
+				timeoutSeconds := int64(watchOptions.Timeout.Seconds())
+				options.TimeoutSeconds = &timeoutSeconds
+			}
+			options.AllowWatchBookmarks = watchOptions.Bookmarks
+			w, err := clientset.CoreV1().Pods(namespace).Watch(ctx, options)
+			if err != nil {
+				return nil, err
+			}
+			// bookmarks are consumed by the reflector and never reach the condition
+			return watch.Filter(w, func(e watch.Event) (watch.Event, bool) {
+				heartbeat.Beat()
+				return e, true
+			}), nil
 		},
 	}
 
-	go func() {
+	crash.Go(ctx, "pod watch", func() {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithCancel(ctx)
 		// cancel the provided context when done, so that caller can block on it
 		defer cancel()
 
-		// watch until deleted
-		_, err := watchtools.UntilWithSync(ctx, lw, &corev1.Pod{}, nil, func(e watch.Event) (bool, error) {
-			if e.Type == watch.Error {
-				event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Pod Watch(%s): recoverable error: %+v", podName, e.Object))
-				return false, nil
+		// disconnect the watch on chaos injection
+		crash.Go(ctx, "pod watch chaos", func() {
+			select {
+			case <-ctx.Done():
+			case <-chaos.ContextInjector(ctx).Disconnect():
+				event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Pod Watch(%s): chaos: disconnected", podName))
+				cancel()
 			}
+		})
 
-			eventHandler(ctx, e)
+		// watch until deleted, reconnecting on terminal errors with the retry policy
+		err := retry.Do(ctx, "pod watch", func(ctx context.Context) error {
+			_, err := watchtools.UntilWithSync(ctx, lw, &corev1.Pod{}, nil, func(e watch.Event) (bool, error) {
+				if e.Type == watch.Error {
+					event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Pod Watch(%s): recoverable error: %+v", podName, e.Object))
+					event.ContextWarnings(ctx).Warn("PodWatchFailed", fmt.Sprintf("pod %s watch error: %+v", podName, e.Object))
+					return false, nil
+				}
 
-			if e.Type == watch.Deleted {
-				event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Pod Watch(%s): pod deleted", podName))
-				return true, nil
+				eventHandler(ctx, e)
+
+				if e.Type == watch.Deleted {
+					event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Pod Watch(%s): pod deleted", podName))
+					return true, nil
+				}
+				return false, nil
+			})
+			// ErrWaitTimeout is returned when the context is canceled.
+			// Since cancellation is the only way we exit, don't retry it.
+			if err == wait.ErrWaitTimeout {
+				return retry.Permanent(err)
 			}
-			return false, nil
+			return err
 		})
-		// ErrWaitTimeout is returned when the context is canceled.
-		// Since cancellation is the only way we exit, just ignore it.
 		if err != nil && err != wait.ErrWaitTimeout {
-			// TODO: should we do something about this??
+			// watchdog recreates the watch, when its heartbeat is stale
 			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Pod Watch(%s): terminal error: %v", podName, err))
 		}
 		event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Pod Watch(%s): done\n", podName))
-	}()
+	})
 
 	return nil
 }
@@ -0,0 +1,60 @@
+//go:build !nokubernetes
+// +build !nokubernetes
+
+package kubexit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/kubernetes"
+)
+
+// defaultContainerWatcher is nil in slim build made with nokubernetes tag
+var defaultContainerWatcher ContainerWatcher = WatchPodContainers
+
+// WatchPodContainers is ContainerWatcher watching the pod via kubernetes api
+func WatchPodContainers(ctx context.Context, namespace, podName string, containers []string, setReady func(name string)) error {
+	event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Watching pod %s updates", podName))
+	return watchContainersOf(ctx, namespace, podName, containers, func(state kubernetes.ContainerStateChange) {
+		if state.Ready {
+			setReady(state.Name)
+		}
+	})
+}
+
+// defaultContainerStateWatcher is nil in slim build made with nokubernetes tag
+var defaultContainerStateWatcher ContainerStateWatcher = WatchPodContainerStates
+
+// WatchPodContainerStates is ContainerStateWatcher watching the pod via kubernetes api.
+// setState is called for each readiness change of the containers, e.g. terminated or restarting container is not ready
+func WatchPodContainerStates(ctx context.Context, namespace, podName string, containers []string, setState func(name string, ready bool)) error {
+	event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Watching pod %s container states", podName))
+	return watchContainersOf(ctx, namespace, podName, containers, func(state kubernetes.ContainerStateChange) {
+		setState(state.Name, state.Ready)
+	})
+}
+
+// watchContainersOf calls handle with state changes of the containers until the watch is done.
+// Init container states are included to support native sidecars (init containers with restartPolicy: Always)
+func watchContainersOf(ctx context.Context, namespace, podName string, containers []string, handle func(kubernetes.ContainerStateChange)) error {
+	changes, err := kubernetes.WatchContainers(ctx, namespace, podName)
+	if err != nil {
+		return err
+	}
+
+	watched := map[string]struct{}{}
+	for _, name := range containers {
+		watched[name] = struct{}{}
+	}
+	crash.Go(ctx, "pod containers", func() {
+		for state := range changes {
+			if _, ok := watched[state.Name]; ok {
+				handle(state)
+			}
+		}
+	})
+	return nil
+}
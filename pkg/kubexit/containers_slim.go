@@ -0,0 +1,10 @@
+//go:build nokubernetes
+// +build nokubernetes
+
+package kubexit
+
+// defaultContainerWatcher is nil in slim build made with nokubernetes tag, so container birth deps require WithContainerWatcher
+var defaultContainerWatcher ContainerWatcher
+
+// defaultContainerStateWatcher is nil in slim build, so WatchBirthDeps of container deps requires WithContainerStateWatcher
+var defaultContainerStateWatcher ContainerStateWatcher
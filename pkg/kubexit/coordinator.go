@@ -0,0 +1,444 @@
+// Package kubexit embeds kubexit birth and death coordination into Go programs.
+//
+// Coordinator waits for birth deps, watches graveyard for death deps and supervises the child,
+// recording its tombstone the same way kubexit binary does, so embedding programs may coordinate with kubexit wrapped containers.
+package kubexit
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/readiness"
+	"github.com/ispringtech/kubexit/pkg/retry"
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+	"github.com/ispringtech/kubexit/pkg/tombstone"
+	"github.com/ispringtech/kubexit/pkg/watchdog"
+)
+
+var (
+	// ErrBirthTimeout is the cause of WaitForBirthDeps error, when birth deps are not ready within BirthTimeout
+	ErrBirthTimeout = errors.New("timed out waiting for birth deps to be ready")
+	// ErrBirthInterrupted is the cause of WaitForBirthDeps error, when ctx is done before birth deps are ready
+	ErrBirthInterrupted = errors.New("interrupted waiting for birth deps to be ready")
+)
+
+// Policies applied when the graveyard is removed while watching death deps and can't be recreated
+const (
+	// GraveyardLossIgnore keeps running the child, silently
+	GraveyardLossIgnore = "ignore"
+	// GraveyardLossWarn keeps running the child, logging a warning. Default
+	GraveyardLossWarn = "warn"
+	// GraveyardLossDeath treats the loss as death of all death deps
+	GraveyardLossDeath = "death"
+)
+
+type Coordinator struct {
+	config          Config
+	logger          logrus.FieldLogger
+	watchGraveyard  GraveyardWatcher
+	watchContainers ContainerWatcher
+	// watchContainerStates watches container birth deps after birth
+	watchContainerStates ContainerStateWatcher
+	birthDeps            []readiness.Dep
+
+	m sync.Mutex
+	// pod is the pod watch shared by birth deps wait and watch, while WatchContainers runs
+	pod *podWatch
+}
+
+// New creates coordinator of participant with the name, options are applied over DefaultConfig
+func New(name string, options ...Option) (*Coordinator, error) {
+	c := &Coordinator{
+		config:          DefaultConfig(name),
+		logger:          discardLogger(),
+		watchGraveyard:  tombstone.Watch,
+		watchContainers: defaultContainerWatcher,
+
+		watchContainerStates: defaultContainerStateWatcher,
+	}
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.config.Name == "" {
+		return nil, errors.New("missing participant name")
+	}
+
+	switch c.config.GraveyardLossPolicy {
+	case "", GraveyardLossIgnore, GraveyardLossWarn, GraveyardLossDeath:
+	default:
+		return nil, errors.Errorf("invalid graveyard loss policy %s, expected one of: ignore, warn, death", c.config.GraveyardLossPolicy)
+	}
+
+	deps, err := readiness.ParseDeps(c.config.BirthDeps)
+	if err != nil {
+		return nil, err
+	}
+	c.birthDeps = deps
+
+	if len(readiness.Containers(deps)) > 0 {
+		if c.watchContainers == nil {
+			return nil, errors.New("container birth deps require kubexit built with kubernetes support or WithContainerWatcher")
+		}
+		if c.config.PodName == "" || c.config.Namespace == "" {
+			return nil, errors.New("container birth deps require pod name and namespace")
+		}
+	}
+
+	return c, nil
+}
+
+// Run supervises the command with the config, see Coordinator.Run
+func Run(ctx context.Context, config Config, command string, args ...string) (int, error) {
+	c, err := New(config.Name, withConfig(config))
+	if err != nil {
+		return 1, err
+	}
+	return c.Run(ctx, command, args...)
+}
+
+// Config returns effective config of the coordinator
+func (c *Coordinator) Config() Config {
+	return c.config
+}
+
+// WaitForBirthDeps blocks until all birth deps are ready, BirthTimeout elapses or ctx is done.
+// Each kind of dependency is checked by its own goroutine, feeding a shared readiness matrix:
+// containers by a single pod watch, shared with WatchBirthDeps while WatchContainers runs, other deps by polling probes.
+// onDepReady, if not nil, is called once for each dependency when it becomes ready.
+func (c *Coordinator) WaitForBirthDeps(ctx context.Context, onDepReady func(name string)) error {
+	ctx, stopCheckers := context.WithTimeout(ctx, c.config.BirthTimeout)
+	// Stop checkers on exit, if not sooner
+	defer stopCheckers()
+
+	matrix := readiness.NewMatrix(c.config.BirthDeps, onDepReady)
+
+	if pod := c.sharedPodWatch(); pod != nil {
+		pod.subscribe(ctx, func(name string, ready bool) {
+			if ready {
+				matrix.SetReady(name)
+			}
+		})
+	} else if containers := readiness.Containers(c.birthDeps); len(containers) > 0 {
+		err := watchdog.Run(ctx, "pod", c.config.WatchdogStaleAfter, func(ctx context.Context) error {
+			return c.watchContainers(ctx, c.config.Namespace, c.config.PodName, containers, matrix.SetReady)
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to watch pod")
+		}
+	}
+
+	for _, dep := range c.birthDeps {
+		if dep.Kind == readiness.KindContainer {
+			continue
+		}
+		probe, err := readiness.NewProbe(dep, readiness.Graveyard{
+			Path:   c.config.Graveyard,
+			PodUID: c.config.PodUID,
+			Key:    c.config.TombstoneKey,
+		})
+		if err != nil {
+			return err
+		}
+		name := dep.Name
+		crash.Go(ctx, name+" probe", func() {
+			readiness.Poll(ctx, name, c.config.BirthCheckInterval, probe, matrix)
+		})
+	}
+
+	// Block until all birth deps are ready
+	err := matrix.Wait(ctx)
+	c.logger.WithField("birth_deps", matrix.Snapshot()).Info("birth deps readiness")
+	if err == context.DeadlineExceeded {
+		return errors.Wrapf(ErrBirthTimeout, "birth timeout %s elapsed, pending: %s",
+			c.config.BirthTimeout, strings.Join(matrix.Pending(), ", "))
+	} else if err != nil {
+		return errors.Wrapf(ErrBirthInterrupted, "%v, pending: %s", err, strings.Join(matrix.Pending(), ", "))
+	}
+
+	event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("All birth deps ready: %v", strings.Join(c.config.BirthDeps, ", ")))
+	return nil
+}
+
+// WatchBirthDeps keeps checking birth deps in background until ctx is done, and calls onChange on each readiness change,
+// so the caller may react to a dependency lost after birth. Deps are considered ready initially, as the birth waits for them.
+// onChange may be called with the same state repeatedly, container deps are reported on each pod update
+func (c *Coordinator) WatchBirthDeps(ctx context.Context, onChange func(name string, ready bool)) error {
+	if pod := c.sharedPodWatch(); pod != nil {
+		pod.subscribe(ctx, onChange)
+	} else if containers := readiness.Containers(c.birthDeps); len(containers) > 0 {
+		if c.watchContainerStates == nil {
+			return errors.New("watching container birth deps requires kubexit built with kubernetes support or WithContainerStateWatcher")
+		}
+		err := watchdog.Run(ctx, "pod", c.config.WatchdogStaleAfter, func(ctx context.Context) error {
+			return c.watchContainerStates(ctx, c.config.Namespace, c.config.PodName, containers, onChange)
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to watch pod")
+		}
+	}
+
+	for _, dep := range c.birthDeps {
+		if dep.Kind == readiness.KindContainer {
+			continue
+		}
+		probe, err := readiness.NewProbe(dep, readiness.Graveyard{
+			Path:   c.config.Graveyard,
+			PodUID: c.config.PodUID,
+			Key:    c.config.TombstoneKey,
+		})
+		if err != nil {
+			return err
+		}
+		name := dep.Name
+		crash.Go(ctx, name+" probe", func() {
+			readiness.Monitor(ctx, name, c.config.BirthCheckInterval, probe, onChange)
+		})
+	}
+	return nil
+}
+
+// WatchDeathDeps watches graveyard in background until ctx is done, and calls onDeath once, when any of death deps has died.
+// Watching stops after onDeath is called.
+func (c *Coordinator) WatchDeathDeps(ctx context.Context, onDeath func() error) error {
+	ctx, stopWatcher := context.WithCancel(ctx)
+
+	var once sync.Once
+	callback := func() error {
+		var err error
+		once.Do(func() {
+			stopWatcher()
+			err = onDeath()
+		})
+		return err
+	}
+	handler := c.onGraveyardLoss(onDeathOfAny(c.config.PodUID, c.config.TombstoneKey, c.config.DeathDeps, callback), callback)
+
+	err := watchdog.Run(ctx, "graveyard", c.config.WatchdogStaleAfter, func(ctx context.Context) error {
+		return c.watchGraveyard(ctx, c.config.Graveyard, c.config.DeathDeps, handler)
+	})
+	if err != nil {
+		stopWatcher()
+		return errors.Wrap(err, "failed to watch graveyard")
+	}
+	return nil
+}
+
+// Run supervises the command: waits for birth deps, starts the command and records its birth,
+// terminates it with GracePeriod when a death dep dies or ctx is done, and records its death.
+// Returns the command exit code, and 1 with error when coordination failed.
+func (c *Coordinator) Run(ctx context.Context, command string, args ...string) (int, error) {
+	ts := &tombstone.Tombstone{
+		Graveyard: c.config.Graveyard,
+		Name:      c.config.Name,
+		PodUID:    c.config.PodUID,
+		Key:       c.config.TombstoneKey,
+		BirthDeps: c.config.BirthDeps,
+		DeathDeps: c.config.DeathDeps,
+	}
+	child := supervisor.New(ctx, command, args...)
+
+	shutdown := func() error {
+		// Skipped if not started.
+		err := child.ShutdownWithTimeout(c.config.GracePeriod)
+		// ShutdownWithTimeout doesn't block until timeout
+		if err != nil {
+			return errors.Wrapf(err, "failed to shutdown")
+		}
+		return nil
+	}
+
+	watchCtx, stopWatchers := context.WithCancel(ctx)
+	// stop watchers on exit, if not sooner
+	defer stopWatchers()
+
+	// watch for death deps early, so they can interrupt waiting for birth deps
+	if len(c.config.DeathDeps) > 0 {
+		err := c.WatchDeathDeps(watchCtx, func() error {
+			stopWatchers()
+			return shutdown()
+		})
+		if err != nil {
+			return c.abort(ctx, child, ts, err)
+		}
+	}
+
+	if len(c.config.BirthDeps) > 0 {
+		err := c.WaitForBirthDeps(watchCtx, nil)
+		if err != nil {
+			return c.abort(ctx, child, ts, err)
+		}
+	}
+
+	err := child.Start()
+	if err != nil {
+		return c.abort(ctx, child, ts, err)
+	}
+
+	err = c.recordBirth(ctx, ts)
+	if err != nil {
+		return c.abort(ctx, child, ts, err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	crash.Go(ctx, "shutdown on cancel", func() {
+		select {
+		case <-ctx.Done():
+			err2 := shutdown()
+			if err2 != nil {
+				event.ContextEventTrace(ctx).AddEvent(err2.Error())
+			}
+		case <-done:
+		}
+	})
+
+	code := WaitForExit(child)
+
+	err = c.recordDeath(ctx, ts, code)
+	if err != nil {
+		return code, err
+	}
+	return code, nil
+}
+
+// abort kills the child, if started, and records its death, so others may react on it
+func (c *Coordinator) abort(ctx context.Context, child *supervisor.Supervisor, ts *tombstone.Tombstone, err error) (int, error) {
+	const exitCode = 1
+
+	// Skipped if not started.
+	stopError := child.ShutdownNow()
+	if stopError != nil {
+		return exitCode, errors.Wrap(err, stopError.Error())
+	}
+
+	code := WaitForExit(child)
+
+	// Attempt to record death, if possible.
+	// Another process may be waiting for it.
+	recordDeathErr := c.recordDeath(ctx, ts, code)
+	if recordDeathErr != nil {
+		return exitCode, errors.Wrap(err, recordDeathErr.Error())
+	}
+	return exitCode, err
+}
+
+func (c *Coordinator) recordBirth(ctx context.Context, ts *tombstone.Tombstone) error {
+	ctx, cancel := c.tombstoneContext(ctx)
+	defer cancel()
+	return ts.RecordBirth(ctx)
+}
+
+func (c *Coordinator) recordDeath(ctx context.Context, ts *tombstone.Tombstone, exitCode int) error {
+	ctx, cancel := c.tombstoneContext(ctx)
+	defer cancel()
+	return ts.RecordDeath(ctx, exitCode)
+}
+
+// tombstoneContext returns context of tombstone write bounded by TombstoneTimeout, with event trace and retry policy of ctx.
+// It is not canceled with ctx, so death is recorded after Run ctx is canceled
+func (c *Coordinator) tombstoneContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	writeCtx := retry.WithPolicy(event.WithEventTrace(context.Background(), event.ContextEventTrace(ctx)), retry.ContextPolicy(ctx))
+	return context.WithTimeout(writeCtx, c.config.TombstoneTimeout)
+}
+
+// WaitForExit waits for the child to exit and returns the exit code.
+// The child killed by a signal exits with 128+signo, as reported by shells, e.g. 143 for SIGTERM
+func WaitForExit(child *supervisor.Supervisor) int {
+	var code int
+	err := child.Wait()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			code = exitErr.ProcessState.ExitCode()
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				code = 128 + int(status.Signal())
+			}
+		} else {
+			code = -1
+		}
+	} else {
+		code = 0
+	}
+	return code
+}
+
+// onGraveyardLoss returns an EventHandler applying GraveyardLossPolicy, when the graveyard is lost,
+// and passing other events to handler
+func (c *Coordinator) onGraveyardLoss(handler tombstone.EventHandler, onDeath func() error) tombstone.EventHandler {
+	return func(ctx context.Context, e fsnotify.Event) error {
+		if !tombstone.GraveyardLost(e, c.config.Graveyard) {
+			return handler(ctx, e)
+		}
+		event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Graveyard %s is lost", c.config.Graveyard))
+
+		entry := c.logger.WithField("graveyard", c.config.Graveyard).WithField("policy", c.config.GraveyardLossPolicy)
+		switch c.config.GraveyardLossPolicy {
+		case GraveyardLossIgnore:
+			return nil
+		case GraveyardLossDeath:
+			entry.Warn("graveyard is removed and can't be recreated, treating it as death of all death deps")
+			return onDeath()
+		default:
+			entry.Warn("graveyard is removed and can't be recreated, death deps are not watched anymore")
+			return nil
+		}
+	}
+}
+
+// OnDeathOfAny returns an EventHandler that executes the callback when any of
+// the deathDeps processes have died.
+func OnDeathOfAny(deathDeps []string, callback func() error) tombstone.EventHandler {
+	return onDeathOfAny("", nil, deathDeps, callback)
+}
+
+// onDeathOfAny is OnDeathOfAny ignoring tombstones of other pods than podUID, and not signed with key, if set
+func onDeathOfAny(podUID string, key []byte, deathDeps []string, callback func() error) tombstone.EventHandler {
+	deathDepSet := map[string]struct{}{}
+	for _, depName := range deathDeps {
+		deathDepSet[depName] = struct{}{}
+	}
+
+	return func(ctx context.Context, e fsnotify.Event) error {
+		if e.Op&fsnotify.Create != fsnotify.Create && e.Op&fsnotify.Write != fsnotify.Write {
+			// ignore other events
+			return nil
+		}
+		graveyard := filepath.Dir(e.Name)
+		name := filepath.Base(e.Name)
+
+		if _, ok := deathDepSet[name]; !ok {
+			// ignore other tombstones, delivered by watchers not filtering by name
+			return nil
+		}
+
+		event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Reading tombstone: %s", name))
+		ts, err := tombstone.ReadSigned(graveyard, name, key)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read tombstone %s", name)
+		}
+
+		if ts.FromOtherPod(podUID) {
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Ignore tombstone %s of pod %s", name, ts.PodUID))
+			return nil
+		}
+
+		if ts.Died == nil {
+			// still alive
+			return nil
+		}
+		event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("New death: %s", name))
+
+		return callback()
+	}
+}
@@ -0,0 +1,187 @@
+package kubexit
+
+import (
+	"context"
+	"io/ioutil"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/tombstone"
+)
+
+// Config is the coordination config of a single kubexit participant
+type Config struct {
+	// Name of the participant tombstone, must match the pod container name when it is a birth dep of others
+	Name      string
+	Graveyard string
+
+	BirthDeps          []string
+	DeathDeps          []string
+	BirthTimeout       time.Duration
+	BirthCheckInterval time.Duration
+	GracePeriod        time.Duration
+
+	// PodName and Namespace are required by container birth deps
+	PodName   string
+	Namespace string
+	// PodUID is stamped into the tombstone, tombstones of other pods are ignored
+	PodUID string
+	// TombstoneKey signs own tombstone and verifies others, unsigned tombstones are rejected
+	TombstoneKey []byte
+
+	// TombstoneTimeout bounds each tombstone write, so a hung graveyard can't block the exit forever
+	TombstoneTimeout time.Duration
+
+	// GraveyardLossPolicy applies when the graveyard is removed while watching death deps and can't be recreated:
+	// GraveyardLossIgnore, GraveyardLossWarn or GraveyardLossDeath
+	GraveyardLossPolicy string
+
+	// WatchdogStaleAfter is the duration without heartbeat, after which graveyard and pod watchers are recreated.
+	// Zero disables watchdog
+	WatchdogStaleAfter time.Duration
+}
+
+// DefaultConfig returns config with the same defaults as kubexit binary has
+func DefaultConfig(name string) Config {
+	return Config{
+		Name:               name,
+		Graveyard:          "/graveyard",
+		BirthTimeout:       30 * time.Second,
+		BirthCheckInterval: time.Second,
+		GracePeriod:        30 * time.Second,
+		TombstoneTimeout:   10 * time.Second,
+		WatchdogStaleAfter: time.Minute,
+
+		GraveyardLossPolicy: GraveyardLossWarn,
+	}
+}
+
+// GraveyardWatcher watches graveyard in background and calls handler on each event of tombstones with the names, until ctx is done
+type GraveyardWatcher func(ctx context.Context, graveyard string, names []string, handler tombstone.EventHandler) error
+
+// ContainerWatcher watches readiness of pod containers in background and calls setReady for each ready container, until ctx is done
+type ContainerWatcher func(ctx context.Context, namespace, podName string, containers []string, setReady func(name string)) error
+
+// ContainerStateWatcher watches readiness of pod containers in background and calls setState on each container status,
+// ready or not, until ctx is done
+type ContainerStateWatcher func(ctx context.Context, namespace, podName string, containers []string, setState func(name string, ready bool)) error
+
+type Option func(c *Coordinator)
+
+func WithGraveyard(graveyard string) Option {
+	return func(c *Coordinator) {
+		c.config.Graveyard = graveyard
+	}
+}
+
+func WithBirthDeps(deps ...string) Option {
+	return func(c *Coordinator) {
+		c.config.BirthDeps = append(c.config.BirthDeps, deps...)
+	}
+}
+
+func WithDeathDeps(deps ...string) Option {
+	return func(c *Coordinator) {
+		c.config.DeathDeps = append(c.config.DeathDeps, deps...)
+	}
+}
+
+func WithBirthTimeout(timeout time.Duration) Option {
+	return func(c *Coordinator) {
+		c.config.BirthTimeout = timeout
+	}
+}
+
+func WithBirthCheckInterval(interval time.Duration) Option {
+	return func(c *Coordinator) {
+		c.config.BirthCheckInterval = interval
+	}
+}
+
+func WithGracePeriod(gracePeriod time.Duration) Option {
+	return func(c *Coordinator) {
+		c.config.GracePeriod = gracePeriod
+	}
+}
+
+// WithPod sets the pod, whose containers are watched for container birth deps
+func WithPod(namespace, podName string) Option {
+	return func(c *Coordinator) {
+		c.config.Namespace = namespace
+		c.config.PodName = podName
+	}
+}
+
+// WithPodUID sets uid of the pod, stamped into the tombstone, so tombstones of other pods are ignored
+func WithPodUID(podUID string) Option {
+	return func(c *Coordinator) {
+		c.config.PodUID = podUID
+	}
+}
+
+// WithTombstoneKey enables tombstone signing with HMAC key, so a compromised participant sharing the graveyard can't forge deaths
+func WithTombstoneKey(key []byte) Option {
+	return func(c *Coordinator) {
+		c.config.TombstoneKey = key
+	}
+}
+
+func WithTombstoneTimeout(timeout time.Duration) Option {
+	return func(c *Coordinator) {
+		c.config.TombstoneTimeout = timeout
+	}
+}
+
+// WithGraveyardLossPolicy sets the policy applied when the graveyard is removed and can't be recreated
+func WithGraveyardLossPolicy(policy string) Option {
+	return func(c *Coordinator) {
+		c.config.GraveyardLossPolicy = policy
+	}
+}
+
+func WithWatchdog(staleAfter time.Duration) Option {
+	return func(c *Coordinator) {
+		c.config.WatchdogStaleAfter = staleAfter
+	}
+}
+
+// WithGraveyardWatcher replaces default fsnotify based graveyard watcher, e.g. with tombstone.Poll
+func WithGraveyardWatcher(watcher GraveyardWatcher) Option {
+	return func(c *Coordinator) {
+		c.watchGraveyard = watcher
+	}
+}
+
+// WithContainerWatcher replaces default kubernetes api based container watcher
+func WithContainerWatcher(watcher ContainerWatcher) Option {
+	return func(c *Coordinator) {
+		c.watchContainers = watcher
+	}
+}
+
+// WithContainerStateWatcher replaces default kubernetes api based container watcher of WatchBirthDeps
+func WithContainerStateWatcher(watcher ContainerStateWatcher) Option {
+	return func(c *Coordinator) {
+		c.watchContainerStates = watcher
+	}
+}
+
+// WithLogger sets logger for coordination summaries, nothing is logged by default
+func WithLogger(logger logrus.FieldLogger) Option {
+	return func(c *Coordinator) {
+		c.logger = logger
+	}
+}
+
+func withConfig(config Config) Option {
+	return func(c *Coordinator) {
+		c.config = config
+	}
+}
+
+func discardLogger() logrus.FieldLogger {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	return logger
+}
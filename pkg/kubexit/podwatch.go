@@ -0,0 +1,104 @@
+package kubexit
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/readiness"
+	"github.com/ispringtech/kubexit/pkg/watchdog"
+)
+
+// podWatch is the pod watch shared by WaitForBirthDeps and WatchBirthDeps, see Coordinator.WatchContainers.
+// It keeps the last readiness of containers, so a late subscriber gets the current state first
+type podWatch struct {
+	m           sync.Mutex
+	ready       map[string]bool
+	subscribers map[int]func(name string, ready bool)
+	nextID      int
+}
+
+func newPodWatch() *podWatch {
+	return &podWatch{
+		ready:       map[string]bool{},
+		subscribers: map[int]func(name string, ready bool){},
+	}
+}
+
+// setState is ContainerStateWatcher callback, passing the state to subscribers
+func (w *podWatch) setState(name string, ready bool) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.ready[name] = ready
+	for _, setState := range w.subscribers {
+		setState(name, ready)
+	}
+}
+
+// subscribe calls setState with known container states and then with each update, until ctx is done
+func (w *podWatch) subscribe(ctx context.Context, setState func(name string, ready bool)) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	for name, ready := range w.ready {
+		setState(name, ready)
+	}
+	id := w.nextID
+	w.nextID++
+	w.subscribers[id] = setState
+
+	crash.Go(ctx, "pod watch subscription", func() {
+		<-ctx.Done()
+		w.m.Lock()
+		defer w.m.Unlock()
+
+		delete(w.subscribers, id)
+	})
+}
+
+// WatchContainers starts a single pod watch of container birth deps until ctx is done, shared by WaitForBirthDeps and WatchBirthDeps called meanwhile,
+// so the pod is watched once for the whole life of the child, instead of a new list and watch by each of them.
+// No-op without container birth deps
+func (c *Coordinator) WatchContainers(ctx context.Context) error {
+	containers := readiness.Containers(c.birthDeps)
+	if len(containers) == 0 {
+		return nil
+	}
+	if c.watchContainerStates == nil {
+		return errors.New("watching container birth deps requires kubexit built with kubernetes support or WithContainerStateWatcher")
+	}
+
+	pod := newPodWatch()
+	err := watchdog.Run(ctx, "pod", c.config.WatchdogStaleAfter, func(ctx context.Context) error {
+		return c.watchContainerStates(ctx, c.config.Namespace, c.config.PodName, containers, pod.setState)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to watch pod")
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.pod = pod
+	crash.Go(ctx, "shared pod watch", func() {
+		<-ctx.Done()
+		c.m.Lock()
+		defer c.m.Unlock()
+
+		if c.pod == pod {
+			c.pod = nil
+		}
+	})
+	return nil
+}
+
+// sharedPodWatch returns the pod watch started by WatchContainers, nil if none
+func (c *Coordinator) sharedPodWatch() *podWatch {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.pod
+}
@@ -0,0 +1,99 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/event"
+)
+
+// Lock is an advisory exclusive lock of a file, shared by processes via graveyard volume.
+// The lock is released by the kernel when holding process dies, so it can't be left stale
+type Lock struct {
+	file *os.File
+}
+
+// Acquire takes exclusive lock of path, retrying every interval until it's released by another holder or ctx is done.
+// The holder name is written to the lock file for diagnostics
+func Acquire(ctx context.Context, path, holder string, interval time.Duration) (*Lock, error) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to open lock file: %v", err))
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastHolder string
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			_ = file.Close()
+			return nil, errors.WithStack(fmt.Errorf("failed to lock %s: %v", path, err))
+		}
+
+		// log changes only, so waiting doesn't flood the trace
+		if current := readHolder(path); current != lastHolder {
+			lastHolder = current
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Lock %s is held by %s", path, current))
+		}
+
+		select {
+		case <-ctx.Done():
+			_ = file.Close()
+			return nil, errors.Wrapf(ctx.Err(), "lock %s is held by %s", path, lastHolder)
+		case <-ticker.C:
+		}
+	}
+
+	event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Lock %s acquired", path))
+	err = writeHolder(file, holder)
+	if err != nil {
+		event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Failed to write lock holder: %v", err))
+	}
+	return &Lock{file: file}, nil
+}
+
+// Release unlocks the file, so the next waiting holder may take it
+func (l *Lock) Release() error {
+	// the file is kept, removing it would let waiters lock different inodes
+	_ = l.file.Truncate(0)
+	err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	_ = l.file.Close()
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to unlock: %v", err))
+	}
+	return nil
+}
+
+func writeHolder(file *os.File, holder string) error {
+	err := file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt([]byte(fmt.Sprintf("%s pid=%d\n", holder, os.Getpid())), 0)
+	return err
+}
+
+func readHolder(path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil || len(content) == 0 {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(content))
+}
@@ -0,0 +1,27 @@
+package loggerhook
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/ispringtech/kubexit/pkg/timestamp"
+)
+
+// TimestampHook converts entry time to UTC and sets epoch timestamp to Key field, according to Format
+type TimestampHook struct {
+	Format timestamp.Format
+	Key    string
+}
+
+func (h *TimestampHook) Fire(entry *logrus.Entry) error {
+	if h.Format.UTC {
+		entry.Time = entry.Time.UTC()
+	}
+	if h.Format.Layout == timestamp.LayoutEpochMillis {
+		entry.Data[h.Key] = h.Format.Value(entry.Time)
+	}
+	return nil
+}
+
+func (h *TimestampHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
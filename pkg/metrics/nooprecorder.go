@@ -0,0 +1,11 @@
+package metrics
+
+type noopRecorder struct{}
+
+func (n noopRecorder) SetGauge(string, string, float64, ...Label) {
+	//	Do nothing
+}
+
+func (n noopRecorder) AddCounter(string, string, float64, ...Label) {
+	//	Do nothing
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+func L(name, value string) Label {
+	return Label{Name: name, Value: value}
+}
+
+type Recorder interface {
+	SetGauge(name, help string, value float64, labels ...Label)
+	AddCounter(name, help string, delta float64, labels ...Label)
+}
+
+type registryKey struct{}
+
+func WithRecorder(ctx context.Context, r Recorder) context.Context {
+	return context.WithValue(ctx, registryKey{}, r)
+}
+
+func ContextRecorder(ctx context.Context) Recorder {
+	r, ok := ctx.Value(registryKey{}).(Recorder)
+	if !ok {
+		return &noopRecorder{}
+	}
+	return r
+}
+
+// Registry keeps the latest value of every metric and renders them
+// in the Prometheus text exposition format.
+type Registry struct {
+	m        sync.Mutex
+	families map[string]*family
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	samples map[string]float64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+func (r *Registry) SetGauge(name, help string, value float64, labels ...Label) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.family(name, help, "gauge").samples[formatLabels(labels)] = value
+}
+
+func (r *Registry) AddCounter(name, help string, delta float64, labels ...Label) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.family(name, help, "counter").samples[formatLabels(labels)] += delta
+}
+
+func (r *Registry) family(name, help, typ string) *family {
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{name: name, help: help, typ: typ, samples: map[string]float64{}}
+		r.families[name] = f
+	}
+	return f
+}
+
+// WriteTo writes all metrics sorted by name and labels, so output is stable between scrapes
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buffer strings.Builder
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(&buffer, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(&buffer, "# TYPE %s %s\n", f.name, f.typ)
+
+		keys := make([]string, 0, len(f.samples))
+		for key := range f.samples {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&buffer, "%s%s %g\n", f.name, key, f.samples[key])
+		}
+	}
+
+	n, err := io.WriteString(w, buffer.String())
+	return int64(n), err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = r.WriteTo(w)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, len(labels))
+	for _, label := range labels {
+		value := labelValueEscaper.Replace(label.Value)
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, label.Name, value))
+	}
+	sort.Strings(parts)
+	return "{" + strings.Join(parts, ",") + "}"
+}
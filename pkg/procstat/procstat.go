@@ -0,0 +1,13 @@
+package procstat
+
+// Stat is a resource usage sample of a process
+type Stat struct {
+	// RSS is resident set size in bytes
+	RSS uint64 `json:"rss_bytes"`
+	// CPUSeconds is total user and system CPU time consumed
+	CPUSeconds float64 `json:"cpu_seconds"`
+	// FDs is number of open file descriptors
+	FDs int `json:"fds"`
+	// Threads is number of threads
+	Threads int `json:"threads"`
+}
@@ -0,0 +1,66 @@
+//go:build linux
+// +build linux
+
+package procstat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// USER_HZ is 100 on all supported architectures
+const clockTicksPerSecond = 100
+
+// Sample reads resource usage of a process from /proc
+func Sample(pid int) (*Stat, error) {
+	raw, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to read process stat: %v", err))
+	}
+
+	// comm field may contain spaces, fields are counted after its closing parenthesis
+	stat := string(raw)
+	commEnd := strings.LastIndexByte(stat, ')')
+	if commEnd < 0 {
+		return nil, errors.Errorf("malformed process stat: %s", stat)
+	}
+	// fields[0] is field 3 (state) of proc(5)
+	fields := strings.Fields(stat[commEnd+1:])
+	if len(fields) < 22 {
+		return nil, errors.Errorf("malformed process stat: %s", stat)
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse utime")
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse stime")
+	}
+	threads, err := strconv.Atoi(fields[17])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse num_threads")
+	}
+	rssPages, err := strconv.ParseUint(fields[21], 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse rss")
+	}
+
+	fds, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to list process fds: %v", err))
+	}
+
+	return &Stat{
+		RSS:        rssPages * uint64(os.Getpagesize()),
+		CPUSeconds: float64(utime+stime) / clockTicksPerSecond,
+		FDs:        len(fds),
+		Threads:    threads,
+	}, nil
+}
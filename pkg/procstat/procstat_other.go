@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package procstat
+
+import "github.com/pkg/errors"
+
+// Sample reads resource usage of a process from /proc
+func Sample(int) (*Stat, error) {
+	return nil, errors.New("process sampling is supported on linux only")
+}
@@ -0,0 +1,135 @@
+package readiness
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// KindContainer is a pod container, ready by kubernetes container status
+	KindContainer = "container"
+	// KindTCP is ready when tcp connection to host:port succeeds
+	KindTCP = "tcp"
+	// KindDNS is ready when host resolves
+	KindDNS = "dns"
+	// KindHTTP is ready when GET of url responds with 2xx or 3xx status
+	KindHTTP = "http"
+	// KindExec is ready when command exits with zero code
+	KindExec = "exec"
+	// KindKubexit is ready when sibling kubexit status endpoint at host:port reports its child running
+	KindKubexit = "kubexit"
+	// KindAbsent is ready when tombstone of the participant is absent in graveyard or records its death
+	KindAbsent = "absent"
+)
+
+// Dep is a birth dependency parsed from its spec:
+//   - `name` - pod container
+//   - `tcp://host:port`
+//   - `dns://host`
+//   - `http://host:port/path` or `https://...`
+//   - `exec:command arg...`
+//   - `kubexit://host:port` - status endpoint of sibling kubexit
+//   - `!name` - participant is dead or absent
+type Dep struct {
+	// Name is the dependency spec, used as its name in matrix
+	Name string
+	Kind string
+	// Target is a container name, address, url or command depending on Kind
+	Target string
+}
+
+func ParseDep(spec string) (Dep, error) {
+	dep := Dep{Name: spec}
+
+	switch {
+	case strings.HasPrefix(spec, "!"):
+		dep.Kind = KindAbsent
+		dep.Target = strings.TrimSpace(strings.TrimPrefix(spec, "!"))
+		if dep.Target == "" || strings.ContainsAny(dep.Target, ":/") {
+			return Dep{}, errors.Errorf("invalid participant name in birth dep %s", spec)
+		}
+	case strings.HasPrefix(spec, "exec:"):
+		dep.Kind = KindExec
+		dep.Target = strings.TrimSpace(strings.TrimPrefix(spec, "exec:"))
+		if dep.Target == "" {
+			return Dep{}, errors.Errorf("empty command in birth dep %s", spec)
+		}
+	case strings.HasPrefix(spec, "tcp://"):
+		dep.Kind = KindTCP
+		dep.Target = strings.TrimPrefix(spec, "tcp://")
+		if dep.Target == "" {
+			return Dep{}, errors.Errorf("empty address in birth dep %s", spec)
+		}
+		err := checkHostPort(spec, dep.Target)
+		if err != nil {
+			return Dep{}, err
+		}
+	case strings.HasPrefix(spec, "dns://"):
+		dep.Kind = KindDNS
+		dep.Target = strings.Trim(strings.TrimSuffix(strings.TrimPrefix(spec, "dns://"), "/"), "[]")
+		if dep.Target == "" {
+			return Dep{}, errors.Errorf("empty host in birth dep %s", spec)
+		}
+	case strings.HasPrefix(spec, "kubexit://"):
+		dep.Kind = KindKubexit
+		dep.Target = strings.TrimSuffix(strings.TrimPrefix(spec, "kubexit://"), "/")
+		if dep.Target == "" {
+			return Dep{}, errors.Errorf("empty address in birth dep %s", spec)
+		}
+		err := checkHostPort(spec, dep.Target)
+		if err != nil {
+			return Dep{}, err
+		}
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		dep.Kind = KindHTTP
+		_, err := url.Parse(spec)
+		if err != nil {
+			return Dep{}, errors.Wrapf(err, "invalid url in birth dep %s", spec)
+		}
+		dep.Target = spec
+	default:
+		if strings.Contains(spec, "://") {
+			return Dep{}, errors.Errorf("unsupported birth dep %s", spec)
+		}
+		dep.Kind = KindContainer
+		dep.Target = spec
+	}
+
+	return dep, nil
+}
+
+// checkHostPort checks addr of the birth dep spec is host:port, IPv6 literals are bracketed, e.g. [::1]:8080
+func checkHostPort(spec, addr string) error {
+	_, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.Errorf("invalid address in birth dep %s, expected host:port with IPv6 literals in brackets: %v", spec, err)
+	}
+	return nil
+}
+
+// ParseDeps parses all specs
+func ParseDeps(specs []string) ([]Dep, error) {
+	deps := make([]Dep, 0, len(specs))
+	for _, spec := range specs {
+		dep, err := ParseDep(spec)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, dep)
+	}
+	return deps, nil
+}
+
+// Containers returns names of container dependencies
+func Containers(deps []Dep) []string {
+	var names []string
+	for _, dep := range deps {
+		if dep.Kind == KindContainer {
+			names = append(names, dep.Target)
+		}
+	}
+	return names
+}
@@ -0,0 +1,103 @@
+package readiness
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Matrix tracks readiness of a fixed set of dependencies.
+// A dependency is satisfied once it has been reported ready, later readiness changes are ignored.
+type Matrix struct {
+	m         sync.Mutex
+	satisfied map[string]*time.Time
+	onReady   func(name string)
+	done      chan struct{}
+}
+
+// NewMatrix creates a matrix of not yet satisfied dependencies.
+// onReady is called once for each dependency when it becomes satisfied.
+func NewMatrix(names []string, onReady func(name string)) *Matrix {
+	satisfied := make(map[string]*time.Time, len(names))
+	for _, name := range names {
+		satisfied[name] = nil
+	}
+
+	m := &Matrix{
+		satisfied: satisfied,
+		onReady:   onReady,
+		done:      make(chan struct{}),
+	}
+	if len(names) == 0 {
+		close(m.done)
+	}
+	return m
+}
+
+// SetReady marks the dependency satisfied. Unknown and already satisfied dependencies are ignored
+func (m *Matrix) SetReady(name string) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	at, ok := m.satisfied[name]
+	if !ok || at != nil {
+		return
+	}
+
+	now := time.Now()
+	m.satisfied[name] = &now
+	if m.onReady != nil {
+		m.onReady(name)
+	}
+
+	for _, at := range m.satisfied {
+		if at == nil {
+			return
+		}
+	}
+	close(m.done)
+}
+
+// Wait blocks until all dependencies are satisfied or ctx is done
+func (m *Matrix) Wait(ctx context.Context) error {
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		// all may be satisfied at the same moment
+		select {
+		case <-m.done:
+			return nil
+		default:
+			return ctx.Err()
+		}
+	}
+}
+
+// Pending returns names of not yet satisfied dependencies
+func (m *Matrix) Pending() []string {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	var pending []string
+	for name, at := range m.satisfied {
+		if at == nil {
+			pending = append(pending, name)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// Snapshot returns time each dependency was satisfied at, nil for pending ones
+func (m *Matrix) Snapshot() map[string]*time.Time {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	snapshot := make(map[string]*time.Time, len(m.satisfied))
+	for name, at := range m.satisfied {
+		snapshot[name] = at
+	}
+	return snapshot
+}
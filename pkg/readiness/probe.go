@@ -0,0 +1,245 @@
+package readiness
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/tombstone"
+)
+
+// kubexitStateRunning is the state reported by kubexit status endpoint, when its child is started and birth is recorded
+const kubexitStateRunning = "running"
+
+// Probe checks a dependency once, returns nil if it is ready
+type Probe func(ctx context.Context) error
+
+// Graveyard locates tombstones checked by absence dependencies
+type Graveyard struct {
+	Path string
+	// PodUID, if set, makes tombstones of other pods absent
+	PodUID string
+	// Key, if set, verifies tombstone signatures
+	Key []byte
+}
+
+// NewProbe returns probe for non-container dependency. Graveyard is used by absence dependencies
+func NewProbe(dep Dep, graveyard Graveyard) (Probe, error) {
+	switch dep.Kind {
+	case KindTCP:
+		return TCPProbe(dep.Target), nil
+	case KindDNS:
+		return DNSProbe(dep.Target), nil
+	case KindHTTP:
+		return HTTPProbe(dep.Target), nil
+	case KindExec:
+		return ExecProbe(strings.Fields(dep.Target)), nil
+	case KindKubexit:
+		return KubexitProbe(dep.Target), nil
+	case KindAbsent:
+		return AbsenceProbe(graveyard, dep.Target), nil
+	default:
+		return nil, errors.Errorf("no probe for %s dependency %s", dep.Kind, dep.Name)
+	}
+}
+
+// TCPProbe checks tcp connection to addr succeeds. The host is resolved by SetResolver options,
+// a failed lookup is DNSError
+func TCPProbe(addr string) Probe {
+	return func(ctx context.Context) error {
+		conn, err := currentResolver().dial(ctx, "tcp", addr)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return conn.Close()
+	}
+}
+
+// HTTPProbe checks GET of url responds with 2xx or 3xx status. The host is resolved as by TCPProbe
+func HTTPProbe(url string) Probe {
+	return func(ctx context.Context) error {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		response, err := currentResolver().client.Do(request)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode < 200 || response.StatusCode >= 400 {
+			return errors.Errorf("unexpected status %s", response.Status)
+		}
+		return nil
+	}
+}
+
+// KubexitProbe checks /status endpoint of sibling kubexit serving metrics on addr, ready when its child is running
+func KubexitProbe(addr string) Probe {
+	url := fmt.Sprintf("http://%s/status", addr)
+	return func(ctx context.Context) error {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		response, err := currentResolver().client.Do(request)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return errors.Errorf("unexpected status %s", response.Status)
+		}
+
+		var status struct {
+			Name  string `json:"name"`
+			State string `json:"state"`
+		}
+		err = json.NewDecoder(response.Body).Decode(&status)
+		if err != nil {
+			return errors.Wrap(err, "failed to decode kubexit status")
+		}
+		if status.State != kubexitStateRunning {
+			return errors.Errorf("%s is %s", status.Name, status.State)
+		}
+		return nil
+	}
+}
+
+// DNSProbe checks host resolves by SetResolver options, e.g. a headless service has ready endpoints
+func DNSProbe(host string) Probe {
+	return func(ctx context.Context) error {
+		_, err := currentResolver().lookup(ctx, host)
+		return errors.WithStack(err)
+	}
+}
+
+// PortFreeProbe checks that nobody listens on tcp port, by binding it on all interfaces of the network set by SetResolver.
+// Ports in TIME_WAIT state are free, as listeners reuse addresses
+func PortFreeProbe(port int) Probe {
+	addr := fmt.Sprintf(":%d", port)
+	return func(ctx context.Context) error {
+		var config net.ListenConfig
+		listener, err := config.Listen(ctx, network(), addr)
+		if err != nil {
+			return errors.WithStack(fmt.Errorf("port %d is busy: %v", port, err))
+		}
+		return listener.Close()
+	}
+}
+
+// AbsenceProbe checks that participant name has no tombstone in graveyard, it has died or its tombstone is expired,
+// e.g. the old process of blue/green handover has released its port or lock file.
+// Tombstones of other pods are considered absent, tombstones with invalid signature are never absent
+func AbsenceProbe(graveyard Graveyard, name string) Probe {
+	return func(ctx context.Context) error {
+		_, err := os.Stat(filepath.Join(graveyard.Path, name))
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+
+		ts, err := tombstone.ReadSigned(graveyard.Path, name, graveyard.Key)
+		if err != nil {
+			return err
+		}
+		if ts.FromOtherPod(graveyard.PodUID) {
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Tombstone %s is from pod %s, considered absent", name, ts.PodUID))
+			return nil
+		}
+		switch state := ts.State(time.Now()); state {
+		case tombstone.StateAlive, tombstone.StateUnborn:
+			return errors.Errorf("%s is %s", name, state)
+		case tombstone.StateUnknown:
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Tombstone %s is expired, considered absent", name))
+		}
+		return nil
+	}
+}
+
+func ExecProbe(argv []string) Probe {
+	return func(ctx context.Context) error {
+		// #nosec G204 command is configured by kubexit user
+		output, err := exec.CommandContext(ctx, argv[0], argv[1:]...).CombinedOutput()
+		if err != nil {
+			return errors.WithStack(fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output))))
+		}
+		return nil
+	}
+}
+
+// Poll runs probe every interval until it succeeds, then marks dependency ready in matrix.
+// Each probe attempt is limited by interval. Returns when ready or ctx is done.
+func Poll(ctx context.Context, name string, interval time.Duration, probe Probe, matrix *Matrix) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastErr string
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, interval)
+		err := probe(probeCtx)
+		cancel()
+		if err == nil {
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Birth dep ready: %s", name))
+			matrix.SetReady(name)
+			return
+		}
+		// log changes only, so waiting doesn't flood the trace
+		if err.Error() != lastErr {
+			lastErr = err.Error()
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Birth dep %s not ready: %v", name, err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Monitor runs probe every interval until ctx is done, and calls setState on each readiness change of the dependency,
+// which is ready initially, e.g. to check birth deps stay ready while the child runs
+func Monitor(ctx context.Context, name string, interval time.Duration, probe Probe, setState func(name string, ready bool)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ready := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		probeCtx, cancel := context.WithTimeout(ctx, interval)
+		err := probe(probeCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if (err == nil) == ready {
+			continue
+		}
+		ready = err == nil
+		if ready {
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Birth dep ready again: %s", name))
+		} else {
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Birth dep %s not ready: %v", name, err))
+		}
+		setState(name, ready)
+	}
+}
@@ -0,0 +1,183 @@
+package readiness
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ResolverOptions configure name resolution of tcp, http, kubexit and dns probes, see SetResolver
+type ResolverOptions struct {
+	// Nameserver is host:port of the DNS server queried instead of ones of resolv.conf, empty uses the system resolver
+	Nameserver string
+	// Timeout bounds each lookup, zero bounds it by the probe only
+	Timeout time.Duration
+	// CacheTTL keeps resolved addresses for the time, zero disables caching. Failed lookups are never cached,
+	// so a name is resolved again until pod DNS is ready
+	CacheTTL time.Duration
+	// Network restricts probes to IPv4 or IPv6 addresses with tcp4 or tcp6, e.g. on single-stack clusters,
+	// including ports bound by PortFreeProbe. Empty or tcp is dual-stack
+	Network string
+}
+
+// DNSError is a failed lookup of the probed host, so DNS not ready yet is told apart from the service down
+type DNSError struct {
+	Host string
+	Err  error
+}
+
+func (e *DNSError) Error() string {
+	return fmt.Sprintf("DNS not ready: %v", e.Err)
+}
+
+func (e *DNSError) Unwrap() error {
+	return e.Err
+}
+
+// defaultResolver is set by SetResolver
+var defaultResolver atomic.Value
+
+func init() {
+	defaultResolver.Store(newResolver(ResolverOptions{}))
+}
+
+// SetResolver sets name resolution of probes created before and after the call, the system resolver without cache by default
+func SetResolver(options ResolverOptions) {
+	defaultResolver.Store(newResolver(options))
+}
+
+func currentResolver() *resolver {
+	return defaultResolver.Load().(*resolver)
+}
+
+// network returns the network of probes set by SetResolver
+func network() string {
+	if network := currentResolver().options.Network; network != "" {
+		return network
+	}
+	return "tcp"
+}
+
+// resolver resolves hosts of probes with the cache, and dials and serves http requests by resolved addresses
+type resolver struct {
+	options  ResolverOptions
+	lookuper *net.Resolver
+	client   *http.Client
+
+	m     sync.Mutex
+	cache map[string]resolved
+}
+
+type resolved struct {
+	addrs   []string
+	expires time.Time
+}
+
+func newResolver(options ResolverOptions) *resolver {
+	r := &resolver{
+		options:  options,
+		lookuper: net.DefaultResolver,
+		cache:    map[string]resolved{},
+	}
+	if options.Nameserver != "" {
+		r.lookuper = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, options.Nameserver)
+			},
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = r.dial
+	r.client = &http.Client{Transport: transport}
+	return r
+}
+
+// lookup returns addresses of host, failing with DNSError
+func (r *resolver) lookup(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	now := time.Now()
+	r.m.Lock()
+	cached, ok := r.cache[host]
+	r.m.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.addrs, nil
+	}
+
+	if r.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.options.Timeout)
+		defer cancel()
+	}
+	addrs, err := r.lookuper.LookupHost(ctx, host)
+	if err == nil {
+		addrs = r.filter(addrs)
+		if len(addrs) == 0 {
+			// filtered out only, LookupHost fails on no addresses
+			err = fmt.Errorf("lookup %s: no addresses of network %s", host, r.options.Network)
+		}
+	}
+	if err != nil {
+		return nil, &DNSError{Host: host, Err: err}
+	}
+
+	if r.options.CacheTTL > 0 {
+		r.m.Lock()
+		r.cache[host] = resolved{addrs: addrs, expires: now.Add(r.options.CacheTTL)}
+		r.m.Unlock()
+	}
+	return addrs, nil
+}
+
+// filter returns addresses of the network family
+func (r *resolver) filter(addrs []string) []string {
+	if r.options.Network != "tcp4" && r.options.Network != "tcp6" {
+		return addrs
+	}
+	filtered := addrs[:0:0]
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		if (ip.To4() != nil) == (r.options.Network == "tcp4") {
+			filtered = append(filtered, addr)
+		}
+	}
+	return filtered
+}
+
+// dial connects to the first reachable address of the resolved host, IPv6 literals are bracketed in addr
+func (r *resolver) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := r.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "tcp" && r.options.Network != "" {
+		network = r.options.Network
+	}
+
+	var dialer net.Dialer
+	for _, ip := range addrs {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil || ctx.Err() != nil {
+			return conn, err
+		}
+	}
+	return nil, err
+}
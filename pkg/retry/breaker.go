@@ -0,0 +1,52 @@
+package retry
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBreakerOpen is returned by Breaker.Do without calling op, the target failed Threshold attempts in a row
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+// Breaker stops calls to a failing target, e.g. a collector webhook, after Threshold failed attempts in a row,
+// so retries of one dead target don't consume the time left for others. Once open it stays open, the zero Threshold never opens.
+// Permanent errors don't count, the target responds
+type Breaker struct {
+	Threshold int
+
+	m        sync.Mutex
+	failures int
+}
+
+// Do calls op as the package Do does, failing with ErrBreakerOpen instead of attempts made while the breaker is open
+func (b *Breaker) Do(ctx context.Context, name string, op func(ctx context.Context) error) error {
+	return Do(ctx, name, func(ctx context.Context) error {
+		if b.Open() {
+			return Permanent(ErrBreakerOpen)
+		}
+		err := op(ctx)
+		b.record(err)
+		return err
+	})
+}
+
+// Open reports whether calls are stopped
+func (b *Breaker) Open() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.Threshold > 0 && b.failures >= b.Threshold
+}
+
+func (b *Breaker) record(err error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if _, permanent := err.(permanentError); err == nil || permanent {
+		b.failures = 0
+		return
+	}
+	b.failures++
+}
@@ -0,0 +1,140 @@
+// Package retry retries failing operations with exponential backoff and jitter,
+// with a single policy shared by tombstone writes, pod watch reconnects, kubernetes clients and webhooks
+package retry
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/metrics"
+)
+
+// Policy of retries, the delay starts with Initial and doubles with each failed attempt up to Max
+type Policy struct {
+	Initial time.Duration
+	Max     time.Duration
+	// MaxElapsed bounds time since the first failure, after which the last error is returned. Zero is unbounded
+	MaxElapsed time.Duration
+	// Jitter randomizes each delay by up to the fraction of it, from 0 to 1,
+	// so participants failing together don't retry together
+	Jitter float64
+}
+
+// DefaultPolicy is used when no policy is set in the context
+var DefaultPolicy = Policy{
+	Initial:    100 * time.Millisecond,
+	Max:        5 * time.Second,
+	MaxElapsed: 30 * time.Second,
+	Jitter:     0.2,
+}
+
+// Validate checks the policy values are in range
+func (p Policy) Validate() error {
+	if p.Initial <= 0 || p.Max < p.Initial {
+		return errors.Errorf("invalid retry delays %s..%s, expected positive initial not greater than max", p.Initial, p.Max)
+	}
+	if p.MaxElapsed < 0 {
+		return errors.Errorf("invalid retry max elapsed %s", p.MaxElapsed)
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return errors.Errorf("invalid retry jitter %v, expected from 0 to 1", p.Jitter)
+	}
+	return nil
+}
+
+// Delay returns the delay after the failed attempt, counting from 1
+func (p Policy) Delay(attempt int) time.Duration {
+	delay := p.Initial
+	for i := 1; i < attempt && delay < p.Max; i++ {
+		delay *= 2
+	}
+	if delay > p.Max {
+		delay = p.Max
+	}
+	if p.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent marks err as not worth retrying, Do returns it right away
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+type policyKey struct{}
+
+func WithPolicy(ctx context.Context, p Policy) context.Context {
+	return context.WithValue(ctx, policyKey{}, p)
+}
+
+// ContextPolicy returns the policy set in ctx, DefaultPolicy otherwise
+func ContextPolicy(ctx context.Context) Policy {
+	p, ok := ctx.Value(policyKey{}).(Policy)
+	if !ok {
+		return DefaultPolicy
+	}
+	return p
+}
+
+// Do calls op with the policy of ctx until it succeeds, returns a Permanent error, MaxElapsed is exceeded or ctx is done,
+// and returns the last error of op. Retries are traced and counted by name
+func Do(ctx context.Context, name string, op func(ctx context.Context) error) error {
+	policy := ContextPolicy(ctx)
+
+	var firstFailure time.Time
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil {
+			return nil
+		}
+		if permanent, ok := err.(permanentError); ok {
+			return permanent.err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+
+		now := time.Now()
+		if firstFailure.IsZero() {
+			firstFailure = now
+		}
+		delay := policy.Delay(attempt)
+		if policy.MaxElapsed > 0 && now.Add(delay).Sub(firstFailure) > policy.MaxElapsed {
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Retry(%s): giving up after %d attempts: %v", name, attempt, err))
+			return err
+		}
+
+		event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Retry(%s): attempt %d failed, retrying in %s: %v", name, attempt, delay, err))
+		metrics.ContextRecorder(ctx).AddCounter(
+			"kubexit_retries_total",
+			"Number of retries of failed operations",
+			1,
+			metrics.L("operation", name),
+		)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
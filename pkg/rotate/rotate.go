@@ -0,0 +1,162 @@
+// Package rotate writes files rotated by size, e.g. to keep child output for postmortem within bounded disk space.
+package rotate
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Options of rotation
+type Options struct {
+	// MaxSize is the size of the file after which it's rotated, zero disables rotation
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept, as <path>.1 being the newest, older are removed
+	MaxBackups int
+	// Compress rotated files with gzip, as <path>.1.gz
+	Compress bool
+}
+
+// File is io.Writer appending to the file at path, rotating it when it exceeds MaxSize.
+// Writes are never split between files, so a write larger than MaxSize makes a file of its own
+type File struct {
+	path    string
+	options Options
+
+	m    sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Open opens the file at path for appending, creating it and its directory
+func Open(path string, options Options) (*File, error) {
+	f := &File{path: path, options: options}
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to open output file: %v", err))
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return errors.WithStack(fmt.Errorf("failed to stat output file: %v", err))
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.options.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.options.MaxSize {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the file, further writes fail
+func (f *File) Close() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// rotate shifts backups, moves the file to the first backup and opens a new file. Must be called with m held
+func (f *File) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ext := ""
+	if f.options.Compress {
+		ext = ".gz"
+	}
+	backup := func(i int) string {
+		return fmt.Sprintf("%s.%d%s", f.path, i, ext)
+	}
+
+	if f.options.MaxBackups <= 0 {
+		err = os.Remove(f.path)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+		return f.open()
+	}
+
+	// the oldest backup is overwritten by the shift
+	for i := f.options.MaxBackups - 1; i >= 1; i-- {
+		err = os.Rename(backup(i), backup(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+	}
+	if f.options.Compress {
+		err = compress(f.path, backup(1))
+	} else {
+		err = os.Rename(f.path, backup(1))
+	}
+	if err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// compress writes gzip of src to dst and removes src
+func compress(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err2 := gz.Close(); err == nil {
+		err = err2
+	}
+	if err2 := out.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to compress output file: %v", err))
+	}
+	return errors.WithStack(os.Remove(src))
+}
@@ -0,0 +1,69 @@
+// Package sdnotify receives systemd sd_notify messages of the child on NOTIFY_SOCKET
+package sdnotify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+)
+
+// EnvName is the env var passing the socket path to the child
+const EnvName = "NOTIFY_SOCKET"
+
+// maxMessageSize bounds a single datagram, systemd limits it the same way
+const maxMessageSize = 4096
+
+// Handler is called with variables of each received message, e.g. STOPPING=1 or WATCHDOG=1
+type Handler func(state map[string]string)
+
+// Listen receives sd_notify messages on unix datagram socket at path, until ctx is done
+func Listen(ctx context.Context, path string, handler Handler) error {
+	// socket left by previous run of the container
+	_ = os.Remove(path)
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to listen %s: %v", path, err))
+	}
+
+	crash.Go(ctx, "notify socket close", func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	})
+
+	crash.Go(ctx, "notify socket", func() {
+		buf := make([]byte, maxMessageSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Notify socket(%s): terminal error: %v", path, err))
+				}
+				return
+			}
+			handler(Parse(string(buf[:n])))
+		}
+	})
+
+	return nil
+}
+
+// Parse parses newline separated VARIABLE=value assignments of a message
+func Parse(message string) map[string]string {
+	state := map[string]string{}
+	for _, line := range strings.Split(message, "\n") {
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			continue
+		}
+		state[line[:i]] = line[i+1:]
+	}
+	return state
+}
@@ -0,0 +1,569 @@
+// Package simulate replays scripted dependency scenarios against kubexit coordination decisions on a fake clock,
+// with no child process, so timeouts and grace periods can be reviewed before deploying
+package simulate
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/readiness"
+	"github.com/ispringtech/kubexit/pkg/supervisor"
+)
+
+// Policies applied when a birth dep is lost while the child runs, matching KUBEXIT_BIRTH_DEPS_LOST_POLICY
+const (
+	DepLostLog      = "log"
+	DepLostSignal   = "signal"
+	DepLostRestart  = "restart"
+	DepLostShutdown = "shutdown"
+)
+
+// killedExitCode is the exit code of the child killed by SIGKILL, 128+signo as reported by kubexit
+const killedExitCode = 128 + int(syscall.SIGKILL)
+
+// Config is the part of participant config affecting coordination decisions
+type Config struct {
+	BirthDeps    []string
+	DeathDeps    []string
+	BirthTimeout time.Duration
+	GracePeriod  time.Duration
+
+	// BirthTerm, RunningTerm and DrainingTerm are SIGTERM policies by phase: supervisor.TermForward, TermGrace or TermCancel
+	BirthTerm    string
+	RunningTerm  string
+	DrainingTerm string
+
+	// RestartExitCode of the child restarts it in place, zero disables.
+	// RestartLimit bounds restarts by kubexit, but not with RestartExitCode, zero is unlimited
+	RestartExitCode int
+	RestartLimit    int
+
+	// BirthDepsLostPolicy is one of DepLost policies, empty disables checking birth deps after birth
+	BirthDepsLostPolicy      string
+	BirthDepsLostAfter       time.Duration
+	BirthDepsLostSignal      syscall.Signal
+	BirthDepsRecoveredAfter  time.Duration
+	BirthDepsRecoveredSignal syscall.Signal
+}
+
+// Duration is time.Duration read from string, e.g. 1m30s
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Scenario is a scripted sequence of steps, each one setting exactly one of the step fields
+type Scenario struct {
+	// Config is the config document of the participant in KUBEXIT_CONFIG_JSON format, applied by the caller
+	Config json.RawMessage `json:"config,omitempty"`
+	Child  Child           `json:"child"`
+	Steps  []Step          `json:"steps"`
+}
+
+// Child models the child process reaction to SIGTERM
+type Child struct {
+	// StopAfter is the time the child takes to exit on SIGTERM, the child ignoring SIGTERM is killed when unset
+	StopAfter Duration `json:"stop_after"`
+	// ExitCode of the child stopped by SIGTERM
+	ExitCode int `json:"exit_code"`
+}
+
+type Step struct {
+	At Duration `json:"at"`
+	// Ready birth dep becomes ready
+	Ready string `json:"ready,omitempty"`
+	// Lost birth dep becomes not ready
+	Lost string `json:"lost,omitempty"`
+	// Death of a participant, making absence birth deps on it ready
+	Death string `json:"death,omitempty"`
+	// Signal received by kubexit, e.g. SIGTERM
+	Signal string `json:"signal,omitempty"`
+	// Exit of the child by itself with the code
+	Exit *int `json:"exit,omitempty"`
+}
+
+// Validate checks the steps are ordered by time and set exactly one field
+func (s *Scenario) Validate() error {
+	var last Duration
+	for i, step := range s.Steps {
+		if step.At < last {
+			return errors.Errorf("step %d at %s is before the previous one", i+1, time.Duration(step.At))
+		}
+		last = step.At
+
+		set := 0
+		for _, value := range []string{step.Ready, step.Lost, step.Death, step.Signal} {
+			if value != "" {
+				set++
+			}
+		}
+		if step.Exit != nil {
+			set++
+		}
+		if set != 1 {
+			return errors.Errorf("step %d must set one of: ready, lost, death, signal, exit", i+1)
+		}
+	}
+	return nil
+}
+
+// Decision is a coordination decision made at the time since kubexit start
+type Decision struct {
+	At   time.Duration
+	What string
+}
+
+// Result of the simulation
+type Result struct {
+	Decisions []Decision
+	// Exited is false when the child still runs after the last step
+	Exited   bool
+	ExitCode int
+	// Duration is the time of the exit, or of the last decision while the child runs
+	Duration time.Duration
+	Restarts int
+}
+
+// Run simulates coordination of the scenario with the config
+func Run(config Config, scenario Scenario) (*Result, error) {
+	err := scenario.Validate()
+	if err != nil {
+		return nil, err
+	}
+	deps, err := readiness.ParseDeps(config.BirthDeps)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &simulation{
+		config:    config,
+		child:     scenario.Child,
+		deps:      deps,
+		ready:     map[string]bool{},
+		losses:    map[string]*depLoss{},
+		deathDeps: map[string]bool{},
+		result:    &Result{},
+	}
+	for _, dep := range deps {
+		// absent participant is ready until it's born
+		s.ready[dep.Name] = dep.Kind == readiness.KindAbsent
+	}
+	for _, name := range config.DeathDeps {
+		s.deathDeps[name] = true
+	}
+	for _, step := range scenario.Steps {
+		step := step
+		s.after(time.Duration(step.At), func() {
+			s.step(step)
+		})
+	}
+
+	s.start()
+	for s.phase != phaseExited {
+		t := s.next()
+		if t == nil {
+			break
+		}
+		s.now = t.at
+		t.fire()
+	}
+	s.result.Duration = s.now
+	return s.result, nil
+}
+
+const (
+	phaseBirth    = "birth"
+	phaseRunning  = "running"
+	phaseDraining = "draining"
+	phaseExited   = "exited"
+)
+
+type simulation struct {
+	config Config
+	child  Child
+	deps   []readiness.Dep
+
+	now    time.Duration
+	timers []*timer
+	seq    int
+
+	phase     string
+	ready     map[string]bool
+	deathDeps map[string]bool
+	// termReceived is SIGTERM received while waiting for birth deps, forwarded to the child on start
+	termReceived bool
+
+	birthTimer    *timer
+	stopTimer     *timer
+	graceTimer    *timer
+	restartReason string
+	// limitedRestarts count toward RestartLimit, restarts with RestartExitCode don't
+	limitedRestarts int
+	losses          map[string]*depLoss
+
+	result *Result
+}
+
+// timer fires at the time, timers due at the same time fire in order of creation
+type timer struct {
+	at      time.Duration
+	seq     int
+	fire    func()
+	stopped bool
+}
+
+func (t *timer) stop() {
+	if t != nil {
+		t.stopped = true
+	}
+}
+
+// depLoss mirrors hysteresis of kubexit birth deps monitor
+type depLoss struct {
+	down *timer
+	up   *timer
+	lost bool
+}
+
+func (s *simulation) after(d time.Duration, fire func()) *timer {
+	s.seq++
+	t := &timer{at: s.now + d, seq: s.seq, fire: fire}
+	s.timers = append(s.timers, t)
+	return t
+}
+
+func (s *simulation) next() *timer {
+	sort.SliceStable(s.timers, func(i, j int) bool {
+		if s.timers[i].at != s.timers[j].at {
+			return s.timers[i].at < s.timers[j].at
+		}
+		return s.timers[i].seq < s.timers[j].seq
+	})
+	for len(s.timers) > 0 {
+		t := s.timers[0]
+		s.timers = s.timers[1:]
+		if !t.stopped {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *simulation) decide(format string, args ...interface{}) {
+	s.result.Decisions = append(s.result.Decisions, Decision{At: s.now, What: fmt.Sprintf(format, args...)})
+}
+
+func (s *simulation) start() {
+	s.phase = phaseBirth
+	if len(s.deps) == 0 {
+		s.startChild()
+		return
+	}
+	s.decide("waiting for birth deps: %s, timeout %s", strings.Join(s.pending(), ", "), s.config.BirthTimeout)
+	s.birthTimer = s.after(s.config.BirthTimeout, func() {
+		s.decide("birth timeout elapsed, pending: %s", strings.Join(s.pending(), ", "))
+		s.exit(1)
+	})
+	s.checkBirth()
+}
+
+func (s *simulation) pending() []string {
+	var names []string
+	for _, dep := range s.deps {
+		if !s.ready[dep.Name] {
+			names = append(names, dep.Name)
+		}
+	}
+	return names
+}
+
+func (s *simulation) checkBirth() {
+	if len(s.pending()) > 0 {
+		return
+	}
+	s.birthTimer.stop()
+	s.decide("all birth deps ready")
+	s.startChild()
+}
+
+func (s *simulation) startChild() {
+	s.phase = phaseRunning
+	s.decide("child started, birth recorded")
+	if s.termReceived {
+		s.termReceived = false
+		s.decide("forwarding SIGTERM received while waiting for birth deps")
+		s.terminateChild()
+	}
+}
+
+func (s *simulation) step(step Step) {
+	switch {
+	case step.Ready != "":
+		s.setReady(step.Ready, true)
+	case step.Lost != "":
+		s.setReady(step.Lost, false)
+	case step.Death != "":
+		s.death(step.Death)
+	case step.Signal != "":
+		s.signal(step.Signal)
+	case step.Exit != nil:
+		if s.phase != phaseRunning && s.phase != phaseDraining {
+			s.decide("child exit %d ignored, child is not running", *step.Exit)
+			return
+		}
+		s.decide("child exited by itself with %d", *step.Exit)
+		s.childExited(*step.Exit)
+	}
+}
+
+func (s *simulation) isBirthDep(name string) bool {
+	_, ok := s.ready[name]
+	return ok
+}
+
+func (s *simulation) setReady(name string, ready bool) {
+	if !s.isBirthDep(name) {
+		s.decide("%s is not a birth dep, ignored", name)
+		return
+	}
+	s.ready[name] = ready
+	state := "ready"
+	if !ready {
+		state = "not ready"
+	}
+
+	switch s.phase {
+	case phaseBirth:
+		s.decide("birth dep %s is %s", name, state)
+		s.checkBirth()
+	case phaseRunning, phaseDraining:
+		if s.config.BirthDepsLostPolicy == "" {
+			s.decide("birth dep %s is %s, not monitored after birth", name, state)
+			return
+		}
+		s.setLossState(name, ready)
+	}
+}
+
+func (s *simulation) death(name string) {
+	for _, dep := range s.deps {
+		if dep.Name == name && dep.Kind == readiness.KindAbsent {
+			s.setReady(name, true)
+		}
+	}
+	if !s.deathDeps[name] {
+		if !s.isBirthDep(name) {
+			s.decide("%s died, not a dep, ignored", name)
+		}
+		return
+	}
+	s.decide("death dep %s died", name)
+	s.shutdown("death dep")
+}
+
+func (s *simulation) signal(name string) {
+	if strings.TrimPrefix(strings.ToUpper(name), "SIG") != "TERM" {
+		if s.phase == phaseRunning || s.phase == phaseDraining {
+			s.decide("forwarding %s to child", name)
+		} else {
+			s.decide("%s ignored, child is not running", name)
+		}
+		return
+	}
+
+	switch s.phase {
+	case phaseBirth:
+		s.decide("SIGTERM while waiting for birth deps, policy %s", s.config.BirthTerm)
+		switch s.config.BirthTerm {
+		case supervisor.TermCancel:
+			s.decide("birth interrupted")
+			s.exit(1)
+		case supervisor.TermGrace:
+			s.termReceived = true
+			s.after(s.config.GracePeriod, func() {
+				if s.phase != phaseBirth {
+					return
+				}
+				s.decide("grace period %s elapsed, birth interrupted", s.config.GracePeriod)
+				s.exit(1)
+			})
+		default:
+			s.termReceived = true
+		}
+	case phaseRunning:
+		s.decide("SIGTERM while running, policy %s", s.config.RunningTerm)
+		s.applyTerm(s.config.RunningTerm, false)
+	case phaseDraining:
+		s.decide("SIGTERM while draining, policy %s", s.config.DrainingTerm)
+		s.applyTerm(s.config.DrainingTerm, true)
+	}
+}
+
+// applyTerm mirrors supervisor SIGTERM policies
+func (s *simulation) applyTerm(policy string, draining bool) {
+	switch policy {
+	case supervisor.TermCancel:
+		s.kill()
+	case supervisor.TermGrace:
+		if !draining {
+			s.shutdown("sigterm")
+			return
+		}
+		s.terminateChild()
+		if s.graceTimer != nil {
+			s.graceTimer.stop()
+			s.decide("grace period restarted, killing child after %s", s.config.GracePeriod)
+			s.graceTimer = s.after(s.config.GracePeriod, s.graceElapsed)
+		}
+	default:
+		s.terminateChild()
+	}
+}
+
+// shutdown starts graceful shutdown of the child, killing it after grace period
+func (s *simulation) shutdown(trigger string) {
+	switch s.phase {
+	case phaseBirth:
+		s.decide("shutdown by %s skipped, child is not started", trigger)
+		return
+	case phaseDraining:
+		s.decide("shutdown by %s skipped, already draining", trigger)
+		return
+	case phaseExited:
+		return
+	}
+	s.phase = phaseDraining
+	s.decide("graceful shutdown by %s, killing child after %s", trigger, s.config.GracePeriod)
+	s.terminateChild()
+	s.graceTimer = s.after(s.config.GracePeriod, s.graceElapsed)
+}
+
+func (s *simulation) graceElapsed() {
+	s.decide("grace period elapsed")
+	s.kill()
+}
+
+// terminateChild sends SIGTERM to the child, which exits after StopAfter, if set
+func (s *simulation) terminateChild() {
+	s.decide("SIGTERM sent to child")
+	if s.child.StopAfter == 0 || s.stopTimer != nil {
+		return
+	}
+	s.stopTimer = s.after(time.Duration(s.child.StopAfter), func() {
+		s.decide("child stopped on SIGTERM with %d", s.child.ExitCode)
+		s.childExited(s.child.ExitCode)
+	})
+}
+
+func (s *simulation) kill() {
+	s.decide("child killed")
+	s.childExited(killedExitCode)
+}
+
+func (s *simulation) childExited(code int) {
+	s.stopTimer.stop()
+	s.stopTimer = nil
+	s.graceTimer.stop()
+	s.graceTimer = nil
+
+	// restart requested by kubexit takes precedence, as it killed the child
+	reason := s.restartReason
+	s.restartReason = ""
+	if reason == "" && s.config.RestartExitCode != 0 && code == s.config.RestartExitCode && s.phase == phaseRunning {
+		reason = "exit_code"
+	}
+	if reason != "" && s.phase == phaseRunning {
+		limited := reason != "exit_code"
+		if limited && s.config.RestartLimit > 0 && s.limitedRestarts >= s.config.RestartLimit {
+			s.decide("child exhausted %d restarts", s.config.RestartLimit)
+		} else {
+			if limited {
+				s.limitedRestarts++
+			}
+			s.result.Restarts++
+			s.decide("child restarted in place, reason %s", reason)
+			return
+		}
+	}
+	s.exit(code)
+}
+
+func (s *simulation) exit(code int) {
+	for _, loss := range s.losses {
+		loss.down.stop()
+		loss.up.stop()
+	}
+	s.phase = phaseExited
+	s.result.Exited = true
+	s.result.ExitCode = code
+	s.decide("death recorded, exit code %d", code)
+}
+
+// setLossState mirrors hysteresis of kubexit birth deps monitor
+func (s *simulation) setLossState(name string, ready bool) {
+	loss := s.losses[name]
+	switch {
+	case ready && loss != nil && loss.up == nil:
+		s.decide("birth dep %s is ready, recovered after %s", name, s.config.BirthDepsRecoveredAfter)
+		loss.up = s.after(s.config.BirthDepsRecoveredAfter, func() {
+			s.recover(name, loss)
+		})
+	case !ready && loss == nil:
+		s.decide("birth dep %s is not ready, applying %s policy after %s", name, s.config.BirthDepsLostPolicy, s.config.BirthDepsLostAfter)
+		loss = &depLoss{}
+		loss.down = s.after(s.config.BirthDepsLostAfter, func() {
+			loss.down = nil
+			loss.lost = true
+			s.lose(name)
+		})
+		s.losses[name] = loss
+	case !ready && loss.up != nil:
+		s.decide("birth dep %s is not ready again before recovery", name)
+		loss.up.stop()
+		loss.up = nil
+	}
+}
+
+func (s *simulation) recover(name string, loss *depLoss) {
+	loss.down.stop()
+	delete(s.losses, name)
+	s.decide("birth dep %s recovered", name)
+	if loss.lost && s.config.BirthDepsRecoveredSignal != 0 && s.phase == phaseRunning {
+		s.decide("%s sent to child on recovery", s.config.BirthDepsRecoveredSignal)
+	}
+}
+
+func (s *simulation) lose(name string) {
+	if s.phase != phaseRunning {
+		return
+	}
+	s.decide("birth dep %s lost, policy %s", name, s.config.BirthDepsLostPolicy)
+	switch s.config.BirthDepsLostPolicy {
+	case DepLostSignal:
+		s.decide("%s sent to child", s.config.BirthDepsLostSignal)
+	case DepLostRestart:
+		if s.restartReason == "" {
+			s.restartReason = "birth_dep_lost"
+		}
+		s.kill()
+	case DepLostShutdown:
+		s.shutdown("birth dep loss")
+	}
+}
@@ -0,0 +1,37 @@
+package supervisor
+
+import (
+	"time"
+)
+
+// Clock runs timers of graceful shutdown: the grace period, shutdown ack and escalation, and restart backoff. Real time by default,
+// replaced by a fake one in tests, e.g. wrapping testkit.Clock
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine, or synchronously by fake clocks, after d
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer returned by Clock.AfterFunc
+type Timer interface {
+	// Stop prevents the timer from firing, returns false when it has fired or been stopped already
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// SetClock replaces the real clock of shutdown timers. Must be called before Start
+func (s *Supervisor) SetClock(clock Clock) {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	s.clock = clock
+}
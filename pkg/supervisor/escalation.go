@@ -0,0 +1,62 @@
+package supervisor
+
+import (
+	"fmt"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+)
+
+// EscalationStep of graceful shutdown sends Signal to the child still running After shutdown started
+type EscalationStep struct {
+	Signal syscall.Signal
+	After  time.Duration
+}
+
+// SetShutdownEscalation sets signals sent to the child during graceful shutdown after the shutdown signal,
+// e.g. SIGINT after 10s for staged shutdown of databases. The child is still killed when the grace period elapses.
+// Must be called before Start
+func (s *Supervisor) SetShutdownEscalation(steps []EscalationStep) {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	s.escalation = append([]EscalationStep(nil), steps...)
+	sort.SliceStable(s.escalation, func(i, j int) bool {
+		return s.escalation[i].After < s.escalation[j].After
+	})
+}
+
+// scheduleEscalation starts timers of escalation steps. Must be called with startStopLock held
+func (s *Supervisor) scheduleEscalation() {
+	for _, step := range s.escalation {
+		step := step
+		s.escalationTimers = append(s.escalationTimers, s.clock.AfterFunc(step.After, crash.Func(s.context, "shutdown escalation timer", func() {
+			s.escalate(step)
+		})))
+	}
+}
+
+func (s *Supervisor) escalate(step EscalationStep) {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	if !s.isRunning() || s.escalationTimers == nil {
+		return
+	}
+	event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Shutdown escalation: child is running after %s, sending %s", step.After, step.Signal))
+	err := s.signal(step.Signal)
+	if err != nil {
+		event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Failed to send %s: %v", step.Signal, err))
+	}
+}
+
+// stopEscalation stops pending escalation steps. Must be called with startStopLock held
+func (s *Supervisor) stopEscalation() {
+	for _, timer := range s.escalationTimers {
+		timer.Stop()
+	}
+	s.escalationTimers = nil
+}
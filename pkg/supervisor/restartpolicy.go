@@ -0,0 +1,123 @@
+package supervisor
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/audit"
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/retry"
+)
+
+// Modes of RestartPolicy
+const (
+	// RestartNever leaves the exited child dead. Default
+	RestartNever = "never"
+	// RestartOnFailure restarts the child exited with non-zero code or killed
+	RestartOnFailure = "on-failure"
+	// RestartAlways restarts the exited child regardless of the code
+	RestartAlways = "always"
+)
+
+// ErrRetriesExhausted is the cause of RestartByPolicy error, when the child is not restarted as MaxRetries is reached
+var ErrRetriesExhausted = errors.New("restart policy retries exhausted")
+
+// RestartPolicy of the exited child, applied by RestartByPolicy
+type RestartPolicy struct {
+	Mode string
+	// MaxRetries bounds consecutive restarts, zero is unlimited
+	MaxRetries int
+	// Backoff delays consecutive restarts, MaxElapsed is ignored.
+	// Restarts are not consecutive, when the child ran for longer than Backoff.Max
+	Backoff retry.Policy
+}
+
+// SetRestartPolicy sets restarts of the exited child by RestartByPolicy
+func (s *Supervisor) SetRestartPolicy(policy RestartPolicy) {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	s.restartPolicy = policy
+}
+
+// RestartByPolicy restarts the child exited with the code, when the restart policy allows it, after backoff delay.
+// Returns false without restart when the policy doesn't allow it or shutdown is requested while waiting,
+// e.g. by SIGTERM or ShutdownWithTimeout, and ErrRetriesExhausted when retries are exhausted
+func (s *Supervisor) RestartByPolicy(code int) (bool, error) {
+	s.startStopLock.Lock()
+	policy := s.restartPolicy
+	if s.isRunning() {
+		s.startStopLock.Unlock()
+		return false, errors.New("child is running")
+	}
+	if policy.Mode != RestartAlways && (policy.Mode != RestartOnFailure || code == 0) {
+		s.startStopLock.Unlock()
+		return false, nil
+	}
+	if s.stoppedAt.Sub(s.startedAt) > policy.Backoff.Max {
+		// the child was healthy for a while, the crash is not a loop
+		s.policyRestarts = 0
+	}
+	if policy.MaxRetries > 0 && s.policyRestarts >= policy.MaxRetries {
+		s.startStopLock.Unlock()
+		event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Restart policy %s: exhausted %d retries", policy.Mode, policy.MaxRetries))
+		return false, errors.Wrapf(ErrRetriesExhausted, "child exited with code %d after %d restarts", code, s.policyRestarts)
+	}
+	s.policyRestarts++
+	delay := policy.Backoff.Delay(s.policyRestarts)
+	canceled := make(chan struct{})
+	s.restartCanceled = canceled
+	s.startStopLock.Unlock()
+
+	defer func() {
+		s.startStopLock.Lock()
+		s.restartCanceled = nil
+		s.startStopLock.Unlock()
+	}()
+
+	event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Restart policy %s: exit code %d, restarting in %s", policy.Mode, code, delay))
+
+	// signal propagation is stopped with the child, so SIGTERM is handled here while waiting
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	elapsed := make(chan struct{})
+	timer := s.clock.AfterFunc(delay, crash.Func(s.context, "restart backoff timer", func() {
+		close(elapsed)
+	}))
+	defer timer.Stop()
+
+	select {
+	case <-sigCh:
+		event.ContextEventTrace(s.context).AddEvent("Restart policy: SIGTERM received while waiting, restart canceled")
+		audit.ContextLog(s.context).Append(audit.Record{Event: audit.EventSignalReceived, Signal: syscall.SIGTERM.String()})
+		s.startStopLock.Lock()
+		s.terminated = true
+		s.startStopLock.Unlock()
+		return false, nil
+	case <-canceled:
+		event.ContextEventTrace(s.context).AddEvent("Restart policy: shutdown requested while waiting, restart canceled")
+		return false, nil
+	case <-s.context.Done():
+		return false, nil
+	case <-elapsed:
+	}
+
+	return true, s.Restart()
+}
+
+// cancelRestart cancels restart by policy waiting for backoff, so the child stays dead. Must be called with startStopLock held
+func (s *Supervisor) cancelRestart() {
+	if s.restartCanceled == nil {
+		return
+	}
+	close(s.restartCanceled)
+	s.restartCanceled = nil
+	s.terminated = true
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -12,6 +13,9 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/ispringtech/kubexit/pkg/audit"
+	"github.com/ispringtech/kubexit/pkg/chaos"
+	"github.com/ispringtech/kubexit/pkg/crash"
 	"github.com/ispringtech/kubexit/pkg/event"
 
 	"github.com/pkg/errors"
@@ -22,9 +26,65 @@ type Supervisor struct {
 	cmd           *exec.Cmd
 	sigCh         chan os.Signal
 	startStopLock sync.Mutex
-	shutdownTimer *time.Timer
+	clock         Clock
+	shutdownTimer Timer
+	// state is set by Wait when the current cmd exits, as cmd.ProcessState is written by cmd.Wait without startStopLock
+	state *os.ProcessState
+	// shutdownStarted and shutdownDeadline are set when graceful shutdown starts
+	shutdownStarted  time.Time
+	shutdownDeadline time.Time
+	// skippedSignals are handled by kubexit itself and not propagated to the child
+	skippedSignals map[os.Signal]struct{}
+	// forwardedSignals, if set, are the only signals propagated to the child, besides SIGTERM
+	forwardedSignals map[os.Signal]struct{}
+	graceExtender    GraceExtender
+	paused           bool
+	// startedAt and stoppedAt are set by Start and Wait
+	startedAt time.Time
+	stoppedAt time.Time
+	// ackWindow is the time for the child to acknowledge SIGTERM of graceful shutdown, zero disables waiting for ack.
+	// ackRetries is the number of SIGTERM retries without ack before the child is killed
+	ackWindow  time.Duration
+	ackRetries int
+	ackTimer   Timer
+	ackLeft    int
+	// runningTerm and drainingTerm are TermPolicy of SIGTERM received while running and draining
+	runningTerm  string
+	drainingTerm string
+	gracePeriod  time.Duration
+	// terminated is set when SIGTERM is received from outside
+	terminated bool
+	// shutdownSignal is sent to the child for graceful shutdown instead of SIGTERM, zero is SIGTERM
+	shutdownSignal syscall.Signal
+	// escalation is sent to the child during graceful shutdown by escalationTimers, sorted by delay
+	escalation       []EscalationStep
+	escalationTimers []Timer
+	// restarts is the number of Restart calls
+	restarts int
+	// restartPolicy is applied by RestartByPolicy, policyRestarts counts consecutive restarts by it
+	restartPolicy  RestartPolicy
+	policyRestarts int
+	// restartCanceled is closed to cancel restart by policy waiting for backoff
+	restartCanceled chan struct{}
+	// shutdownHook is called once by runShutdownHook
+	shutdownHook     func()
+	shutdownHookOnce sync.Once
 }
 
+// Policies of SIGTERM handling, see SetTermPolicy
+const (
+	// TermForward forwards SIGTERM to the child only
+	TermForward = "forward"
+	// TermGrace forwards SIGTERM and starts the grace timer killing the child, restarts it while draining
+	TermGrace = "grace"
+	// TermCancel kills the child immediately
+	TermCancel = "cancel"
+)
+
+// GraceExtender is called when shutdown grace period elapses, with time elapsed since shutdown started,
+// and returns for how long to extend the grace period. Zero means kill the child now
+type GraceExtender func(elapsed time.Duration) time.Duration
+
 func New(ctx context.Context, name string, args ...string) *Supervisor {
 	// Don't use CommandContext.
 	// We want the child process to exit on its own so we can return its exit code.
@@ -35,11 +95,162 @@ func New(ctx context.Context, name string, args ...string) *Supervisor {
 	cmd.Stderr = os.Stderr
 	cmd.Env = os.Environ()
 	return &Supervisor{
-		context: ctx,
-		cmd:     cmd,
+		context:        ctx,
+		cmd:            cmd,
+		clock:          realClock{},
+		skippedSignals: map[os.Signal]struct{}{},
+	}
+}
+
+// SkipSignals excludes signals from propagation to the child. Must be called before Start
+func (s *Supervisor) SkipSignals(signals ...os.Signal) {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	for _, sig := range signals {
+		s.skippedSignals[sig] = struct{}{}
 	}
 }
 
+// ForwardSignals restricts propagation to the child to the signals, others are handled by kubexit as skipped ones.
+// SIGTERM is handled by the term policy regardless. Must be called before Start
+func (s *Supervisor) ForwardSignals(signals ...os.Signal) {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	s.forwardedSignals = map[os.Signal]struct{}{}
+	for _, sig := range signals {
+		s.forwardedSignals[sig] = struct{}{}
+	}
+}
+
+// skipped reports whether the received signal is not propagated to the child
+func (s *Supervisor) skipped(sig os.Signal) bool {
+	if _, ok := s.skippedSignals[sig]; ok {
+		return true
+	}
+	if s.forwardedSignals == nil || sig == syscall.SIGTERM {
+		return false
+	}
+	_, ok := s.forwardedSignals[sig]
+	return !ok
+}
+
+// SetTermPolicy sets handling of SIGTERM received while the child runs, and while it's draining
+// after ShutdownWithTimeout. Must be called before Start. Both are TermForward by default
+func (s *Supervisor) SetTermPolicy(running, draining string, gracePeriod time.Duration) {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	s.runningTerm = running
+	s.drainingTerm = draining
+	s.gracePeriod = gracePeriod
+}
+
+// SetShutdownHook sets hook called once, when shutdown of the child is requested by ShutdownWithTimeout or SIGTERM,
+// before the child is signaled, e.g. to stop requests acting on the child. Must be called before Start
+func (s *Supervisor) SetShutdownHook(hook func()) {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	s.shutdownHook = hook
+}
+
+// runShutdownHook calls the shutdown hook once, concurrent callers wait for it to return.
+// Must be called without startStopLock held, as the hook may call the supervisor
+func (s *Supervisor) runShutdownHook() {
+	s.shutdownHookOnce.Do(func() {
+		s.startStopLock.Lock()
+		hook := s.shutdownHook
+		s.startStopLock.Unlock()
+
+		if hook != nil {
+			hook()
+		}
+	})
+}
+
+// SetShutdownSignal sets the signal of graceful shutdown, sent instead of SIGTERM by ShutdownWithTimeout
+// and in place of SIGTERM received from outside, e.g. SIGQUIT for nginx. The child is still killed with SIGKILL.
+// Must be called before Start
+func (s *Supervisor) SetShutdownSignal(sig syscall.Signal) {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	s.shutdownSignal = sig
+}
+
+// terminationSignal returns the signal of graceful shutdown. Must be called with startStopLock held
+func (s *Supervisor) terminationSignal() syscall.Signal {
+	if s.shutdownSignal == 0 {
+		return syscall.SIGTERM
+	}
+	return s.shutdownSignal
+}
+
+// SetShutdownAck makes graceful shutdown wait for the child to acknowledge the shutdown signal with AckShutdown within window.
+// The signal is resent up to retries times without ack, then the child is killed without waiting for the grace period.
+// Must be called before Start
+func (s *Supervisor) SetShutdownAck(window time.Duration, retries int) {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	s.ackWindow = window
+	s.ackRetries = retries
+}
+
+// AckShutdown acknowledges graceful shutdown by the child. Ignored unless shutdown waits for ack
+func (s *Supervisor) AckShutdown() {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	if s.ackTimer == nil {
+		return
+	}
+	s.ackTimer.Stop()
+	s.ackTimer = nil
+	event.ContextEventTrace(s.context).AddEvent("Shutdown acknowledged by child process")
+}
+
+// SetEnv sets env vars of the child as KEY=value pairs, over inherited ones. Must be called before Start
+func (s *Supervisor) SetEnv(env ...string) {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	s.cmd.Env = append(s.cmd.Env, env...)
+}
+
+// SetCredential runs the child as uid and gid, e.g. kubexit runs as root for graveyard access and the child drops privileges.
+// Supplementary groups are dropped. Kubexit must be privileged to change them. Must be called before Start
+func (s *Supervisor) SetCredential(uid, gid uint32) {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	if s.cmd.SysProcAttr == nil {
+		s.cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	s.cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uid, Gid: gid}
+}
+
+// SetOutput replaces stdout and stderr of the child, the container ones by default, e.g. to tee them to files.
+// Writers other than files are fed through pipes, so Wait also waits for descendants of the child holding them open.
+// Must be called before Start
+func (s *Supervisor) SetOutput(stdout, stderr io.Writer) {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	s.cmd.Stdout = stdout
+	s.cmd.Stderr = stderr
+}
+
+// SetGraceExtender sets extender of ShutdownWithTimeout grace period. Must be called before shutdown
+func (s *Supervisor) SetGraceExtender(extender GraceExtender) {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	s.graceExtender = extender
+}
+
 func (s *Supervisor) Start() error {
 	s.startStopLock.Lock()
 	defer s.startStopLock.Unlock()
@@ -48,18 +259,21 @@ func (s *Supervisor) Start() error {
 	if err := s.cmd.Start(); err != nil {
 		return errors.WithStack(fmt.Errorf("failed to start child process: %v", err))
 	}
+	s.startedAt = s.clock.Now()
 
-	// Propegate all signals to the child process
-	s.sigCh = make(chan os.Signal, 1)
-	signal.Notify(s.sigCh)
+	// Propegate all signals to the child process.
+	// The forwarder reads its own channel, Restart replaces s.sigCh for the next child
+	sigCh := make(chan os.Signal, 1)
+	s.sigCh = sigCh
+	signal.Notify(sigCh)
 
-	go func() {
+	crash.Go(s.context, "signal forwarder", func() {
 		for {
 			select {
 			case <-s.context.Done():
 				event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Stop signal propegation %s", s.context.Err()))
 				return
-			case sig, ok := <-s.sigCh:
+			case sig, ok := <-sigCh:
 				if !ok {
 					return
 				}
@@ -71,28 +285,78 @@ func (s *Supervisor) Start() error {
 				if sig == syscall.SIGCHLD {
 					continue
 				}
-				err := s.cmd.Process.Signal(sig)
+				skipped := s.skipped(sig)
+				if sig != syscall.SIGURG {
+					forwarded := !skipped
+					audit.ContextLog(s.context).Append(audit.Record{Event: audit.EventSignalReceived, Signal: sig.String(), Forwarded: &forwarded})
+				}
+				if skipped {
+					continue
+				}
+				if sig == syscall.SIGTERM {
+					s.runShutdownHook()
+					s.startStopLock.Lock()
+					s.terminated = true
+					s.startStopLock.Unlock()
+				}
+				if sig == syscall.SIGTERM && s.handleTerm() {
+					continue
+				}
+				if sig == syscall.SIGTERM {
+					s.startStopLock.Lock()
+					sig = s.terminationSignal()
+					s.startStopLock.Unlock()
+				}
+				err := s.Signal(sig)
 				if err != nil {
 					event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Signal propegation failed: %v\n", err))
 				}
 			}
 		}
-	}()
+	})
 
 	return nil
 }
 
+// Wait waits for the child to exit. After overlapped restart it waits for the new child.
+// Must not be called concurrently with itself
 func (s *Supervisor) Wait() error {
-	defer func() {
-		signal.Reset()
-		if s.sigCh != nil {
-			close(s.sigCh)
-		}
-		if s.shutdownTimer != nil {
-			s.shutdownTimer.Stop()
+	for {
+		s.startStopLock.Lock()
+		cmd := s.cmd
+		s.startStopLock.Unlock()
+
+		err := cmd.Wait()
+
+		s.startStopLock.Lock()
+		if s.cmd == cmd {
+			// the exit is recorded with the lock held, so Restart can't start the next child in between
+			s.exited(cmd.ProcessState)
+			s.startStopLock.Unlock()
+			return err
 		}
-	}()
-	return s.cmd.Wait()
+		s.startStopLock.Unlock()
+	}
+}
+
+// exited records the exit of the child and stops its signal forwarder and shutdown timers. Must be called with startStopLock held
+func (s *Supervisor) exited(state *os.ProcessState) {
+	s.state = state
+	s.stoppedAt = s.clock.Now()
+	if s.sigCh != nil {
+		// stop only own notifications, other signal handlers of kubexit keep working.
+		// No signal is sent to the channel after Stop returns, so it's closed once, stopping the forwarder
+		signal.Stop(s.sigCh)
+		close(s.sigCh)
+		s.sigCh = nil
+	}
+	if s.shutdownTimer != nil {
+		s.shutdownTimer.Stop()
+	}
+	if s.ackTimer != nil {
+		s.ackTimer.Stop()
+	}
+	s.stopEscalation()
 }
 
 func (s *Supervisor) ShutdownNow() error {
@@ -100,11 +364,12 @@ func (s *Supervisor) ShutdownNow() error {
 	defer s.startStopLock.Unlock()
 
 	if !s.isRunning() {
+		s.cancelRestart()
 		return nil
 	}
 	// TODO: Use Process.Kill() instead?
 	// Sending Interrupt on Windows is not implemented.
-	err := s.cmd.Process.Signal(syscall.SIGKILL)
+	err := s.signal(syscall.SIGKILL)
 	if err != nil {
 		return errors.WithStack(fmt.Errorf("failed to kill child process: %v", err))
 	}
@@ -112,10 +377,13 @@ func (s *Supervisor) ShutdownNow() error {
 }
 
 func (s *Supervisor) ShutdownWithTimeout(timeout time.Duration) error {
+	s.runShutdownHook()
+
 	s.startStopLock.Lock()
 	defer s.startStopLock.Unlock()
 
 	if !s.isRunning() {
+		s.cancelRestart()
 		return nil
 	}
 
@@ -124,28 +392,424 @@ func (s *Supervisor) ShutdownWithTimeout(timeout time.Duration) error {
 	}
 
 	event.ContextEventTrace(s.context).AddEvent("Terminating child process")
-	err := s.cmd.Process.Signal(syscall.SIGTERM)
+	if delay := chaos.ContextInjector(s.context).ShutdownDelay; delay > 0 {
+		// the child looks slow to stop, while the grace period runs
+		sig := s.terminationSignal()
+		event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Chaos: delaying %s by %s", sig, delay))
+		s.clock.AfterFunc(delay, crash.Func(s.context, "chaos shutdown delay", func() {
+			_ = s.Signal(sig)
+		}))
+	} else {
+		err := s.signal(s.terminationSignal())
+		if err != nil {
+			return errors.WithStack(fmt.Errorf("failed to terminate child process: %v", err))
+		}
+	}
+
+	if s.paused {
+		// paused child can't handle the shutdown signal
+		event.ContextEventTrace(s.context).AddEvent("Resuming paused child process to terminate")
+		err := s.signal(syscall.SIGCONT)
+		if err != nil {
+			return errors.WithStack(fmt.Errorf("failed to resume child process: %v", err))
+		}
+		s.paused = false
+	}
+
+	s.shutdownStarted = s.clock.Now()
+	s.scheduleKill(timeout)
+	s.scheduleEscalation()
+
+	if s.ackWindow > 0 {
+		s.ackLeft = s.ackRetries
+		s.ackTimer = s.clock.AfterFunc(s.ackWindow, crash.Func(s.context, "shutdown ack timer", s.ackElapsed))
+	}
+
+	return nil
+}
+
+// ackElapsed resends the shutdown signal to the child not acknowledging shutdown, and kills it when retries are exhausted
+func (s *Supervisor) ackElapsed() {
+	s.startStopLock.Lock()
+	if s.ackTimer == nil || !s.isRunning() {
+		s.startStopLock.Unlock()
+		return
+	}
+	if s.ackLeft > 0 {
+		defer s.startStopLock.Unlock()
+		s.ackLeft--
+		event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Shutdown not acknowledged in %s, resending %s", s.ackWindow, s.terminationSignal()))
+		err := s.signal(s.terminationSignal())
+		if err != nil {
+			event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Failed to resend %s: %v", s.terminationSignal(), err))
+		}
+		s.ackTimer = s.clock.AfterFunc(s.ackWindow, crash.Func(s.context, "shutdown ack timer", s.ackElapsed))
+		return
+	}
+	s.ackTimer = nil
+	if s.shutdownTimer != nil {
+		s.shutdownTimer.Stop()
+	}
+	s.startStopLock.Unlock()
+
+	event.ContextEventTrace(s.context).AddEvent("Shutdown not acknowledged, killing child process early")
+	audit.ContextLog(s.context).Append(audit.Record{Event: audit.EventShutdown, Trigger: "shutdown_not_acknowledged"})
+	err := s.ShutdownNow()
+	if err != nil {
+		event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Failed to kill child process: %v", err))
+	}
+}
+
+// handleTerm applies TermPolicy of the current phase to received SIGTERM, returns false when it's just forwarded
+func (s *Supervisor) handleTerm() bool {
+	s.startStopLock.Lock()
+	draining := s.shutdownTimer != nil
+	policy := s.runningTerm
+	if draining {
+		policy = s.drainingTerm
+	}
+	s.startStopLock.Unlock()
+
+	var err error
+	switch policy {
+	case TermCancel:
+		event.ContextEventTrace(s.context).AddEvent("SIGTERM policy: killing child process")
+		audit.ContextLog(s.context).Append(audit.Record{Event: audit.EventShutdown, Trigger: "sigterm"})
+		err = s.ShutdownNow()
+	case TermGrace:
+		if !draining {
+			event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("SIGTERM policy: starting grace period %s", s.gracePeriod))
+			audit.ContextLog(s.context).Append(audit.Record{Event: audit.EventShutdown, Trigger: "sigterm"})
+			err = s.ShutdownWithTimeout(s.gracePeriod)
+			break
+		}
+		err = s.restartGrace()
+	default:
+		return false
+	}
+	if err != nil {
+		event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("SIGTERM policy %s failed: %v", policy, err))
+	}
+	return true
+}
+
+// restartGrace forwards SIGTERM, as the shutdown signal, to draining child and restarts the grace period
+func (s *Supervisor) restartGrace() error {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	if !s.isRunning() {
+		return nil
+	}
+	err := s.signal(s.terminationSignal())
 	if err != nil {
 		return errors.WithStack(fmt.Errorf("failed to terminate child process: %v", err))
 	}
+	if !s.shutdownTimer.Stop() {
+		// grace period elapsed, the child is being killed
+		return nil
+	}
+	event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("SIGTERM policy: grace period restarted %s", s.gracePeriod))
+	s.shutdownStarted = s.clock.Now()
+	s.scheduleKill(s.gracePeriod)
+	return nil
+}
 
-	s.shutdownTimer = time.AfterFunc(timeout, func() {
-		err := s.ShutdownNow()
-		if err != nil {
-			// TODO: ignorable?
-			event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Failed after timeout: %v", err))
+// ExtendShutdown postpones killing the child by extension, while graceful shutdown is in progress
+func (s *Supervisor) ExtendShutdown(extension time.Duration) error {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	if !s.isRunning() {
+		return errors.New("child is not running")
+	}
+	if s.shutdownTimer == nil {
+		return errors.New("shutdown is not in progress")
+	}
+	if !s.shutdownTimer.Stop() {
+		return errors.New("grace period already elapsed")
+	}
+
+	event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Grace period extended by %s on request", extension))
+	s.scheduleKill(s.shutdownDeadline.Sub(s.clock.Now()) + extension)
+	return nil
+}
+
+// scheduleKill kills the child after timeout, unless grace extender extends it. Must be called with startStopLock held
+func (s *Supervisor) scheduleKill(timeout time.Duration) {
+	s.shutdownDeadline = s.clock.Now().Add(timeout)
+	s.shutdownTimer = s.clock.AfterFunc(timeout, crash.Func(s.context, "grace timer", s.killAfterGrace))
+}
+
+func (s *Supervisor) killAfterGrace() {
+	s.startStopLock.Lock()
+	extender := s.graceExtender
+	elapsed := s.clock.Now().Sub(s.shutdownStarted)
+	s.startStopLock.Unlock()
+
+	if extender != nil {
+		// the extender is called without the lock, it may take a while, e.g. checking the queue
+		if extension := extender(elapsed); extension > 0 {
+			s.startStopLock.Lock()
+			defer s.startStopLock.Unlock()
+			if s.isRunning() {
+				event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Grace period extended by %s", extension))
+				s.scheduleKill(extension)
+			}
+			return
 		}
-	})
+	}
+
+	audit.ContextLog(s.context).Append(audit.Record{Event: audit.EventShutdown, Trigger: "grace_period_elapsed"})
+	err := s.ShutdownNow()
+	if err != nil {
+		// TODO: ignorable?
+		event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Failed after timeout: %v", err))
+	}
+}
 
+// Pause stops the child with SIGSTOP. Not allowed while shutdown is in progress
+func (s *Supervisor) Pause() error {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	if !s.isRunning() {
+		return errors.New("child is not running")
+	}
+	if s.shutdownTimer != nil {
+		return errors.New("shutdown is in progress")
+	}
+	if s.paused {
+		return errors.New("child is already paused")
+	}
+
+	event.ContextEventTrace(s.context).AddEvent("Pausing child process")
+	err := s.signal(syscall.SIGSTOP)
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to pause child process: %v", err))
+	}
+	s.paused = true
+	return nil
+}
+
+// Resume continues the child paused by Pause with SIGCONT
+func (s *Supervisor) Resume() error {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	if !s.isRunning() {
+		return errors.New("child is not running")
+	}
+	if !s.paused {
+		return errors.New("child is not paused")
+	}
+
+	event.ContextEventTrace(s.context).AddEvent("Resuming child process")
+	err := s.signal(syscall.SIGCONT)
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to resume child process: %v", err))
+	}
+	s.paused = false
 	return nil
 }
 
+// signal sends sig to the child on behalf of kubexit itself
+func (s *Supervisor) signal(sig syscall.Signal) error {
+	audit.ContextLog(s.context).Append(audit.Record{Event: audit.EventSignalSent, Signal: sig.String()})
+	return signalProcess(s.cmd.Process, sig)
+}
+
+// signalProcess sends sig to the process. The process exited, but not recorded by Wait yet, is not an error
+func signalProcess(process *os.Process, sig os.Signal) error {
+	err := process.Signal(sig)
+	if errors.Is(err, os.ErrProcessDone) {
+		return nil
+	}
+	return err
+}
+
+// Restart starts the exited child again with the same command, env and stdio
+func (s *Supervisor) Restart() error {
+	s.startStopLock.Lock()
+	if s.isRunning() {
+		s.startStopLock.Unlock()
+		return errors.New("child is running")
+	}
+
+	s.cmd = s.cloneCmd()
+	s.state = nil
+	s.shutdownTimer = nil
+	s.shutdownStarted = time.Time{}
+	s.shutdownDeadline = time.Time{}
+	s.ackTimer = nil
+	s.stopEscalation()
+	s.paused = false
+	s.stoppedAt = time.Time{}
+	s.restarts++
+	restarts := s.restarts
+	s.startStopLock.Unlock()
+
+	event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Restart #%d", restarts))
+	return s.Start()
+}
+
+// RestartOverlapped starts a new child with the same command while the old one runs, and waits until ready reports it ready,
+// checking every interval. Then the new child replaces the old one, which is stopped with the shutdown signal and killed after gracePeriod.
+// The new child is killed, if it's not ready until ctx is done
+func (s *Supervisor) RestartOverlapped(ctx context.Context, ready func(context.Context) error, interval, gracePeriod time.Duration) error {
+	s.startStopLock.Lock()
+	if !s.isRunning() || !s.shutdownStarted.IsZero() {
+		s.startStopLock.Unlock()
+		return errors.New("child is not running")
+	}
+	cmd := s.cloneCmd()
+	s.startStopLock.Unlock()
+
+	event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Overlapped restart: starting new child: %s", s))
+	err := cmd.Start()
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to start new child process: %v", err))
+	}
+
+	err = waitReady(ctx, ready, interval)
+	if err != nil {
+		killCmd(cmd)
+		return errors.Wrap(err, "new child process is not ready")
+	}
+
+	s.startStopLock.Lock()
+	if !s.isRunning() || !s.shutdownStarted.IsZero() {
+		s.startStopLock.Unlock()
+		killCmd(cmd)
+		return errors.New("old child process exited or is shutting down")
+	}
+	old := s.cmd
+	paused := s.paused
+	sig := s.terminationSignal()
+	s.cmd = cmd
+	s.startedAt = s.clock.Now()
+	s.paused = false
+	s.restarts++
+	s.startStopLock.Unlock()
+
+	event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Overlapped restart #%d: new child is ready, terminating old child", s.Restarts()))
+	err = old.Process.Signal(sig)
+	if err == nil && paused {
+		// paused child can't handle the shutdown signal
+		err = old.Process.Signal(syscall.SIGCONT)
+	}
+	if err != nil {
+		event.ContextEventTrace(s.context).AddEvent(fmt.Sprintf("Failed to terminate old child process: %v", err))
+	}
+	// Wait reaps the old child, kill of reaped process fails harmlessly
+	s.clock.AfterFunc(gracePeriod, crash.Func(s.context, "overlapped restart timer", func() {
+		_ = old.Process.Kill()
+	}))
+	return nil
+}
+
+// waitReady probes ready every interval until it succeeds or ctx is done
+func waitReady(ctx context.Context, ready func(context.Context) error, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := ready(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(err, ctx.Err().Error())
+		case <-ticker.C:
+		}
+	}
+}
+
+func killCmd(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+}
+
+// cloneCmd returns unstarted copy of the child command. Must be called with startStopLock held
+func (s *Supervisor) cloneCmd() *exec.Cmd {
+	previous := s.cmd
+	cmd := exec.Command(previous.Path, previous.Args[1:]...)
+	cmd.Args = previous.Args
+	cmd.Env = previous.Env
+	cmd.Dir = previous.Dir
+	cmd.Stdin = previous.Stdin
+	cmd.Stdout = previous.Stdout
+	cmd.Stderr = previous.Stderr
+	cmd.SysProcAttr = previous.SysProcAttr
+	return cmd
+}
+
+// Restarts returns the number of restarts
+func (s *Supervisor) Restarts() int {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	return s.restarts
+}
+
+// ShutdownRequested reports whether graceful shutdown is started or SIGTERM is received, so the child is not to be restarted
+func (s *Supervisor) ShutdownRequested() bool {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	return !s.shutdownStarted.IsZero() || s.terminated
+}
+
+// Signal sends sig to the child, if it's running
+func (s *Supervisor) Signal(sig os.Signal) error {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	if !s.isRunning() {
+		return nil
+	}
+	return errors.WithStack(signalProcess(s.cmd.Process, sig))
+}
+
+// Pid returns the child process id, or 0 if it's not running
+func (s *Supervisor) Pid() int {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	if !s.isRunning() {
+		return 0
+	}
+	return s.cmd.Process.Pid
+}
+
+// Summary describes the child run. Exit code and signal are set after Wait
+func (s *Supervisor) Summary() event.Summary {
+	s.startStopLock.Lock()
+	defer s.startStopLock.Unlock()
+
+	summary := event.Summary{
+		StartedAt: s.startedAt,
+		StoppedAt: s.stoppedAt,
+	}
+	if s.cmd.Process != nil {
+		summary.PID = s.cmd.Process.Pid
+	}
+	if state := s.state; state != nil {
+		code := state.ExitCode()
+		summary.ExitCode = &code
+		if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			summary.Signal = status.Signal().String()
+		}
+	}
+	return summary
+}
+
 func (s *Supervisor) isRunning() bool {
 	// Process set by cmd.Start - means started
 	// https://golang.org/src/os/exec/exec.go?s=11514:11541#L422
-	// ProcessState set by cmd.Wait - means exited
-	// https://golang.org/src/os/exec/exec.go?s=14689:14715#L511
-	return s.cmd.Process != nil && s.cmd.ProcessState == nil
+	// state set by Wait after cmd.Wait - means exited
+	return s.cmd.Process != nil && s.state == nil
 }
 
 // String joins the command Path and Args and quotes any with spaces
@@ -0,0 +1,592 @@
+package supervisor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/retry"
+	"github.com/ispringtech/kubexit/pkg/testkit"
+)
+
+// testTimeout bounds waiting for the fake child, which reacts in milliseconds
+const testTimeout = 5 * time.Second
+
+// TestHelperProcess is the fake child, the test binary re-executed by newFakeChild:
+//
+//	exit <code> - exits with the code at once
+//	trap <code> - prints "ready", exits with the code on SIGTERM
+//	ignore      - prints "ready" and each SIGTERM and SIGINT received, until killed
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("KUBEXIT_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		os.Exit(2)
+	}
+
+	switch args[1] {
+	case "exit":
+		code, _ := strconv.Atoi(args[2])
+		os.Exit(code)
+	case "trap":
+		code, _ := strconv.Atoi(args[2])
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGTERM)
+		fmt.Println("ready")
+		<-sigCh
+		os.Exit(code)
+	case "ignore":
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+		fmt.Println("ready")
+		for sig := range sigCh {
+			fmt.Println(sig)
+		}
+	}
+	os.Exit(2)
+}
+
+// fakeClock adapts testkit.Clock to Clock, sending delays of started timers to timers
+type fakeClock struct {
+	*testkit.Clock
+	timers chan time.Duration
+}
+
+func newFakeClock() fakeClock {
+	return fakeClock{Clock: testkit.NewClock(time.Now()), timers: make(chan time.Duration, 64)}
+}
+
+func (c fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	timer := c.Clock.AfterFunc(d, f)
+	select {
+	case c.timers <- d:
+	default:
+	}
+	return timer
+}
+
+// awaitTimer returns delay of the next timer started, so the clock may be advanced past it
+func awaitTimer(t *testing.T, clock fakeClock) time.Duration {
+	t.Helper()
+
+	select {
+	case d := <-clock.timers:
+		return d
+	case <-time.After(testTimeout):
+		t.Fatalf("timer was not started in %s", testTimeout)
+		return 0
+	}
+}
+
+// newFakeChild returns supervisor of the fake child of TestHelperProcess with timers run by clock.
+// Lines printed by the child are sent to the returned channel
+func newFakeChild(t *testing.T, clock fakeClock, args ...string) (*Supervisor, <-chan string) {
+	t.Helper()
+
+	s := New(context.Background(), os.Args[0], append([]string{"-test.run=TestHelperProcess", "--"}, args...)...)
+	s.SetEnv("KUBEXIT_HELPER_PROCESS=1")
+	s.SetClock(clock)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = w.Close()
+		_ = r.Close()
+	})
+	s.SetOutput(w, ioutil.Discard)
+
+	lines := make(chan string, 64)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return s, lines
+}
+
+func awaitLine(t *testing.T, lines <-chan string, expected string) {
+	t.Helper()
+
+	select {
+	case line := <-lines:
+		if line != expected {
+			t.Fatalf("expected child to print %q, got %q", expected, line)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("child did not print %q in %s", expected, testTimeout)
+	}
+}
+
+// wait calls Wait in background
+func wait(s *Supervisor) <-chan error {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- s.Wait()
+	}()
+	return exited
+}
+
+func awaitExit(t *testing.T, exited <-chan error) error {
+	t.Helper()
+
+	select {
+	case err := <-exited:
+		return err
+	case <-time.After(testTimeout):
+		t.Fatalf("child did not exit in %s", testTimeout)
+		return nil
+	}
+}
+
+func assertRunning(t *testing.T, exited <-chan error) {
+	t.Helper()
+
+	select {
+	case err := <-exited:
+		t.Fatalf("child exited early: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func exitCode(t *testing.T, err error) int {
+	t.Helper()
+
+	if err == nil {
+		return 0
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		t.Fatalf("unexpected wait error: %v", err)
+	}
+	return exitErr.ExitCode()
+}
+
+func TestGracePeriodKillsChild(t *testing.T) {
+	clock := newFakeClock()
+	s, lines := newFakeChild(t, clock, "ignore")
+
+	err := s.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exited := wait(s)
+	awaitLine(t, lines, "ready")
+
+	err = s.ShutdownWithTimeout(30 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	awaitLine(t, lines, "terminated")
+
+	clock.Advance(29 * time.Second)
+	assertRunning(t, exited)
+
+	clock.Advance(time.Second)
+	_ = awaitExit(t, exited)
+	if signal := s.Summary().Signal; signal != syscall.SIGKILL.String() {
+		t.Fatalf("expected child killed, got signal %q", signal)
+	}
+}
+
+func TestShutdownEscalation(t *testing.T) {
+	clock := newFakeClock()
+	s, lines := newFakeChild(t, clock, "ignore")
+	s.SetShutdownEscalation([]EscalationStep{{Signal: syscall.SIGINT, After: 10 * time.Second}})
+
+	err := s.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exited := wait(s)
+	awaitLine(t, lines, "ready")
+
+	err = s.ShutdownWithTimeout(30 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	awaitLine(t, lines, "terminated")
+
+	clock.Advance(10 * time.Second)
+	awaitLine(t, lines, "interrupt")
+	assertRunning(t, exited)
+
+	clock.Advance(20 * time.Second)
+	_ = awaitExit(t, exited)
+}
+
+func TestShutdownAckResendsSignal(t *testing.T) {
+	clock := newFakeClock()
+	s, lines := newFakeChild(t, clock, "ignore")
+	s.SetShutdownAck(5*time.Second, 1)
+
+	err := s.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exited := wait(s)
+	awaitLine(t, lines, "ready")
+
+	err = s.ShutdownWithTimeout(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	awaitLine(t, lines, "terminated")
+
+	clock.Advance(5 * time.Second)
+	awaitLine(t, lines, "terminated")
+
+	// retries are exhausted long before the grace period
+	clock.Advance(5 * time.Second)
+	_ = awaitExit(t, exited)
+}
+
+// TestShutdownHookBeforeSignal calls the shutdown hook once, before the child is signaled
+func TestShutdownHookBeforeSignal(t *testing.T) {
+	s, lines := newFakeChild(t, newFakeClock(), "ignore")
+	calls := 0
+	s.SetShutdownHook(func() {
+		calls++
+		// the hook may call the supervisor
+		if pid := s.Pid(); pid == 0 {
+			t.Error("expected child running in shutdown hook")
+		}
+		select {
+		case line := <-lines:
+			t.Errorf("child is signaled before shutdown hook returned: %q", line)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	err := s.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exited := wait(s)
+	awaitLine(t, lines, "ready")
+
+	err = s.ShutdownWithTimeout(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	awaitLine(t, lines, "terminated")
+	err = s.ShutdownWithTimeout(time.Minute)
+	if err == nil {
+		t.Fatal("expected repeated shutdown to fail")
+	}
+	if calls != 1 {
+		t.Fatalf("expected shutdown hook called once, got %d", calls)
+	}
+
+	err = s.ShutdownNow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = awaitExit(t, exited)
+}
+
+func TestShutdownBeforeStart(t *testing.T) {
+	s, _ := newFakeChild(t, newFakeClock(), "exit", "0")
+
+	err := s.ShutdownNow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.ShutdownWithTimeout(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := s.Pid(); pid != 0 {
+		t.Fatalf("expected no pid of not started child, got %d", pid)
+	}
+}
+
+// TestConcurrentShutdown interleaves shutdowns, both graceful and immediate, with Wait and readers of the child state
+func TestConcurrentShutdown(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		s, lines := newFakeChild(t, newFakeClock(), "trap", "0")
+
+		err := s.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		exited := wait(s)
+		awaitLine(t, lines, "ready")
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 16)
+		for j := 0; j < 4; j++ {
+			wg.Add(3)
+			go func() {
+				defer wg.Done()
+				err := s.ShutdownWithTimeout(time.Minute)
+				if err != nil && err.Error() != "shutdown already started" {
+					errs <- err
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				if err := s.ShutdownNow(); err != nil {
+					errs <- err
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				_ = s.Pid()
+				_ = s.Summary()
+				_ = s.ShutdownRequested()
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatalf("shutdown failed: %v", err)
+		}
+
+		_ = awaitExit(t, exited)
+		if pid := s.Pid(); pid != 0 {
+			t.Fatalf("expected no pid of exited child, got %d", pid)
+		}
+	}
+}
+
+// TestShutdownRacesExit shuts down the child exiting by itself, so the exit is not recorded by Wait yet
+func TestShutdownRacesExit(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		s, _ := newFakeChild(t, newFakeClock(), "exit", "3")
+
+		err := s.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		exited := wait(s)
+
+		if i%2 == 0 {
+			err = s.ShutdownWithTimeout(time.Minute)
+		} else {
+			err = s.ShutdownNow()
+		}
+		if err != nil {
+			t.Fatalf("shutdown of exiting child failed: %v", err)
+		}
+
+		err = awaitExit(t, exited)
+		if code := exitCode(t, err); code != 3 && code != -1 {
+			t.Fatalf("expected exit code 3 or killed, got %d", code)
+		}
+		err = s.ShutdownNow()
+		if err != nil {
+			t.Fatalf("shutdown of exited child failed: %v", err)
+		}
+	}
+}
+
+// TestRestartWhileForwardingSignals restarts the child, while signals are forwarded to it,
+// so each Wait closes the signal channel of its child only
+func TestRestartWhileForwardingSignals(t *testing.T) {
+	s, _ := newFakeChild(t, newFakeClock(), "exit", "1")
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// ignored by the test binary, when not forwarded
+			_ = syscall.Kill(os.Getpid(), syscall.SIGWINCH)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	err := s.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const restarts = 20
+	for i := 0; i < restarts; i++ {
+		err = awaitExit(t, wait(s))
+		if code := exitCode(t, err); code != 1 {
+			t.Fatalf("expected exit code 1, got %d", code)
+		}
+		err = s.Restart()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = awaitExit(t, wait(s))
+
+	if n := s.Restarts(); n != restarts {
+		t.Fatalf("expected %d restarts, got %d", restarts, n)
+	}
+}
+
+type restartResult struct {
+	restarted bool
+	err       error
+}
+
+// restartByPolicy calls RestartByPolicy in background
+func restartByPolicy(s *Supervisor, code int) <-chan restartResult {
+	results := make(chan restartResult, 1)
+	go func() {
+		restarted, err := s.RestartByPolicy(code)
+		results <- restartResult{restarted, err}
+	}()
+	return results
+}
+
+func awaitRestart(t *testing.T, results <-chan restartResult) restartResult {
+	t.Helper()
+
+	select {
+	case r := <-results:
+		return r
+	case <-time.After(testTimeout):
+		t.Fatalf("restart by policy did not return in %s", testTimeout)
+		return restartResult{}
+	}
+}
+
+// restartAfterBackoff restarts the exited child by policy, advancing the clock by the expected backoff delay
+func restartAfterBackoff(t *testing.T, s *Supervisor, clock fakeClock, code int, delay time.Duration) {
+	t.Helper()
+
+	results := restartByPolicy(s, code)
+	if d := awaitTimer(t, clock); d != delay {
+		t.Fatalf("expected backoff %s, got %s", delay, d)
+	}
+	clock.Advance(delay - time.Millisecond)
+	select {
+	case r := <-results:
+		t.Fatalf("restarted before backoff elapsed: %t, %v", r.restarted, r.err)
+	default:
+	}
+
+	clock.Advance(time.Millisecond)
+	r := awaitRestart(t, results)
+	if r.err != nil || !r.restarted {
+		t.Fatalf("expected restart, got restarted %t: %v", r.restarted, r.err)
+	}
+}
+
+func assertRetriesExhausted(t *testing.T, s *Supervisor, code int) {
+	t.Helper()
+
+	restarted, err := s.RestartByPolicy(code)
+	if restarted || errors.Cause(err) != ErrRetriesExhausted {
+		t.Fatalf("expected retries exhausted, got restarted %t: %v", restarted, err)
+	}
+}
+
+func TestRestartByPolicyMaxRetries(t *testing.T) {
+	clock := newFakeClock()
+	s, _ := newFakeChild(t, clock, "exit", "1")
+	s.SetRestartPolicy(RestartPolicy{Mode: RestartOnFailure, MaxRetries: 2, Backoff: retry.Policy{Initial: time.Second, Max: time.Minute}})
+
+	err := s.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = awaitExit(t, wait(s))
+
+	restartAfterBackoff(t, s, clock, 1, time.Second)
+	_ = awaitExit(t, wait(s))
+	restartAfterBackoff(t, s, clock, 1, 2*time.Second)
+	_ = awaitExit(t, wait(s))
+
+	assertRetriesExhausted(t, s, 1)
+}
+
+// TestRestartByPolicyResetsRetries resets consecutive restarts after the child ran for longer than Backoff.Max only
+func TestRestartByPolicyResetsRetries(t *testing.T) {
+	clock := newFakeClock()
+	s, lines := newFakeChild(t, clock, "trap", "1")
+	s.SetRestartPolicy(RestartPolicy{Mode: RestartOnFailure, MaxRetries: 1, Backoff: retry.Policy{Initial: time.Second, Max: time.Minute}})
+
+	// run runs the child for d and stops it with SIGTERM
+	run := func(d time.Duration) {
+		t.Helper()
+
+		exited := wait(s)
+		awaitLine(t, lines, "ready")
+		clock.Advance(d)
+		err := s.Signal(syscall.SIGTERM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = awaitExit(t, exited)
+	}
+
+	err := s.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(0)
+	restartAfterBackoff(t, s, clock, 1, time.Second)
+
+	// the run as long as Backoff.Max keeps restarts consecutive
+	run(time.Minute)
+	assertRetriesExhausted(t, s, 1)
+
+	err = s.Restart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(time.Minute + time.Second)
+	restartAfterBackoff(t, s, clock, 1, time.Second)
+}
+
+func TestShutdownCancelsRestartByPolicy(t *testing.T) {
+	clock := newFakeClock()
+	s, _ := newFakeChild(t, clock, "exit", "1")
+	s.SetRestartPolicy(RestartPolicy{Mode: RestartAlways, Backoff: retry.Policy{Initial: time.Hour, Max: time.Hour}})
+
+	err := s.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = awaitExit(t, wait(s))
+
+	results := restartByPolicy(s, 1)
+	// the restart waits for backoff, once its timer is started
+	_ = awaitTimer(t, clock)
+
+	err = s.ShutdownWithTimeout(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := awaitRestart(t, results)
+	if r.err != nil || r.restarted {
+		t.Fatalf("expected restart canceled, got restarted %t: %v", r.restarted, r.err)
+	}
+	if !s.ShutdownRequested() {
+		t.Fatal("expected shutdown requested")
+	}
+}
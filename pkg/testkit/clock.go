@@ -0,0 +1,118 @@
+// Package testkit provides fakes to test kubexit coordination without a cluster:
+// a graveyard delivering tombstone events synchronously, a pod with scripted container readiness and a manually advanced clock.
+package testkit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is a fake clock, which time moves by Advance only
+type Clock struct {
+	m       sync.Mutex
+	now     time.Time
+	waiters []clockWaiter
+	timers  []*ClockTimer
+}
+
+type clockWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.now
+}
+
+// After returns channel receiving the time, when the clock is advanced by d
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	ch := make(chan time.Time, 1)
+	at := c.now.Add(d)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, clockWaiter{at: at, ch: ch})
+	return ch
+}
+
+// ClockTimer is a timer of Clock.AfterFunc
+type ClockTimer struct {
+	clock *Clock
+	at    time.Time
+	f     func()
+}
+
+// AfterFunc calls f, when the clock is advanced by d. Due timers are called synchronously by Advance in the order of their time,
+// so timers of the tested code fire deterministically. f called with non-positive d runs in its own goroutine at once, as time.AfterFunc does
+func (c *Clock) AfterFunc(d time.Duration, f func()) *ClockTimer {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	t := &ClockTimer{clock: c, at: c.now.Add(d), f: f}
+	if d <= 0 {
+		go f()
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Stop prevents the timer from firing, returns false when it has fired or been stopped already
+func (t *ClockTimer) Stop() bool {
+	t.clock.m.Lock()
+	defer t.clock.m.Unlock()
+
+	for i, pending := range t.clock.timers {
+		if pending == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock, fires all After channels due and calls functions of timers due.
+// Timers are called without the clock lock, so they may start and stop timers
+func (c *Clock) Advance(d time.Duration) {
+	c.m.Lock()
+
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+
+	var due, pendingTimers []*ClockTimer
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pendingTimers = append(pendingTimers, t)
+			continue
+		}
+		due = append(due, t)
+	}
+	c.timers = pendingTimers
+	c.m.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].at.Before(due[j].at)
+	})
+	for _, t := range due {
+		t.f()
+	}
+}
@@ -0,0 +1,141 @@
+package testkit
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/tombstone"
+)
+
+// Graveyard is a temporary graveyard, which delivers events of tombstones written by Birth and Bury to watchers synchronously.
+// Tombstones written by participants are not delivered, Await polls for them.
+type Graveyard struct {
+	Path string
+
+	m        sync.Mutex
+	watchers []graveyardWatcher
+}
+
+type graveyardWatcher struct {
+	ctx     context.Context
+	names   []string
+	handler tombstone.EventHandler
+}
+
+// NewGraveyard creates graveyard in test temp dir, removed after the test
+func NewGraveyard(t testing.TB) *Graveyard {
+	return &Graveyard{Path: t.TempDir()}
+}
+
+// Watch implements kubexit.GraveyardWatcher, the handler is called until ctx is done
+func (g *Graveyard) Watch(ctx context.Context, graveyard string, names []string, handler tombstone.EventHandler) error {
+	if graveyard != g.Path {
+		return errors.Errorf("unexpected graveyard %s, expected %s", graveyard, g.Path)
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.watchers = append(g.watchers, graveyardWatcher{ctx: ctx, names: names, handler: handler})
+	return nil
+}
+
+// Birth records birth of participant with the name
+func (g *Graveyard) Birth(name string) error {
+	ts := g.tombstone(name)
+	err := ts.RecordBirth(context.Background())
+	if err != nil {
+		return err
+	}
+	return g.notify(name, fsnotify.Create)
+}
+
+// Bury records death of participant with the name, recording its birth first if missing
+func (g *Graveyard) Bury(name string, exitCode int) error {
+	ts, err := tombstone.Read(g.Path, name)
+	if err != nil {
+		ts = g.tombstone(name)
+		err = ts.RecordBirth(context.Background())
+		if err != nil {
+			return err
+		}
+	}
+	err = ts.RecordDeath(context.Background(), exitCode)
+	if err != nil {
+		return err
+	}
+	return g.notify(name, fsnotify.Write)
+}
+
+// Read returns tombstone of participant with the name
+func (g *Graveyard) Read(name string) (*tombstone.Tombstone, error) {
+	return tombstone.Read(g.Path, name)
+}
+
+// Await polls tombstone of participant with the name, until it satisfies cond or timeout elapses
+func (g *Graveyard) Await(name string, cond func(ts *tombstone.Tombstone) bool, timeout time.Duration) (*tombstone.Tombstone, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		ts, err := g.Read(name)
+		if err == nil && cond(ts) {
+			return ts, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.Errorf("timed out awaiting tombstone %s", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Born is Await condition of recorded birth
+func Born(ts *tombstone.Tombstone) bool {
+	return ts.Born != nil
+}
+
+// Died is Await condition of recorded death
+func Died(ts *tombstone.Tombstone) bool {
+	return ts.Died != nil
+}
+
+func (g *Graveyard) tombstone(name string) *tombstone.Tombstone {
+	return &tombstone.Tombstone{
+		Graveyard: g.Path,
+		Name:      name,
+	}
+}
+
+func (g *Graveyard) notify(name string, op fsnotify.Op) error {
+	g.m.Lock()
+	watchers := append([]graveyardWatcher{}, g.watchers...)
+	g.m.Unlock()
+
+	e := fsnotify.Event{Name: filepath.Join(g.Path, name), Op: op}
+	for _, w := range watchers {
+		if w.ctx.Err() != nil || !w.watches(name) {
+			continue
+		}
+		err := w.handler(w.ctx, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watches reports whether the watcher is interested in tombstone with the name
+func (w graveyardWatcher) watches(name string) bool {
+	if len(w.names) == 0 {
+		return true
+	}
+	for _, watched := range w.names {
+		if watched == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package testkit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Pod is a fake pod, which delivers container readiness set by SetReady or Script to watchers
+type Pod struct {
+	m        sync.Mutex
+	ready    map[string]struct{}
+	watchers []podWatcher
+	watched  chan struct{}
+}
+
+type podWatcher struct {
+	ctx      context.Context
+	setReady func(name string)
+}
+
+// PodStep makes containers ready, when the script clock is advanced by After
+type PodStep struct {
+	After time.Duration
+	Ready []string
+}
+
+func NewPod() *Pod {
+	return &Pod{
+		ready:   map[string]struct{}{},
+		watched: make(chan struct{}),
+	}
+}
+
+// Watch implements kubexit.ContainerWatcher, already ready containers are delivered immediately
+func (p *Pod) Watch(ctx context.Context, _, _ string, _ []string, setReady func(name string)) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for name := range p.ready {
+		setReady(name)
+	}
+	p.watchers = append(p.watchers, podWatcher{ctx: ctx, setReady: setReady})
+	if len(p.watchers) == 1 {
+		close(p.watched)
+	}
+	return nil
+}
+
+// Watched is closed when the pod is watched first time
+func (p *Pod) Watched() <-chan struct{} {
+	return p.watched
+}
+
+// SetReady makes containers ready
+func (p *Pod) SetReady(names ...string) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for _, name := range names {
+		p.ready[name] = struct{}{}
+		for _, w := range p.watchers {
+			if w.ctx.Err() == nil {
+				w.setReady(name)
+			}
+		}
+	}
+}
+
+// Script plays steps in background, each step waits for clock to be advanced by its After since the previous step
+func (p *Pod) Script(clock *Clock, steps ...PodStep) {
+	// register timers synchronously, so clock advanced right after Script is not missed
+	var after time.Duration
+	timers := make([]<-chan time.Time, 0, len(steps))
+	for _, step := range steps {
+		after += step.After
+		timers = append(timers, clock.After(after))
+	}
+
+	go func() {
+		for i, step := range steps {
+			<-timers[i]
+			p.SetReady(step.Ready...)
+		}
+	}()
+}
@@ -0,0 +1,84 @@
+// Package timestamp formats timestamps kubexit writes to tombstones, logs, event traces and audit log.
+package timestamp
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// LayoutRFC3339 is RFC3339 string with nanoseconds
+	LayoutRFC3339 = "rfc3339"
+	// LayoutEpochMillis is integer number of milliseconds since Unix epoch
+	LayoutEpochMillis = "epoch-millis"
+)
+
+// Format of written timestamps. Zero value is RFC3339 in local time zone
+type Format struct {
+	// Layout is LayoutRFC3339 or LayoutEpochMillis, empty means LayoutRFC3339
+	Layout string
+	// UTC converts timestamps to UTC, so TZ of the image doesn't leak into RFC3339 offsets
+	UTC bool
+}
+
+var (
+	m             sync.RWMutex
+	defaultFormat Format
+)
+
+// SetDefault sets format of timestamps written by kubexit, which don't carry their own format
+func SetDefault(f Format) {
+	m.Lock()
+	defer m.Unlock()
+	defaultFormat = f
+}
+
+// Default returns format set by SetDefault
+func Default() Format {
+	m.RLock()
+	defer m.RUnlock()
+	return defaultFormat
+}
+
+// Value returns t as json value in the format
+func (f Format) Value(t time.Time) interface{} {
+	if f.UTC {
+		t = t.UTC()
+	}
+	if f.Layout == LayoutEpochMillis {
+		return t.UnixNano() / int64(time.Millisecond)
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// AppendJSON appends t as json value in the format, without allocations
+func (f Format) AppendJSON(dst []byte, t time.Time) []byte {
+	if f.UTC {
+		t = t.UTC()
+	}
+	if f.Layout == LayoutEpochMillis {
+		return strconv.AppendInt(dst, t.UnixNano()/int64(time.Millisecond), 10)
+	}
+	dst = append(dst, '"')
+	dst = t.AppendFormat(dst, time.RFC3339Nano)
+	return append(dst, '"')
+}
+
+// Parse parses json value in any layout, returning the layout too
+func Parse(raw json.RawMessage) (time.Time, string, error) {
+	var millis int64
+	if err := json.Unmarshal(raw, &millis); err == nil {
+		return time.Unix(0, millis*int64(time.Millisecond)), LayoutEpochMillis, nil
+	}
+
+	var t time.Time
+	err := json.Unmarshal(raw, &t)
+	if err != nil {
+		return time.Time{}, "", errors.Wrapf(err, "invalid timestamp %s", raw)
+	}
+	return t, LayoutRFC3339, nil
+}
@@ -0,0 +1,173 @@
+package tombstone
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+)
+
+// Kinds of TombstoneEvent
+const (
+	// ChangeBorn is a tombstone recording birth, on creation or rebirth after death
+	ChangeBorn = "born"
+	// ChangeDied is a tombstone recording death, see TombstoneEvent.ExitCode
+	ChangeDied = "died"
+	// ChangeRemoved is a removed tombstone
+	ChangeRemoved = "removed"
+	// ChangeGraveyardLost is the graveyard removed and not recreated, the last event of the channel
+	ChangeGraveyardLost = "graveyard-lost"
+)
+
+// TombstoneEvent is a change of a watched tombstone state. Writes not changing the state, e.g. renewals and restarts, are not reported
+type TombstoneEvent struct {
+	Kind string
+	// Name of the tombstone, empty for ChangeGraveyardLost
+	Name string
+	// ExitCode of ChangeDied, nil when the tombstone doesn't record it
+	ExitCode *int
+	// Tombstone as read, nil for ChangeRemoved and ChangeGraveyardLost
+	Tombstone *Tombstone
+}
+
+// WatchChanges watches the graveyard as Watch does and sends typed changes of tombstones with the names, or of all tombstones when names are empty,
+// so embedders don't parse fsnotify events and tombstones themselves. The channel is closed when ctx is done.
+// Tombstones failing to read are reported to the event trace and warnings of ctx, as errors of Watch handlers.
+// Like Watch, only changes made after the call are reported
+func WatchChanges(ctx context.Context, graveyard string, names []string) (<-chan TombstoneEvent, error) {
+	changes := make(chan TombstoneEvent)
+	w := &changeWatcher{ctx: ctx, graveyard: graveyard, changes: changes, kinds: map[string]string{}}
+
+	// existing tombstones are known, so their later writes of the same state are not reported, and removals are
+	existing, err := ReadAll(graveyard, nil)
+	if err != nil {
+		return nil, err
+	}
+	filter := newNameFilter(names)
+	for _, t := range existing {
+		if _, ok := filter[t.Name]; ok || len(filter) == 0 {
+			w.kinds[t.Name] = changeKind(t)
+		}
+	}
+
+	err = Watch(ctx, graveyard, names, w.handle)
+	if err != nil {
+		return nil, err
+	}
+
+	crash.Go(ctx, "tombstone changes", func() {
+		<-ctx.Done()
+		w.m.Lock()
+		defer w.m.Unlock()
+
+		w.closed = true
+		close(changes)
+	})
+	return changes, nil
+}
+
+// changeWatcher is the EventHandler of WatchChanges, converting fsnotify events to state changes
+type changeWatcher struct {
+	ctx       context.Context
+	graveyard string
+	changes   chan TombstoneEvent
+
+	m      sync.Mutex
+	closed bool
+	// kinds are the last reported kinds of tombstones, so repeated writes of the same state are not reported
+	kinds map[string]string
+}
+
+func (w *changeWatcher) handle(ctx context.Context, e fsnotify.Event) error {
+	if GraveyardLost(e, w.graveyard) {
+		w.send(TombstoneEvent{Kind: ChangeGraveyardLost})
+		return nil
+	}
+
+	name := filepath.Base(e.Name)
+	if strings.HasPrefix(name, ".") {
+		// temp files of tombstone writes
+		return nil
+	}
+
+	if e.Op&fsnotify.Remove == fsnotify.Remove || e.Op&fsnotify.Rename == fsnotify.Rename {
+		if w.forget(name) {
+			w.send(TombstoneEvent{Kind: ChangeRemoved, Name: name})
+		}
+		return nil
+	}
+	if e.Op&fsnotify.Create != fsnotify.Create && e.Op&fsnotify.Write != fsnotify.Write {
+		return nil
+	}
+
+	t, err := Read(w.graveyard, name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read tombstone %s", name)
+	}
+
+	change := TombstoneEvent{Kind: changeKind(t), Name: name, Tombstone: t}
+	if change.Kind == "" {
+		// not a tombstone, e.g. lock file or audit log
+		return nil
+	}
+	if change.Kind == ChangeDied {
+		change.ExitCode = t.ExitCode
+	}
+	if w.update(name, change.Kind) {
+		w.send(change)
+	}
+	return nil
+}
+
+// changeKind returns the kind of change reporting the tombstone state, empty when it is not a tombstone
+func changeKind(t *Tombstone) string {
+	switch {
+	case t.Died != nil:
+		return ChangeDied
+	case t.Born != nil:
+		return ChangeBorn
+	default:
+		return ""
+	}
+}
+
+// update records the kind of the tombstone, returns false when it is reported already
+func (w *changeWatcher) update(name, kind string) bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.kinds[name] == kind {
+		return false
+	}
+	w.kinds[name] = kind
+	return true
+}
+
+// forget removes the tombstone, returns false when it was not known, e.g. it was not a tombstone
+func (w *changeWatcher) forget(name string) bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	_, ok := w.kinds[name]
+	delete(w.kinds, name)
+	return ok
+}
+
+// send blocks until the change is received or ctx is done
+func (w *changeWatcher) send(change TombstoneEvent) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.closed {
+		return
+	}
+	select {
+	case w.changes <- change:
+	case <-w.ctx.Done():
+	}
+}
@@ -0,0 +1,46 @@
+package tombstone
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/event"
+)
+
+// ErrDuplicateName is the cause of CheckDuplicate error, when the tombstone is renewed by another live process,
+// e.g. a container with the same name copy-pasted within the pod
+var ErrDuplicateName = errors.New("tombstone name is used by another live kubexit")
+
+// CheckDuplicate returns error with ErrDuplicateName cause, when the tombstone with the name is alive and keeps being renewed.
+// Alive tombstone with ExpiresAt is re-read every interval until it's renewed, dies or expires, e.g. left by a crashed predecessor.
+// Tombstones without TTL, of other pods than podUID and not signed with key, if set, can't prove a live owner and are ignored
+func CheckDuplicate(ctx context.Context, graveyard, name, podUID string, key []byte, interval time.Duration) error {
+	ts, err := ReadSigned(graveyard, name, key)
+	if err != nil || ts.FromOtherPod(podUID) || ts.State(time.Now()) != StateAlive || ts.ExpiresAt == nil {
+		return nil
+	}
+	expiresAt := *ts.ExpiresAt
+	event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Tombstone %s is alive until %s, waiting for renewal", ts.Path(), expiresAt))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-ticker.C:
+		}
+
+		current, err := ReadSigned(graveyard, name, key)
+		if err != nil || current.State(time.Now()) != StateAlive || current.ExpiresAt == nil {
+			return nil
+		}
+		if !current.ExpiresAt.Equal(expiresAt) {
+			return errors.Wrapf(ErrDuplicateName, "tombstone %s is renewed by another process, born %s", current.Path(), current.Born)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package tombstone
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/ispringtech/kubexit/pkg/metrics"
+)
+
+// nameFilter matches events of tombstones with the names, so churn of unrelated tombstones doesn't reach the handler.
+// Empty filter matches events of all files
+type nameFilter map[string]struct{}
+
+func newNameFilter(names []string) nameFilter {
+	filter := make(nameFilter, len(names))
+	for _, name := range names {
+		filter[name] = struct{}{}
+	}
+	return filter
+}
+
+// match reports whether e is an event of the interesting tombstone, counting ignored events
+func (f nameFilter) match(ctx context.Context, e fsnotify.Event) bool {
+	if len(f) == 0 {
+		return true
+	}
+	if _, ok := f[filepath.Base(e.Name)]; ok {
+		return true
+	}
+	metrics.ContextRecorder(ctx).AddCounter(
+		"kubexit_graveyard_events_ignored_total",
+		"Number of graveyard events ignored, as not of the watched tombstones",
+		1,
+	)
+	return false
+}
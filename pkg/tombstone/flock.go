@@ -0,0 +1,82 @@
+package tombstone
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// lockTimeout bounds waiting for flock held by an external writer, e.g. a wedged sidecar tool,
+	// the file is accessed without the lock after it
+	lockTimeout = 2 * time.Second
+	// lockPollInterval is the interval of flock attempts
+	lockPollInterval = 10 * time.Millisecond
+)
+
+// flock takes flock of the file with the operation, LOCK_EX or LOCK_SH, polling it till lockTimeout.
+// Returns false, when the lock is still held by another file descriptor then
+func flock(file *os.File, how int) (bool, error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			return true, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			return false, errors.WithStack(err)
+		}
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// lockExisting takes exclusive flock of the existing tombstone file, waiting for external writers updating it in place,
+// e.g. sidecar tools, till lockTimeout. Missing file or file still locked is not locked, returned unlock just closes it then
+func lockExisting(path string) (unlock func(), err error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return func() {}, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	locked, err := flock(file, syscall.LOCK_EX)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return func() {
+		if locked {
+			_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		}
+		_ = file.Close()
+	}, nil
+}
+
+// readShared reads the file holding shared flock, so it is never read while an external writer updates it in place.
+// The file is read without the lock, when the writer holds it longer than lockTimeout
+func readShared(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	locked, err := flock(file, syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	if locked {
+		defer func() {
+			_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		}()
+	}
+
+	return ioutil.ReadAll(file)
+}
@@ -0,0 +1,42 @@
+package tombstone
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Formats of written tombstone files. Both are read by the yaml parser, as json is yaml
+const (
+	// FormatYAML is the default
+	FormatYAML = "yaml"
+	// FormatJSON is a single json line, for consumers of the graveyard without yaml parser
+	FormatJSON = "json"
+)
+
+// marshal returns the tombstone content in its Format
+func (t *Tombstone) marshal() ([]byte, error) {
+	if t.Format != FormatJSON {
+		content, err := yaml.Marshal(t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal tombstone yaml: %v", err)
+		}
+		return content, nil
+	}
+
+	content, err := json.Marshal(t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal tombstone json: %v", err)
+	}
+	return append(content, '\n'), nil
+}
+
+// detectFormat returns the format of read tombstone content
+func detectFormat(content []byte) string {
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		return FormatJSON
+	}
+	return FormatYAML
+}
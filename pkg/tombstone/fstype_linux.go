@@ -0,0 +1,39 @@
+//go:build linux
+// +build linux
+
+package tombstone
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Magic numbers from linux/magic.h
+var fsTypeNames = map[int64]string{
+	0x01021994: "tmpfs",
+	0x858458f6: "ramfs",
+	0x794c7630: "overlayfs",
+	0xef53:     "ext4",
+	0x58465342: "xfs",
+	0x9123683e: "btrfs",
+	0x6969:     "nfs",
+	0xff534d42: "cifs",
+	0x65735546: "fuse",
+	0x01161970: "gfs2",
+}
+
+// detectFSType returns file system name of path and whether it is memory-backed
+func detectFSType(path string) (string, bool) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return "unknown", false
+	}
+
+	magic := int64(stat.Type)
+	name, ok := fsTypeNames[magic]
+	if !ok {
+		name = fmt.Sprintf("0x%x", magic)
+	}
+	return name, name == "tmpfs" || name == "ramfs"
+}
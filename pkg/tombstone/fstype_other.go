@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package tombstone
+
+// detectFSType returns file system name of path and whether it is memory-backed
+func detectFSType(string) (string, bool) {
+	return "unknown", false
+}
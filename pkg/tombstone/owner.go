@@ -0,0 +1,94 @@
+package tombstone
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/event"
+)
+
+// Owner identifies the process writing a tombstone on the node
+type Owner struct {
+	// BootID changes on node reboot
+	BootID string
+	// PIDNamespace is the pid namespace PID belongs to, e.g. pid:[4026531836]
+	PIDNamespace string
+	PID          int
+}
+
+// CurrentOwner returns owner of tombstones written by current process
+func CurrentOwner() (*Owner, error) {
+	bootID, err := ioutil.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to read boot id: %v", err))
+	}
+	pidNamespace, err := os.Readlink("/proc/self/ns/pid")
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to read pid namespace: %v", err))
+	}
+	return &Owner{
+		BootID:       strings.TrimSpace(string(bootID)),
+		PIDNamespace: pidNamespace,
+		PID:          os.Getpid(),
+	}, nil
+}
+
+// stale reports whether the owner process is gone without recording death: the node is rebooted since,
+// or the process doesn't exist. Processes of other pid namespaces can't be checked and are considered alive
+func (o *Owner) stale(current *Owner) bool {
+	if o.BootID != current.BootID {
+		return true
+	}
+	if o.PIDNamespace != current.PIDNamespace || o.PID == current.PID {
+		return false
+	}
+	return syscall.Kill(o.PID, 0) == syscall.ESRCH
+}
+
+// CollectStale removes tombstones of owners gone without recording death, e.g. on node crash or SIGKILL,
+// from node scoped graveyard, and expired tombstones. Tombstones not signed with key, if set, are never collected. Returns names of removed tombstones
+func CollectStale(ctx context.Context, graveyard string, current *Owner, key []byte) ([]string, error) {
+	infos, err := ioutil.ReadDir(graveyard)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to list graveyard: %v", err))
+	}
+
+	var collected []string
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		ts, err := ReadSigned(graveyard, info.Name(), key)
+		if err != nil {
+			// not a tombstone, e.g. audit log, or forged one
+			continue
+		}
+		switch ts.State(time.Now()) {
+		case StateDead, StateCrashed:
+			continue
+		case StateUnknown:
+			// expired, the owner stopped renewing it
+		default:
+			if ts.Owner == nil || !ts.Owner.stale(current) {
+				continue
+			}
+		}
+
+		event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Collecting stale tombstone: %s", ts.Path()))
+		err = os.Remove(ts.Path())
+		if err != nil && !os.IsNotExist(err) {
+			return collected, errors.WithStack(fmt.Errorf("failed to remove stale tombstone: %v", err))
+		}
+		collected = append(collected, ts.Name)
+	}
+	return collected, nil
+}
@@ -0,0 +1,121 @@
+package tombstone
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/watchdog"
+)
+
+// Poll is an alternative to Watch for file systems that do not deliver inotify events.
+// It lists the graveyard every interval and calls the eventHandler (asyncronously) with
+// synthetic fsnotify events for created, updated and removed tombstones with the names, or any files when names are empty.
+// When the supplied context is canceled, polling will stop. Removed graveyard is handled the same way as by Watch.
+func Poll(ctx context.Context, graveyard string, interval time.Duration, names []string, eventHandler EventHandler) error {
+	// first listing is done synchronously to report unreadable graveyard to the caller.
+	// Like Watch, only changes made after this point are reported.
+	known, err := listGraveyard(graveyard)
+	if err != nil {
+		return err
+	}
+
+	heartbeat := watchdog.ContextHeartbeat(ctx)
+	filter := newNameFilter(names)
+
+	crash.Go(ctx, "graveyard poll", func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Tombstone Poll(%s): done", graveyard))
+				return
+			case <-ticker.C:
+				heartbeat.Beat()
+				current, err2 := listGraveyard(graveyard)
+				if err2 != nil {
+					if _, statErr := os.Stat(graveyard); os.IsNotExist(statErr) {
+						if restoreGraveyard(ctx, graveyard, nil) {
+							continue
+						}
+						err2 = eventHandler(ctx, fsnotify.Event{Name: graveyard, Op: fsnotify.Remove})
+						if err2 != nil {
+							event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Handler error: %s", err2))
+							event.ContextWarnings(ctx).Warn(handlerFailedReason, err2.Error())
+						}
+						return
+					}
+					event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Tombstone Poll(%s): error: %v", graveyard, err2))
+					continue
+				}
+				for _, e := range diffGraveyard(known, current) {
+					if !filter.match(ctx, e) {
+						continue
+					}
+					err2 = eventHandler(ctx, e)
+					if err2 != nil {
+						event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Handler error: %s", err2))
+						event.ContextWarnings(ctx).Warn(handlerFailedReason, err2.Error())
+					}
+				}
+				known = current
+			}
+		}
+	})
+
+	return nil
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func listGraveyard(graveyard string) (map[string]fileVersion, error) {
+	infos, err := ioutil.ReadDir(graveyard)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to list graveyard: %v", err))
+	}
+
+	files := make(map[string]fileVersion, len(infos))
+	for _, info := range infos {
+		// dot files are temporary files of tombstone writes
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		files[filepath.Join(graveyard, info.Name())] = fileVersion{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+		}
+	}
+	return files, nil
+}
+
+func diffGraveyard(previous, current map[string]fileVersion) []fsnotify.Event {
+	var events []fsnotify.Event
+	for path, version := range current {
+		previousVersion, ok := previous[path]
+		switch {
+		case !ok:
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Create})
+		case previousVersion != version:
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Write})
+		}
+	}
+	for path := range previous {
+		if _, ok := current[path]; !ok {
+			events = append(events, fsnotify.Event{Name: path, Op: fsnotify.Remove})
+		}
+	}
+	return events
+}
@@ -0,0 +1,84 @@
+package tombstone
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+
+	"github.com/ispringtech/kubexit/pkg/event"
+)
+
+type ProbeResult struct {
+	// FSType is a name of the graveyard file system, e.g. tmpfs, or "unknown"
+	FSType string
+	// MemoryBacked is true when graveyard is on tmpfs, e.g. emptyDir with medium: Memory
+	MemoryBacked bool
+	// Notify is true when fsnotify delivered an event for a probe file in time
+	Notify bool
+}
+
+// Probe detects the graveyard file system and verifies that fsnotify delivers events there,
+// by writing a probe file and waiting up to timeout for the event.
+func Probe(ctx context.Context, graveyard, name string, timeout time.Duration) (*ProbeResult, error) {
+	err := os.MkdirAll(graveyard, os.ModePerm)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to create graveyard: %v", err))
+	}
+
+	fsType, memoryBacked := detectFSType(graveyard)
+	result := &ProbeResult{
+		FSType:       fsType,
+		MemoryBacked: memoryBacked,
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to create watcher: %v", err))
+	}
+	defer watcher.Close()
+
+	err = watcher.Add(graveyard)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to add watcher: %v", err))
+	}
+
+	// hidden file, so it doesn't look like a tombstone for ones listing the graveyard
+	probePath := filepath.Join(graveyard, fmt.Sprintf(".%s.probe", name))
+	defer os.Remove(probePath)
+
+	err = os.WriteFile(probePath, []byte(time.Now().Format(time.RFC3339Nano)), 0o644)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to write probe file: %v", err))
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, errors.WithStack(ctx.Err())
+		case <-timer.C:
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Probe(%s): no fsnotify event in %s", graveyard, timeout))
+			return result, nil
+		case e, ok := <-watcher.Events:
+			if !ok {
+				return result, nil
+			}
+			if e.Name == probePath {
+				result.Notify = true
+				return result, nil
+			}
+		case err2, ok := <-watcher.Errors:
+			if !ok {
+				return result, nil
+			}
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Probe(%s): error: %v", graveyard, err2))
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package tombstone
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/retry"
+	"github.com/ispringtech/kubexit/pkg/watchdog"
+)
+
+// GraveyardLost reports whether e is the removal of the graveyard itself, delivered by Watch and Poll,
+// when they failed to recreate it. Watching stops after this event
+func GraveyardLost(e fsnotify.Event, graveyard string) bool {
+	return e.Op&fsnotify.Remove == fsnotify.Remove && filepath.Clean(e.Name) == filepath.Clean(graveyard)
+}
+
+// restoreGraveyard recreates the graveyard removed while watching, e.g. on volume teardown race,
+// and adds it to watch, if add is not nil. Attempts are retried with the retry policy of ctx.
+// Returns false, when the graveyard can't be restored or ctx is done
+func restoreGraveyard(ctx context.Context, graveyard string, add func(string) error) bool {
+	heartbeat := watchdog.ContextHeartbeat(ctx)
+
+	err := retry.Do(ctx, "graveyard restore", func(ctx context.Context) error {
+		heartbeat.Beat()
+		err := os.MkdirAll(graveyard, os.ModePerm)
+		if err == nil && add != nil {
+			err = add(graveyard)
+		}
+		return err
+	})
+	if err != nil {
+		event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Graveyard %s restore failed: %v", graveyard, err))
+		return false
+	}
+	event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Graveyard %s is removed, recreated", graveyard))
+	return true
+}
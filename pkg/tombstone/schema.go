@@ -0,0 +1,162 @@
+package tombstone
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ispringtech/kubexit/pkg/event"
+)
+
+// CurrentSchemaVersion is written to each tombstone. Readers ignore unknown fields, so tombstones of newer versions
+// are read by older kubexit as far as it knows them, and migrations upgrade tombstones of older versions
+const CurrentSchemaVersion = 1
+
+// migrations upgrade tombstone of version i to i+1 in place
+var migrations = []func(t *Tombstone){
+	// 0 is any tombstone written before versioning, its fields are the same as of version 1
+	func(t *Tombstone) {},
+}
+
+// Migrate upgrades the tombstone to CurrentSchemaVersion in memory, returns false when it is current or newer
+func (t *Tombstone) Migrate() bool {
+	if t.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	for version := t.SchemaVersion; version < CurrentSchemaVersion; version++ {
+		migrations[version](t)
+	}
+	t.SchemaVersion = CurrentSchemaVersion
+	return true
+}
+
+// MigrationResult of a graveyard file
+type MigrationResult struct {
+	Name string
+	// FromVersion is the schema version before migration
+	FromVersion int
+	// Migrated is false when the tombstone is current or newer, or is skipped on error
+	Migrated bool
+	Err      error
+}
+
+// MigrateGraveyard upgrades tombstones of older schema versions in the graveyard to CurrentSchemaVersion, re-signing them with key.
+// Each tombstone is read and replaced holding its file lock, so the owner writing it concurrently is not overwritten with stale content.
+// Signed tombstones require the key, forged ones are skipped. With dryRun tombstones to upgrade are reported only
+func MigrateGraveyard(ctx context.Context, graveyard string, key []byte, dryRun bool) ([]MigrationResult, error) {
+	infos, err := ioutil.ReadDir(graveyard)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to list graveyard: %v", err))
+	}
+
+	var results []MigrationResult
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		result, ok := migrateFile(ctx, graveyard, info.Name(), key, dryRun)
+		if ok {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// migrateFile migrates the tombstone file, returns false when the file is not a tombstone, e.g. audit log
+func migrateFile(ctx context.Context, graveyard, name string, key []byte, dryRun bool) (MigrationResult, bool) {
+	t := &Tombstone{Graveyard: graveyard, Name: name, Key: key}
+	result := MigrationResult{Name: name}
+
+	unlock, err := lockExisting(t.Path())
+	if err != nil {
+		result.Err = errors.Wrap(err, "failed to lock tombstone file")
+		return result, true
+	}
+	defer unlock()
+
+	// the file is locked exclusively already, so it's read without shared lock
+	content, err := ioutil.ReadFile(t.Path())
+	if os.IsNotExist(err) {
+		return result, false
+	} else if err != nil {
+		result.Err = errors.WithStack(err)
+		return result, true
+	}
+	err = yaml.Unmarshal(content, t)
+	if err != nil || (t.Born == nil && t.Died == nil) {
+		return result, false
+	}
+	t.raw = content
+	t.Format = detectFormat(content)
+	result.FromVersion = t.SchemaVersion
+
+	if t.SchemaVersion >= CurrentSchemaVersion {
+		return result, true
+	}
+	if t.Signature != "" || len(key) > 0 {
+		if len(key) == 0 {
+			result.Err = errors.New("tombstone is signed, migration requires the key")
+			return result, true
+		}
+		err = t.Verify(key)
+		if err != nil {
+			result.Err = err
+			return result, true
+		}
+	}
+
+	t.Migrate()
+	result.Migrated = true
+	if dryRun {
+		return result, true
+	}
+
+	if len(key) > 0 {
+		t.Signature, err = t.sign(key)
+		if err != nil {
+			result.Err = err
+			return result, true
+		}
+	}
+	pretty, err := t.marshal()
+	if err != nil {
+		result.Err = errors.WithStack(err)
+		return result, true
+	}
+	err = t.replace(t.Graveyard, pretty)
+	if err != nil {
+		result.Err = errors.WithStack(err)
+		return result, true
+	}
+	event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Migrated tombstone %s from schema version %d to %d", t.Path(), result.FromVersion, CurrentSchemaVersion))
+	return result, true
+}
+
+// signRaw returns signature of the tombstone content as read, without signature, for newer schema versions,
+// which fields unknown here are dropped by unmarshaling. Maps are marshaled with sorted keys as structs are,
+// so the content is the same as signed by the writer
+func signRaw(name string, raw, key []byte) (string, error) {
+	var doc map[string]interface{}
+	err := yaml.Unmarshal(raw, &doc)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal tombstone yaml: %v", err)
+	}
+	delete(doc, "Signature")
+	content, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal tombstone yaml: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(name + "\n"))
+	_, _ = mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
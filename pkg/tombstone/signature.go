@@ -0,0 +1,66 @@
+package tombstone
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// ErrInvalidSignature is the cause of ReadSigned error, when the tombstone is unsigned or its signature doesn't match the key
+var ErrInvalidSignature = errors.New("invalid tombstone signature")
+
+// sign returns hex encoded HMAC-SHA256 of the tombstone name and content without signature.
+// Name is signed, so a signed tombstone can't be copied under another name
+func (t *Tombstone) sign(key []byte) (string, error) {
+	signature := t.Signature
+	t.Signature = ""
+	content, err := yaml.Marshal(t)
+	t.Signature = signature
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal tombstone yaml: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(t.Name + "\n"))
+	_, _ = mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Verify checks the tombstone is signed with key. Tombstones of newer schema versions are verified as read
+func (t *Tombstone) Verify(key []byte) error {
+	if t.Signature == "" {
+		return errors.Wrapf(ErrInvalidSignature, "tombstone %s is unsigned", t.Name)
+	}
+	var expected string
+	var err error
+	if t.SchemaVersion > CurrentSchemaVersion && len(t.raw) > 0 {
+		expected, err = signRaw(t.Name, t.raw, key)
+	} else {
+		expected, err = t.sign(key)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !hmac.Equal([]byte(expected), []byte(t.Signature)) {
+		return errors.Wrapf(ErrInvalidSignature, "tombstone %s signature mismatch", t.Name)
+	}
+	return nil
+}
+
+// ReadSigned reads a tombstone from a graveyard and verifies its signature with key, if key is not empty.
+// Unsigned and forged tombstones are rejected with ErrInvalidSignature cause
+func ReadSigned(graveyard, name string, key []byte) (*Tombstone, error) {
+	t, err := Read(graveyard, name)
+	if err != nil || len(key) == 0 {
+		return t, err
+	}
+	err = t.Verify(key)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,149 @@
+package tombstone
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+var testKey = []byte("secret")
+
+// writeSigned records birth of the tombstone name signed with testKey in graveyard
+func writeSigned(t *testing.T, graveyard, name string) {
+	t.Helper()
+
+	ts := &Tombstone{Graveyard: graveyard, Name: name, Key: testKey}
+	err := ts.RecordBirth(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertInvalidSignature(t *testing.T, graveyard, name string) {
+	t.Helper()
+
+	_, err := ReadSigned(graveyard, name, testKey)
+	if errors.Cause(err) != ErrInvalidSignature {
+		t.Fatalf("expected %v, got %v", ErrInvalidSignature, err)
+	}
+}
+
+func TestSignedRoundTrip(t *testing.T) {
+	graveyard := t.TempDir()
+	writeSigned(t, graveyard, "server")
+
+	ts, err := ReadSigned(graveyard, "server", testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Born == nil || ts.Signature == "" {
+		t.Fatalf("unexpected tombstone: %s", ts)
+	}
+}
+
+func TestTamperedTombstoneRejected(t *testing.T) {
+	graveyard := t.TempDir()
+	writeSigned(t, graveyard, "server")
+
+	ts, err := Read(graveyard, "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a forged death keeps the signature of the birth
+	code := 0
+	ts.Died = ts.Born
+	ts.ExitCode = &code
+	content, err := ts.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(ts.Path(), content, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertInvalidSignature(t, graveyard, "server")
+}
+
+func TestUnsignedTombstoneRejected(t *testing.T) {
+	graveyard := t.TempDir()
+	ts := &Tombstone{Graveyard: graveyard, Name: "server"}
+	err := ts.RecordBirth(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertInvalidSignature(t, graveyard, "server")
+
+	// unsigned tombstones are read as is without key
+	_, err = ReadSigned(graveyard, "server", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignedTombstoneCopiedRejected(t *testing.T) {
+	graveyard := t.TempDir()
+	writeSigned(t, graveyard, "server")
+
+	content, err := ioutil.ReadFile(filepath.Join(graveyard, "server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(graveyard, "client"), content, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertInvalidSignature(t, graveyard, "client")
+}
+
+// TestNewerSchemaSignature verifies tombstones of newer schema versions by the content as read,
+// including fields unknown to this version
+func TestNewerSchemaSignature(t *testing.T) {
+	graveyard := t.TempDir()
+	doc := map[string]interface{}{
+		"SchemaVersion": CurrentSchemaVersion + 1,
+		"Born":          "2021-10-13T15:00:21Z",
+		"FutureField":   "value",
+	}
+	write := func(doc map[string]interface{}) {
+		t.Helper()
+
+		content, err := yaml.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(graveyard, "server"), content, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := yaml.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc["Signature"], err = signRaw("server", content, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(doc)
+
+	ts, err := ReadSigned(graveyard, "server", testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.SchemaVersion != CurrentSchemaVersion+1 || ts.Born == nil {
+		t.Fatalf("unexpected tombstone: %s", ts)
+	}
+
+	// fields unknown here are signed too
+	doc["FutureField"] = "forged"
+	write(doc)
+	assertInvalidSignature(t, graveyard, "server")
+}
@@ -0,0 +1,22 @@
+package tombstone
+
+import "sync/atomic"
+
+// strict is set by SetStrict
+var strict int32
+
+// SetStrict sets parsing of tombstones read by Read, and so by Watch and Poll handlers.
+// Strict parsing rejects unknown fields and duplicate keys, so a tombstone crafted by a compromised participant
+// can't smuggle content, but rejects tombstones of newer schema versions too.
+// Lenient parsing, the default, ignores unknown fields for forward compatibility. Fields of unexpected types are rejected by both
+func SetStrict(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&strict, value)
+}
+
+func strictParsing() bool {
+	return atomic.LoadInt32(&strict) == 1
+}
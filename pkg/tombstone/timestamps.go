@@ -0,0 +1,118 @@
+package tombstone
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/ispringtech/kubexit/pkg/timestamp"
+)
+
+// plainTombstone has no custom json methods
+type plainTombstone Tombstone
+
+// MarshalJSON writes timestamps in TimeFormat
+func (t *Tombstone) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		*plainTombstone
+		Born      interface{}     `json:",omitempty"`
+		Died      interface{}     `json:",omitempty"`
+		Paused    interface{}     `json:",omitempty"`
+		ExpiresAt interface{}     `json:",omitempty"`
+		Restarts  []restartRecord `json:",omitempty"`
+	}{
+		plainTombstone: (*plainTombstone)(t),
+		Born:           timeValue(t.TimeFormat, t.Born),
+		Died:           timeValue(t.TimeFormat, t.Died),
+		Paused:         timeValue(t.TimeFormat, t.Paused),
+		ExpiresAt:      timeValue(t.TimeFormat, t.ExpiresAt),
+		Restarts:       restartRecords(t.TimeFormat, t.Restarts),
+	})
+}
+
+// restartRecord is Restart with Time in TimeFormat
+type restartRecord struct {
+	Time     interface{}
+	ExitCode *int `json:",omitempty"`
+	Reason   string
+}
+
+func restartRecords(f timestamp.Format, restarts []Restart) []restartRecord {
+	if len(restarts) == 0 {
+		return nil
+	}
+	records := make([]restartRecord, 0, len(restarts))
+	for _, r := range restarts {
+		records = append(records, restartRecord{
+			Time:     f.Value(r.Time),
+			ExitCode: r.ExitCode,
+			Reason:   r.Reason,
+		})
+	}
+	return records
+}
+
+// UnmarshalJSON reads timestamps in any layout, and sets TimeFormat layout to the read one,
+// so the tombstone is marshaled the same way to verify its signature
+func (t *Tombstone) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		*plainTombstone
+		Born      json.RawMessage
+		Died      json.RawMessage
+		Paused    json.RawMessage
+		ExpiresAt json.RawMessage
+		Restarts  []struct {
+			Time     json.RawMessage
+			ExitCode *int
+			Reason   string
+		}
+	}{
+		plainTombstone: (*plainTombstone)(t),
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if strictParsing() {
+		// custom unmarshaling is not affected by strictness of the outer decoder
+		decoder.DisallowUnknownFields()
+	}
+	err := decoder.Decode(&aux)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range []struct {
+		raw json.RawMessage
+		t   **time.Time
+	}{
+		{aux.Born, &t.Born},
+		{aux.Died, &t.Died},
+		{aux.Paused, &t.Paused},
+		{aux.ExpiresAt, &t.ExpiresAt},
+	} {
+		if len(field.raw) == 0 || string(field.raw) == "null" {
+			continue
+		}
+		parsed, layout, err := timestamp.Parse(field.raw)
+		if err != nil {
+			return err
+		}
+		*field.t = &parsed
+		t.TimeFormat.Layout = layout
+	}
+
+	t.Restarts = nil
+	for _, r := range aux.Restarts {
+		parsed, _, err := timestamp.Parse(r.Time)
+		if err != nil {
+			return err
+		}
+		t.Restarts = append(t.Restarts, Restart{Time: parsed, ExitCode: r.ExitCode, Reason: r.Reason})
+	}
+	return nil
+}
+
+func timeValue(f timestamp.Format, t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return f.Value(*t)
+}
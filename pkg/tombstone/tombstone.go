@@ -2,12 +2,16 @@ package tombstone
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -15,20 +19,102 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/ispringtech/kubexit/pkg/chaos"
+	"github.com/ispringtech/kubexit/pkg/crash"
 	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/retry"
+	"github.com/ispringtech/kubexit/pkg/timestamp"
+	"github.com/ispringtech/kubexit/pkg/watchdog"
 )
 
 type Tombstone struct {
-	Context context.Context `json:"-"`
+	// SchemaVersion of the tombstone content, zero for tombstones written before versioning, see CurrentSchemaVersion
+	SchemaVersion int `json:",omitempty"`
 
 	Born     *time.Time `json:",omitempty"`
 	Died     *time.Time `json:",omitempty"`
 	ExitCode *int       `json:",omitempty"`
+	// SupervisorCrashed is the panic of kubexit itself, recorded with death when kubexit crashed rather than the child exited
+	SupervisorCrashed string `json:",omitempty"`
+	// Artifacts are locations of post-mortem artifacts collected after death
+	Artifacts []string `json:",omitempty"`
+	// Paused is set while the child is paused
+	Paused *time.Time `json:",omitempty"`
+	// ExpiresAt is set while alive, when TTL is set. Renewed by the owner until death, so expired tombstone
+	// means its owner crashed without recording death
+	ExpiresAt *time.Time `json:",omitempty"`
+
+	Startup *StartupLatency `json:",omitempty"`
+
+	// PodUID is the pod of the tombstone owner, so tombstones of previous pod incarnations surviving on persistent volumes are ignored
+	PodUID string `json:",omitempty"`
+	// RestartCount is the number of previous births of the owner in the same pod
+	RestartCount int `json:",omitempty"`
+	// Incarnation is random id of the birth, telling apart the tombstone of this birth from ones of previous births
+	Incarnation string `json:",omitempty"`
+	// Restarts are the last MaxRestarts restarts of the child by kubexit itself, without recording death
+	Restarts []Restart `json:",omitempty"`
+	// BirthDeps and DeathDeps are dependencies of the owner as configured, so the pod dependency graph can be gathered from the graveyard
+	BirthDeps []string `json:",omitempty"`
+	DeathDeps []string `json:",omitempty"`
+	// Labels are user supplied, e.g. team=payments, so tooling scraping graveyards can attribute tombstones
+	Labels map[string]string `json:",omitempty"`
+	// Image and ImageID are the version of the owner container
+	Image   string `json:",omitempty"`
+	ImageID string `json:",omitempty"`
+
+	// ClockOffset is the offset of the API server clock from the owner one at startup, formatted as Go duration
+	ClockOffset string `json:",omitempty"`
+
+	// Signature is HMAC of the tombstone content, when it is written with Key
+	Signature string `json:",omitempty"`
+
+	// Owner identifies the process writing the tombstone in node scoped graveyards, for stale tombstones collection
+	Owner *Owner `json:",omitempty"`
 
 	Graveyard string `json:"-"`
 	Name      string `json:"-"`
+	// TTL of alive tombstone, see ExpiresAt. Zero disables expiry
+	TTL time.Duration `json:"-"`
+	// Key signs written tombstone, if set
+	Key []byte `json:"-"`
+	// ReadOnly tombstone is recorded in memory only, for participants with read-only graveyard
+	ReadOnly bool `json:"-"`
+	// TimeFormat of written timestamps, set to the layout of read timestamps by Read
+	TimeFormat timestamp.Format `json:"-"`
+	// Format of the written file, FormatYAML when empty. Set to the format of read file by Read
+	Format string `json:"-"`
 
 	fileLock sync.Mutex
+	// deathWritten is set once death is written, so repeated RecordDeath doesn't rewrite it
+	deathWritten bool
+	// raw is the content read by Read, to verify signature of newer schema versions with fields unknown here
+	raw []byte
+}
+
+// ErrDeathRecorded is the cause of RecordBirth error after death, and of RecordDeath error with another exit code than recorded,
+// as the first recorded death is final and dependents may have reacted on it already
+var ErrDeathRecorded = errors.New("tombstone death is recorded already")
+
+// MaxRestarts bounds restart history in the tombstone
+const MaxRestarts = 10
+
+// Restart of the child by kubexit
+type Restart struct {
+	Time time.Time
+	// ExitCode of the replaced child, unless it was replaced while running
+	ExitCode *int `json:",omitempty"`
+	Reason   string
+}
+
+// StartupLatency holds durations of the startup phases preceding the birth, formatted as Go durations
+type StartupLatency struct {
+	ConfigParse    string            `json:",omitempty"`
+	GraveyardWatch string            `json:",omitempty"`
+	BirthDepsWait  string            `json:",omitempty"`
+	BirthDeps      map[string]string `json:",omitempty"`
+	ChildStart     string            `json:",omitempty"`
+	TimeToReady    string            `json:",omitempty"`
 }
 
 func (t *Tombstone) Path() string {
@@ -37,53 +123,344 @@ func (t *Tombstone) Path() string {
 
 // Write a tombstone file, truncating before writing.
 // If the FilePath directories do not exist, they will be created.
-func (t *Tombstone) Write() error {
+// File operations can't be interrupted, so when ctx is done first, e.g. graveyard on network filesystem hangs,
+// the write is left to complete in background and ctx error is returned.
+func (t *Tombstone) Write(ctx context.Context) error {
+	if t.ReadOnly {
+		event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Graveyard is read-only, skip writing tombstone: %s", t.Path()))
+		return nil
+	}
+
+	chaos.ContextInjector(ctx).DelayWrite(ctx)
+
+	pretty, err := t.seal()
+	if err != nil {
+		return err
+	}
+	return t.writeWithin(ctx, t.Graveyard, pretty)
+}
+
+// Mirror writes the tombstone to another graveyard as Write does, e.g. to a node-local graveyard watched by a node agent.
+// The tombstone is written there even if its own graveyard is read-only
+func (t *Tombstone) Mirror(ctx context.Context, graveyard string) error {
+	pretty, err := t.seal()
+	if err != nil {
+		return err
+	}
+	return t.writeWithin(ctx, graveyard, pretty)
+}
+
+// seal stamps the schema version and signature, and returns the content to write
+func (t *Tombstone) seal() ([]byte, error) {
+	t.SchemaVersion = CurrentSchemaVersion
+	if len(t.Key) > 0 {
+		signature, err := t.sign(t.Key)
+		if err != nil {
+			return nil, err
+		}
+		t.Signature = signature
+	}
+	return t.marshal()
+}
+
+// writeWithin writes the tombstone content to graveyard in background, returning when it is written or ctx is done
+func (t *Tombstone) writeWithin(ctx context.Context, graveyard string, pretty []byte) error {
+	done := make(chan error, 1)
+	crash.Go(ctx, "tombstone write", func() {
+		// transient failures, e.g. of network filesystem, are retried until ctx is done
+		done <- retry.Do(ctx, "tombstone write", func(context.Context) error {
+			err := t.write(graveyard, pretty)
+			if Unwritable(err) {
+				return retry.Permanent(err)
+			}
+			return err
+		})
+	})
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("tombstone write is not completed: %v", ctx.Err())
+	}
+}
+
+// Unwritable reports whether write err is caused by read-only or full graveyard file system,
+// so retrying it in the same graveyard is pointless
+func Unwritable(err error) bool {
+	return errors.Is(err, syscall.EROFS) || errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+func (t *Tombstone) write(graveyard string, pretty []byte) error {
 	// one write at a time
 	t.fileLock.Lock()
 	defer t.fileLock.Unlock()
 
-	err := os.MkdirAll(t.Graveyard, os.ModePerm)
+	err := os.MkdirAll(graveyard, os.ModePerm)
 	if err != nil {
 		return err
 	}
 
-	// does not exit
-	file, err := os.Create(t.Path())
+	// the replaced file is locked, so external writers updating it in place and readers are not interleaved with the write
+	path := filepath.Join(graveyard, t.Name)
+	unlock, err := lockExisting(path)
 	if err != nil {
-		return fmt.Errorf("failed to create tombstone file: %v", err)
+		return fmt.Errorf("failed to lock tombstone file: %w", err)
 	}
-	defer file.Close()
+	defer unlock()
 
-	pretty, err := yaml.Marshal(t)
+	err = t.checkRecordedDeath(path)
 	if err != nil {
-		return fmt.Errorf("failed to marshal tombstone yaml: %v", err)
+		return retry.Permanent(err)
+	}
+	return t.replace(graveyard, pretty)
+}
+
+// checkRecordedDeath verifies the tombstone file, locked by the caller, doesn't hold death of this incarnation,
+// recorded by another writer or a copy of the tombstone, with another exit code than written, or the tombstone alive
+func (t *Tombstone) checkRecordedDeath(path string) error {
+	if t.Incarnation == "" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		// missing file is created, unreadable one is replaced
+		return nil
+	}
+	var recorded Tombstone
+	err = yaml.Unmarshal(content, &recorded)
+	// tombstones of previous incarnations, e.g. of the restarted container, are replaced
+	if err != nil || recorded.Died == nil || recorded.Incarnation != t.Incarnation {
+		return nil
+	}
+	if t.Died == nil || t.ExitCode == nil || recorded.ExitCode == nil || *recorded.ExitCode != *t.ExitCode {
+		return errors.Wrapf(ErrDeathRecorded, "failed to write tombstone %s %s, recorded dead %s", path, t.describeDeath(), formatExitCode(recorded.ExitCode))
 	}
-	_, _ = file.Write(pretty)
 	return nil
 }
 
-func (t *Tombstone) RecordBirth() error {
-	born := time.Now()
-	t.Born = &born
+// newIncarnation returns random id of the birth
+func newIncarnation() (string, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate tombstone incarnation")
+	}
+	return hex.EncodeToString(id), nil
+}
 
-	event.ContextEventTrace(t.Context).AddEvent(fmt.Sprintf("Creating tombstone: %s", t.Path()))
-	err := t.Write()
+func (t *Tombstone) describeDeath() string {
+	if t.Died == nil {
+		return "alive"
+	}
+	return "dead " + formatExitCode(t.ExitCode)
+}
+
+// replace writes the tombstone file in graveyard, the replaced file must be locked by the caller
+func (t *Tombstone) replace(graveyard string, pretty []byte) error {
+	// written to temp file and renamed, so concurrent readers never see partially written tombstone
+	file, err := ioutil.TempFile(graveyard, "."+t.Name+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create tombstone file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(pretty)
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
 	if err != nil {
-		return errors.WithStack(fmt.Errorf("failed to create tombstone: %v", err))
+		return fmt.Errorf("failed to write tombstone file: %w", err)
+	}
+
+	// temp files are created with 0600, tombstones are read by other users
+	err = os.Chmod(file.Name(), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write tombstone file: %w", err)
+	}
+
+	err = os.Rename(file.Name(), filepath.Join(graveyard, t.Name))
+	if err != nil {
+		return fmt.Errorf("failed to replace tombstone file: %w", err)
 	}
 	return nil
 }
 
-func (t *Tombstone) RecordDeath(exitCode int) error {
-	code := exitCode
-	died := time.Now()
-	t.Died = &died
-	t.ExitCode = &code
+// States of tombstone owner, see Tombstone.State
+const (
+	StateUnborn = "unborn"
+	StateAlive  = "alive"
+	StateDead   = "dead"
+	// StateCrashed is dead because kubexit of the owner crashed, see SupervisorCrashed
+	StateCrashed = "supervisor-crashed"
+	StateUnknown = "unknown"
+)
+
+// State returns the owner state at now. Expired alive tombstones are unknown rather than alive
+func (t *Tombstone) State(now time.Time) string {
+	switch {
+	case t.Died != nil && t.SupervisorCrashed != "":
+		return StateCrashed
+	case t.Died != nil:
+		return StateDead
+	case t.Born == nil:
+		return StateUnborn
+	case t.ExpiresAt != nil && now.After(*t.ExpiresAt):
+		return StateUnknown
+	default:
+		return StateAlive
+	}
+}
+
+// FromOtherPod reports whether the tombstone is written by another pod than podUID, e.g. previous incarnation of the pod.
+// Tombstones are assumed to be from the same pod, when either pod uid is unknown
+func (t *Tombstone) FromOtherPod(podUID string) bool {
+	return podUID != "" && t.PodUID != "" && t.PodUID != podUID
+}
+
+// RecordBirth records birth once. Repeated calls retry the write keeping the time of the first call,
+// they fail with ErrDeathRecorded cause after death is recorded, in memory or in the tombstone file
+func (t *Tombstone) RecordBirth(ctx context.Context) error {
+	if t.Died != nil {
+		return errors.Wrapf(ErrDeathRecorded, "failed to create tombstone %s", t.Path())
+	}
 
-	event.ContextEventTrace(t.Context).AddEvent(fmt.Sprintf("Updating tombstone: %s", t.Path()))
-	err := t.Write()
+	if t.Born == nil {
+		// the owner is restarted, when its previous tombstone is left in the same pod,
+		// unless restart count is known already, e.g. from pod status
+		if t.RestartCount == 0 {
+			previous, err := ReadSigned(t.Graveyard, t.Name, t.Key)
+			if err == nil && previous.Born != nil && previous.PodUID == t.PodUID {
+				t.RestartCount = previous.RestartCount + 1
+			}
+		}
+
+		incarnation, err := newIncarnation()
+		if err != nil {
+			return err
+		}
+		born := time.Now()
+		t.Born = &born
+		t.Incarnation = incarnation
+	}
+	t.renew(time.Now())
+
+	event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Creating tombstone: %s", t.Path()))
+	err := t.Write(ctx)
 	if err != nil {
-		return errors.WithStack(fmt.Errorf("failed to update tombstone: %v", err))
+		return errors.WithStack(fmt.Errorf("failed to create tombstone: %w", err))
+	}
+	return nil
+}
+
+// RecordDeath records death with the exit code once. Repeated calls with the same code are no-op once the death is written,
+// and retry the write otherwise, keeping the time of the first call. Calls with another code fail with ErrDeathRecorded cause,
+// as well as calls after death with another code is recorded in the tombstone file, e.g. by another writer
+func (t *Tombstone) RecordDeath(ctx context.Context, exitCode int) error {
+	if t.Died != nil {
+		if t.ExitCode == nil || *t.ExitCode != exitCode {
+			return errors.Wrapf(ErrDeathRecorded, "failed to record death of %s with exit code %d, recorded %s", t.Path(), exitCode, formatExitCode(t.ExitCode))
+		}
+		if t.deathWritten {
+			return nil
+		}
+	} else {
+		code := exitCode
+		died := time.Now()
+		t.Died = &died
+		t.ExitCode = &code
+		t.Paused = nil
+		t.ExpiresAt = nil
+	}
+
+	event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Updating tombstone: %s", t.Path()))
+	err := t.Write(ctx)
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to update tombstone: %w", err))
+	}
+	t.deathWritten = true
+	return nil
+}
+
+func formatExitCode(code *int) string {
+	if code == nil {
+		return "without exit code"
+	}
+	return fmt.Sprintf("with exit code %d", *code)
+}
+
+// RecordCrash records death of the owner because its kubexit crashed with the panic,
+// so dependents react to it as to any death instead of waiting for the tombstone to expire.
+// Death recorded already is kept, its write is retried if failed
+func (t *Tombstone) RecordCrash(ctx context.Context, exitCode int, panicked string) error {
+	if t.Died != nil && t.ExitCode != nil {
+		return t.RecordDeath(ctx, *t.ExitCode)
+	}
+	t.SupervisorCrashed = panicked
+	return t.RecordDeath(ctx, exitCode)
+}
+
+// Renew extends ExpiresAt of alive tombstone by TTL
+func (t *Tombstone) Renew(ctx context.Context) error {
+	if t.TTL <= 0 || t.Died != nil {
+		return nil
+	}
+	t.renew(time.Now())
+
+	err := t.Write(ctx)
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to renew tombstone: %w", err))
+	}
+	return nil
+}
+
+func (t *Tombstone) renew(now time.Time) {
+	if t.TTL <= 0 {
+		return
+	}
+	expiresAt := now.Add(t.TTL)
+	t.ExpiresAt = &expiresAt
+}
+
+// RecordPause records the child paused or resumed
+func (t *Tombstone) RecordPause(ctx context.Context, paused bool) error {
+	t.Paused = nil
+	if paused {
+		now := time.Now()
+		t.Paused = &now
+	}
+
+	event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Updating tombstone pause: %s", t.Path()))
+	err := t.Write(ctx)
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to update tombstone: %w", err))
+	}
+	return nil
+}
+
+// RecordRestart appends restart to the history, keeping the last MaxRestarts
+func (t *Tombstone) RecordRestart(ctx context.Context, restart Restart) error {
+	t.Restarts = append(t.Restarts, restart)
+	if len(t.Restarts) > MaxRestarts {
+		t.Restarts = t.Restarts[len(t.Restarts)-MaxRestarts:]
+	}
+
+	event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Updating tombstone restarts: %s", t.Path()))
+	err := t.Write(ctx)
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to update tombstone: %w", err))
+	}
+	return nil
+}
+
+// RecordArtifacts records locations of collected artifacts
+func (t *Tombstone) RecordArtifacts(ctx context.Context, locations []string) error {
+	t.Artifacts = locations
+
+	event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Updating tombstone artifacts: %s", t.Path()))
+	err := t.Write(ctx)
+	if err != nil {
+		return errors.WithStack(fmt.Errorf("failed to update tombstone: %w", err))
 	}
 	return nil
 }
@@ -91,7 +468,6 @@ func (t *Tombstone) RecordDeath(exitCode int) error {
 func (t *Tombstone) String() string {
 	inline, err := json.Marshal(t)
 	if err != nil {
-		event.ContextEventTrace(t.Context).AddEvent(fmt.Sprintf("Error: failed to marshal tombstone as json: %v", err))
 		return "{}"
 	}
 	return string(inline)
@@ -104,44 +480,142 @@ func Read(graveyard, name string) (*Tombstone, error) {
 		Name:      name,
 	}
 
-	bytes, err := ioutil.ReadFile(t.Path())
+	bytes, err := readShared(t.Path())
 	if err != nil {
 		return nil, errors.WithStack(fmt.Errorf("failed to read tombstone file: %v", err))
 	}
 
-	err = yaml.Unmarshal(bytes, &t)
+	// unknown fields of newer schema versions are ignored, unless parsing is strict
+	unmarshal := yaml.Unmarshal
+	if strictParsing() {
+		unmarshal = yaml.UnmarshalStrict
+	}
+	err = unmarshal(bytes, &t)
 	if err != nil {
 		return nil, errors.WithStack(fmt.Errorf("failed to unmarshal tombstone yaml: %v", err))
 	}
+	t.raw = bytes
+	t.Format = detectFormat(bytes)
 
 	return &t, nil
 }
 
+// ReadAll reads all tombstones in a graveyard, verifying signatures with key, if key is not empty.
+// Files which are not tombstones, e.g. audit logs, and forged tombstones are skipped
+func ReadAll(graveyard string, key []byte) ([]*Tombstone, error) {
+	infos, err := ioutil.ReadDir(graveyard)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("failed to list graveyard: %v", err))
+	}
+
+	var tombstones []*Tombstone
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		ts, err := ReadSigned(graveyard, info.Name(), key)
+		// empty files, e.g. lock files, unmarshal as empty tombstone
+		if err != nil || (ts.Born == nil && ts.Died == nil) {
+			continue
+		}
+		tombstones = append(tombstones, ts)
+	}
+	return tombstones, nil
+}
+
 type EventHandler func(context.Context, fsnotify.Event) error
 
+// handlerFailedReason is the warning reason of EventHandler errors
+const handlerFailedReason = "TombstoneHandlerFailed"
+
+// DefaultCoalesceWindow is the window Watch coalesces events of a tombstone within,
+// enough for create, write and chmod of a single tombstone write
+const DefaultCoalesceWindow = 50 * time.Millisecond
+
 // Watch a graveyard and call the eventHandler (asyncronously) when an
-// event happens. When the supplied context is canceled, watching will stop.
-func Watch(ctx context.Context, graveyard string, eventHandler EventHandler) error {
+// event of a tombstone with one of the names happens, or of any file when names are empty.
+// When the supplied context is canceled, watching will stop.
+// Events are coalesced within DefaultCoalesceWindow, see WatchCoalesced.
+func Watch(ctx context.Context, graveyard string, names []string, eventHandler EventHandler) error {
+	return WatchCoalesced(ctx, graveyard, DefaultCoalesceWindow, names, eventHandler)
+}
+
+// WatchCoalesced is Watch, which merges events of the same file received within window after the first one
+// into a single event with combined Op, so the handler reads a tombstone once per write.
+// Zero window dispatches every event.
+// Removed graveyard is recreated, when it is impossible the handler is called with the event satisfying GraveyardLost.
+func WatchCoalesced(ctx context.Context, graveyard string, window time.Duration, names []string, eventHandler EventHandler) error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return errors.WithStack(fmt.Errorf("failed to create watcher: %v", err))
 	}
 
-	go func() {
+	heartbeat := watchdog.ContextHeartbeat(ctx)
+	filter := newNameFilter(names)
+	injector := chaos.ContextInjector(ctx)
+
+	dispatch := func(e fsnotify.Event) {
+		err2 := eventHandler(ctx, e)
+		if err2 != nil {
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Handler error: %s", err2))
+			event.ContextWarnings(ctx).Warn(handlerFailedReason, err2.Error())
+		}
+	}
+
+	crash.Go(ctx, "graveyard watch", func() {
 		defer watcher.Close()
+
+		ticker := time.NewTicker(heartbeat.Interval())
+		defer ticker.Stop()
+
+		// pending events in order of arrival, one per file
+		var pending []fsnotify.Event
+		var flush <-chan time.Time
+		disconnect := injector.Disconnect()
+
 		for {
 			select {
 			case <-ctx.Done():
 				event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Tombstone Watch(%s): done", graveyard))
 				return
+			case <-disconnect:
+				event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Tombstone Watch(%s): chaos: disconnected", graveyard))
+				return
+			case <-ticker.C:
+				heartbeat.Beat()
 			case e, ok := <-watcher.Events:
 				if !ok {
 					return
 				}
-				err = eventHandler(ctx, e)
-				if err != nil {
-					event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Handler error: %s", err))
+				heartbeat.Beat()
+				if injector.DropEvent() {
+					event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Tombstone Watch(%s): chaos: dropped event: %s", graveyard, e))
+					continue
+				}
+				if GraveyardLost(e, graveyard) {
+					if restoreGraveyard(ctx, graveyard, watcher.Add) {
+						continue
+					}
+					dispatch(e)
+					return
+				}
+				if !filter.match(ctx, e) {
+					continue
+				}
+				if window <= 0 {
+					dispatch(e)
+					continue
+				}
+				pending = coalesce(pending, e)
+				if flush == nil {
+					flush = time.After(window)
+				}
+			case <-flush:
+				flush = nil
+				for _, e := range pending {
+					dispatch(e)
 				}
+				pending = nil
 			case err2, ok := <-watcher.Errors:
 				if !ok {
 					return
@@ -150,7 +624,7 @@ func Watch(ctx context.Context, graveyard string, eventHandler EventHandler) err
 				// TODO: wrap ctx with WithCancel and cancel on terminal errors, if any
 			}
 		}
-	}()
+	})
 
 	err = watcher.Add(graveyard)
 	if err != nil {
@@ -158,3 +632,14 @@ func Watch(ctx context.Context, graveyard string, eventHandler EventHandler) err
 	}
 	return nil
 }
+
+// coalesce merges e into pending event of the same file, or appends it
+func coalesce(pending []fsnotify.Event, e fsnotify.Event) []fsnotify.Event {
+	for i := range pending {
+		if pending[i].Name == e.Name {
+			pending[i].Op |= e.Op
+			return pending
+		}
+	}
+	return append(pending, e)
+}
@@ -0,0 +1,169 @@
+package tombstone
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// born records birth of the tombstone name in graveyard
+func born(t *testing.T, graveyard, name string) *Tombstone {
+	t.Helper()
+
+	ts := &Tombstone{Graveyard: graveyard, Name: name, TTL: time.Minute}
+	err := ts.RecordBirth(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+// buryCopy records death of the tombstone read from graveyard, as another writer would
+func buryCopy(t *testing.T, graveyard, name string, exitCode int) {
+	t.Helper()
+
+	ts, err := Read(graveyard, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ts.RecordDeath(context.Background(), exitCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertDeathRecorded(t *testing.T, err error) {
+	t.Helper()
+
+	if !errors.Is(err, ErrDeathRecorded) {
+		t.Fatalf("expected %v, got %v", ErrDeathRecorded, err)
+	}
+}
+
+func assertDead(t *testing.T, graveyard, name string, exitCode int) *Tombstone {
+	t.Helper()
+
+	ts, err := Read(graveyard, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Died == nil || ts.ExitCode == nil || *ts.ExitCode != exitCode {
+		t.Fatalf("expected death with exit code %d, got %s", exitCode, ts)
+	}
+	return ts
+}
+
+func TestRecordDeathRepeated(t *testing.T) {
+	graveyard := t.TempDir()
+	ts := born(t, graveyard, "server")
+
+	err := ts.RecordDeath(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := assertDead(t, graveyard, "server", 1)
+
+	err = ts.RecordDeath(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repeated := assertDead(t, graveyard, "server", 1); !repeated.Died.Equal(*first.Died) {
+		t.Fatalf("expected death time %s kept, got %s", first.Died, repeated.Died)
+	}
+
+	// the same death recorded by a copy of the tombstone
+	buryCopy(t, graveyard, "server", 1)
+}
+
+func TestRecordDeathConflictingCode(t *testing.T) {
+	graveyard := t.TempDir()
+	ts := born(t, graveyard, "server")
+
+	err := ts.RecordDeath(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ts.RecordDeath(context.Background(), 2)
+	assertDeathRecorded(t, err)
+	assertDead(t, graveyard, "server", 1)
+
+	// death recorded by another writer is checked in the file
+	ts = born(t, t.TempDir(), "server")
+	buryCopy(t, ts.Graveyard, "server", 2)
+	err = ts.RecordDeath(context.Background(), 1)
+	assertDeathRecorded(t, err)
+	assertDead(t, ts.Graveyard, "server", 2)
+}
+
+func TestRecordBirthAfterDeath(t *testing.T) {
+	graveyard := t.TempDir()
+	ts := born(t, graveyard, "server")
+
+	err := ts.RecordDeath(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ts.RecordBirth(context.Background())
+	assertDeathRecorded(t, err)
+	assertDead(t, graveyard, "server", 1)
+
+	// death recorded by another writer is checked in the file, by birth and renewal alike
+	ts = born(t, t.TempDir(), "server")
+	buryCopy(t, ts.Graveyard, "server", 1)
+	err = ts.RecordBirth(context.Background())
+	assertDeathRecorded(t, err)
+	err = ts.Renew(context.Background())
+	assertDeathRecorded(t, err)
+	assertDead(t, ts.Graveyard, "server", 1)
+
+	// the next incarnation, e.g. of the restarted container, replaces the tombstone
+	next := born(t, ts.Graveyard, "server")
+	if next.RestartCount != 1 || next.Incarnation == ts.Incarnation {
+		t.Fatalf("expected the next incarnation with restart count 1, got %s after %s", next, ts)
+	}
+	current, err := Read(ts.Graveyard, "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.State(time.Now()) != StateAlive {
+		t.Fatalf("expected tombstone of the next incarnation alive, got %s", current)
+	}
+}
+
+// TestAccessWhileLocked checks the tombstone is read and written without the lock,
+// when an external writer holds it longer than lockTimeout
+func TestAccessWhileLocked(t *testing.T) {
+	defer func(timeout time.Duration) { lockTimeout = timeout }(lockTimeout)
+	lockTimeout = 50 * time.Millisecond
+
+	graveyard := t.TempDir()
+	ts := born(t, graveyard, "server")
+
+	file, err := os.OpenFile(ts.Path(), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := Read(graveyard, "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Incarnation != ts.Incarnation {
+		t.Fatalf("expected tombstone of incarnation %s, got %s", ts.Incarnation, read)
+	}
+
+	err = ts.RecordDeath(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDead(t, graveyard, "server", 1)
+}
@@ -0,0 +1,68 @@
+package watchdog
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Heartbeat is beaten by a watcher loop to prove it makes progress
+type Heartbeat interface {
+	Beat()
+	// Interval is how often a watcher should beat while idle
+	Interval() time.Duration
+}
+
+type heartbeatKey struct{}
+
+func WithHeartbeat(ctx context.Context, hb Heartbeat) context.Context {
+	return context.WithValue(ctx, heartbeatKey{}, hb)
+}
+
+func ContextHeartbeat(ctx context.Context) Heartbeat {
+	hb, ok := ctx.Value(heartbeatKey{}).(Heartbeat)
+	if !ok {
+		return &noopHeartbeat{}
+	}
+	return hb
+}
+
+type heartbeat struct {
+	m        sync.Mutex
+	last     time.Time
+	interval time.Duration
+}
+
+func newHeartbeat(interval time.Duration) *heartbeat {
+	return &heartbeat{
+		last:     time.Now(),
+		interval: interval,
+	}
+}
+
+func (h *heartbeat) Beat() {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.last = time.Now()
+}
+
+func (h *heartbeat) Interval() time.Duration {
+	return h.interval
+}
+
+func (h *heartbeat) age() time.Duration {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return time.Since(h.last)
+}
+
+type noopHeartbeat struct{}
+
+func (n noopHeartbeat) Beat() {
+	//	Do nothing
+}
+
+func (n noopHeartbeat) Interval() time.Duration {
+	// nobody checks, so beat rarely
+	return time.Hour
+}
@@ -0,0 +1,91 @@
+package watchdog
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ispringtech/kubexit/pkg/crash"
+	"github.com/ispringtech/kubexit/pkg/event"
+	"github.com/ispringtech/kubexit/pkg/health"
+	"github.com/ispringtech/kubexit/pkg/metrics"
+)
+
+// StartFunc starts a watcher in background, watching until ctx is canceled.
+// The watcher must beat ContextHeartbeat(ctx) at least every its Interval.
+type StartFunc func(ctx context.Context) error
+
+// Run starts a watcher and recreates it whenever its heartbeat is older than staleAfter,
+// i.e. the watcher goroutine is wedged or exited unexpectedly.
+// Only the first start error is returned, failed restarts are retried on the next check.
+// With zero staleAfter the watcher is just started.
+// Watcher health is reported to health.ContextRegistry(ctx) as "<name> watcher", until ctx is done.
+func Run(ctx context.Context, name string, staleAfter time.Duration, start StartFunc) error {
+	subsystem := name + " watcher"
+	registry := health.ContextRegistry(ctx)
+
+	if staleAfter <= 0 {
+		// liveness is unknown without heartbeat checks
+		err := start(ctx)
+		if err != nil {
+			return err
+		}
+		registry.Up(subsystem)
+		crash.Go(ctx, "watchdog", func() {
+			<-ctx.Done()
+			registry.Remove(subsystem)
+		})
+		return nil
+	}
+
+	// beat several times within staleAfter, so a single late beat is not a reason to restart
+	interval := staleAfter / 3
+
+	hb := newHeartbeat(interval)
+	watcherCtx, stopWatcher := context.WithCancel(WithHeartbeat(ctx, hb))
+	err := start(watcherCtx)
+	if err != nil {
+		stopWatcher()
+		return err
+	}
+	registry.Up(subsystem)
+
+	crash.Go(ctx, "watchdog", func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				stopWatcher()
+				registry.Remove(subsystem)
+				return
+			case <-ticker.C:
+			}
+
+			age := hb.age()
+			if age < staleAfter {
+				registry.Up(subsystem)
+				continue
+			}
+			registry.Down(subsystem, fmt.Sprintf("heartbeat is stale for %s", age))
+
+			event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Watchdog(%s): heartbeat is stale for %s, restarting watcher", name, age))
+			metrics.ContextRecorder(ctx).AddCounter(
+				"kubexit_watcher_restarts_total",
+				"Number of internal watchers restarted by watchdog",
+				1,
+				metrics.L("watcher", name),
+			)
+
+			stopWatcher()
+			hb = newHeartbeat(interval)
+			watcherCtx, stopWatcher = context.WithCancel(WithHeartbeat(ctx, hb))
+			err2 := start(watcherCtx)
+			if err2 != nil {
+				event.ContextEventTrace(ctx).AddEvent(fmt.Sprintf("Watchdog(%s): failed to restart watcher: %v", name, err2))
+			}
+		}
+	})
+
+	return nil
+}
@@ -25,4 +25,8 @@ for PLATFORM in ${PLATFORMS[@]} ; do
     echo "Building: bin/${PLATFORM}/${CMD}"
     go build -o "bin/${PLATFORM}/${CMD}" "./cmd/${CMD}"
   done
+
+  # Slim kubexit without kubernetes client, supports graveyard based death deps only
+  echo "Building: bin/${PLATFORM}/kubexit-slim"
+  go build -tags nokubernetes -o "bin/${PLATFORM}/kubexit-slim" "./cmd/kubexit"
 done